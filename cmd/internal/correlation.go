@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"golang.org/x/exp/slog"
+)
+
+// CorrelationIDKey is the log field shared by all the log lines of a single
+// invocation.
+const CorrelationIDKey = "correlation_id"
+
+// CorrelationID returns the ID correlating the logs of a single invocation.
+// Where the platform provides a request ID, like on Lambda, that's what we use.
+// Otherwise a random one is generated, so that local runs can be correlated too.
+func CorrelationID(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+
+	buf := make([]byte, 8)
+
+	// Reading from crypto/rand doesn't fail on the platforms we run on, and
+	// a missing correlation ID isn't worth failing the run over anyway.
+	_, _ = rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// WithCorrelationID attaches the correlation ID of the invocation to the logger.
+func WithCorrelationID(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	return logger.With(CorrelationIDKey, CorrelationID(ctx))
+}
@@ -0,0 +1,48 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/cmd/internal"
+)
+
+func TestWithCorrelationIDSharedAcrossRun(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := internal.NewLogger(&buf, internal.LogFormatJSON)
+	require.NoError(t, err)
+
+	logger = internal.WithCorrelationID(context.Background(), logger)
+	logger.Info("starting the run")
+	logger.With("worker_id", "bacon").Info("scaling down")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var ids []any
+	for _, line := range lines {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		ids = append(ids, entry[internal.CorrelationIDKey])
+	}
+
+	require.NotEmpty(t, ids[0])
+	require.Equal(t, ids[0], ids[1])
+}
+
+func TestCorrelationIDGeneratedPerRun(t *testing.T) {
+	require.NotEqual(t, internal.CorrelationID(context.Background()), internal.CorrelationID(context.Background()))
+}
+
+func TestCorrelationIDUsesLambdaRequestID(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request"})
+
+	require.Equal(t, "request", internal.CorrelationID(ctx))
+}
@@ -3,22 +3,169 @@ package internal
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 
-	"github.com/caarlos0/env/v9"
+	"github.com/aws/aws-xray-sdk-go/xray"
 	"golang.org/x/exp/slog"
 
 	"github.com/spacelift-io/awsautoscalr/internal"
 )
 
-func Handle(ctx context.Context, logger *slog.Logger) error {
-	var cfg internal.RuntimeConfig
-	if err := env.Parse(&cfg); err != nil {
-		return fmt.Errorf("could not parse environment variables: %w", err)
+// Event is the payload the autoscaler is invoked with.
+type Event = internal.Event
+
+// Preview is the response to a preview invocation.
+type Preview = internal.Preview
+
+// TerminateInstancesEnvVar lists the instances to terminate when running
+// locally, where there's no invocation event to pass them in.
+const TerminateInstancesEnvVar = "AUTOSCALING_TERMINATE_INSTANCES"
+
+// PreviewEnvVar makes a local run only print the decision the autoscaler
+// would make, as there's no invocation event to ask for a preview.
+const PreviewEnvVar = "AUTOSCALING_PREVIEW"
+
+// RunSegmentName is the name of the X-Ray subsegment covering a whole run.
+const RunSegmentName = "autoscaler.run"
+
+// LocalEvent builds the event for a local run from the environment.
+func LocalEvent() Event {
+	event := Event{Preview: os.Getenv(PreviewEnvVar) == "true"}
+
+	for _, instanceID := range strings.Split(os.Getenv(TerminateInstancesEnvVar), ",") {
+		if instanceID = strings.TrimSpace(instanceID); instanceID != "" {
+			event.TerminateInstances = append(event.TerminateInstances, instanceID)
+		}
+	}
+
+	return event
+}
+
+// Handle handles a single invocation of the autoscaler, within a subsegment
+// tying together all the operations of the run.
+func Handle(ctx context.Context, logger *slog.Logger, event Event) error {
+	return Trace(ctx, func(ctx context.Context) error {
+		return handle(ctx, logger, event)
+	})
+}
+
+func handle(ctx context.Context, logger *slog.Logger, event Event) error {
+	logger = WithCorrelationID(ctx, logger)
+
+	cfg, controller, err := setup(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.AutoscalingTagWorkerPool {
+		applied, err := controller.EnsureWorkerPoolTag(ctx)
+		if err != nil {
+			return fmt.Errorf("could not ensure worker pool tag: %w", err)
+		}
+
+		if applied {
+			logger.With("tag", internal.WorkerPoolIDTagKey).Info("tagged the ASG with the worker pool ID")
+		}
+	}
+
+	var notifiers []internal.Notifier
+
+	if cfg.AutoscalingWebhookURL != "" {
+		httpClient, err := internal.NewHTTPClient(cfg)
+		if err != nil {
+			return fmt.Errorf("could not create HTTP client: %w", err)
+		}
+
+		notifiers = append(notifiers, internal.NewWebhookNotifier(cfg, httpClient))
+	}
+
+	if cfg.AutoscalingCloudEventsSink == internal.CloudEventsSinkStdout {
+		notifiers = append(notifiers, internal.NewCloudEventsNotifier(os.Stdout))
 	}
 
-	controller, err := internal.NewController(ctx, &cfg)
+	if cfg.AutoscalingEMFNamespace != "" {
+		notifiers = append(notifiers, internal.NewEMFNotifier(os.Stdout, cfg.AutoscalingEMFNamespace))
+	}
+
+	scaler, err := withHintSources(ctx, cfg, controller, internal.NewAutoScaler(controller, logger, notifiers...))
 	if err != nil {
-		return fmt.Errorf("could not create controller: %w", err)
+		return err
+	}
+
+	if event.IsOverride() {
+		return scaler.Override(ctx, *cfg, event)
 	}
-	return internal.NewAutoScaler(controller, logger).Scale(ctx, cfg)
+
+	return scaler.Scale(ctx, *cfg)
+}
+
+// HandlePreview returns the decision the autoscaler would make, without making
+// any changes. Nothing is tagged and no notifications are sent either.
+func HandlePreview(ctx context.Context, logger *slog.Logger, event Event) (preview *Preview, err error) {
+	err = Trace(ctx, func(ctx context.Context) error {
+		preview, err = handlePreview(ctx, logger, event)
+		return err
+	})
+
+	return preview, err
+}
+
+func handlePreview(ctx context.Context, logger *slog.Logger, event Event) (*Preview, error) {
+	logger = WithCorrelationID(ctx, logger)
+
+	if err := event.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid preview: %w", err)
+	}
+
+	cfg, controller, err := setup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scaler, err := withHintSources(ctx, cfg, controller, internal.NewAutoScaler(controller, logger))
+	if err != nil {
+		return nil, err
+	}
+
+	return scaler.Preview(ctx, *cfg)
+}
+
+// Trace runs the function within the run subsegment, which is marked as
+// failed with the error it returns, if any.
+func Trace(ctx context.Context, fn func(context.Context) error) error {
+	return xray.Capture(ctx, RunSegmentName, fn)
+}
+
+// setup loads the configuration and creates the controller.
+func setup(ctx context.Context) (*internal.RuntimeConfig, *internal.Controller, error) {
+	cfg, err := internal.LoadRuntimeConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load configuration: %w", err)
+	}
+
+	controller, err := internal.NewController(ctx, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create controller: %w", err)
+	}
+
+	return cfg, controller, nil
+}
+
+// withHintSources adds the configured sources of scaling hints to the scaler.
+func withHintSources(ctx context.Context, cfg *internal.RuntimeConfig, controller *internal.Controller, scaler *internal.AutoScaler) (*internal.AutoScaler, error) {
+	if cfg.AutoscalingQueueURL != "" {
+		scaler = scaler.WithQueueDepthSource(internal.NewSQSQueueDepthSource(cfg, controller))
+	}
+
+	if cfg.AutoscalingUtilizationThreshold > 0 {
+		source, err := internal.NewCloudWatchUtilizationSource(cfg, controller)
+		if err != nil {
+			return nil, fmt.Errorf("could not create utilization source: %w", err)
+		}
+
+		scaler = scaler.WithUtilizationSource(source)
+	}
+
+	return scaler, nil
 }
@@ -0,0 +1,71 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/cmd/internal"
+)
+
+func TestLocalEvent(t *testing.T) {
+	require.False(t, internal.LocalEvent().IsOverride())
+
+	t.Setenv(internal.TerminateInstancesEnvVar, "i-1, i-2,")
+
+	event := internal.LocalEvent()
+	require.True(t, event.IsOverride())
+	require.Equal(t, []string{"i-1", "i-2"}, event.TerminateInstances)
+}
+
+func TestLocalEventPreview(t *testing.T) {
+	require.False(t, internal.LocalEvent().Preview)
+
+	t.Setenv(internal.PreviewEnvVar, "true")
+
+	event := internal.LocalEvent()
+	require.True(t, event.Preview)
+	require.False(t, event.IsOverride())
+}
+
+func TestTrace(t *testing.T) {
+	// trace runs the function within the run subsegment, and returns the
+	// subsegment once it's closed.
+	trace := func(t *testing.T, fnErr error) (*xray.Segment, error) {
+		ctx, segment := xray.BeginSegment(context.Background(), "test")
+		defer segment.Close(nil)
+
+		var run *xray.Segment
+
+		err := internal.Trace(ctx, func(ctx context.Context) error {
+			run = xray.GetSegment(ctx)
+			return fnErr
+		})
+
+		require.NotNil(t, run)
+		require.Equal(t, internal.RunSegmentName, run.Name)
+		require.False(t, run.InProgress)
+
+		return run, err
+	}
+
+	t.Run("succeeds", func(t *testing.T) {
+		run, err := trace(t, nil)
+
+		require.NoError(t, err)
+		require.False(t, run.Fault)
+		require.Nil(t, run.Cause)
+	})
+
+	t.Run("fails", func(t *testing.T) {
+		run, err := trace(t, errors.New("bacon"))
+
+		require.EqualError(t, err, "bacon")
+		require.True(t, run.Fault)
+		require.Len(t, run.Cause.Exceptions, 1)
+		require.Equal(t, "bacon", run.Cause.Exceptions[0].Message)
+	})
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/exp/slog"
+)
+
+// LogFormatEnvVar is the environment variable selecting the log format. The
+// logger is needed before the configuration is loaded, so it's read directly.
+const LogFormatEnvVar = "LOG_FORMAT"
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// NewLogger creates a logger writing to the given writer in the given format.
+// JSON is the default, since it's what log aggregators expect, while text is
+// easier to read in a terminal.
+func NewLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "", LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	default:
+		return nil, fmt.Errorf("invalid %s value: %s", LogFormatEnvVar, format)
+	}
+}
@@ -0,0 +1,43 @@
+package internal_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/cmd/internal"
+)
+
+func TestNewLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := internal.NewLogger(&buf, internal.LogFormatText)
+	require.NoError(t, err)
+
+	logger.With("worker_id", "bacon").Info("scaling down")
+
+	require.Contains(t, buf.String(), `level=INFO msg="scaling down" worker_id=bacon`)
+	require.False(t, json.Valid(buf.Bytes()))
+}
+
+func TestNewLoggerDefaultsToJSON(t *testing.T) {
+	for _, format := range []string{"", internal.LogFormatJSON} {
+		var buf bytes.Buffer
+
+		logger, err := internal.NewLogger(&buf, format)
+		require.NoError(t, err)
+
+		logger.Info("scaling down")
+
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		require.Equal(t, "scaling down", line["msg"])
+	}
+}
+
+func TestNewLoggerInvalidFormat(t *testing.T) {
+	_, err := internal.NewLogger(&bytes.Buffer{}, "xml")
+	require.EqualError(t, err, "invalid LOG_FORMAT value: xml")
+}
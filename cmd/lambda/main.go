@@ -8,17 +8,25 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-xray-sdk-go/xray"
-	"golang.org/x/exp/slog"
 
 	"github.com/spacelift-io/awsautoscalr/cmd/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/version"
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger, err := internal.NewLogger(os.Stdout, os.Getenv(internal.LogFormatEnvVar))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	lambda.Start(func(ctx context.Context) error {
-		if err := xray.Configure(xray.Config{ServiceVersion: "1.2.3"}); err != nil {
-			return fmt.Errorf("could not configure X-Ray: %w", err)
+	logger.With(version.LogAttrs()...).Info("starting the autoscaler")
+
+	// Only previews return anything, for all other invocations the response
+	// is empty.
+	lambda.Start(func(ctx context.Context, event internal.Event) (*internal.Preview, error) {
+		if err := xray.Configure(xray.Config{ServiceVersion: version.Version}); err != nil {
+			return nil, fmt.Errorf("could not configure X-Ray: %w", err)
 		}
 
 		logger := logger
@@ -26,6 +34,10 @@ func main() {
 			logger = logger.With("aws_request_id", lc.AwsRequestID)
 		}
 
-		return internal.Handle(ctx, logger)
+		if event.Preview {
+			return internal.HandlePreview(ctx, logger, event)
+		}
+
+		return nil, internal.Handle(ctx, logger, event)
 	})
 }
@@ -2,25 +2,50 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 
-	"golang.org/x/exp/slog"
-
 	"github.com/aws/aws-xray-sdk-go/xray"
 	cmdinternal "github.com/spacelift-io/awsautoscalr/cmd/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/version"
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger, err := cmdinternal.NewLogger(os.Stdout, os.Getenv(cmdinternal.LogFormatEnvVar))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	if err := xray.Configure(xray.Config{ServiceVersion: "1.2.3"}); err != nil {
+	logger.With(version.LogAttrs()...).Info("starting the autoscaler")
+
+	if err := xray.Configure(xray.Config{ServiceVersion: version.Version}); err != nil {
 		logger.With("msg", err.Error()).Error("could not configure X-Ray")
 		os.Exit(1)
 	}
 
 	ctx, segment := xray.BeginSegment(context.Background(), "autoscaling")
 
-	if err := cmdinternal.Handle(ctx, logger); err != nil {
+	event := cmdinternal.LocalEvent()
+
+	if event.Preview {
+		preview, err := cmdinternal.HandlePreview(ctx, logger, event)
+		if err != nil {
+			logger.With("msg", err.Error()).Error("could not preview the decision")
+			segment.Close(err)
+			os.Exit(1)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(preview)
+
+		segment.Close(nil)
+		return
+	}
+
+	if err := cmdinternal.Handle(ctx, logger, event); err != nil {
 		logger.With("msg", err.Error()).Error("could not handle request")
 		segment.Close(err)
 		os.Exit(1)
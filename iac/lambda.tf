@@ -48,7 +48,7 @@ resource "aws_lambda_function" "autoscaler" {
   }
 
   environment {
-    variables = {
+    variables = merge({
       AUTOSCALING_GROUP_ARN         = var.autoscaling_group_arn
       AUTOSCALING_REGION            = data.aws_region.current.name
       SPACELIFT_API_KEY_ID          = var.spacelift_api_key_id
@@ -57,7 +57,9 @@ resource "aws_lambda_function" "autoscaler" {
       SPACELIFT_WORKER_POOL_ID      = var.worker_pool_id
       AUTOSCALING_MAX_CREATE        = var.autoscaling_max_create
       AUTOSCALING_MAX_KILL          = var.autoscaling_max_terminate
-    }
+      }, var.state_parameter_name == null ? {} : {
+      AUTOSCALING_STATE_PARAMETER = var.state_parameter_name
+    })
   }
 
   tracing_config {
@@ -21,14 +21,18 @@ data "aws_iam_policy_document" "autoscaler" {
     resources = ["*"]
   }
 
-  # Allow the Lambda to DescribeAutoScalingGroups, DetachInstances and SetDesiredCapacity
-  # on the AutoScalingGroup.
+  # Allow the Lambda to manage the AutoScalingGroup.
   statement {
     effect = "Allow"
     actions = [
+      "autoscaling:CreateOrUpdateTags",
       "autoscaling:DetachInstances",
       "autoscaling:SetDesiredCapacity",
       "autoscaling:DescribeAutoScalingGroups",
+      "autoscaling:DescribeInstanceRefreshes",
+      "autoscaling:DescribePolicies",
+      "autoscaling:StartInstanceRefresh",
+      "autoscaling:UpdateAutoScalingGroup",
     ]
 
     resources = ["*"]
@@ -58,12 +62,48 @@ data "aws_iam_policy_document" "autoscaler" {
     resources = ["*"]
   }
 
+  # Allow the Lambda to read the depth of the external queue.
+  statement {
+    effect    = "Allow"
+    actions   = ["sqs:GetQueueAttributes"]
+    resources = ["*"]
+  }
+
+  # Allow the Lambda to read the utilization of the instances.
+  statement {
+    effect    = "Allow"
+    actions   = ["cloudwatch:GetMetricStatistics"]
+    resources = ["*"]
+  }
+
+  # Allow the Lambda to look up the instance quota.
+  statement {
+    effect    = "Allow"
+    actions   = ["servicequotas:GetServiceQuota"]
+    resources = ["*"]
+  }
+
   # Allow the Lambda to read the secret from SSM Parameter Store.
   statement {
     effect    = "Allow"
     actions   = ["ssm:GetParameter"]
     resources = [aws_ssm_parameter.spacelift_api_key_secret.arn]
   }
+
+  # Allow the Lambda to persist its state in SSM Parameter Store.
+  dynamic "statement" {
+    for_each = var.state_parameter_name == null ? [] : [var.state_parameter_name]
+
+    content {
+      effect = "Allow"
+      actions = [
+        "ssm:GetParameter",
+        "ssm:PutParameter",
+      ]
+
+      resources = ["arn:${data.aws_partition.current.partition}:ssm:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:parameter/${trimprefix(statement.value, "/")}"]
+    }
+  }
 }
 
 
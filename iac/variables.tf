@@ -99,3 +99,10 @@ variable "autoscaler_permissions_boundary" {
   default     = null
   description = "ARN of the policy that is used to set the permissions boundary for the autoscale IAM role."
 }
+
+variable "state_parameter_name" {
+  type        = string
+  description = "Name of the SSM Parameter Store parameter to persist the autoscaler state in, required by some of its features. The autoscaler creates it on the first run."
+  nullable    = true
+  default     = null
+}
@@ -2,11 +2,16 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 )
 
@@ -14,19 +19,62 @@ import (
 type ControllerInterface interface {
 	DescribeInstances(ctx context.Context, instanceIDs []string) (instances []ec2types.Instance, err error)
 	GetAutoscalingGroup(ctx context.Context) (out *autoscalingtypes.AutoScalingGroup, err error)
+	GetInstanceQuota(ctx context.Context) (quota int, err error)
+	GetScalingPolicies(ctx context.Context) (names []string, err error)
+	InstanceRefreshInProgress(ctx context.Context) (inProgress bool, err error)
 	GetWorkerPool(ctx context.Context) (out *WorkerPool, err error)
+	GetWorkerPoolSummary(ctx context.Context) (out *WorkerPool, err error)
 	DrainWorker(ctx context.Context, workerID string) (drained bool, err error)
+	ForceDrainWorker(ctx context.Context, workerID string) (err error)
+	UndrainWorker(ctx context.Context, workerID string) (err error)
 	KillInstance(ctx context.Context, instanceID string) (err error)
 	ScaleUpASG(ctx context.Context, desiredCapacity int32) (err error)
+	SetMinSize(ctx context.Context, minSize int32) (err error)
+	StartInstanceRefresh(ctx context.Context) (started bool, err error)
+	TagLaunchCohort(ctx context.Context, cohort string) (err error)
+	LoadState(ctx context.Context) (out *PersistedState, err error)
+	SaveState(ctx context.Context, state *PersistedState) (err error)
+	CheckCredentials(ctx context.Context, buffer time.Duration) (fresh bool, err error)
+	APICalls() int
 }
 
+// Approximate number of API calls required to handle a single stray instance
+// (describe, detach and terminate), and to scale down a single worker (drain,
+// possibly undrain, detach and terminate).
+const (
+	strayInstanceAPICalls = 3
+	scaleDownAPICalls     = 4
+)
+
+// Approximate number of API calls required to recycle a single expired
+// instance (describe, scale down and scale back up).
+const recycleAPICalls = scaleDownAPICalls + 2
+
 type AutoScaler struct {
 	controller ControllerInterface
 	logger     *slog.Logger
+	notifiers  []Notifier
+	queueDepth QueueDepthSource
+
+	utilization UtilizationSource
+}
+
+func NewAutoScaler(controller ControllerInterface, logger *slog.Logger, notifiers ...Notifier) *AutoScaler {
+	return &AutoScaler{controller: controller, logger: logger, notifiers: notifiers}
+}
+
+// WithQueueDepthSource makes the autoscaler take the depth of an external
+// queue into account when deciding how many workers are needed.
+func (s *AutoScaler) WithQueueDepthSource(source QueueDepthSource) *AutoScaler {
+	s.queueDepth = source
+	return s
 }
 
-func NewAutoScaler(controller ControllerInterface, logger *slog.Logger) *AutoScaler {
-	return &AutoScaler{controller: controller, logger: logger}
+// WithUtilizationSource makes the autoscaler take the utilization of the
+// workers into account when deciding whether to scale up.
+func (s *AutoScaler) WithUtilizationSource(source UtilizationSource) *AutoScaler {
+	s.utilization = source
+	return s
 }
 
 func (s AutoScaler) Scale(ctx context.Context, cfg RuntimeConfig) error {
@@ -35,24 +83,218 @@ func (s AutoScaler) Scale(ctx context.Context, cfg RuntimeConfig) error {
 		"worker_pool_id", cfg.SpaceliftWorkerPoolID,
 	)
 
+	result := NewRunResult(cfg)
+
+	// Persisting the state between invocations is optional, and only needed
+	// by some of the features.
+	persisted := &PersistedState{}
+
+	if cfg.AutoscalingStateParameter != "" {
+		var err error
+
+		if persisted, err = s.controller.LoadState(ctx); err != nil {
+			err = fmt.Errorf("could not load state: %w", err)
+			result.Error = err.Error()
+			s.notify(ctx, logger, result)
+			return err
+		}
+	}
+
+	err := s.scale(ctx, cfg, logger, result, persisted)
+
+	if cfg.AutoscalingThrottleBackoff > 0 {
+		if persisted.ObserveThrottling(IsThrottlingError(err), time.Now(), cfg.AutoscalingThrottleThreshold, cfg.AutoscalingThrottleBackoff) {
+			logger.With("backoff", cfg.AutoscalingThrottleBackoff).Warn("API throttling in consecutive invocations, backing off")
+		}
+	}
+
+	if cfg.AutoscalingStateParameter != "" {
+		if saveErr := s.controller.SaveState(ctx, persisted); saveErr != nil && err == nil {
+			err = fmt.Errorf("could not save state: %w", saveErr)
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+
+		if class := SpaceliftErrorClass(err); class != "unknown" {
+			result.ErrorClass = class
+		}
+	}
+
+	recordDecision(ctx, result.Decision)
+	s.notify(ctx, logger, result)
+
+	return err
+}
+
+// recordDecision adds the final scaling decision to the X-Ray trace, so that
+// the trace explains the whole invocation. The direction and size are also
+// annotations, so that the traces can be searched by them.
+func recordDecision(ctx context.Context, decision Decision) {
+	xray.AddAnnotation(ctx, "decision_direction", decision.ScalingDirection.String())
+	xray.AddAnnotation(ctx, "decision_size", decision.ScalingSize)
+	xray.AddMetadata(ctx, "decision", decision)
+}
+
+// notify sends the result of the run to all the notifiers.
+func (s AutoScaler) notify(ctx context.Context, logger *slog.Logger, result *RunResult) {
+	// Notifications are best-effort, and should never fail the run.
+	for _, notifier := range s.notifiers {
+		if notifyErr := notifier.Notify(ctx, result); notifyErr != nil {
+			logger.With("msg", notifyErr.Error()).Warn("could not send run result notification")
+		}
+	}
+}
+
+func (s AutoScaler) scale(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, result *RunResult, persisted *PersistedState) error {
+	// A run started with credentials about to expire could fail half-way
+	// through, leaving for example a worker drained with its instance still
+	// running. It's safer to wait for the next invocation.
+	if cfg.CloudCredentialsExpiryBuffer > 0 {
+		fresh, err := s.controller.CheckCredentials(ctx, cfg.CloudCredentialsExpiryBuffer)
+		if err != nil {
+			return fmt.Errorf("could not check cloud credentials: %w", err)
+		}
+
+		if !fresh {
+			logger.With("buffer", cfg.CloudCredentialsExpiryBuffer).Warn("cloud credentials expire within the buffer and could not be refreshed, skipping scaling")
+			xray.AddAnnotation(ctx, "credentials_near_expiry", true)
+
+			result.Decision = Decision{
+				ScalingDirection: ScalingDirectionNone,
+				Comments:         []string{"cloud credentials about to expire"},
+				SuppressedBy:     []string{GateCredentialsExpiry},
+			}
+
+			return nil
+		}
+	}
+
+	// After repeated throttling, we only make the calls needed to serve the
+	// runs, and leave the housekeeping for later.
+	backingOff := persisted.BackingOff(time.Now())
+
+	if backingOff {
+		logger.With("until", time.Unix(persisted.Throttle.BackoffUntil, 0)).Warn("backing off after API throttling, skipping non-essential work")
+		xray.AddAnnotation(ctx, "throttle_backoff", true)
+	}
+
+	if cfg.AutoscalingCheckASGPolicies != "" && !backingOff {
+		policies, err := s.controller.GetScalingPolicies(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get scaling policies: %w", err)
+		}
+
+		if len(policies) > 0 {
+			logger := logger.With("policies", policies)
+
+			if cfg.AutoscalingCheckASGPolicies == ASGPolicyCheckRefuse {
+				logger.Error("ASG has scaling policies which conflict with the autoscaler, refusing to run")
+				return fmt.Errorf("ASG has %d conflicting scaling policies", len(policies))
+			}
+
+			logger.Warn("ASG has scaling policies which may conflict with the autoscaler")
+		}
+	}
+
+	// Scaling up only needs the worker counts, so for large pools we can
+	// save Spacelift the trouble of returning the full worker details. The
+	// soft drain needs them to find the workers to drain though.
+	if cfg.AutoscalingSummaryScaleUp && !cfg.AutoscalingSoftDrain {
+		if handled, err := s.scaleUpFromSummary(ctx, cfg, logger, result, persisted); handled || err != nil {
+			return err
+		}
+	}
+
 	workerPool, err := s.controller.GetWorkerPool(ctx)
 	if err != nil {
+		xray.AddAnnotation(ctx, "spacelift_error", SpaceliftErrorClass(err))
+
+		if cfg.AutoscalingPoolCacheStaleness > 0 && IsTimeout(err) {
+			if handled, cacheErr := s.scaleUpFromCache(ctx, cfg, logger, result, persisted); handled {
+				return cacheErr
+			}
+		}
+
 		return fmt.Errorf("could not get worker pool: %w", err)
 	}
 
+	if cfg.AutoscalingPoolCacheStaleness > 0 {
+		persisted.CachePool(cfg, workerPool, time.Now())
+	}
+
+	if workerPool.AutoscalingDisabled() {
+		s.skipDisabled(ctx, logger, result)
+		return nil
+	}
+
+	s.warnAboutUnknownMetadataVersions(logger, workerPool)
+
 	asg, err := s.controller.GetAutoscalingGroup(ctx)
 	if err != nil {
 		return fmt.Errorf("could not get autoscaling group: %w", err)
 	}
 
-	state, err := NewState(workerPool, asg)
+	state, err := NewState(workerPool, asg, cfg.MetadataKeys())
 	if err != nil {
 		return fmt.Errorf("could not create state: %w", err)
 	}
 
+	state.HeartbeatStaleness = cfg.AutoscalingHeartbeatStaleness
+	state.Bounds = ActiveBounds(cfg, time.Now())
+
+	if cfg.AutoscalingScaleDownDelayAnchor == ScaleDownDelayAnchorIdle {
+		state.LastBusy = persisted.LastBusy
+		persisted.ObserveBusyWorkers(workerPool.Workers, time.Now(), time.Duration(cfg.AutoscalingScaleDownDelay)*time.Minute)
+	}
+
+	if stale := len(state.StaleWorkers()); stale > 0 {
+		logger.With("workers", stale).Warn("found workers with stale heartbeats, treating them as dead")
+		xray.AddAnnotation(ctx, "stale_workers", stale)
+	}
+
+	if skipped := state.InstancesWithoutID(); skipped > 0 {
+		logger.With("instances", skipped).Debug("skipped ASG instances without an ID")
+	}
+
+	s.addHints(ctx, cfg, logger, state)
+
+	if err := s.applyEmergencyFloor(ctx, cfg, logger, persisted, state, time.Now()); err != nil {
+		return err
+	}
+
+	s.warnAboutASG(ctx, logger, state)
+
+	// A growing number of these indicates a systemic problem with terminating
+	// instances, like missing IAM permissions.
+	detachedNotTerminated := len(state.DetachedNotTerminatedInstances())
+	xray.AddAnnotation(ctx, "detached_not_terminated", detachedNotTerminated)
+
+	if detachedNotTerminated > 0 {
+		logger.With("detached_not_terminated", detachedNotTerminated).Warn("found instances detached from the ASG but not terminated")
+	}
+
 	// Let's make sure that for each of the in-service instances we have a
 	// corresponding worker in Spacelift, or that we have "stray" machines.
-	if strayInstances := state.StrayInstances(); len(strayInstances) > 0 {
+	strayInstances := state.StrayInstances()
+
+	if len(strayInstances) > 0 && cfg.AutoscalingMaxStrayPercent > 0 && state.StraysExceedPercent(strayInstances, cfg.AutoscalingMaxStrayPercent) {
+		// Rather than risking a mass termination, let's leave it to a human
+		// to figure out what's going on.
+		logger.With(
+			"instances", len(strayInstances),
+			"max_stray_percent", cfg.AutoscalingMaxStrayPercent,
+		).Error("too many instances classified as strays, refusing to terminate any")
+
+		xray.AddAnnotation(ctx, "stray_safety_abort", true)
+	} else if len(strayInstances) > 0 && state.ProcessSuspended(ProcessTerminate) {
+		logger.With("instances", len(strayInstances)).Info("deferring stray instance handling while the Terminate process is suspended in the ASG")
+	} else if len(strayInstances) > 0 && backingOff {
+		logger.With("instances", len(strayInstances)).Info("deferring stray instance handling until the throttling backoff is over")
+	} else if len(strayInstances) > 0 && s.apiCallBudgetExceeded(logger, cfg, strayInstanceAPICalls) {
+		logger.Warn("deferring stray instance handling to the next invocation")
+	} else if len(strayInstances) > 0 {
 		// There's a question of what to do with the "stray" machines. The
 		// decision will be made based on the creation timestamp.
 		instances, err := s.controller.DescribeInstances(ctx, strayInstances)
@@ -69,6 +311,12 @@ func (s AutoScaler) Scale(ctx context.Context, cfg RuntimeConfig) error {
 				"instance_age", instanceAge,
 			)
 
+			// Protected instances are left alone, whatever their age.
+			if tag, ok := cfg.ProtectionTag(); ok && tag.Protects(instance.Tags) {
+				logger.With("tag", tag.String()).Warn("instance has no corresponding worker in Spacelift, but is protected from termination")
+				continue
+			}
+
 			// If the machine was only created recently (say a generous window of 10
 			// minutes), it is possible that it hasn't managed to register itself with
 			// Spacelift yet. But if it's been around for a while we will want to kill
@@ -80,62 +328,1474 @@ func (s AutoScaler) Scale(ctx context.Context, cfg RuntimeConfig) error {
 					return fmt.Errorf("could not kill instance: %w", err)
 				}
 
+				result.KilledInstances = append(result.KilledInstances, *instance.InstanceId)
+				result.StraysKilled++
+
+				// An instance we launched which never registered is a failed
+				// attempt at covering the deficit.
+				if cfg.AutoscalingLaunchRetryBudget > 0 && persisted.ObserveFailedLaunch(*instance.LaunchTime) {
+					logger.With(
+						"failed_launches", persisted.LaunchCohort.Failed,
+						"budget", cfg.AutoscalingLaunchRetryBudget,
+					).Warn("instance launched to cover the deficit failed to register as a worker")
+				}
+
 				// We don't want to kill too many instances at once, so let's
 				// return after the first successfully killed one.
 				logger.Info("instance successfully removed from the ASG and terminated")
 
-				return nil
+				if !cfg.AutoscalingContinueAfterStrayCleanup {
+					result.Decision = strayCleanupDecision()
+					return nil
+				}
+
+				// Scaling is only safe once the rest of the ASG matches the
+				// worker pool again.
+				state.RemoveInstance(*instance.InstanceId)
+
+				if remaining := len(state.StrayInstances()); remaining > 0 {
+					logger.With("instances", remaining).Info("stray instances left, deferring scaling to the next invocation")
+					result.Decision = strayCleanupDecision()
+					return nil
+				}
+
+				logger.Info("stray instances cleaned up, continuing to the scaling decision")
+
+				break
+			}
+		}
+	}
+
+	// While winding the pool down, none of the regular scaling applies.
+	if cfg.AutoscalingSoftDrain {
+		return s.softDrain(ctx, cfg, logger, state, result)
+	}
+
+	// Rather than recycling outdated instances ourselves, we can let AWS
+	// replace them gracefully. Whether to scale during the refresh is then up
+	// to the same setting as for the refreshes started by anyone else.
+	if outdated := state.OutdatedInstances(); cfg.AutoscalingUseInstanceRefresh && len(outdated) > 0 && !backingOff {
+		logger := logger.With("outdated_instances", len(outdated))
+
+		inProgress, err := s.controller.InstanceRefreshInProgress(ctx)
+		if err != nil {
+			return fmt.Errorf("could not check for instance refreshes: %w", err)
+		}
+
+		if inProgress {
+			logger.Info("instance refresh already in progress")
+		} else {
+			started, err := s.controller.StartInstanceRefresh(ctx)
+			if err != nil {
+				return fmt.Errorf("could not start instance refresh: %w", err)
+			}
+
+			if started {
+				logger.Info("started instance refresh to replace outdated instances")
+			} else {
+				logger.Info("instance refresh already in progress")
 			}
 		}
 	}
 
-	decision := state.Decide(cfg.AutoscalingMaxCreate, cfg.AutoscalingMaxKill)
+	// After a panic scale-up, the workers added in a hurry are the first ones
+	// to go, until the pool is back to its size from before the panic.
+	if cfg.AutoscalingPanicThreshold > 0 && persisted.Panic != nil {
+		if len(workerPool.Workers) <= persisted.Panic.Baseline {
+			logger.With("baseline", persisted.Panic.Baseline).Info("pool returned to its baseline after a panic scale-up")
+			persisted.Panic = nil
+		} else {
+			state.ReclaimNewestFirst = true
+		}
+	}
+
+	// Workers recorded for a two-phase scale-down which has since been turned
+	// off are simply left drained, and handled like any other such workers.
+	if cfg.AutoscalingTwoPhaseScaleDown {
+		state.PendingTermination = persisted.PendingTerminationSet()
+	} else {
+		persisted.PendingTermination = nil
+	}
+
+	leftDrained := state.LeftDrainedWorkers()
+	if len(leftDrained) > 0 {
+		logger.With("workers", len(leftDrained)).Warn("found drained workers with instances still in service")
+	}
+
+	if err := s.handleGhostWorkers(ctx, cfg, logger, state, result); err != nil {
+		return err
+	}
+
+	if cfg.AutoscalingSafeModeDecay > 0 {
+		persisted.ObservePeak(len(workerPool.Workers), time.Now(), cfg.AutoscalingSafeModeDecay)
+	}
+
+	decision, effective, err := s.decide(ctx, cfg, logger, persisted, state)
+	if err != nil {
+		return err
+	}
+
+	result.Decision = decision
+	result.EffectiveConfig = &effective
+
+	// Once the deficit is covered, or gone, the next one gets a fresh budget.
+	// Right after a scale-up the new instances haven't registered yet, which
+	// keeps us from scaling, so that alone doesn't mean we've caught up.
+	if decision.ScalingDirection != ScalingDirectionUp && state.CaughtUp(cfg) {
+		persisted.LaunchCohort = nil
+	}
+
+	// A decision to scale ends the streak of identical decisions not to.
+	if decision.ScalingDirection != ScalingDirectionNone {
+		persisted.NoOp = nil
+	}
+
+	// The workers drained by the previous invocation are either terminated,
+	// or put back to work if they're needed after all. Terminating them
+	// changes the pool under the decision, so unless we're scaling up, the
+	// rest of it has to wait for the next invocation.
+	if len(persisted.PendingTermination) > 0 {
+		if err := s.finishScaleDown(ctx, cfg, logger, state, persisted, &decision, result); err != nil {
+			return err
+		}
+
+		result.Decision.ScalingSize = decision.ScalingSize
+
+		if decision.ScalingDirection != ScalingDirectionUp || decision.ScalingSize == 0 {
+			return nil
+		}
+	}
+
+	// Workers left drained by a failed undrain are the cheapest capacity we
+	// can get, so let's bring them back before launching new instances.
+	if decision.ScalingDirection == ScalingDirectionUp && cfg.AutoscalingRecoverDrainedWorkers {
+		for _, worker := range leftDrained {
+			if decision.ScalingSize == 0 {
+				break
+			}
+
+			logger := logger.With("worker_id", worker.ID)
+
+			if err := s.controller.UndrainWorker(ctx, worker.ID); err != nil {
+				return fmt.Errorf("could not undrain worker: %w", err)
+			}
+
+			logger.Info("undrained a worker left drained by a previous run")
+			decision.ScalingSize--
+		}
+
+		result.Decision.ScalingSize = decision.ScalingSize
+
+		if decision.ScalingSize == 0 {
+			return nil
+		}
+	}
 
 	if decision.ScalingDirection == ScalingDirectionNone {
-		logger.Info("no scaling decision to be made")
+		s.logNoOp(cfg, logger, persisted, decision)
+
+		if cfg.AutoscalingMaxInstanceLifetime > 0 && !backingOff && !state.ProcessSuspended(ProcessTerminate) {
+			return s.recycleExpiredInstance(ctx, cfg, logger, state, result)
+		}
+
 		return nil
 	}
 
 	if decision.ScalingDirection == ScalingDirectionUp {
-		logger.With("instances", decision.ScalingSize).Info("scaling up the ASG")
+		return s.scaleUp(ctx, cfg, logger, persisted, state, decision, result)
+	}
+
+	// If we got this far, we're scaling down.
+	if err := s.scaleDown(ctx, cfg, logger, persisted, state, decision, result); err != nil {
+		return err
+	}
+
+	// Instances terminated by someone else in the meantime could have taken
+	// the ASG below its minimum size along with ours.
+	if cfg.AutoscalingVerifyMinSize && len(result.KilledInstances) > 0 {
+		return s.verifyMinSize(ctx, logger)
+	}
+
+	return nil
+}
+
+// decide makes the scaling decision for the state, and runs it through the
+// gates which apply to it regardless of how the state was obtained: safe mode,
+// the suspended processes, instance refreshes and the decision hook.
+func (s AutoScaler) decide(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, state *State) (decision Decision, effective EffectiveConfig, err error) {
+	now := time.Now()
+	offHours := s.outsideBusinessHours(cfg, now)
 
-		if err := s.controller.ScaleUpASG(ctx, *asg.DesiredCapacity+int32(decision.ScalingSize)); err != nil {
-			return fmt.Errorf("could not scale up ASG: %w", err)
+	// The soft drain takes over from the regular scaling, see softDrain.
+	if cfg.AutoscalingSoftDrain {
+		return softDrainDecision(), state.EffectiveConfig(cfg, now, offHours), nil
+	}
+
+	// The integral takes the distance up to and including this run.
+	if cfg.AutoscalingTargetBusyPercent > 0 && cfg.AutoscalingTargetBusyIntegralGain > 0 && !offHours {
+		maxSize, _ := state.maxSize()
+		persisted.ObserveBusySetpointError(state.BusySetpointError(cfg), float64(maxSize))
+		state.BusySetpointIntegral = persisted.BusySetpointIntegral
+	}
+
+	// Outside of business hours the pool is held steady, but the cleanup
+	// before the decision still happens.
+	if offHours {
+		decision = state.DecideOffHours(cfg)
+	} else {
+		decision = state.Decide(cfg)
+	}
+
+	effective = state.EffectiveConfig(cfg, now, offHours)
+
+	if mismatched, transient := state.Mismatch(); mismatched {
+		logger := logger.With("workers", len(state.WorkerPool.Workers), "instances", len(state.ASG.Instances))
+
+		if transient {
+			logger.Info("number of workers does not match the number of instances while instances are changing state")
+		} else {
+			logger.Warn("number of workers does not match the number of instances with all instances in a steady state")
 		}
+	}
 
-		return nil
+	if cfg.AutoscalingSafeModeDecay > 0 {
+		effective.SafeModeFloor = persisted.PeakFloor(now, cfg.AutoscalingSafeModeMargin, cfg.AutoscalingSafeModeDecay)
 	}
 
-	// If we got this far, we're scaling down.
+	logger.With("effective_config", effective).Info("effective scaling parameters")
+
+	// In safe mode, we don't want to scale down below the recent peak (minus
+	// the margin) until the lull has lasted for a while.
+	if decision.ScalingDirection == ScalingDirectionDown && cfg.AutoscalingSafeModeDecay > 0 {
+		floor := effective.SafeModeFloor
+
+		if allowed := len(state.WorkerPool.Workers) - floor; allowed < decision.ScalingSize {
+			logger.With("floor", floor, "peak", persisted.Peak.Workers).Info("safe mode limits scaling down below the recent peak")
+
+			if allowed <= 0 {
+				decision = Decision{
+					ScalingDirection: ScalingDirectionNone,
+					Comments:         append(decision.Comments, "safe mode prevents scaling down below the recent peak"),
+					SuppressedBy:     []string{GateSafeMode},
+				}
+			} else {
+				decision.ScalingSize = allowed
+			}
+		}
+	}
+
+	decision = s.holdForLaunchRetryBudget(ctx, cfg, logger, persisted, decision)
+	decision = s.holdForSuspendedProcess(ctx, logger, state, decision)
+
+	if decision, err = s.holdForInstanceRefresh(ctx, cfg, logger, decision); err != nil {
+		return decision, effective, err
+	}
+
+	decision = s.consultDecisionHook(ctx, cfg, logger, state, effective, decision)
+
+	return decision, effective, nil
+}
+
+// warnAboutASG reports the problems with the ASG which the autoscaler can't
+// fix, but which keep it from scaling the pool as intended.
+func (s AutoScaler) warnAboutASG(ctx context.Context, logger *slog.Logger, state *State) {
+	asg := state.ASG
+
+	// The ASG settings were likely changed under a running pool. Until they're
+	// fixed, the pool can't grow and shrinks in ways nobody asked for.
+	if state.MaxSizeBelowWorkers() {
+		logger.With(
+			"workers", len(state.WorkerPool.Workers),
+			"max_size", *asg.MaxSize,
+		).Warn("ASG maximum size is below the current number of workers, consider increasing the maximum size")
+
+		xray.AddAnnotation(ctx, "max_size_below_workers", true)
+	}
+
+	// This is not something we can fix, but it's something a human should
+	// definitely look into.
+	if state.IsDeadlocked() {
+		logger.With(
+			"pending_runs", state.WorkerPool.PendingRuns,
+			"instances", len(asg.Instances),
+			"max_size", *asg.MaxSize,
+		).Error("ASG is at maximum size with pending runs and instances not in service, no scaling is possible")
+
+		xray.AddAnnotation(ctx, "scaling_deadlock", true)
+	}
+}
+
+// scaleDown drains the idle workers picked for removal and terminates their
+// instances, stopping at the first worker which turns out to be busy.
+func (s AutoScaler) scaleDown(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, state *State, decision Decision, result *RunResult) error {
 	logger.With("instances", decision.ScalingSize).Info("scaling down ASG")
 
-	idleWorkers := state.IdleWorkers()
+	protected, err := s.protectedInstances(ctx, cfg, state.WorkerPool.Workers)
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < decision.ScalingSize; i++ {
-		worker := idleWorkers[i]
+	state.ProtectedInstances = protected
 
-		_, instanceID, _ := worker.InstanceIdentity()
+	candidates := state.ScaleDownCandidates(decision.ScalingSize, cfg)
 
-		logger := logger.With(
-			"worker_id", worker.ID,
-			"instance_id", instanceID,
-		)
-		logger.Info("scaling down ASG and killing worker")
+	if len(candidates) < decision.ScalingSize {
+		logger.With("candidates", len(candidates)).Info("not enough workers can be removed at this point")
+	}
 
-		drained, err := s.controller.DrainWorker(ctx, worker.ID)
-		if err != nil {
-			return fmt.Errorf("could not drain worker: %w", err)
+	// Workers are drained in batches of concurrent drains. Once a batch turns
+	// up a busy worker, the newer ones are likely busy too, so we stop there.
+	batchSize := cfg.AutoscalingMaxConcurrentDrains
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(candidates); start += batchSize {
+		end := start + batchSize
+		if end > len(candidates) {
+			end = len(candidates)
 		}
 
-		if !drained {
-			logger.Warn("worker was busy, stopping the scaling down process")
+		batch := candidates[start:end]
+
+		if s.apiCallBudgetExceeded(logger, cfg, scaleDownAPICalls*len(batch)) {
+			logger.With("remaining", len(candidates)-start).Warn("deferring the rest of the scale-down to the next invocation")
 			return nil
 		}
 
-		if err := s.controller.KillInstance(ctx, string(instanceID)); err != nil {
-			return fmt.Errorf("could not kill instance: %w", err)
+		for _, worker := range batch {
+			_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+			logger.With("worker_id", worker.ID, "instance_id", instanceID).Info("scaling down ASG and killing worker")
+		}
+
+		drained, drainErrs := s.drainWorkers(ctx, batch, batchSize)
+
+		// With a two-phase scale-down, the drains are confirmed in the next
+		// invocation, right before the termination.
+		var confirmed map[string]struct{}
+
+		if !cfg.AutoscalingTwoPhaseScaleDown {
+			if confirmed, err = s.confirmDrains(ctx, cfg, batch, drained); err != nil {
+				return err
+			}
+		}
+
+		var drainErr error
+		var busy bool
+
+		// The instances of the workers drained in this batch are terminated
+		// even if some of the other drains failed, so that they're not left
+		// drained.
+		for i, worker := range batch {
+			_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+
+			logger := logger.With(
+				"worker_id", worker.ID,
+				"instance_id", instanceID,
+			)
+
+			if drainErrs[i] != nil {
+				if drainErr == nil {
+					drainErr = fmt.Errorf("could not drain worker: %w", drainErrs[i])
+				}
+
+				continue
+			}
+
+			if !drained[i] {
+				logger.Warn("worker was busy, stopping the scaling down process")
+				busy = true
+
+				continue
+			}
+
+			result.DrainedWorkers = append(result.DrainedWorkers, worker.ID)
+
+			if cfg.AutoscalingTwoPhaseScaleDown {
+				logger.Info("worker drained, its instance will be terminated in the next invocation")
+				persisted.PendingTermination = append(persisted.PendingTermination, worker.ID)
+				continue
+			}
+
+			if _, ok := confirmed[worker.ID]; confirmed != nil && !ok {
+				logger.Warn("worker drain not yet reflected by Spacelift, skipping the termination")
+				continue
+			}
+
+			if err := s.controller.KillInstance(ctx, string(instanceID)); err != nil {
+				return fmt.Errorf("could not kill instance: %w", err)
+			}
+
+			result.KilledInstances = append(result.KilledInstances, string(instanceID))
+		}
+
+		if drainErr != nil {
+			return drainErr
+		}
+
+		if busy {
+			return nil
 		}
 	}
 
 	return nil
 }
+
+// confirmDrains reads the workers again right before their instances are
+// terminated, if configured to. Spacelift may still report a worker which has
+// just been drained as undrained, or as busy, until the drain propagates. It
+// returns the IDs of the drained workers which are confirmed to be drained and
+// idle, or nil if the drains need no confirmation.
+func (s AutoScaler) confirmDrains(ctx context.Context, cfg RuntimeConfig, workers []Worker, drained []bool) (map[string]struct{}, error) {
+	if !cfg.AutoscalingConfirmDrains || !slices.Contains(drained, true) {
+		return nil, nil
+	}
+
+	pool, err := s.controller.GetWorkerPoolSummary(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not confirm worker drains: %w", err)
+	}
+
+	fresh := make(map[string]Worker, len(pool.Workers))
+	for _, worker := range pool.Workers {
+		fresh[worker.ID] = worker
+	}
+
+	confirmed := make(map[string]struct{})
+
+	for i, worker := range workers {
+		if !drained[i] {
+			continue
+		}
+
+		if current, ok := fresh[worker.ID]; ok && current.Drained && !current.Busy {
+			confirmed[worker.ID] = struct{}{}
+		}
+	}
+
+	return confirmed, nil
+}
+
+// drainWorkers drains the workers, with at most limit drains in flight at the
+// same time. It returns the outcome of each drain, in the order of the workers.
+func (s AutoScaler) drainWorkers(ctx context.Context, workers []Worker, limit int) (drained []bool, errs []error) {
+	drained = make([]bool, len(workers))
+	errs = make([]error, len(workers))
+
+	semaphore := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+
+	for i, worker := range workers {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int, workerID string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			drained[i], errs[i] = s.controller.DrainWorker(ctx, workerID)
+		}(i, worker.ID)
+	}
+
+	wg.Wait()
+
+	return drained, errs
+}
+
+// verifyMinSize re-reads the ASG after a scale-down, and brings the desired
+// capacity back up to the minimum size if it dropped below it.
+func (s AutoScaler) verifyMinSize(ctx context.Context, logger *slog.Logger) error {
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return fmt.Errorf("could not verify ASG minimum size: %w", err)
+	}
+
+	if *asg.DesiredCapacity >= *asg.MinSize {
+		return nil
+	}
+
+	logger.With(
+		"desired_capacity", *asg.DesiredCapacity,
+		"min_size", *asg.MinSize,
+	).Warn("desired capacity dropped below the ASG minimum size, correcting")
+
+	if err := s.controller.ScaleUpASG(ctx, *asg.MinSize); err != nil {
+		return fmt.Errorf("could not restore ASG minimum size: %w", err)
+	}
+
+	return nil
+}
+
+// finishScaleDown handles the workers drained by the previous invocation of a
+// two-phase scale-down. Those still idle and drained have their instances
+// terminated, unless we're scaling up, in which case they're undrained to
+// make up for some of the new capacity. Those which were undrained or picked
+// up work in the meantime are left alone.
+func (s AutoScaler) finishScaleDown(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State, persisted *PersistedState, decision *Decision, result *RunResult) error {
+	workersByID := make(map[string]Worker, len(state.WorkerPool.Workers))
+	for _, worker := range state.WorkerPool.Workers {
+		workersByID[worker.ID] = worker
+	}
+
+	pending := persisted.PendingTermination
+
+	var pendingWorkers []Worker
+	for _, workerID := range pending {
+		if worker, ok := workersByID[workerID]; ok {
+			pendingWorkers = append(pendingWorkers, worker)
+		}
+	}
+
+	protected, err := s.protectedInstances(ctx, cfg, pendingWorkers)
+	if err != nil {
+		return err
+	}
+
+	var confirmed map[string]struct{}
+
+	if decision.ScalingDirection != ScalingDirectionUp {
+		drained := make([]bool, len(pendingWorkers))
+		for i, worker := range pendingWorkers {
+			drained[i] = worker.Drained && !worker.Busy
+		}
+
+		if confirmed, err = s.confirmDrains(ctx, cfg, pendingWorkers, drained); err != nil {
+			return err
+		}
+	}
+
+	for i, workerID := range pending {
+		logger := logger.With("worker_id", workerID)
+
+		worker, ok := workersByID[workerID]
+		if !ok {
+			logger.Info("worker pending termination is no longer in the pool")
+			continue
+		}
+
+		if worker.Busy || !worker.Drained {
+			logger.Warn("worker pending termination is no longer idle and drained, keeping it")
+			continue
+		}
+
+		if decision.ScalingDirection == ScalingDirectionUp && decision.ScalingSize > 0 {
+			if err := s.controller.UndrainWorker(ctx, worker.ID); err != nil {
+				persisted.PendingTermination = pending[i:]
+				return fmt.Errorf("could not undrain worker: %w", err)
+			}
+
+			logger.Info("undrained a worker pending termination, since it's needed after all")
+			decision.ScalingSize--
+
+			continue
+		}
+
+		if s.apiCallBudgetExceeded(logger, cfg, scaleDownAPICalls) {
+			logger.With("remaining", len(pending)-i).Warn("deferring the rest of the terminations to the next invocation")
+			persisted.PendingTermination = pending[i:]
+
+			return nil
+		}
+
+		_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+		logger = logger.With("instance_id", instanceID)
+
+		// The tag may have been added since the worker was drained, so let's
+		// put it back to work rather than leave it drained.
+		if _, ok := protected[instanceID]; ok {
+			if err := s.controller.UndrainWorker(ctx, worker.ID); err != nil {
+				persisted.PendingTermination = pending[i:]
+				return fmt.Errorf("could not undrain worker: %w", err)
+			}
+
+			logger.Warn("instance of a worker pending termination is protected from termination, undrained the worker")
+
+			continue
+		}
+
+		if _, ok := confirmed[worker.ID]; confirmed != nil && !ok {
+			logger.Warn("worker pending termination is no longer reported as idle and drained, keeping it")
+			continue
+		}
+
+		if err := s.controller.KillInstance(ctx, string(instanceID)); err != nil {
+			persisted.PendingTermination = pending[i:]
+			return fmt.Errorf("could not kill instance: %w", err)
+		}
+
+		logger.Info("terminated the instance of a worker drained by the previous invocation")
+		result.KilledInstances = append(result.KilledInstances, string(instanceID))
+	}
+
+	persisted.PendingTermination = nil
+
+	return nil
+}
+
+// softDrain drains all the workers in the pool, so that none of them accept new
+// runs, and terminates the instances of those which are done with their runs.
+// Busy workers are left alone, and terminated by a later invocation, so across
+// invocations the pool goes down to the minimum size of the ASG.
+func (s AutoScaler) softDrain(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State, result *RunResult) error {
+	logger.Info("soft drain in progress, winding the pool down")
+	xray.AddAnnotation(ctx, "soft_drain", true)
+
+	removable := int(*state.ASG.DesiredCapacity) - int(*state.ASG.MinSize)
+	var busy int
+
+	protected, err := s.protectedInstances(ctx, cfg, state.WorkerPool.Workers)
+	if err != nil {
+		return err
+	}
+
+	for i, worker := range state.WorkerPool.Workers {
+		logger := logger.With("worker_id", worker.ID)
+
+		if !worker.Drained {
+			if err := s.controller.ForceDrainWorker(ctx, worker.ID); err != nil {
+				return fmt.Errorf("could not drain worker: %w", err)
+			}
+
+			logger.Info("drained worker for the soft drain")
+			result.DrainedWorkers = append(result.DrainedWorkers, worker.ID)
+		}
+
+		if worker.Busy {
+			busy++
+			continue
+		}
+
+		if removable <= 0 {
+			logger.Warn("can't terminate any more instances without going below the minimum size of the ASG")
+			break
+		}
+
+		if s.apiCallBudgetExceeded(logger, cfg, scaleDownAPICalls) {
+			logger.With("remaining", len(state.WorkerPool.Workers)-i).Warn("deferring the rest of the soft drain to the next invocation")
+			break
+		}
+
+		_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+
+		if _, ok := protected[instanceID]; ok {
+			logger.With("instance_id", instanceID).Warn("instance is protected from termination, leaving it for the soft drain")
+			continue
+		}
+
+		if err := s.controller.KillInstance(ctx, string(instanceID)); err != nil {
+			return fmt.Errorf("could not kill instance: %w", err)
+		}
+
+		logger.With("instance_id", instanceID).Info("terminated the instance of an idle worker for the soft drain")
+		result.KilledInstances = append(result.KilledInstances, string(instanceID))
+		removable--
+	}
+
+	result.Decision = softDrainDecision()
+
+	if killed := len(result.KilledInstances); killed > 0 {
+		result.Decision = Decision{
+			ScalingDirection: ScalingDirectionDown,
+			ScalingSize:      killed,
+			Comments:         []string{"soft drain in progress", "removing idle drained workers"},
+		}
+	}
+
+	if remaining := len(state.WorkerPool.Workers) - len(result.KilledInstances); remaining == 0 {
+		logger.Info("soft drain complete, the pool is empty")
+	} else {
+		logger.With("remaining", remaining, "busy", busy).Info("soft drain waiting for workers to finish their runs")
+	}
+
+	return nil
+}
+
+// scaleUpFromSummary decides on scaling up based on a lightweight summary of
+// the worker pool. It only handles the run if a scale-up is needed, and the
+// number of workers matches the number of instances - the summary can't tell
+// which instances are strays. Instance refresh, drained worker recovery and
+// finishing a two-phase scale-down need the full worker details too, so runs
+// with any of these pending fall back to them. Once the summary calls for a
+// scale-up, the decision goes through the same gates as with the full worker
+// details, except that a decision to scale down is not acted on.
+func (s AutoScaler) scaleUpFromSummary(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, result *RunResult, persisted *PersistedState) (handled bool, err error) {
+	if cfg.AutoscalingSoftDrain || s.outsideBusinessHours(cfg, time.Now()) || len(persisted.PendingTermination) > 0 {
+		return false, nil
+	}
+
+	workerPool, err := s.controller.GetWorkerPoolSummary(ctx)
+	if err != nil {
+		xray.AddAnnotation(ctx, "spacelift_error", SpaceliftErrorClass(err))
+		return false, fmt.Errorf("could not get worker pool summary: %w", err)
+	}
+
+	if cfg.AutoscalingPoolCacheStaleness > 0 {
+		persisted.CachePool(cfg, workerPool, time.Now())
+	}
+
+	if workerPool.AutoscalingDisabled() {
+		s.skipDisabled(ctx, logger, result)
+		return true, nil
+	}
+
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return false, fmt.Errorf("could not get autoscaling group: %w", err)
+	}
+
+	// Without the metadata, workers can't be matched to their instances, so
+	// the state only holds the counts.
+	state, err := NewCountsState(workerPool, asg)
+	if err != nil {
+		return false, fmt.Errorf("could not create state: %w", err)
+	}
+
+	state.HeartbeatStaleness = cfg.AutoscalingHeartbeatStaleness
+	state.Bounds = ActiveBounds(cfg, time.Now())
+
+	if len(workerPool.Workers) != len(asg.Instances) {
+		return false, nil
+	}
+
+	if cfg.AutoscalingUseInstanceRefresh && len(state.OutdatedInstances()) > 0 {
+		return false, nil
+	}
+
+	if cfg.AutoscalingRecoverDrainedWorkers {
+		for _, worker := range workerPool.Workers {
+			if worker.Drained && !worker.Busy {
+				return false, nil
+			}
+		}
+	}
+
+	s.addHints(ctx, cfg, logger, state)
+
+	// The full worker details wouldn't change a decision to scale up, so from
+	// here on the run is handled based on the summary.
+	if state.Decide(cfg).ScalingDirection != ScalingDirectionUp {
+		return false, nil
+	}
+
+	if err := s.applyEmergencyFloor(ctx, cfg, logger, persisted, state, time.Now()); err != nil {
+		return true, err
+	}
+
+	s.warnAboutASG(ctx, logger, state)
+
+	if cfg.AutoscalingSafeModeDecay > 0 {
+		persisted.ObservePeak(len(workerPool.Workers), time.Now(), cfg.AutoscalingSafeModeDecay)
+	}
+
+	decision, effective, err := s.decide(ctx, cfg, logger, persisted, state)
+	if err != nil {
+		return true, err
+	}
+
+	result.Decision = decision
+	result.EffectiveConfig = &effective
+
+	switch decision.ScalingDirection {
+	case ScalingDirectionNone:
+		s.logNoOp(cfg, logger, persisted, decision)
+		return true, nil
+	case ScalingDirectionDown:
+		logger.Info("worker pool summary only allows scaling up, leaving the rest to the next invocation")
+
+		result.Decision = Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         append(decision.Comments, "only scaling up based on the worker pool summary"),
+			SuppressedBy:     []string{GatePoolSummary},
+		}
+
+		return true, nil
+	}
+
+	persisted.NoOp = nil
+
+	logger.Debug("scaling up based on the worker pool summary")
+
+	return true, s.scaleUp(ctx, cfg, logger, persisted, state, decision, result)
+}
+
+// scaleUpFromCache decides on scaling based on the cached worker pool state,
+// when the worker pool query timed out. The cached state may be outdated, so
+// only a scale-up is considered, since it can at worst leave some instances
+// idle until the next scale-down. Stray instance cleanup and scaling down are
+// left for an invocation with fresh data. The run is not handled if there's
+// no cached state within the staleness bound.
+func (s AutoScaler) scaleUpFromCache(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, result *RunResult, persisted *PersistedState) (handled bool, err error) {
+	workerPool, age, ok := persisted.CachedPool(time.Now(), cfg.AutoscalingPoolCacheStaleness)
+	if !ok {
+		logger.With("max_staleness", cfg.AutoscalingPoolCacheStaleness).Warn("worker pool query timed out, and there's no fresh cached pool state to fall back to")
+		return false, nil
+	}
+
+	logger = logger.With("cache_age", age)
+	logger.Warn("worker pool query timed out, falling back to the cached pool state")
+	xray.AddAnnotation(ctx, "pool_cache_fallback", true)
+
+	if workerPool.AutoscalingDisabled() {
+		s.skipDisabled(ctx, logger, result)
+		return true, nil
+	}
+
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return true, fmt.Errorf("could not get autoscaling group: %w", err)
+	}
+
+	// Like with the summary, the cached workers can't be matched to their
+	// instances, so the state only holds the counts.
+	state, err := NewCountsState(workerPool, asg)
+	if err != nil {
+		return true, fmt.Errorf("could not create state: %w", err)
+	}
+
+	state.HeartbeatStaleness = cfg.AutoscalingHeartbeatStaleness
+	state.Bounds = ActiveBounds(cfg, time.Now())
+
+	s.addHints(ctx, cfg, logger, state)
+
+	decideCfg := cachedPoolConfig(cfg)
+
+	// Only a scale-up is acted on, so the decision hook isn't consulted about
+	// anything else.
+	decision := state.Decide(decideCfg)
+
+	if decision.ScalingDirection == ScalingDirectionUp {
+		var effective EffectiveConfig
+
+		if decision, effective, err = s.decide(ctx, decideCfg, logger, persisted, state); err != nil {
+			return true, err
+		}
+
+		result.EffectiveConfig = &effective
+	}
+
+	if decision.ScalingDirection != ScalingDirectionUp {
+		logger.Info("cached pool state doesn't call for a scale-up, leaving the rest to the next invocation")
+
+		result.Decision = Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         []string{"worker pool query timed out, only scaling up from the cached pool state"},
+			SuppressedBy:     append([]string{GatePoolCache}, decision.SuppressedBy...),
+		}
+
+		return true, nil
+	}
+
+	persisted.NoOp = nil
+
+	logger.Debug("scaling up based on the cached pool state")
+	result.Decision = decision
+
+	return true, s.scaleUp(ctx, cfg, logger, persisted, state, decision, result)
+}
+
+// protectedInstances returns the IDs of the instances of the given workers
+// which carry the protection tag. Without a protection tag configured, no
+// instances are protected and no API call is made.
+func (s AutoScaler) protectedInstances(ctx context.Context, cfg RuntimeConfig, workers []Worker) (map[InstanceID]struct{}, error) {
+	tag, ok := cfg.ProtectionTag()
+	if !ok {
+		return nil, nil
+	}
+
+	var instanceIDs []string
+
+	for _, worker := range workers {
+		if _, instanceID, err := worker.InstanceIdentity(cfg.MetadataKeys()); err == nil {
+			instanceIDs = append(instanceIDs, string(instanceID))
+		}
+	}
+
+	// Describing no instances would describe all of them.
+	if len(instanceIDs) == 0 {
+		return nil, nil
+	}
+
+	instances, err := s.controller.DescribeInstances(ctx, instanceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("could not list EC2 instances: %w", err)
+	}
+
+	out := make(map[InstanceID]struct{})
+
+	for _, instance := range instances {
+		if tag.Protects(instance.Tags) {
+			out[InstanceID(*instance.InstanceId)] = struct{}{}
+		}
+	}
+
+	return out, nil
+}
+
+// softDrainDecision is the decision not to scale while the pool is being wound
+// down by the soft drain.
+func softDrainDecision() Decision {
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         []string{"soft drain in progress"},
+		SuppressedBy:     []string{GateSoftDrain},
+	}
+}
+
+// strayCleanupDecision is the decision not to scale while the ASG doesn't match
+// the worker pool because of the stray instances.
+func strayCleanupDecision() Decision {
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         []string{"stray instance cleanup in progress"},
+		SuppressedBy:     []string{GateStrayCleanup},
+	}
+}
+
+// holdForLaunchRetryBudget replaces a decision to scale up with no scaling once
+// the instances launched to cover the current deficit have failed to register
+// as workers too many times.
+func (s AutoScaler) holdForLaunchRetryBudget(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, decision Decision) Decision {
+	if decision.ScalingDirection != ScalingDirectionUp || !persisted.LaunchRetryBudgetExhausted(cfg.AutoscalingLaunchRetryBudget) {
+		return decision
+	}
+
+	// Launching yet more instances which won't register would only burn
+	// money, so it's up to a human to fix the launch configuration.
+	logger.With(
+		"instances", decision.ScalingSize,
+		"failed_launches", persisted.LaunchCohort.Failed,
+		"since", time.Unix(persisted.LaunchCohort.StartedAt, 0),
+	).Error("launched instances keep failing to register as workers, launch retry budget exhausted, skipping the scale-up")
+
+	xray.AddAnnotation(ctx, "launch_retry_budget_exhausted", true)
+
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         append(decision.Comments, "launch retry budget exhausted"),
+		SuppressedBy:     []string{GateLaunchRetryBudget},
+	}
+}
+
+// holdForSuspendedProcess replaces the decision with no scaling if the Auto
+// Scaling process it relies on is suspended on the ASG. Scaling anyway would
+// only churn, since the ASG won't follow through.
+func (s AutoScaler) holdForSuspendedProcess(ctx context.Context, logger *slog.Logger, state *State, decision Decision) Decision {
+	process, suspended := state.SuspendedProcessFor(decision.ScalingDirection)
+	if !suspended {
+		return decision
+	}
+
+	logger.With(
+		"process", process,
+		"direction", decision.ScalingDirection.String(),
+		"size", decision.ScalingSize,
+	).Warn("Auto Scaling process suspended in the ASG, skipping scaling")
+
+	xray.AddAnnotation(ctx, "suspended_process", process)
+
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         append(decision.Comments, fmt.Sprintf("%s process is suspended in the ASG", process)),
+		SuppressedBy:     []string{GateSuspendedProcess},
+	}
+}
+
+// holdForInstanceRefresh replaces the decision with no scaling, or only allows
+// scaling up, while an instance refresh of the ASG is in progress, depending
+// on the configuration. Scaling down could otherwise remove the instances the
+// refresh has just launched, or take the pool below its healthy percentage.
+func (s AutoScaler) holdForInstanceRefresh(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, decision Decision) (Decision, error) {
+	mode := cfg.AutoscalingDuringInstanceRefresh
+
+	if mode == "" || mode == DuringInstanceRefreshIgnore || decision.ScalingDirection == ScalingDirectionNone {
+		return decision, nil
+	}
+
+	if mode == DuringInstanceRefreshScaleUpOnly && decision.ScalingDirection == ScalingDirectionUp {
+		return decision, nil
+	}
+
+	inProgress, err := s.controller.InstanceRefreshInProgress(ctx)
+	if err != nil {
+		return decision, fmt.Errorf("could not check for instance refreshes: %w", err)
+	}
+
+	if !inProgress {
+		return decision, nil
+	}
+
+	logger.With(
+		"direction", decision.ScalingDirection.String(),
+		"size", decision.ScalingSize,
+		"mode", mode,
+	).Info("instance refresh in progress, deferring scaling to the next invocation")
+
+	xray.AddAnnotation(ctx, "instance_refresh_deferral", true)
+
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         append(decision.Comments, "instance refresh in progress"),
+		SuppressedBy:     []string{GateInstanceRefresh},
+	}, nil
+}
+
+// handleGhostWorkers warns about the workers whose instances are gone from the
+// ASG and, if configured, drains them so that no runs get stuck on them.
+func (s AutoScaler) handleGhostWorkers(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State, result *RunResult) error {
+	ghosts := state.GhostWorkers()
+	if len(ghosts) == 0 {
+		return nil
+	}
+
+	logger.With("workers", len(ghosts)).Warn("found workers whose instances are not in the ASG")
+	xray.AddAnnotation(ctx, "ghost_workers", len(ghosts))
+
+	if cfg.AutoscalingGhostWorkers != GhostWorkersDrain {
+		return nil
+	}
+
+	for _, worker := range ghosts {
+		_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+		logger := logger.With("worker_id", worker.ID, "instance_id", instanceID)
+
+		if err := s.controller.ForceDrainWorker(ctx, worker.ID); err != nil {
+			return fmt.Errorf("could not drain ghost worker: %w", err)
+		}
+
+		logger.Info("drained worker whose instance is not in the ASG")
+		result.DrainedWorkers = append(result.DrainedWorkers, worker.ID)
+	}
+
+	return nil
+}
+
+// applyEmergencyFloor lowers the minimum size of the ASG to the emergency
+// floor while it's in effect, so that the idle workers can be scaled down below
+// the regular minimum. The original minimum size is kept in the persisted
+// state, and restored once the emergency floor is no longer in effect.
+func (s AutoScaler) applyEmergencyFloor(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, state *State, now time.Time) error {
+	floor, ok := cfg.EmergencyFloor(now)
+
+	if !ok {
+		return s.restoreMinSize(ctx, logger, persisted, state)
+	}
+
+	if floor >= int(*state.ASG.MinSize) {
+		return nil
+	}
+
+	logger.With(
+		"min_size", *state.ASG.MinSize,
+		"floor", floor,
+		"until", cfg.AutoscalingEmergencyFloorUntil,
+	).Error("emergency floor in effect, lowering the ASG minimum size")
+
+	xray.AddAnnotation(ctx, "emergency_floor", floor)
+
+	if err := s.controller.SetMinSize(ctx, int32(floor)); err != nil {
+		return fmt.Errorf("could not apply the emergency floor: %w", err)
+	}
+
+	if persisted.EmergencyFloor == nil {
+		persisted.EmergencyFloor = &EmergencyFloorState{MinSize: *state.ASG.MinSize}
+	}
+
+	minSize := int32(floor)
+	state.ASG.MinSize = &minSize
+
+	return nil
+}
+
+// restoreMinSize sets the minimum size of the ASG back to what it was before
+// the emergency floor lowered it, unless it has been raised since.
+func (s AutoScaler) restoreMinSize(ctx context.Context, logger *slog.Logger, persisted *PersistedState, state *State) error {
+	if persisted.EmergencyFloor == nil {
+		return nil
+	}
+
+	if original := persisted.EmergencyFloor.MinSize; *state.ASG.MinSize < original {
+		logger.With(
+			"min_size", *state.ASG.MinSize,
+			"original_min_size", original,
+		).Info("emergency floor no longer in effect, restoring the ASG minimum size")
+
+		if err := s.controller.SetMinSize(ctx, original); err != nil {
+			return fmt.Errorf("could not restore the minimum size: %w", err)
+		}
+
+		state.ASG.MinSize = &original
+	}
+
+	persisted.EmergencyFloor = nil
+
+	return nil
+}
+
+// consultDecisionHook runs the decision hook, if one is configured, for a
+// decision to scale. If the hook fails, the decision is either held or acted
+// on as is, depending on the configuration.
+func (s AutoScaler) consultDecisionHook(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State, effective EffectiveConfig, decision Decision) Decision {
+	if cfg.AutoscalingDecisionHook == "" || decision.ScalingDirection == ScalingDirectionNone {
+		return decision
+	}
+
+	hook := DecisionHook{Command: cfg.AutoscalingDecisionHook, Timeout: cfg.AutoscalingDecisionHookTimeout}
+
+	response, err := hook.Run(ctx, newPreview(cfg, state, decision, effective))
+
+	var modified Decision
+	if err == nil {
+		modified, err = response.Apply(decision)
+	}
+
+	if err != nil {
+		logger := logger.With("msg", err.Error(), "on_failure", cfg.AutoscalingDecisionHookOnFailure)
+		xray.AddAnnotation(ctx, "decision_hook", "failed")
+
+		if cfg.AutoscalingDecisionHookOnFailure == HookFailureProceed {
+			logger.Error("decision hook failed, proceeding with the decision")
+			return decision
+		}
+
+		logger.Error("decision hook failed, holding the decision")
+
+		return Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         append(decision.Comments, "decision hook failed"),
+			SuppressedBy:     []string{GateDecisionHook},
+		}
+	}
+
+	logger.With(
+		"action", response.Action,
+		"direction", modified.ScalingDirection.String(),
+		"size", modified.ScalingSize,
+	).Info("consulted the decision hook")
+
+	xray.AddAnnotation(ctx, "decision_hook", response.Action)
+
+	return modified
+}
+
+// logNoOp logs the decision not to scale, sampling the repetitions of the same
+// decision if configured to.
+func (s AutoScaler) logNoOp(cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, decision Decision) {
+	if cfg.AutoscalingNoOpLogInterval <= 0 {
+		logger.Info("no scaling decision to be made")
+		return
+	}
+
+	key := strings.Join(decision.Comments, "; ")
+
+	if log, suppressed := persisted.ObserveNoOp(key, time.Now(), cfg.AutoscalingNoOpLogInterval); log {
+		logger.With("comments", decision.Comments, "suppressed", suppressed).Info("no scaling decision to be made")
+	}
+}
+
+// skipDisabled records that the run was skipped because the worker pool is
+// labelled to pause the autoscaler.
+func (s AutoScaler) skipDisabled(ctx context.Context, logger *slog.Logger, result *RunResult) {
+	logger.With("label", DisabledLabel).Info("autoscaling disabled by the worker pool label, skipping")
+	xray.AddAnnotation(ctx, "autoscaling_disabled", true)
+
+	result.Decision = Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         []string{"autoscaling disabled by the worker pool label"},
+		SuppressedBy:     []string{GateDisabled},
+	}
+}
+
+// addHints adds the optional inputs to the scaling decision to the state. They
+// are only hints, so failing to get them does not fail the run.
+func (s AutoScaler) addHints(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State) {
+	if s.queueDepth != nil {
+		if depth, err := s.queueDepth.QueueDepth(ctx); err != nil {
+			logger.With("msg", err.Error()).Warn("could not get external queue depth")
+		} else {
+			state.QueueDepth = depth
+		}
+	}
+
+	if s.utilization != nil {
+		if percent, err := s.utilization.Utilization(ctx); err != nil {
+			logger.With("msg", err.Error()).Warn("could not get worker utilization")
+		} else {
+			state.Utilization = percent
+		}
+	}
+
+	// Without the quota, the scale-up would merely fail on account limits.
+	if cfg.AutoscalingVCPUQuotaCode != "" {
+		if quota, err := s.controller.GetInstanceQuota(ctx); err != nil {
+			logger.With("msg", err.Error()).Warn("could not get instance quota")
+		} else {
+			state.InstanceQuota = quota
+
+			if maxSize := *state.ASG.MaxSize; quota < int(maxSize) {
+				logger.With("quota", quota, "max_size", maxSize).Info("instance quota is below the ASG max size")
+			}
+		}
+	}
+}
+
+// scaleUp adds the number of instances from the decision to the ASG.
+func (s AutoScaler) scaleUp(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, persisted *PersistedState, state *State, decision Decision, result *RunResult) error {
+	logger.With("instances", decision.ScalingSize).Info("scaling up the ASG")
+
+	// The tag has to be in place before the instances are launched for them
+	// to inherit it, unless the ASG already carries it. It's only there for
+	// reporting, so it's not worth failing the scale-up over.
+	if cfg.AutoscalingTagLaunchCohort {
+		cohort := LaunchCohort(time.Now())
+
+		if LaunchCohortTagged(state.ASG, cohort) {
+			result.LaunchCohort = cohort
+		} else if err := s.controller.TagLaunchCohort(ctx, cohort); err != nil {
+			logger.With("msg", err.Error()).Warn("could not tag the launch cohort")
+		} else {
+			result.LaunchCohort = cohort
+		}
+	}
+
+	err := s.controller.ScaleUpASG(ctx, *state.ASG.DesiredCapacity+int32(decision.ScalingSize))
+
+	// Another activity (eg. an instance refresh) is still being processed
+	// by AWS. That's not an error, we'll just try again next time.
+	if errors.Is(err, ErrScalingActivityInProgress) {
+		logger.Warn("scaling activity in progress, deferring the scale-up to the next invocation")
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not scale up ASG: %w", err)
+	}
+
+	if cfg.AutoscalingLaunchRetryBudget > 0 {
+		persisted.ObserveLaunch(time.Now())
+	}
+
+	if workers := len(state.WorkerPool.Workers); cfg.AutoscalingPanicThreshold > 0 && decision.ScalingSize >= cfg.AutoscalingPanicThreshold && persisted.Panic == nil {
+		logger.With("baseline", workers).Info("recorded a panic scale-up")
+		persisted.Panic = &PanicState{Baseline: workers, ObservedAt: time.Now().Unix()}
+	}
+
+	if cfg.AutoscalingConfirmScaleUp && !persisted.BackingOff(time.Now()) {
+		return s.confirmScaleUp(ctx, cfg, logger, activeInstances(state.ASG)+decision.ScalingSize)
+	}
+
+	return nil
+}
+
+// recycleExpiredInstance replaces the oldest idle worker whose instance has
+// been running for longer than the configured maximum lifetime. Only one
+// instance is recycled per invocation so that the pool is cycled gradually.
+func (s AutoScaler) recycleExpiredInstance(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, state *State, result *RunResult) error {
+	workersByInstanceID := make(map[string]Worker)
+	var instanceIDs []string
+
+	for _, worker := range state.IdleWorkers() {
+		_, instanceID, _ := worker.InstanceIdentity(cfg.MetadataKeys())
+
+		workersByInstanceID[string(instanceID)] = worker
+		instanceIDs = append(instanceIDs, string(instanceID))
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	if s.apiCallBudgetExceeded(logger, cfg, recycleAPICalls) {
+		logger.Warn("deferring expired instance recycling to the next invocation")
+		return nil
+	}
+
+	instances, err := s.controller.DescribeInstances(ctx, instanceIDs)
+	if err != nil {
+		return fmt.Errorf("could not list EC2 instances: %w", err)
+	}
+
+	var oldest *ec2types.Instance
+
+	tag, protection := cfg.ProtectionTag()
+
+	for i, instance := range instances {
+		if time.Since(*instance.LaunchTime) <= cfg.AutoscalingMaxInstanceLifetime {
+			continue
+		}
+
+		if protection && tag.Protects(instance.Tags) {
+			continue
+		}
+
+		if oldest == nil || instance.LaunchTime.Before(*oldest.LaunchTime) {
+			oldest = &instances[i]
+		}
+	}
+
+	if oldest == nil {
+		return nil
+	}
+
+	worker, ok := workersByInstanceID[*oldest.InstanceId]
+	if !ok {
+		return nil
+	}
+
+	logger = logger.With(
+		"worker_id", worker.ID,
+		"instance_id", *oldest.InstanceId,
+		"launch_timestamp", oldest.LaunchTime.Unix(),
+		"instance_age", time.Since(*oldest.LaunchTime),
+	)
+	logger.Info("instance exceeded its maximum lifetime, recycling")
+
+	drained, err := s.controller.DrainWorker(ctx, worker.ID)
+	if err != nil {
+		return fmt.Errorf("could not drain worker: %w", err)
+	}
+
+	if !drained {
+		logger.Warn("worker was busy, not recycling the instance")
+		return nil
+	}
+
+	result.DrainedWorkers = append(result.DrainedWorkers, worker.ID)
+
+	if err := s.controller.KillInstance(ctx, *oldest.InstanceId); err != nil {
+		return fmt.Errorf("could not kill instance: %w", err)
+	}
+
+	result.KilledInstances = append(result.KilledInstances, *oldest.InstanceId)
+
+	// Killing the instance decremented the desired capacity, so let's bring
+	// it back up to get a fresh replacement.
+	if err := s.controller.ScaleUpASG(ctx, *state.ASG.DesiredCapacity); err != nil {
+		return fmt.Errorf("could not replace recycled instance: %w", err)
+	}
+
+	logger.Info("expired instance recycled")
+
+	return nil
+}
+
+// confirmScaleUp polls the ASG until the expected number of instances is at
+// least launching, so that a failing launch (eg. a bad AMI) is reported right
+// away rather than after many silent invocations.
+func (s AutoScaler) confirmScaleUp(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, expected int) error {
+	logger = logger.With("expected_instances", expected)
+	deadline := time.Now().Add(cfg.AutoscalingConfirmScaleUpTimeout)
+
+	for {
+		asg, err := s.controller.GetAutoscalingGroup(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get autoscaling group: %w", err)
+		}
+
+		active := activeInstances(asg)
+
+		if active >= expected {
+			logger.With("instances", active).Info("new capacity confirmed")
+			return nil
+		}
+
+		if !time.Now().Add(cfg.AutoscalingConfirmScaleUpInterval).Before(deadline) {
+			logger.With("instances", active).Error("new capacity did not appear after scaling up, check the launch template")
+			xray.AddAnnotation(ctx, "scale_up_unconfirmed", true)
+
+			return nil
+		}
+
+		if err := sleepContext(ctx, cfg.AutoscalingConfirmScaleUpInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// activeInstances returns the number of ASG instances which are launching or
+// in service, as opposed to being on their way out.
+func activeInstances(asg *autoscalingtypes.AutoScalingGroup) int {
+	var count int
+
+	for _, instance := range asg.Instances {
+		switch instance.LifecycleState {
+		case autoscalingtypes.LifecycleStateTerminating,
+			autoscalingtypes.LifecycleStateTerminatingWait,
+			autoscalingtypes.LifecycleStateTerminatingProceed,
+			autoscalingtypes.LifecycleStateTerminated,
+			autoscalingtypes.LifecycleStateDetaching,
+			autoscalingtypes.LifecycleStateDetached:
+			continue
+		default:
+			count++
+		}
+	}
+
+	return count
+}
+
+// outsideBusinessHours checks whether business hours are configured, and the
+// given time falls outside all of them.
+func (s AutoScaler) outsideBusinessHours(cfg RuntimeConfig, now time.Time) bool {
+	if len(cfg.AutoscalingBusinessHours) == 0 {
+		return false
+	}
+
+	// The windows have been validated when loading the configuration.
+	windows, _ := ParseTimeWindows(cfg.AutoscalingBusinessHours)
+	now = InScheduleTimezone(cfg, now)
+
+	for _, window := range windows {
+		if window.Contains(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// warnAboutUnknownMetadataVersions logs a warning for each unknown version of
+// the worker metadata format, since we can only guess how to handle it.
+func (s AutoScaler) warnAboutUnknownMetadataVersions(logger *slog.Logger, workerPool *WorkerPool) {
+	warned := make(map[string]struct{})
+
+	for _, worker := range workerPool.Workers {
+		version, known := worker.MetadataVersion()
+		if known || version == "" {
+			continue
+		}
+
+		if _, ok := warned[version]; ok {
+			continue
+		}
+
+		warned[version] = struct{}{}
+
+		logger.With(
+			"worker_id", worker.ID,
+			"metadata_version", version,
+		).Warn("worker metadata has an unknown format version, assuming the default format")
+	}
+}
+
+// apiCallBudgetExceeded checks whether making the given number of additional
+// API calls would exceed the configured soft cap.
+func (s AutoScaler) apiCallBudgetExceeded(logger *slog.Logger, cfg RuntimeConfig, calls int) bool {
+	if cfg.AutoscalingMaxAPICalls <= 0 {
+		return false
+	}
+
+	used := s.controller.APICalls()
+	if used+calls <= cfg.AutoscalingMaxAPICalls {
+		return false
+	}
+
+	logger.With(
+		"api_calls", used,
+		"api_calls_max", cfg.AutoscalingMaxAPICalls,
+	).Warn("approaching the API call cap")
+
+	return true
+}
@@ -3,11 +3,18 @@ package internal_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slog"
@@ -78,6 +85,288 @@ func TestAutoScalerScalingUp(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestAutoScalerScalingUpFromZero(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{PendingRuns: 2}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(0)),
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+
+	err := scaler.Scale(context.Background(), internal.RuntimeConfig{AutoscalingMaxCreate: 5})
+	require.NoError(t, err)
+}
+
+func TestAutoScalerGhostWorkers(t *testing.T) {
+	for name, tt := range map[string]struct {
+		policy  string
+		drained bool
+	}{
+		"ignored": {policy: internal.GhostWorkersIgnore},
+		"drained": {policy: internal.GhostWorkersDrain, drained: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+					{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "gone"}`},
+				},
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(1)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+
+			if tt.drained {
+				ctrl.On("ForceDrainWorker", mock.Anything, "2").Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), internal.RuntimeConfig{AutoscalingGhostWorkers: tt.policy})
+			require.NoError(t, err)
+
+			require.Contains(t, buf.String(), "found workers whose instances are not in the ASG")
+			require.Equal(t, tt.drained, strings.Contains(buf.String(), "drained worker whose instance is not in the ASG"))
+		})
+	}
+}
+
+func TestAutoScalerDecisionHook(t *testing.T) {
+	for name, tt := range map[string]struct {
+		script    string
+		onFailure string
+		scaleTo   int32
+		message   string
+	}{
+		"approve":             {script: `echo '{"action": "approve"}'`, scaleTo: 3, message: "consulted the decision hook"},
+		"veto":                {script: `echo '{"action": "reject"}'`, message: "consulted the decision hook"},
+		"modify":              {script: `echo '{"action": "modify", "size": 1}'`, scaleTo: 2, message: "consulted the decision hook"},
+		"failure holds":       {script: `exit 1`, message: "decision hook failed, holding the decision"},
+		"failure can proceed": {script: `exit 1`, onFailure: internal.HookFailureProceed, scaleTo: 3, message: "decision hook failed, proceeding with the decision"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:             5,
+				AutoscalingDecisionHook:          writeHook(t, "cat > /dev/null; "+tt.script),
+				AutoscalingDecisionHookOnFailure: tt.onFailure,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+				},
+				PendingRuns: 2,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(5)),
+				DesiredCapacity:      ptr(int32(1)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+
+			if tt.scaleTo > 0 {
+				ctrl.On("ScaleUpASG", mock.Anything, tt.scaleTo).Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+			require.Contains(t, buf.String(), tt.message)
+		})
+	}
+}
+
+func TestAutoScalerSamplesNoOpLogs(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingStateParameter:  "state",
+		AutoscalingNoOpLogInterval: time.Hour,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, scaler.Scale(context.Background(), cfg))
+	}
+
+	require.Equal(t, 1, strings.Count(buf.String(), "no scaling decision to be made"))
+	require.Equal(t, 4, persisted.NoOp.Suppressed)
+}
+
+func TestAutoScalerTagsLaunchCohort(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		tagErr error
+	}{
+		{name: "when tagging succeeds"},
+		{name: "when tagging fails", tagErr: errors.New("bacon")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{AutoscalingTagLaunchCohort: true}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			notifier := &failingNotifier{}
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h), notifier)
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+				},
+				PendingRuns: 2,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance")},
+				},
+			}, nil)
+
+			var cohort string
+			ctrl.On("TagLaunchCohort", mock.Anything, mock.MatchedBy(func(in string) bool {
+				cohort = in
+				return true
+			})).Return(tt.tagErr)
+
+			// The scale-up goes ahead either way.
+			ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+
+			launchedAt, err := time.Parse("20060102T150405Z", cohort)
+			require.NoError(t, err)
+			require.WithinDuration(t, time.Now(), launchedAt, time.Minute)
+
+			require.Len(t, notifier.results, 1)
+
+			if tt.tagErr == nil {
+				require.Equal(t, cohort, notifier.results[0].LaunchCohort)
+			} else {
+				require.Empty(t, notifier.results[0].LaunchCohort)
+				require.Contains(t, buf.String(), "could not tag the launch cohort")
+			}
+		})
+	}
+}
+
+func TestLaunchCohortTagged(t *testing.T) {
+	tagged := func(value string, propagate bool) *types.AutoScalingGroup {
+		return &types.AutoScalingGroup{
+			Tags: []types.TagDescription{
+				{Key: ptr("team"), Value: ptr("platform")},
+				{Key: ptr(internal.LaunchCohortTagKey), Value: ptr(value), PropagateAtLaunch: ptr(propagate)},
+			},
+		}
+	}
+
+	require.True(t, internal.LaunchCohortTagged(tagged("20231014T120000Z", true), "20231014T120000Z"))
+	require.False(t, internal.LaunchCohortTagged(tagged("20231014T110000Z", true), "20231014T120000Z"))
+	require.False(t, internal.LaunchCohortTagged(tagged("20231014T120000Z", false), "20231014T120000Z"))
+	require.False(t, internal.LaunchCohortTagged(&types.AutoScalingGroup{}, "20231014T120000Z"))
+}
+
+func TestAutoScalerScalingUpDeferredByScalingActivity(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(internal.ErrScalingActivityInProgress)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "deferring the scale-up to the next invocation")
+}
+
 func TestAutoScalerScalingDown(t *testing.T) {
 	var buf bytes.Buffer
 	h := slog.NewTextHandler(&buf, nil)
@@ -119,11 +408,214 @@ func TestAutoScalerScalingDown(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestAutoScalerDetachedNotTerminatedInstances(t *testing.T) {
+func TestAutoScalerScalingDownConfirmsDrains(t *testing.T) {
 	var buf bytes.Buffer
 	h := slog.NewTextHandler(&buf, nil)
 
-	cfg := internal.RuntimeConfig{}
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:             2,
+		AutoscalingMaxConcurrentDrains: 2,
+		AutoscalingConfirmDrains:       true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", CreatedAt: 1, Metadata: `{"asg_id": "group", "instance_id": "instance1"}`},
+			{ID: "2", CreatedAt: 2, Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance1"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+	ctrl.On("DrainWorker", mock.Anything, "2").Return(true, nil)
+
+	// The drain of the second worker has not propagated yet.
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Drained: true},
+			{ID: "2"},
+		},
+	}, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance1").Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "worker drain not yet reflected by Spacelift, skipping the termination")
+	ctrl.AssertNotCalled(t, "KillInstance", mock.Anything, "instance2")
+}
+
+func TestAutoScalerEmergencyFloor(t *testing.T) {
+	for name, tt := range map[string]struct {
+		until    time.Time
+		minSize  int32
+		lowered  *internal.EmergencyFloorState
+		active   bool
+		restored bool
+	}{
+		"in effect":                {until: time.Now().Add(time.Hour), minSize: 2, active: true},
+		"expired before applying":  {until: time.Now().Add(-time.Hour), minSize: 2},
+		"expired after applying":   {until: time.Now().Add(-time.Hour), minSize: 1, lowered: &internal.EmergencyFloorState{MinSize: 2}, restored: true},
+		"expired after a raise":    {until: time.Now().Add(-time.Hour), minSize: 2, lowered: &internal.EmergencyFloorState{MinSize: 2}},
+		"in effect after applying": {until: time.Now().Add(time.Hour), minSize: 1, lowered: &internal.EmergencyFloorState{MinSize: 2}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxKill:             2,
+				AutoscalingEmergencyFloor:      ptr(1),
+				AutoscalingEmergencyFloorUntil: tt.until,
+				AutoscalingStateParameter:      "state",
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			persisted := &internal.PersistedState{EmergencyFloor: tt.lowered}
+
+			ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+			ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", CreatedAt: 1, Metadata: `{"asg_id": "group", "instance_id": "instance1"}`},
+					{ID: "2", CreatedAt: 2, Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+				},
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(tt.minSize),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance1"), LifecycleState: types.LifecycleStateInService},
+					{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+
+			if tt.active {
+				ctrl.On("SetMinSize", mock.Anything, int32(1)).Return(nil)
+			}
+
+			if tt.restored {
+				ctrl.On("SetMinSize", mock.Anything, int32(2)).Return(nil)
+			}
+
+			// Lowering the minimum size lets the desired capacity go down
+			// with the terminated instance.
+			inEffect := tt.until.After(time.Now())
+			if inEffect {
+				ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+				ctrl.On("KillInstance", mock.Anything, "instance1").Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+			require.Equal(t, tt.active, strings.Contains(buf.String(), "emergency floor in effect"))
+			require.Equal(t, tt.restored, strings.Contains(buf.String(), "restoring the ASG minimum size"))
+
+			if inEffect {
+				require.Equal(t, &internal.EmergencyFloorState{MinSize: 2}, persisted.EmergencyFloor)
+			} else {
+				require.Nil(t, persisted.EmergencyFloor)
+			}
+		})
+	}
+}
+
+func TestAutoScalerVerifiesMinSizeAfterScalingDown(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		desired int32
+		correct bool
+	}{
+		{name: "at the minimum size", desired: 1},
+		{name: "below the minimum size after a concurrent change", desired: 0, correct: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxKill:       1,
+				AutoscalingVerifyMinSize: true,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+					{
+						ID:       "2",
+						Metadata: `{"asg_id": "group", "instance_id": "instance2"}`,
+					},
+				},
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance")},
+					{InstanceId: ptr("instance2")},
+				},
+			}, nil).Once()
+			ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+			ctrl.On("KillInstance", mock.Anything, "instance").Return(nil)
+
+			// Re-reading the group after the scale-down.
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(tt.desired),
+			}, nil).Once()
+
+			if tt.correct {
+				ctrl.On("ScaleUpASG", mock.Anything, int32(1)).Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+
+			if tt.correct {
+				require.Contains(t, buf.String(), "desired capacity dropped below the ASG minimum size, correcting")
+			}
+		})
+	}
+}
+
+func TestAutoScalerScalingDownDeferredByAPICallCap(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:     1,
+		AutoscalingMaxAPICalls: 5,
+	}
 
 	ctrl := new(MockController)
 	defer ctrl.AssertExpectations(t)
@@ -138,11 +630,9 @@ func TestAutoScalerDetachedNotTerminatedInstances(t *testing.T) {
 			},
 			{
 				ID:       "2",
-				Drained:  true,
-				Metadata: `{"asg_id": "group", "instance_id": "detached"}`,
+				Metadata: `{"asg_id": "group", "instance_id": "instance2"}`,
 			},
 		},
-		PendingRuns: 2,
 	}, nil)
 	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
 		AutoScalingGroupName: ptr("group"),
@@ -151,22 +641,2562 @@ func TestAutoScalerDetachedNotTerminatedInstances(t *testing.T) {
 		DesiredCapacity:      ptr(int32(2)),
 		Instances: []types.Instance{
 			{InstanceId: ptr("instance")},
+			{InstanceId: ptr("instance2")},
 		},
 	}, nil)
-	ctrl.On("KillInstance", mock.Anything, "detached").Return(nil)
-	output := []ec2types.Instance{{
-		InstanceId: ptr("detached"),
-		LaunchTime: nullable(time.Now().Add(-time.Hour)),
-	}}
-	ctrl.On(
-		"DescribeInstances",
-		mock.Anything,
-		[]string{"detached"},
-	).Return(output, nil)
+	ctrl.On("APICalls").Return(2)
 	err := scaler.Scale(context.Background(), cfg)
 	require.NoError(t, err)
+	require.Contains(t, buf.String(), "deferring the rest of the scale-down to the next invocation")
+	ctrl.AssertNotCalled(t, "DrainWorker", mock.Anything, mock.Anything)
+	ctrl.AssertNotCalled(t, "KillInstance", mock.Anything, mock.Anything)
 }
 
-func ptr[T any](v T) *T {
-	return &v
+func TestAutoScalerInstanceRefresh(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingUseInstanceRefresh:    true,
+		AutoscalingDuringInstanceRefresh: internal.DuringInstanceRefreshDefer,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		LaunchTemplate: &types.LaunchTemplateSpecification{
+			LaunchTemplateId: ptr("lt-1"),
+			Version:          ptr("2"),
+		},
+		Instances: []types.Instance{
+			{
+				InstanceId:     ptr("instance"),
+				LifecycleState: types.LifecycleStateInService,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: ptr("lt-1"),
+					Version:          ptr("1"),
+				},
+			},
+		},
+	}, nil)
+	ctrl.On("InstanceRefreshInProgress", mock.Anything).Return(false, nil).Once()
+	ctrl.On("StartInstanceRefresh", mock.Anything).Return(true, nil)
+	ctrl.On("InstanceRefreshInProgress", mock.Anything).Return(true, nil).Once()
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "started instance refresh to replace outdated instances")
+	require.Contains(t, buf.String(), "instance refresh in progress, deferring scaling to the next invocation")
+	ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerInstanceRefreshAlreadyInProgress(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingUseInstanceRefresh:    true,
+		AutoscalingDuringInstanceRefresh: internal.DuringInstanceRefreshDefer,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		LaunchTemplate: &types.LaunchTemplateSpecification{
+			LaunchTemplateId: ptr("lt-1"),
+			Version:          ptr("2"),
+		},
+		Instances: []types.Instance{
+			{
+				InstanceId:     ptr("instance"),
+				LifecycleState: types.LifecycleStateInService,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: ptr("lt-1"),
+					Version:          ptr("1"),
+				},
+			},
+		},
+	}, nil)
+	ctrl.On("InstanceRefreshInProgress", mock.Anything).Return(true, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "instance refresh already in progress")
+	require.Contains(t, buf.String(), "instance refresh in progress, deferring scaling to the next invocation")
+	ctrl.AssertNotCalled(t, "StartInstanceRefresh", mock.Anything)
+	ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerDeadlock(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(2)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("stuck"), LifecycleState: types.LifecycleStatePending},
+		},
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "level=ERROR")
+	require.Contains(t, buf.String(), "no scaling is possible")
+}
+
+func TestAutoScalerRecoversLeftDrainedWorkers(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:             2,
+		AutoscalingRecoverDrainedWorkers: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	// The second worker was left drained by a failed undrain in a previous
+	// run, so it's not accepting any runs even though it's idle.
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:       "2",
+				Drained:  true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance2"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("UndrainWorker", mock.Anything, "2").Return(nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "undrained a worker left drained by a previous run")
+}
+
+func TestAutoScalerReclaimsDrainedWorkersFirst(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:               1,
+		AutoscalingReclaimDrainedWorkers: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	// The older worker is idle, but the drained one goes first.
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:        "1",
+				CreatedAt: 1,
+				Metadata:  `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:        "2",
+				CreatedAt: 2,
+				Drained:   true,
+				Metadata:  `{"asg_id": "group", "instance_id": "instance2"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DrainWorker", mock.Anything, "2").Return(true, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance2").Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+func TestAutoScalerRefusesToRunWithConflictingPolicies(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingCheckASGPolicies: internal.ASGPolicyCheckRefuse,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetScalingPolicies", mock.Anything).Return([]string{"target-tracking"}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.EqualError(t, err, "ASG has 1 conflicting scaling policies")
+	ctrl.AssertNotCalled(t, "GetWorkerPool", mock.Anything)
+}
+
+func TestAutoScalerWithNoConflictingPolicies(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingCheckASGPolicies: internal.ASGPolicyCheckRefuse,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetScalingPolicies", mock.Anything).Return(nil, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(0)),
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "no scaling decision to be made")
+}
+
+func TestAutoScalerDetachedNotTerminatedInstances(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:       "2",
+				Drained:  true,
+				Metadata: `{"asg_id": "group", "instance_id": "detached"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("KillInstance", mock.Anything, "detached").Return(nil)
+	output := []ec2types.Instance{{
+		InstanceId: ptr("detached"),
+		LaunchTime: nullable(time.Now().Add(-time.Hour)),
+	}}
+	ctrl.On(
+		"DescribeInstances",
+		mock.Anything,
+		[]string{"detached"},
+	).Return(output, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "found instances detached from the ASG but not terminated")
+	require.Contains(t, buf.String(), "detached_not_terminated=1")
+}
+
+func TestAutoScalerRecyclesExpiredInstance(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxInstanceLifetime: 7 * 24 * time.Hour,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:       "2",
+				Metadata: `{"asg_id": "group", "instance_id": "instance2"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(2)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DescribeInstances", mock.Anything, []string{"instance", "instance2"}).Return([]ec2types.Instance{
+		{
+			InstanceId: ptr("instance"),
+			LaunchTime: nullable(time.Now().Add(-time.Hour)),
+		},
+		{
+			InstanceId: ptr("instance2"),
+			LaunchTime: nullable(time.Now().Add(-10 * 24 * time.Hour)),
+		},
+	}, nil)
+	ctrl.On("DrainWorker", mock.Anything, "2").Return(true, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance2").Return(nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "expired instance recycled")
+}
+
+func TestAutoScalerDoesNotRecycleInstancesWithinLifetime(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxInstanceLifetime: 7 * 24 * time.Hour,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DescribeInstances", mock.Anything, []string{"instance"}).Return([]ec2types.Instance{{
+		InstanceId: ptr("instance"),
+		LaunchTime: nullable(time.Now().Add(-time.Hour)),
+	}}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotContains(t, buf.String(), "recycling")
+}
+
+func TestAutoScalerSafeModeLimitsScalingDown(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:        3,
+		AutoscalingStateParameter: "state",
+		AutoscalingSafeModeDecay:  time.Hour,
+		AutoscalingSafeModeMargin: 1,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{
+		Peak: &internal.PeakState{Workers: 4, ObservedAt: time.Now().Unix()},
+	}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:       "2",
+				Metadata: `{"asg_id": "group", "instance_id": "instance2"}`,
+			},
+			{
+				ID:       "3",
+				Metadata: `{"asg_id": "group", "instance_id": "instance3"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(4)),
+		DesiredCapacity:      ptr(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+			{InstanceId: ptr("instance2")},
+			{InstanceId: ptr("instance3")},
+		},
+	}, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "safe mode limits scaling down below the recent peak")
+	require.Equal(t, 4, persisted.Peak.Workers)
+}
+
+type staticQueueDepth struct {
+	depth int
+	err   error
+}
+
+func (q staticQueueDepth) QueueDepth(context.Context) (int, error) {
+	return q.depth, q.err
+}
+
+func TestAutoScalerScalingUpForExternalQueue(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate: 5,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h)).WithQueueDepthSource(staticQueueDepth{depth: 3})
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 1,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(4)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+type staticUtilization struct {
+	percent float64
+	err     error
+}
+
+func (u staticUtilization) Utilization(context.Context) (float64, error) {
+	return u.percent, u.err
+}
+
+func TestAutoScalerScalingUpOnUtilization(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		source  staticUtilization
+		scaleTo int32
+	}{
+		{name: "when the utilization is above the threshold", source: staticUtilization{percent: 95}, scaleTo: 3},
+		{name: "when the utilization is below the threshold", source: staticUtilization{percent: 50}},
+		{name: "when the utilization is unknown", source: staticUtilization{err: errors.New("bacon")}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:            5,
+				AutoscalingUtilizationThreshold: 80,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h)).WithUtilizationSource(tt.source)
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+					{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+				},
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(10)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance-1")},
+					{InstanceId: ptr("instance-2")},
+				},
+			}, nil)
+
+			if tt.scaleTo > 0 {
+				ctrl.On("ScaleUpASG", mock.Anything, tt.scaleTo).Return(nil)
+			}
+
+			require.NoError(t, scaler.Scale(context.Background(), cfg))
+
+			if tt.source.err != nil {
+				require.Contains(t, buf.String(), "could not get worker utilization")
+			}
+		})
+	}
+}
+
+func TestAutoScalerScalingUpCappedByInstanceQuota(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:     10,
+		AutoscalingVCPUQuotaCode: "L-1216C47A",
+		AutoscalingInstanceVCPUs: 4,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 8,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("GetInstanceQuota", mock.Anything).Return(4, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(4)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "instance quota is below the ASG max size")
+}
+
+func TestAutoScalerScalingUpFromSummary(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:      5,
+		AutoscalingSummaryScaleUp: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	// GetWorkerPool is not expected to be called at all.
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Workers:     []internal.Worker{{ID: "1", Busy: true}},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+func TestAutoScalerScalingUpFromSummaryConsultsDecisionHook(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:      5,
+		AutoscalingSummaryScaleUp: true,
+		AutoscalingDecisionHook:   writeHook(t, `cat > /dev/null; echo '{"action": "reject"}'`),
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Workers:     []internal.Worker{{ID: "1", Busy: true}},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "consulted the decision hook")
+	ctrl.AssertNotCalled(t, "GetWorkerPool", mock.Anything)
+	ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerScalingUpFromSummaryWithInvalidASG(t *testing.T) {
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:      5,
+		AutoscalingSummaryScaleUp: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{PendingRuns: 2}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.EqualError(t, err, "could not create state: ASG minimum size is not set")
+}
+
+func TestAutoScalerFallsBackToFullDetailsWithoutScaleUp(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:        1,
+		AutoscalingSummaryScaleUp: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{{ID: "1"}},
+	}, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance").Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+func TestAutoScalerPausedByWorkerPoolLabel(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	// Nothing but the worker pool is expected to be queried.
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Labels:      []string{"team:infra", internal.DisabledLabel},
+		PendingRuns: 3,
+	}, nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "autoscaling disabled by the worker pool label, skipping")
+}
+
+func TestAutoScalerNotPausedByOtherWorkerPoolLabels(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Labels:      []string{"team:infra", "autoscaler:enabled"},
+		PendingRuns: 3,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(0)),
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotContains(t, buf.String(), "autoscaling disabled")
+}
+
+func TestAutoScalerPausedByWorkerPoolLabelInSummary(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5, AutoscalingSummaryScaleUp: true}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Labels:      []string{internal.DisabledLabel},
+		PendingRuns: 3,
+	}, nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "autoscaling disabled by the worker pool label, skipping")
+}
+
+func TestAutoScalerRecordsDecisionInTrace(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{PendingRuns: 2}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(0)),
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+
+	ctx, segment := xray.BeginSegment(context.Background(), "test")
+	defer segment.Close(nil)
+
+	err := scaler.Scale(ctx, cfg)
+	require.NoError(t, err)
+
+	require.Equal(t, "up", segment.Annotations["decision_direction"])
+	require.Equal(t, 2, segment.Annotations["decision_size"])
+	require.Equal(t, internal.Decision{
+		ScalingDirection: internal.ScalingDirectionUp,
+		ScalingSize:      2,
+		Comments:         []string{"adding workers to match pending runs"},
+	}, segment.Metadata["default"]["decision"])
+}
+
+func TestAutoScalerIgnoresExternalQueueFailure(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h)).WithQueueDepthSource(staticQueueDepth{err: errors.New("bacon")})
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "could not get external queue depth")
+}
+
+// offHours returns business hours which don't include the current time.
+func offHours() []string {
+	now := time.Now().UTC()
+	return []string{now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")}
+}
+
+func TestAutoScalerSuppressesScalingUpOutsideBusinessHours(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:     2,
+		AutoscalingBusinessHours: offHours(),
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "no scaling decision to be made")
+}
+
+func TestAutoScalerCleansUpStraysOutsideBusinessHours(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingBusinessHours: offHours(),
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("stray"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("DescribeInstances", mock.Anything, []string{"stray"}).Return([]ec2types.Instance{{
+		InstanceId: ptr("stray"),
+		LaunchTime: nullable(time.Now().Add(-time.Hour)),
+	}}, nil)
+	ctrl.On("KillInstance", mock.Anything, "stray").Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+}
+
+func TestAutoScalerContinuesAfterStrayCleanup(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		strays  []string
+		scaleUp bool
+	}{
+		{name: "single stray", strays: []string{"stray-1"}, scaleUp: true},
+		{name: "more strays left", strays: []string{"stray-1", "stray-2"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:                 5,
+				AutoscalingContinueAfterStrayCleanup: true,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			instances := []types.Instance{{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService}}
+			for _, stray := range tt.strays {
+				instances = append(instances, types.Instance{InstanceId: ptr(stray), LifecycleState: types.LifecycleStateInService})
+			}
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Busy:     true,
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+				},
+				PendingRuns: 2,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(0)),
+				MaxSize:              ptr(int32(5)),
+				DesiredCapacity:      ptr(int32(len(instances))),
+				Instances:            instances,
+			}, nil)
+			ctrl.On("DescribeInstances", mock.Anything, mock.Anything).Return([]ec2types.Instance{{
+				InstanceId: ptr("stray-1"),
+				LaunchTime: nullable(time.Now().Add(-time.Hour)),
+			}}, nil)
+			ctrl.On("KillInstance", mock.Anything, "stray-1").Return(nil)
+
+			// Killing the stray decremented the desired capacity to 1, and
+			// the two pending runs need two more workers on top of that.
+			if tt.scaleUp {
+				ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+
+			if tt.scaleUp {
+				require.Contains(t, buf.String(), "stray instances cleaned up, continuing to the scaling decision")
+			} else {
+				require.Contains(t, buf.String(), "stray instances left, deferring scaling to the next invocation")
+			}
+		})
+	}
+}
+
+func TestAutoScalerLaunchRetryBudget(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		pending   int32
+		failed    int
+		launched  time.Duration
+		scaleUp   bool
+		exhausted bool
+	}{
+		{name: "within the budget", pending: 2, failed: 0, launched: 30 * time.Minute, scaleUp: true},
+		{name: "budget exhausted", pending: 2, failed: 1, launched: 30 * time.Minute, exhausted: true},
+		{name: "stray launched before the cohort", pending: 2, failed: 1, launched: 2 * time.Hour, scaleUp: true},
+		{name: "deficit gone", pending: 0, failed: 1, launched: 30 * time.Minute},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:                 5,
+				AutoscalingContinueAfterStrayCleanup: true,
+				AutoscalingLaunchRetryBudget:         2,
+				AutoscalingStateParameter:            "state",
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			startedAt := time.Now().Add(-time.Hour).Unix()
+			persisted := &internal.PersistedState{
+				LaunchCohort: &internal.LaunchCohortState{StartedAt: startedAt, Failed: tt.failed},
+			}
+
+			ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Busy:     true,
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+				},
+				PendingRuns: tt.pending,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(0)),
+				MaxSize:              ptr(int32(5)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+					{InstanceId: ptr("stray"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+			ctrl.On("DescribeInstances", mock.Anything, []string{"stray"}).Return([]ec2types.Instance{{
+				InstanceId: ptr("stray"),
+				LaunchTime: nullable(time.Now().Add(-tt.launched)),
+			}}, nil)
+			ctrl.On("KillInstance", mock.Anything, "stray").Return(nil)
+			ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+			if tt.scaleUp {
+				ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+			}
+
+			require.NoError(t, scaler.Scale(context.Background(), cfg))
+
+			switch {
+			case tt.exhausted:
+				require.Contains(t, buf.String(), "launch retry budget exhausted")
+				require.Equal(t, 2, persisted.LaunchCohort.Failed)
+			case tt.scaleUp:
+				require.NotContains(t, buf.String(), "launch retry budget exhausted")
+				require.Equal(t, startedAt, persisted.LaunchCohort.StartedAt)
+				require.Equal(t, 1, persisted.LaunchCohort.Failed)
+			default:
+				require.Nil(t, persisted.LaunchCohort)
+			}
+		})
+	}
+}
+
+func TestAutoScalerLaunchRetryBudgetAcrossInvocations(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:                 5,
+		AutoscalingContinueAfterStrayCleanup: true,
+		AutoscalingLaunchRetryBudget:         2,
+		AutoscalingStateParameter:            "state",
+	}
+
+	persisted := &internal.PersistedState{}
+
+	worker := internal.Worker{
+		ID:       "1",
+		Busy:     true,
+		Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+	}
+
+	invoke := func(strays []string, launched time.Duration, setup func(ctrl *MockController)) {
+		t.Helper()
+
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		instances := []types.Instance{{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService}}
+		described := make([]ec2types.Instance, 0, len(strays))
+
+		for _, stray := range strays {
+			instances = append(instances, types.Instance{InstanceId: ptr(stray), LifecycleState: types.LifecycleStateInService})
+			described = append(described, ec2types.Instance{InstanceId: ptr(stray), LaunchTime: nullable(time.Now().Add(-launched))})
+		}
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers:     []internal.Worker{worker},
+			PendingRuns: 2,
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+			AutoScalingGroupName: ptr("group"),
+			MinSize:              ptr(int32(0)),
+			MaxSize:              ptr(int32(5)),
+			DesiredCapacity:      ptr(int32(len(instances))),
+			Instances:            instances,
+		}, nil)
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		if len(strays) > 0 {
+			ctrl.On("DescribeInstances", mock.Anything, mock.Anything).Return(described, nil)
+		}
+
+		if setup != nil {
+			setup(ctrl)
+		}
+
+		require.NoError(t, internal.NewAutoScaler(ctrl, slog.New(h)).Scale(context.Background(), cfg))
+	}
+
+	// The first invocation launches the instances for the deficit.
+	invoke(nil, 0, func(ctrl *MockController) {
+		ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+	})
+	require.NotNil(t, persisted.LaunchCohort)
+
+	// Pretend the scale-up happened a while ago.
+	persisted.LaunchCohort.StartedAt = time.Now().Add(-time.Hour).Unix()
+
+	// The new instances haven't registered yet, which keeps us from scaling,
+	// but the deficit for them is still there.
+	invoke([]string{"stray-1", "stray-2"}, 5*time.Minute, nil)
+	require.NotNil(t, persisted.LaunchCohort)
+
+	// Both of them never register and get removed, using up the budget.
+	invoke([]string{"stray-1", "stray-2"}, 30*time.Minute, func(ctrl *MockController) {
+		ctrl.On("KillInstance", mock.Anything, "stray-1").Return(nil)
+	})
+	invoke([]string{"stray-2"}, 30*time.Minute, func(ctrl *MockController) {
+		ctrl.On("KillInstance", mock.Anything, "stray-2").Return(nil)
+	})
+	require.Equal(t, 2, persisted.LaunchCohort.Failed)
+
+	// No more instances are launched for the same deficit.
+	buf.Reset()
+	invoke(nil, 0, nil)
+	require.Contains(t, buf.String(), "launch retry budget exhausted")
+}
+
+func TestAutoScalerStraySafetyAbort(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		maxPercent int
+		abort      bool
+	}{
+		{name: "below the threshold", maxPercent: 70, abort: false},
+		{name: "above the threshold", maxPercent: 50, abort: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{AutoscalingMaxStrayPercent: tt.maxPercent}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Busy:     true,
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+				},
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(0)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(3)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+					{InstanceId: ptr("stray-1"), LifecycleState: types.LifecycleStateInService},
+					{InstanceId: ptr("stray-2"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+
+			// With 2 of 3 instances classified as strays, only a limit
+			// below 67% aborts the termination.
+			if !tt.abort {
+				ctrl.On("DescribeInstances", mock.Anything, mock.Anything).Return([]ec2types.Instance{{
+					InstanceId: ptr("stray-1"),
+					LaunchTime: nullable(time.Now().Add(-time.Hour)),
+				}}, nil)
+				ctrl.On("KillInstance", mock.Anything, "stray-1").Return(nil)
+			}
+
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+
+			if tt.abort {
+				require.Contains(t, buf.String(), "too many instances classified as strays, refusing to terminate any")
+			} else {
+				require.NotContains(t, buf.String(), "too many instances classified as strays")
+			}
+		})
+	}
+}
+
+func TestAutoScalerRecordsPanicScaleUp(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:      5,
+		AutoscalingStateParameter: "state",
+		AutoscalingPanicThreshold: 3,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 4,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(5)).Return(nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotNil(t, persisted.Panic)
+	require.Equal(t, 1, persisted.Panic.Baseline)
+}
+
+func TestAutoScalerReclaimsNewestWorkersAfterPanic(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:        1,
+		AutoscalingStateParameter: "state",
+		AutoscalingPanicThreshold: 3,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{
+		Panic: &internal.PanicState{Baseline: 1, ObservedAt: time.Now().Unix()},
+	}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:        "1",
+				CreatedAt: 1,
+				Metadata:  `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+			{
+				ID:        "2",
+				CreatedAt: 2,
+				Metadata:  `{"asg_id": "group", "instance_id": "instance2"}`,
+			},
+			{
+				ID:        "3",
+				CreatedAt: 3,
+				Metadata:  `{"asg_id": "group", "instance_id": "instance3"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+			{InstanceId: ptr("instance2")},
+			{InstanceId: ptr("instance3")},
+		},
+	}, nil)
+	ctrl.On("DrainWorker", mock.Anything, "3").Return(true, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance3").Return(nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotNil(t, persisted.Panic)
+}
+
+func TestAutoScalerTwoPhaseScaleDown(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:           1,
+		AutoscalingStateParameter:    "state",
+		AutoscalingTwoPhaseScaleDown: true,
+	}
+
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+
+	persisted := &internal.PersistedState{}
+
+	// The first pass only drains the worker.
+	ctrl := new(MockController)
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", CreatedAt: 1, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			{ID: "2", CreatedAt: 2, Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+	ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err := internal.NewAutoScaler(ctrl, slog.New(h)).Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	ctrl.AssertExpectations(t)
+	require.Equal(t, []string{"1"}, persisted.PendingTermination)
+
+	// The second pass terminates it, and leaves the other idle worker alone
+	// until the next invocation.
+	ctrl = new(MockController)
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", CreatedAt: 1, Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			{ID: "2", CreatedAt: 2, Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance").Return(nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err = internal.NewAutoScaler(ctrl, slog.New(h)).Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	ctrl.AssertExpectations(t)
+	require.Empty(t, persisted.PendingTermination)
+	require.NotContains(t, buf.String(), "found drained workers with instances still in service")
+}
+
+func TestAutoScalerTwoPhaseScaleDownUndrainsNeededWorkers(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:         1,
+		AutoscalingStateParameter:    "state",
+		AutoscalingTwoPhaseScaleDown: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{PendingTermination: []string{"1"}}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+		PendingRuns: 1,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("UndrainWorker", mock.Anything, "1").Return(nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Empty(t, persisted.PendingTermination)
+	require.Contains(t, buf.String(), "undrained a worker pending termination")
+}
+
+func TestAutoScalerTwoPhaseScaleDownKeepsUndrainedWorkers(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:           1,
+		AutoscalingStateParameter:    "state",
+		AutoscalingTwoPhaseScaleDown: true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{PendingTermination: []string{"1", "2"}}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			{ID: "2", Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Empty(t, persisted.PendingTermination)
+	require.Contains(t, buf.String(), "worker pending termination is no longer idle and drained, keeping it")
+}
+
+func TestAutoScalerTwoPhaseScaleDownConfirmsDrains(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxKill:           1,
+		AutoscalingStateParameter:    "state",
+		AutoscalingTwoPhaseScaleDown: true,
+		AutoscalingConfirmDrains:     true,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{PendingTermination: []string{"1"}}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+
+	// By the time of the termination, the worker picked up a run.
+	ctrl.On("GetWorkerPoolSummary", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{{ID: "1", Drained: true, Busy: true}},
+	}, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Empty(t, persisted.PendingTermination)
+	require.Contains(t, buf.String(), "worker pending termination is no longer reported as idle and drained, keeping it")
+	ctrl.AssertNotCalled(t, "KillInstance", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerSoftDrainsToZero(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingSoftDrain: true}
+
+	group := func(desired int32, instanceIDs ...string) *types.AutoScalingGroup {
+		asg := &types.AutoScalingGroup{
+			AutoScalingGroupName: ptr("group"),
+			MinSize:              ptr(int32(0)),
+			MaxSize:              ptr(int32(3)),
+			DesiredCapacity:      ptr(desired),
+		}
+
+		for _, instanceID := range instanceIDs {
+			asg.Instances = append(asg.Instances, types.Instance{InstanceId: ptr(instanceID), LifecycleState: types.LifecycleStateInService})
+		}
+
+		return asg
+	}
+
+	run := func(workerPool *internal.WorkerPool, asg *types.AutoScalingGroup, expect func(ctrl *MockController)) {
+		ctrl := new(MockController)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(workerPool, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		expect(ctrl)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(h)).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+		ctrl.AssertExpectations(t)
+	}
+
+	// The first pass drains all the workers, and terminates the idle one,
+	// even though there are pending runs.
+	run(&internal.WorkerPool{
+		PendingRuns: 3,
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			{ID: "2", Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, group(2, "instance", "instance2"), func(ctrl *MockController) {
+		ctrl.On("ForceDrainWorker", mock.Anything, "1").Return(nil)
+		ctrl.On("ForceDrainWorker", mock.Anything, "2").Return(nil)
+		ctrl.On("KillInstance", mock.Anything, "instance2").Return(nil)
+	})
+
+	require.Contains(t, buf.String(), "soft drain waiting for workers to finish their runs")
+
+	// The busy worker is left alone while it's still working.
+	run(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+	}, group(1, "instance"), func(ctrl *MockController) {})
+
+	// Once it's done, it's terminated too.
+	buf.Reset()
+
+	run(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+	}, group(1, "instance"), func(ctrl *MockController) {
+		ctrl.On("KillInstance", mock.Anything, "instance").Return(nil)
+	})
+
+	require.Contains(t, buf.String(), "soft drain complete, the pool is empty")
+}
+
+func TestAutoScalerSoftDrainRespectsMinimumSize(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingSoftDrain: true}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			{ID: "2", Drained: true, Metadata: `{"asg_id": "group", "instance_id": "instance2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("KillInstance", mock.Anything, "instance").Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "without going below the minimum size of the ASG")
+}
+
+func TestAutoScalerBacksOffAfterThrottling(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:         5,
+		AutoscalingStateParameter:    "state",
+		AutoscalingThrottleBackoff:   15 * time.Minute,
+		AutoscalingThrottleThreshold: 2,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{}
+
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 1,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(fmt.Errorf("could not set desired capacity: %w", &smithy.GenericAPIError{Code: "Throttling"}))
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	require.Error(t, scaler.Scale(context.Background(), cfg))
+	require.False(t, persisted.BackingOff(time.Now()))
+
+	require.Error(t, scaler.Scale(context.Background(), cfg))
+	require.True(t, persisted.BackingOff(time.Now()))
+	require.Contains(t, buf.String(), "API throttling in consecutive invocations, backing off")
+}
+
+func TestAutoScalerSkipsStrayInstancesWhileBackingOff(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{
+		AutoscalingStateParameter:    "state",
+		AutoscalingThrottleBackoff:   15 * time.Minute,
+		AutoscalingThrottleThreshold: 2,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	persisted := &internal.PersistedState{
+		Throttle: &internal.ThrottleState{BackoffUntil: time.Now().Add(time.Minute).Unix()},
+	}
+
+	// DescribeInstances and KillInstance are not expected to be called.
+	ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("stray"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "backing off after API throttling, skipping non-essential work")
+	require.Contains(t, buf.String(), "deferring stray instance handling until the throttling backoff is over")
+	require.True(t, persisted.BackingOff(time.Now()))
+}
+
+func TestAutoScalerConfirmsScaleUp(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		instances []types.Instance
+		message   string
+	}{
+		{
+			name: "new capacity appeared",
+			instances: []types.Instance{
+				{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				{InstanceId: ptr("new"), LifecycleState: types.LifecycleStatePending},
+				{InstanceId: ptr("new2"), LifecycleState: types.LifecycleStatePending},
+			},
+			message: "new capacity confirmed",
+		},
+		{
+			name: "new capacity did not appear",
+			instances: []types.Instance{
+				{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				{InstanceId: ptr("new"), LifecycleState: types.LifecycleStateTerminating},
+			},
+			message: "new capacity did not appear after scaling up",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:              2,
+				AutoscalingConfirmScaleUp:         true,
+				AutoscalingConfirmScaleUpTimeout:  10 * time.Millisecond,
+				AutoscalingConfirmScaleUpInterval: time.Millisecond,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{
+						ID:       "1",
+						Busy:     true,
+						Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+					},
+				},
+				PendingRuns: 2,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(1)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil).Once()
+			ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(1)),
+				MaxSize:              ptr(int32(3)),
+				DesiredCapacity:      ptr(int32(3)),
+				Instances:            tt.instances,
+			}, nil)
+			err := scaler.Scale(context.Background(), cfg)
+			require.NoError(t, err)
+			require.Contains(t, buf.String(), tt.message)
+		})
+	}
+}
+
+type failingNotifier struct {
+	results []*internal.RunResult
+}
+
+func (n *failingNotifier) Notify(_ context.Context, result *internal.RunResult) error {
+	n.results = append(n.results, result)
+	return errors.New("bacon")
+}
+
+func TestAutoScalerReportsSuppressingGate(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		labels    []string
+		suspended string
+		gates     []string
+	}{
+		{name: "when the pool is the right size"},
+		{name: "when disabled by the label", labels: []string{internal.DisabledLabel}, gates: []string{internal.GateDisabled}},
+		{name: "when the Launch process is suspended", suspended: internal.ProcessLaunch, gates: []string{internal.GateSuspendedProcess}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			notifier := &failingNotifier{}
+			scaler := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil)), notifier)
+
+			pending := int32(1)
+			if tt.suspended == "" {
+				pending = 0
+			}
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Labels: tt.labels,
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+				},
+				PendingRuns: pending,
+			}, nil)
+
+			if len(tt.labels) == 0 {
+				asg := &types.AutoScalingGroup{
+					AutoScalingGroupName: ptr("group"),
+					MinSize:              ptr(int32(0)),
+					MaxSize:              ptr(int32(5)),
+					DesiredCapacity:      ptr(int32(1)),
+					Instances: []types.Instance{
+						{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+					},
+				}
+
+				if tt.suspended != "" {
+					asg.SuspendedProcesses = []types.SuspendedProcess{{ProcessName: ptr(tt.suspended)}}
+				}
+
+				ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+			}
+
+			require.NoError(t, scaler.Scale(context.Background(), cfg))
+
+			require.Len(t, notifier.results, 1)
+			require.Equal(t, internal.ScalingDirectionNone, notifier.results[0].Decision.ScalingDirection)
+			require.Equal(t, tt.gates, notifier.results[0].Decision.SuppressedBy)
+		})
+	}
+}
+
+func TestAutoScalerDuringInstanceRefresh(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		mode       string
+		pending    int32
+		inProgress bool
+		checked    bool
+		scaleUp    bool
+		scaleDown  bool
+	}{
+		{name: "ignoring the refresh", mode: internal.DuringInstanceRefreshIgnore, pending: 3, scaleUp: true},
+		{name: "deferring a scale-up", mode: internal.DuringInstanceRefreshDefer, pending: 3, inProgress: true, checked: true},
+		{name: "deferring a scale-down", mode: internal.DuringInstanceRefreshDefer, inProgress: true, checked: true},
+		{name: "scaling up without a refresh", mode: internal.DuringInstanceRefreshDefer, pending: 3, checked: true, scaleUp: true},
+		{name: "scaling down without a refresh", mode: internal.DuringInstanceRefreshScaleUpOnly, checked: true, scaleDown: true},
+		{name: "only scaling up during a refresh", mode: internal.DuringInstanceRefreshScaleUpOnly, pending: 3, inProgress: true, scaleUp: true},
+		{name: "not scaling down during a refresh", mode: internal.DuringInstanceRefreshScaleUpOnly, inProgress: true, checked: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			cfg := internal.RuntimeConfig{
+				AutoscalingMaxCreate:             5,
+				AutoscalingMaxKill:               1,
+				AutoscalingDuringInstanceRefresh: tt.mode,
+			}
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			notifier := &failingNotifier{}
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h), notifier)
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+					{ID: "2", Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+				},
+				PendingRuns: tt.pending,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(0)),
+				MaxSize:              ptr(int32(10)),
+				DesiredCapacity:      ptr(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance-1"), LifecycleState: types.LifecycleStateInService},
+					{InstanceId: ptr("instance-2"), LifecycleState: types.LifecycleStateInService},
+				},
+			}, nil)
+
+			if tt.checked {
+				ctrl.On("InstanceRefreshInProgress", mock.Anything).Return(tt.inProgress, nil)
+			}
+
+			if tt.scaleUp {
+				ctrl.On("ScaleUpASG", mock.Anything, int32(4)).Return(nil)
+			}
+
+			if tt.scaleDown {
+				ctrl.On("DrainWorker", mock.Anything, "2").Return(true, nil)
+				ctrl.On("KillInstance", mock.Anything, "instance-2").Return(nil)
+			}
+
+			require.NoError(t, scaler.Scale(context.Background(), cfg))
+			require.Len(t, notifier.results, 1)
+
+			if tt.scaleUp || tt.scaleDown {
+				require.NotContains(t, buf.String(), "instance refresh in progress")
+				return
+			}
+
+			require.Contains(t, buf.String(), "instance refresh in progress, deferring scaling to the next invocation")
+			require.Equal(t, []string{internal.GateInstanceRefresh}, notifier.results[0].Decision.SuppressedBy)
+		})
+	}
+}
+
+func TestAutoScalerInstanceRefreshCheckFailure(t *testing.T) {
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:             5,
+		AutoscalingDuringInstanceRefresh: internal.DuringInstanceRefreshDefer,
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("InstanceRefreshInProgress", mock.Anything).Return(false, errors.New("bacon"))
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.EqualError(t, err, "could not check for instance refreshes: bacon")
+}
+
+func TestAutoScalerNotificationFailureDoesNotAbortScaling(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	notifier := &failingNotifier{}
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h), notifier)
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Len(t, notifier.results, 1)
+	require.Equal(t, internal.RunEventScaleUp, notifier.results[0].Event())
+	require.Contains(t, buf.String(), "could not send run result notification")
+}
+
+func TestAutoScalerReportsEffectiveConfig(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	now := time.Now().UTC()
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:       5,
+		AutoscalingMaxKill:         1,
+		AutoscalingPrewarmSchedule: []string{now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04") + "=2"},
+	}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	notifier := &failingNotifier{}
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h), notifier)
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(2)).Return(nil)
+
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Len(t, notifier.results, 1)
+	require.Equal(t, &internal.EffectiveConfig{
+		MinSize:     2,
+		MaxSize:     3,
+		MaxCreate:   5,
+		MaxKill:     1,
+		PrewarmSize: 2,
+	}, notifier.results[0].EffectiveConfig)
+	require.Contains(t, buf.String(), "effective scaling parameters")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestAutoScalerReportsSpaceliftErrorClass(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	notifier := &failingNotifier{}
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h), notifier)
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(nil, fmt.Errorf("could not get Spacelift worker pool details: %w", internal.ErrAuth))
+	err := scaler.Scale(context.Background(), cfg)
+	require.ErrorIs(t, err, internal.ErrAuth)
+	require.Len(t, notifier.results, 1)
+	require.Equal(t, "auth", notifier.results[0].ErrorClass)
+}
+
+func TestAutoScalerWarnsAboutUnknownMetadataVersion(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	cfg := internal.RuntimeConfig{}
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Metadata: `{"metadata_version": "42", "asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(3)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+	err := scaler.Scale(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "worker metadata has an unknown format version")
+	require.Contains(t, buf.String(), "metadata_version=42")
+}
+
+func TestAutoScalerPoolCacheFallback(t *testing.T) {
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:          5,
+		AutoscalingStateParameter:     "state",
+		AutoscalingPoolCacheStaleness: 10 * time.Minute,
+	}
+
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}
+
+	cachedState := func(age time.Duration) *internal.PersistedState {
+		return &internal.PersistedState{
+			PoolCache: &internal.PoolCacheState{
+				Workers:     1,
+				PendingRuns: 2,
+				FetchedAt:   time.Now().Add(-age).Unix(),
+			},
+		}
+	}
+
+	t.Run("scales up from a fresh cache when the query times out", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		persisted := cachedState(time.Minute)
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(nil, fmt.Errorf("could not query: %w", context.DeadlineExceeded))
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		ctrl.On("ScaleUpASG", mock.Anything, int32(3)).Return(nil)
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+	})
+
+	t.Run("consults the decision hook before scaling up from the cache", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		persisted := cachedState(time.Minute)
+
+		cfg := cfg
+		cfg.AutoscalingDecisionHook = writeHook(t, `cat > /dev/null; echo '{"action": "reject"}'`)
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(nil, fmt.Errorf("could not query: %w", context.DeadlineExceeded))
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+		ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+	})
+
+	t.Run("fails when the cache is too old", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		persisted := cachedState(time.Hour)
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(nil, fmt.Errorf("could not query: %w", context.DeadlineExceeded))
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("does not fall back on errors other than timeouts", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		persisted := cachedState(time.Minute)
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(nil, errors.New("bacon"))
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.EqualError(t, err, "could not get worker pool: bacon")
+	})
+
+	t.Run("caches the worker pool after a successful query", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		persisted := &internal.PersistedState{}
+
+		ctrl.On("LoadState", mock.Anything).Return(persisted, nil)
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers: []internal.Worker{
+				{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			},
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		ctrl.On("SaveState", mock.Anything, persisted).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+		require.NotNil(t, persisted.PoolCache)
+		require.Equal(t, 1, persisted.PoolCache.Workers)
+		require.Zero(t, persisted.PoolCache.IdleWorkers)
+	})
+}
+
+func TestAutoScalerSuspendedProcesses(t *testing.T) {
+	suspendedASG := func(process string, instances ...string) *types.AutoScalingGroup {
+		asg := &types.AutoScalingGroup{
+			AutoScalingGroupName: ptr("group"),
+			MinSize:              ptr(int32(0)),
+			MaxSize:              ptr(int32(10)),
+			DesiredCapacity:      ptr(int32(len(instances))),
+			SuspendedProcesses:   []types.SuspendedProcess{{ProcessName: ptr(process)}},
+		}
+
+		for _, instance := range instances {
+			asg.Instances = append(asg.Instances, types.Instance{InstanceId: ptr(instance), LifecycleState: types.LifecycleStateInService})
+		}
+
+		return asg
+	}
+
+	t.Run("skips scaling up while launches are suspended", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{PendingRuns: 2}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(suspendedASG(internal.ProcessLaunch), nil)
+
+		notifier := &failingNotifier{}
+		scaler := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil)), notifier)
+
+		err := scaler.Scale(context.Background(), internal.RuntimeConfig{AutoscalingMaxCreate: 5})
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "Auto Scaling process suspended in the ASG, skipping scaling")
+		require.Len(t, notifier.results, 1)
+		require.Equal(t, internal.ScalingDirectionNone, notifier.results[0].Decision.ScalingDirection)
+		require.Contains(t, notifier.results[0].Decision.Comments, "Launch process is suspended in the ASG")
+	})
+
+	t.Run("skips scaling down while terminations are suspended", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers: []internal.Worker{
+				{ID: "1", Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			},
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(suspendedASG(internal.ProcessTerminate, "instance"), nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil))).Scale(context.Background(), internal.RuntimeConfig{AutoscalingMaxKill: 1})
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "Auto Scaling process suspended in the ASG, skipping scaling")
+	})
+
+	t.Run("defers stray instance handling while terminations are suspended", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers: []internal.Worker{
+				{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			},
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(suspendedASG(internal.ProcessTerminate, "instance", "stray"), nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil))).Scale(context.Background(), internal.RuntimeConfig{AutoscalingMaxKill: 1})
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "deferring stray instance handling while the Terminate process is suspended in the ASG")
+	})
+}
+
+func TestAutoScalerMaxConcurrentDrains(t *testing.T) {
+	const workers = 6
+
+	pool := &internal.WorkerPool{}
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(10)),
+		DesiredCapacity:      ptr(int32(workers)),
+	}
+
+	for i := 0; i < workers; i++ {
+		instanceID := fmt.Sprintf("instance-%d", i)
+
+		pool.Workers = append(pool.Workers, internal.Worker{
+			ID:        fmt.Sprintf("worker-%d", i),
+			CreatedAt: int32(i),
+			Metadata:  fmt.Sprintf(`{"asg_id": "group", "instance_id": "%s"}`, instanceID),
+		})
+		asg.Instances = append(asg.Instances, types.Instance{InstanceId: ptr(instanceID), LifecycleState: types.LifecycleStateInService})
+	}
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxKill: workers, AutoscalingMaxConcurrentDrains: 2}
+
+	// countingDrain tracks the number of drains in flight, and the highest
+	// number seen at any time.
+	countingDrain := func(busy map[string]bool) (func(context.Context, string) (bool, error), *atomic.Int32) {
+		var inFlight, peak atomic.Int32
+
+		return func(_ context.Context, workerID string) (bool, error) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			for {
+				seen := peak.Load()
+				if current <= seen || peak.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			return !busy[workerID], nil
+		}, &peak
+	}
+
+	t.Run("never exceeds the configured number of concurrent drains", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		drain, peak := countingDrain(nil)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(pool, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		ctrl.On("DrainWorker", mock.Anything, mock.Anything).Return(drain)
+		ctrl.On("KillInstance", mock.Anything, mock.Anything).Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+
+		ctrl.AssertNumberOfCalls(t, "DrainWorker", workers)
+		ctrl.AssertNumberOfCalls(t, "KillInstance", workers)
+		require.LessOrEqual(t, peak.Load(), int32(cfg.AutoscalingMaxConcurrentDrains))
+	})
+
+	t.Run("stops after the batch with a busy worker", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		drain, _ := countingDrain(map[string]bool{"worker-1": true})
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(pool, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(asg, nil)
+		ctrl.On("DrainWorker", mock.Anything, mock.Anything).Return(drain)
+		ctrl.On("KillInstance", mock.Anything, "instance-0").Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+
+		ctrl.AssertNumberOfCalls(t, "DrainWorker", 2)
+	})
+}
+
+func TestAutoScalerWarnsAboutMaxSizeBelowWorkers(t *testing.T) {
+	var buf bytes.Buffer
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+			{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+		},
+		PendingRuns: 1,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(1)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance-1"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: ptr("instance-2"), LifecycleState: types.LifecycleStateInService},
+		},
+	}, nil)
+
+	err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil))).Scale(context.Background(), internal.RuntimeConfig{AutoscalingMaxCreate: 1})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "ASG maximum size is below the current number of workers, consider increasing the maximum size")
+}
+
+func TestAutoScalerSkipsWithCredentialsNearExpiry(t *testing.T) {
+	var buf bytes.Buffer
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	ctrl.On("CheckCredentials", mock.Anything, 5*time.Minute).Return(false, nil)
+
+	notifier := &failingNotifier{}
+	scaler := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil)), notifier)
+
+	err := scaler.Scale(context.Background(), internal.RuntimeConfig{CloudCredentialsExpiryBuffer: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "cloud credentials expire within the buffer and could not be refreshed, skipping scaling")
+	require.Len(t, notifier.results, 1)
+	require.Equal(t, []string{"cloud credentials about to expire"}, notifier.results[0].Decision.Comments)
+}
+
+func TestAutoScalerProtectionTag(t *testing.T) {
+	cfg := internal.RuntimeConfig{AutoscalingMaxKill: 1, AutoscalingProtectionTag: "keep=true"}
+	protectedTags := []ec2types.Tag{{Key: ptr("keep"), Value: ptr("true")}}
+
+	t.Run("scales down the next worker when the oldest one is protected", func(t *testing.T) {
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers: []internal.Worker{
+				{ID: "1", CreatedAt: 1, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+				{ID: "2", CreatedAt: 2, Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+			},
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+			AutoScalingGroupName: ptr("group"),
+			MinSize:              ptr(int32(0)),
+			MaxSize:              ptr(int32(5)),
+			DesiredCapacity:      ptr(int32(2)),
+			Instances: []types.Instance{
+				{InstanceId: ptr("instance-1"), LifecycleState: types.LifecycleStateInService},
+				{InstanceId: ptr("instance-2"), LifecycleState: types.LifecycleStateInService},
+			},
+		}, nil)
+		ctrl.On("DescribeInstances", mock.Anything, []string{"instance-1", "instance-2"}).Return([]ec2types.Instance{
+			{InstanceId: ptr("instance-1"), Tags: protectedTags},
+			{InstanceId: ptr("instance-2")},
+		}, nil)
+		ctrl.On("DrainWorker", mock.Anything, "2").Return(true, nil)
+		ctrl.On("KillInstance", mock.Anything, "instance-2").Return(nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(io.Discard, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+	})
+
+	t.Run("leaves protected stray instances alone", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		ctrl := new(MockController)
+		defer ctrl.AssertExpectations(t)
+
+		ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+			Workers: []internal.Worker{
+				{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+			},
+		}, nil)
+		ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+			AutoScalingGroupName: ptr("group"),
+			MinSize:              ptr(int32(0)),
+			MaxSize:              ptr(int32(5)),
+			DesiredCapacity:      ptr(int32(2)),
+			Instances: []types.Instance{
+				{InstanceId: ptr("instance"), LifecycleState: types.LifecycleStateInService},
+				{InstanceId: ptr("stray"), LifecycleState: types.LifecycleStateInService},
+			},
+		}, nil)
+		ctrl.On("DescribeInstances", mock.Anything, []string{"stray"}).Return([]ec2types.Instance{
+			{InstanceId: ptr("stray"), LaunchTime: ptr(time.Now().Add(-time.Hour)), Tags: protectedTags},
+		}, nil)
+
+		err := internal.NewAutoScaler(ctrl, slog.New(slog.NewTextHandler(&buf, nil))).Scale(context.Background(), cfg)
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "instance has no corresponding worker in Spacelift, but is protected from termination")
+	})
 }
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CloudEventsSinkStdout writes the events to the standard output, one per line.
+const CloudEventsSinkStdout = "stdout"
+
+// cloudEventTypePrefix is prepended to the run event to form the type of the
+// CloudEvent, eg. io.spacelift.autoscaler.scale_up.
+const cloudEventTypePrefix = "io.spacelift.autoscaler."
+
+// CloudEvent is a CloudEvents 1.0 envelope in the JSON format, carrying the
+// result of an autoscaler run as its data.
+type CloudEvent struct {
+	SpecVersion     string     `json:"specversion"`
+	ID              string     `json:"id"`
+	Source          string     `json:"source"`
+	Type            string     `json:"type"`
+	Subject         string     `json:"subject,omitempty"`
+	Time            string     `json:"time"`
+	DataContentType string     `json:"datacontenttype"`
+	Data            *RunResult `json:"data"`
+}
+
+// NewCloudEvent wraps the run result in a CloudEvent. The source identifies
+// the ASG being scaled, and the subject the worker pool.
+func NewCloudEvent(result *RunResult, id string, now time.Time) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          result.AutoscalingGroupARN,
+		Type:            cloudEventTypePrefix + result.Event(),
+		Subject:         result.WorkerPoolID,
+		Time:            now.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            result,
+	}
+}
+
+// CloudEventsNotifier writes a CloudEvent to the writer for each run which
+// took a scaling action or failed.
+type CloudEventsNotifier struct {
+	Writer io.Writer
+}
+
+// NewCloudEventsNotifier creates a CloudEvents notifier writing to the given
+// writer.
+func NewCloudEventsNotifier(w io.Writer) *CloudEventsNotifier {
+	return &CloudEventsNotifier{Writer: w}
+}
+
+// Notify writes the run result as a CloudEvent, unless no action was taken.
+func (n *CloudEventsNotifier) Notify(_ context.Context, result *RunResult) error {
+	if result.Event() == RunEventNone {
+		return nil
+	}
+
+	id, err := newCloudEventID()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(NewCloudEvent(result, id, time.Now()))
+	if err != nil {
+		return fmt.Errorf("could not serialize CloudEvent: %w", err)
+	}
+
+	if _, err := n.Writer.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("could not write CloudEvent: %w", err)
+	}
+
+	return nil
+}
+
+// newCloudEventID generates a random event ID, unique for every event.
+func newCloudEventID() (string, error) {
+	buf := make([]byte, 16)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate CloudEvent ID: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,110 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestCloudEventsNotifier(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("CloudEventsNotifier", func() {
+		var buf *bytes.Buffer
+		var result *internal.RunResult
+		var sut *internal.CloudEventsNotifier
+		var err error
+
+		g.BeforeEach(func() {
+			buf = &bytes.Buffer{}
+
+			result = internal.NewRunResult(internal.RuntimeConfig{
+				SpaceliftWorkerPoolID: "pool",
+				AutoscalingGroupARN:   "arn",
+			})
+			result.Decision = internal.Decision{
+				ScalingDirection: internal.ScalingDirectionUp,
+				ScalingSize:      2,
+				Comments:         []string{"adding workers to match pending runs"},
+			}
+
+			sut = internal.NewCloudEventsNotifier(buf)
+		})
+
+		g.JustBeforeEach(func() { err = sut.Notify(context.Background(), result) })
+
+		g.Describe("for a scaling action", func() {
+			var event map[string]any
+
+			g.JustBeforeEach(func() {
+				event = nil
+				Expect(json.Unmarshal(buf.Bytes(), &event)).To(Succeed())
+			})
+
+			g.It("should write a single line", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bytes.Count(buf.Bytes(), []byte("\n"))).To(Equal(1))
+			})
+
+			g.It("should set the envelope fields", func() {
+				Expect(event["specversion"]).To(Equal("1.0"))
+				Expect(event["id"]).To(HaveLen(32))
+				Expect(event["source"]).To(Equal("arn"))
+				Expect(event["type"]).To(Equal("io.spacelift.autoscaler.scale_up"))
+				Expect(event["subject"]).To(Equal("pool"))
+				Expect(event["datacontenttype"]).To(Equal("application/json"))
+
+				_, parseErr := time.Parse(time.RFC3339Nano, event["time"].(string))
+				Expect(parseErr).NotTo(HaveOccurred())
+			})
+
+			g.It("should carry the run result as data", func() {
+				data := event["data"].(map[string]any)
+				Expect(data["worker_pool_id"]).To(Equal("pool"))
+				Expect(data["decision"].(map[string]any)["size"]).To(BeEquivalentTo(2))
+			})
+		})
+
+		g.Describe("for a run with an error", func() {
+			g.BeforeEach(func() { result.Error = "bacon" })
+
+			g.It("should use the error event type", func() {
+				var event map[string]any
+				Expect(json.Unmarshal(buf.Bytes(), &event)).To(Succeed())
+				Expect(event["type"]).To(Equal("io.spacelift.autoscaler.error"))
+			})
+		})
+
+		g.Describe("for a run with no action", func() {
+			g.BeforeEach(func() { result.Decision = internal.Decision{ScalingDirection: internal.ScalingDirectionNone} })
+
+			g.It("should not write anything", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buf.Len()).To(BeZero())
+			})
+		})
+
+		g.Describe("when the write fails", func() {
+			g.BeforeEach(func() { sut.Writer = failingWriter{} })
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("could not write CloudEvent: bacon"))
+			})
+		})
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("bacon")
+}
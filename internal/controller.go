@@ -2,17 +2,24 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
 	"github.com/aws/aws-xray-sdk-go/xray"
@@ -23,57 +30,113 @@ import (
 	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
 )
 
+// WorkerPoolIDTagKey is the key of the ASG tag identifying the worker pool its
+// instances belong to, for cost allocation purposes.
+const WorkerPoolIDTagKey = "spacelift:worker-pool-id"
+
+// LaunchCohortTagKey is the tag identifying the scale-up which launched an
+// instance, so that spend can be attributed to scaling decisions.
+const LaunchCohortTagKey = "spacelift:launch-cohort"
+
+// ErrScalingActivityInProgress is returned when the autoscaling group could not
+// be scaled because another scaling activity is still in progress.
+var ErrScalingActivityInProgress = errors.New("scaling activity in progress")
+
+// Maximum number of attempts to set the desired capacity of the autoscaling
+// group when it fails with a transient error.
+const scaleUpMaxAttempts = 3
+
+// How long the instance quota is cached for. Service quotas rarely change, so
+// there is no point in looking them up on every invocation.
+const instanceQuotaTTL = time.Hour
+
+// defaultQuotaCache is shared between controllers created in the same process,
+// so that it survives between invocations of a warm Lambda.
+var defaultQuotaCache = NewQuotaCache(instanceQuotaTTL)
+
 // Controller is responsible for handling interactions with external systems
 // (Spacelift API as well as AWS Autoscaling and EC2 APIs) so that the main
 // package can focus on the core logic.
 type Controller struct {
 	// Clients.
-	Autoscaling ifaces.Autoscaling
-	EC2         ifaces.EC2
-	Spacelift   ifaces.Spacelift
+	Autoscaling   ifaces.Autoscaling
+	EC2           ifaces.EC2
+	Spacelift     ifaces.Spacelift
+	SSM           ifaces.SSM
+	ServiceQuotas ifaces.ServiceQuotas
+
+	// Credentials used by the AWS clients, to check them for expiry.
+	Credentials awssdk.CredentialsProvider
+
+	// AWSConfig is the configuration the AWS clients are created from, to be
+	// shared with the clients created outside the controller.
+	AWSConfig awssdk.Config
 
 	// Configuration.
 	AWSAutoscalingGroupName string
 	SpaceliftWorkerPoolID   string
+	SharedWorkerPoolIDs     []string
+	StateParameterName      string
+	VCPUQuotaCode           string
+	InstanceVCPUs           int
+
+	// Optional worker pool fields to query. Not every Spacelift API exposes
+	// them, so each is queried separately, and only if a feature needs it.
+	QuerySuspension bool
+	QueryPausedRuns bool
+	QueryLabels     bool
+	QueryHeartbeats bool
+	QueryRunLabels  bool
+
+	// Instance refresh preferences. Zero values leave the AWS defaults.
+	RefreshMinHealthyPercentage int
+	RefreshInstanceWarmup       time.Duration
+
+	// Cache for the instance quota. Nil disables caching.
+	QuotaCache *QuotaCache
+
+	// Base delay between retries of transient failures, doubled with each
+	// attempt.
+	RetryBackoff time.Duration
+
+	// Timeout for each individual AWS API call. Zero means no timeout.
+	CloudAPITimeout time.Duration
+
+	// Number of external API calls made by this controller so far.
+	apiCalls atomic.Int64
+
+	// Pools sharing the ASG which the workers seen so far belong to, by their
+	// IDs. Workers of the main pool are not included.
+	sharedWorkerPoolIDs map[string]string
 }
 
 // NewController creates a new controller instance.
 func NewController(ctx context.Context, cfg *RuntimeConfig) (*Controller, error) {
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AutoscalingRegion))
+	baseHTTPClient, err := NewHTTPClient(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("could not load AWS configuration: %w", err)
+		return nil, fmt.Errorf("could not create HTTP client: %w", err)
 	}
 
-	awsv2.AWSV2Instrumentor(&awsConfig.APIOptions)
+	awsConfig, err := loadAWSConfig(ctx, cfg, baseHTTPClient)
+	if err != nil {
+		return nil, err
+	}
 
 	ssmClient := ssm.NewFromConfig(awsConfig)
-	var output *ssm.GetParameterOutput
-
-	xray.Capture(ctx, "aws.ssm.secret", func(ctx context.Context) error {
-		output, err = ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
-			Name:           aws.String(cfg.SpaceliftAPISecretName),
-			WithDecryption: aws.Bool(true),
-		})
-
-		return err
-	})
 
+	apiKeySecret, err := GetAPIKeySecret(ctx, ssmClient, cfg.SpaceliftAPISecretName, cfg.SecretFetchMaxRetries, time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("could not get Spacelift API key secret from SSM: %w", err)
-	} else if output.Parameter == nil {
-		return nil, errors.New("could not find Spacelift API key secret in SSM")
-	} else if output.Parameter.Value == nil {
-		return nil, errors.New("could not find Spacelift API key secret value in SSM")
+		return nil, err
 	}
 
 	var slSession session.Session
-	httpClient := xray.Client(nil)
+	httpClient := xray.Client(baseHTTPClient)
 
 	xray.Capture(ctx, "spacelift.session.get", func(ctx context.Context) error {
 		slSession, err = session.FromAPIKey(ctx, httpClient)(
 			cfg.SpaceliftAPIEndpoint,
 			cfg.SpaceliftAPIKeyID,
-			*output.Parameter.Value,
+			apiKeySecret,
 		)
 
 		return err
@@ -88,24 +151,79 @@ func NewController(ctx context.Context, cfg *RuntimeConfig) (*Controller, error)
 		return nil, fmt.Errorf("could not parse autoscaling group ARN")
 	}
 
+	// The quota is only looked up if there's one to look up.
+	var quotasClient ifaces.ServiceQuotas
+	if cfg.AutoscalingVCPUQuotaCode != "" {
+		quotasClient = servicequotas.NewFromConfig(awsConfig)
+	}
+
+	// The session is only established once per invocation, so only the
+	// regular API requests use persisted queries.
+	spaceliftHTTPClient := httpClient
+	if cfg.SpaceliftPersistedQueries {
+		spaceliftHTTPClient = &http.Client{Transport: &PersistedQueryTransport{Base: httpClient.Transport}}
+	}
+
 	return &Controller{
-		Autoscaling:             autoscaling.NewFromConfig(awsConfig),
-		EC2:                     ec2.NewFromConfig(awsConfig),
-		Spacelift:               spacelift.New(httpClient, slSession),
-		AWSAutoscalingGroupName: arnParts[1],
-		SpaceliftWorkerPoolID:   cfg.SpaceliftWorkerPoolID,
+		Autoscaling:                 autoscaling.NewFromConfig(awsConfig),
+		EC2:                         ec2.NewFromConfig(awsConfig),
+		Spacelift:                   spacelift.New(spaceliftHTTPClient, slSession),
+		SSM:                         ssmClient,
+		ServiceQuotas:               quotasClient,
+		Credentials:                 awsConfig.Credentials,
+		AWSConfig:                   awsConfig,
+		AWSAutoscalingGroupName:     arnParts[1],
+		SpaceliftWorkerPoolID:       cfg.SpaceliftWorkerPoolID,
+		SharedWorkerPoolIDs:         cfg.SpaceliftSharedWorkerPoolIDs,
+		StateParameterName:          cfg.AutoscalingStateParameter,
+		VCPUQuotaCode:               cfg.AutoscalingVCPUQuotaCode,
+		InstanceVCPUs:               cfg.AutoscalingInstanceVCPUs,
+		QuerySuspension:             cfg.AutoscalingHonorPoolSuspension,
+		QueryPausedRuns:             !cfg.AutoscalingIncludePausedRuns,
+		QueryLabels:                 cfg.AutoscalingHonorDisabledLabel,
+		QueryHeartbeats:             cfg.AutoscalingHeartbeatStaleness > 0,
+		QueryRunLabels:              cfg.AutoscalingRunLabelExpression != "",
+		RefreshMinHealthyPercentage: cfg.AutoscalingRefreshMinHealthy,
+		RefreshInstanceWarmup:       cfg.AutoscalingRefreshWarmup,
+		QuotaCache:                  defaultQuotaCache,
+		RetryBackoff:                time.Second,
+		CloudAPITimeout:             cfg.CloudAPITimeout,
 	}, nil
 }
 
+func loadAWSConfig(ctx context.Context, cfg *RuntimeConfig, httpClient *http.Client) (awssdk.Config, error) {
+	awsConfig, err := config.LoadDefaultConfig(
+		ctx,
+		config.WithRegion(cfg.AutoscalingRegion),
+		config.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return awsConfig, fmt.Errorf("could not load AWS configuration: %w", err)
+	}
+
+	awsv2.AWSV2Instrumentor(&awsConfig.APIOptions)
+
+	return awsConfig, nil
+}
+
+// APICalls returns the number of external API calls made by the controller
+// so far.
+func (c *Controller) APICalls() int {
+	return int(c.apiCalls.Load())
+}
+
 // DescribeInstances returns the details of the given instances from AWS,
 // making sure that the instances are valid for further processing.
 func (c *Controller) DescribeInstances(ctx context.Context, instanceIDs []string) (instances []ec2types.Instance, err error) {
 	xray.Capture(ctx, "aws.ec2.describeInstances", func(ctx context.Context) error {
 		var output *ec2.DescribeInstancesOutput
 
-		output, err = c.EC2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.EC2.DescribeInstances(callCtx, &ec2.DescribeInstancesInput{
 			InstanceIds: instanceIDs,
 		})
+		cancel()
 
 		if err != nil {
 			err = fmt.Errorf("could not describe instances: %w", err)
@@ -142,9 +260,12 @@ func (c *Controller) GetAutoscalingGroup(ctx context.Context) (out *autoscalingt
 	xray.Capture(ctx, "aws.asg.get", func(ctx context.Context) error {
 		var output *autoscaling.DescribeAutoScalingGroupsOutput
 
-		output, err = c.Autoscaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.Autoscaling.DescribeAutoScalingGroups(callCtx, &autoscaling.DescribeAutoScalingGroupsInput{
 			AutoScalingGroupNames: []string{c.AWSAutoscalingGroupName},
 		})
+		cancel()
 
 		if err != nil {
 			err = fmt.Errorf("could not get autoscaling group details: %w", err)
@@ -167,18 +288,194 @@ func (c *Controller) GetAutoscalingGroup(ctx context.Context) (out *autoscalingt
 	return
 }
 
-// GetWorkerPool returns the worker pool details from Spacelift.
-func (c *Controller) GetWorkerPool(ctx context.Context) (out *WorkerPool, err error) {
-	xray.Capture(ctx, "spacelift.workerpool.get", func(ctx context.Context) error {
-		var wpDetails WorkerPoolDetails
+// GetInstanceQuota returns the number of instances the account's EC2 vCPU
+// quota allows for, given the number of vCPUs per instance. The result is
+// cached, so the quota is only looked up once in a while.
+func (c *Controller) GetInstanceQuota(ctx context.Context) (quota int, err error) {
+	if quota, ok := c.QuotaCache.get(c.VCPUQuotaCode); ok {
+		return quota, nil
+	}
+
+	xray.Capture(ctx, "aws.servicequotas.get", func(ctx context.Context) error {
+		var output *servicequotas.GetServiceQuotaOutput
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.ServiceQuotas.GetServiceQuota(callCtx, &servicequotas.GetServiceQuotaInput{
+			ServiceCode: aws.String("ec2"),
+			QuotaCode:   aws.String(c.VCPUQuotaCode),
+		})
+		cancel()
 
-		if err = c.Spacelift.Query(ctx, &wpDetails, map[string]any{"workerPool": c.SpaceliftWorkerPoolID}); err != nil {
-			err = fmt.Errorf("could not get Spacelift worker pool details: %w", err)
+		if err != nil {
+			err = fmt.Errorf("could not get service quota %s: %w", c.VCPUQuotaCode, err)
+			return err
+		}
+
+		if output.Quota == nil || output.Quota.Value == nil {
+			err = fmt.Errorf("could not find the value of service quota %s", c.VCPUQuotaCode)
 			return err
 		}
 
-		if wpDetails.Pool == nil {
-			err = errors.New("worker pool not found or not accessible")
+		quota = int(*output.Quota.Value) / c.InstanceVCPUs
+
+		return nil
+	})
+
+	if err == nil {
+		c.QuotaCache.set(c.VCPUQuotaCode, quota)
+	}
+
+	return
+}
+
+// EnsureWorkerPoolTag makes sure that the autoscaling group carries a tag with
+// the worker pool ID, propagated to the instances it launches. The tag is only
+// applied if it's missing or has a different value.
+func (c *Controller) EnsureWorkerPoolTag(ctx context.Context) (applied bool, err error) {
+	var asg *autoscalingtypes.AutoScalingGroup
+
+	if asg, err = c.GetAutoscalingGroup(ctx); err != nil {
+		return false, err
+	}
+
+	for _, tag := range asg.Tags {
+		if tag.Key == nil || *tag.Key != WorkerPoolIDTagKey {
+			continue
+		}
+
+		if tag.Value != nil && *tag.Value == c.SpaceliftWorkerPoolID && tag.PropagateAtLaunch != nil && *tag.PropagateAtLaunch {
+			return false, nil
+		}
+	}
+
+	xray.Capture(ctx, "aws.asg.tag", func(ctx context.Context) error {
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		_, err = c.Autoscaling.CreateOrUpdateTags(callCtx, &autoscaling.CreateOrUpdateTagsInput{
+			Tags: []autoscalingtypes.Tag{{
+				Key:               aws.String(WorkerPoolIDTagKey),
+				Value:             aws.String(c.SpaceliftWorkerPoolID),
+				PropagateAtLaunch: aws.Bool(true),
+				ResourceId:        aws.String(c.AWSAutoscalingGroupName),
+				ResourceType:      aws.String("auto-scaling-group"),
+			}},
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not tag autoscaling group: %w", err)
+			return err
+		}
+
+		applied = true
+
+		return nil
+	})
+
+	return
+}
+
+// TagLaunchCohort sets the launch cohort tag on the autoscaling group. The tag
+// is propagated at launch, so the instances launched from now on carry it.
+func (c *Controller) TagLaunchCohort(ctx context.Context, cohort string) (err error) {
+	xray.Capture(ctx, "aws.asg.tag_cohort", func(ctx context.Context) error {
+		xray.AddAnnotation(ctx, "launch_cohort", cohort)
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		_, err = c.Autoscaling.CreateOrUpdateTags(callCtx, &autoscaling.CreateOrUpdateTagsInput{
+			Tags: []autoscalingtypes.Tag{{
+				Key:               aws.String(LaunchCohortTagKey),
+				Value:             aws.String(cohort),
+				PropagateAtLaunch: aws.Bool(true),
+				ResourceId:        aws.String(c.AWSAutoscalingGroupName),
+				ResourceType:      aws.String("auto-scaling-group"),
+			}},
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not tag launch cohort: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GetScalingPolicies returns the names of the enabled scaling policies attached
+// to the autoscaling group. These would fight with the autoscaler over the
+// desired capacity of the group.
+func (c *Controller) GetScalingPolicies(ctx context.Context) (names []string, err error) {
+	xray.Capture(ctx, "aws.asg.policies", func(ctx context.Context) error {
+		var nextToken *string
+
+		for {
+			var output *autoscaling.DescribePoliciesOutput
+
+			c.recordAPICall()
+			callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+			output, err = c.Autoscaling.DescribePolicies(callCtx, &autoscaling.DescribePoliciesInput{
+				AutoScalingGroupName: aws.String(c.AWSAutoscalingGroupName),
+				NextToken:            nextToken,
+			})
+			cancel()
+			if err != nil {
+				err = fmt.Errorf("could not describe scaling policies: %w", err)
+				return err
+			}
+
+			for _, policy := range output.ScalingPolicies {
+				if policy.Enabled != nil && !*policy.Enabled {
+					continue
+				}
+
+				if policy.PolicyName != nil {
+					names = append(names, *policy.PolicyName)
+				}
+			}
+
+			if nextToken = output.NextToken; nextToken == nil {
+				break
+			}
+		}
+
+		xray.AddMetadata(ctx, "scaling_policies", names)
+
+		return nil
+	})
+
+	return
+}
+
+// GetWorkerPool returns the worker pool details from Spacelift. If other pools
+// share the ASG, they are merged into the result.
+func (c *Controller) GetWorkerPool(ctx context.Context) (out *WorkerPool, err error) {
+	xray.Capture(ctx, "spacelift.workerpool.get", func(ctx context.Context) error {
+		out, err = c.queryWorkerPools(func(poolID string) (*WorkerPool, error) {
+			var wpDetails WorkerPoolDetails
+
+			c.recordAPICall()
+			if err := c.Spacelift.Query(ctx, &wpDetails, map[string]any{"workerPool": poolID}); err != nil {
+				return nil, fmt.Errorf("could not get Spacelift worker pool details: %w", classifySpaceliftError(err))
+			}
+
+			if wpDetails.Pool == nil {
+				return nil, &spaceliftError{category: ErrNotFound, err: errors.New("worker pool not found or not accessible")}
+			}
+
+			pool := wpDetails.Pool.WorkerPool()
+			if err := c.queryOptionalFields(ctx, poolID, pool); err != nil {
+				return nil, err
+			}
+
+			return pool, nil
+		})
+
+		if err != nil {
 			return err
 		}
 
@@ -188,14 +485,53 @@ func (c *Controller) GetWorkerPool(ctx context.Context) (out *WorkerPool, err er
 		//
 		// The backend should already return the workers in the order of their
 		// creation, but let's be extra safe and not rely on that.
-		sort.Slice(wpDetails.Pool.Workers, func(i, j int) bool {
-			return wpDetails.Pool.Workers[i].CreatedAt < wpDetails.Pool.Workers[j].CreatedAt
+		sort.Slice(out.Workers, func(i, j int) bool {
+			return out.Workers[i].CreatedAt < out.Workers[j].CreatedAt
+		})
+
+		xray.AddMetadata(ctx, "workers", len(out.Workers))
+		xray.AddMetadata(ctx, "pending_runs", out.PendingRuns)
+		xray.AddMetadata(ctx, "paused_runs", out.PausedRuns)
+
+		return nil
+	})
+
+	return
+}
+
+// GetWorkerPoolSummary returns a lightweight summary of the worker pool from
+// Spacelift, without the per-worker metadata. For very large pools this is
+// much cheaper than GetWorkerPool. If other pools share the ASG, they are
+// merged into the result.
+func (c *Controller) GetWorkerPoolSummary(ctx context.Context) (out *WorkerPool, err error) {
+	xray.Capture(ctx, "spacelift.workerpool.summary", func(ctx context.Context) error {
+		out, err = c.queryWorkerPools(func(poolID string) (*WorkerPool, error) {
+			var wpSummary WorkerPoolSummaryDetails
+
+			c.recordAPICall()
+			if err := c.Spacelift.Query(ctx, &wpSummary, map[string]any{"workerPool": poolID}); err != nil {
+				return nil, fmt.Errorf("could not get Spacelift worker pool summary: %w", classifySpaceliftError(err))
+			}
+
+			if wpSummary.Pool == nil {
+				return nil, &spaceliftError{category: ErrNotFound, err: errors.New("worker pool not found or not accessible")}
+			}
+
+			pool := wpSummary.Pool.WorkerPool()
+			if err := c.queryOptionalFields(ctx, poolID, pool); err != nil {
+				return nil, err
+			}
+
+			return pool, nil
 		})
 
-		xray.AddMetadata(ctx, "workers", len(wpDetails.Pool.Workers))
-		xray.AddMetadata(ctx, "pending_runs", wpDetails.Pool.PendingRuns)
+		if err != nil {
+			return err
+		}
 
-		out = wpDetails.Pool
+		xray.AddMetadata(ctx, "workers", len(out.Workers))
+		xray.AddMetadata(ctx, "pending_runs", out.PendingRuns)
+		xray.AddMetadata(ctx, "paused_runs", out.PausedRuns)
 
 		return nil
 	})
@@ -203,6 +539,127 @@ func (c *Controller) GetWorkerPool(ctx context.Context) (out *WorkerPool, err er
 	return
 }
 
+// queryWorkerPools queries the worker pool, as well as all the pools sharing
+// its ASG, and merges the results. It also remembers which of the shared pools
+// each of the workers belongs to, so that they can be drained later.
+func (c *Controller) queryWorkerPools(query func(poolID string) (*WorkerPool, error)) (*WorkerPool, error) {
+	primary, err := query(c.SpaceliftWorkerPoolID)
+	if err != nil || len(c.SharedWorkerPoolIDs) == 0 {
+		return primary, err
+	}
+
+	shared := make([]*WorkerPool, 0, len(c.SharedWorkerPoolIDs))
+	c.sharedWorkerPoolIDs = make(map[string]string)
+
+	for _, poolID := range c.SharedWorkerPoolIDs {
+		pool, err := query(poolID)
+		if err != nil {
+			return nil, fmt.Errorf("shared worker pool %s: %w", poolID, err)
+		}
+
+		for _, worker := range pool.Workers {
+			c.sharedWorkerPoolIDs[worker.ID] = poolID
+		}
+
+		shared = append(shared, pool)
+	}
+
+	return MergeWorkerPools(primary, shared...), nil
+}
+
+// queryOptionalFields fills in the optional fields of the worker pool which
+// the enabled features need, one query for each.
+func (c *Controller) queryOptionalFields(ctx context.Context, poolID string, pool *WorkerPool) error {
+	variables := map[string]any{"workerPool": poolID}
+
+	if c.QuerySuspension {
+		var details WorkerPoolSuspensionDetails
+
+		c.recordAPICall()
+		if err := c.Spacelift.Query(ctx, &details, variables); err != nil {
+			return fmt.Errorf("could not get Spacelift worker pool suspension: %w", classifySpaceliftError(err))
+		}
+
+		if details.Pool != nil {
+			pool.Suspended = details.Pool.Suspended
+		}
+	}
+
+	if c.QueryPausedRuns {
+		var details WorkerPoolPausedRunsDetails
+
+		c.recordAPICall()
+		if err := c.Spacelift.Query(ctx, &details, variables); err != nil {
+			return fmt.Errorf("could not get Spacelift worker pool paused runs: %w", classifySpaceliftError(err))
+		}
+
+		if details.Pool != nil {
+			pool.PausedRuns = details.Pool.PausedRuns
+		}
+	}
+
+	if c.QueryLabels {
+		var details WorkerPoolLabelsDetails
+
+		c.recordAPICall()
+		if err := c.Spacelift.Query(ctx, &details, variables); err != nil {
+			return fmt.Errorf("could not get Spacelift worker pool labels: %w", classifySpaceliftError(err))
+		}
+
+		if details.Pool != nil {
+			pool.Labels = details.Pool.Labels
+		}
+	}
+
+	if c.QueryHeartbeats {
+		var details WorkerPoolHeartbeatsDetails
+
+		c.recordAPICall()
+		if err := c.Spacelift.Query(ctx, &details, variables); err != nil {
+			return fmt.Errorf("could not get Spacelift worker heartbeats: %w", classifySpaceliftError(err))
+		}
+
+		if details.Pool != nil {
+			lastSeenAt := make(map[string]int32, len(details.Pool.Workers))
+			for _, worker := range details.Pool.Workers {
+				lastSeenAt[worker.ID] = worker.LastSeenAt
+			}
+
+			for i := range pool.Workers {
+				pool.Workers[i].LastSeenAt = lastSeenAt[pool.Workers[i].ID]
+			}
+		}
+	}
+
+	if c.QueryRunLabels {
+		var details WorkerPoolRunsDetails
+
+		c.recordAPICall()
+		if err := c.Spacelift.Query(ctx, &details, variables); err != nil {
+			return fmt.Errorf("could not get Spacelift worker pool runs: %w", classifySpaceliftError(err))
+		}
+
+		if details.Pool != nil {
+			for _, run := range details.Pool.Runs {
+				if run.State == RunStateReady {
+					pool.Runs = append(pool.Runs, PendingRun{ID: run.ID, Labels: run.Labels})
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// workerPoolID returns the ID of the pool the worker belongs to.
+func (c *Controller) workerPoolID(workerID string) string {
+	if poolID, ok := c.sharedWorkerPoolIDs[workerID]; ok {
+		return poolID
+	}
+
+	return c.SpaceliftWorkerPoolID
+}
+
 // Drain worker drains a worker in the Spacelift worker pool.
 func (c *Controller) DrainWorker(ctx context.Context, workerID string) (drained bool, err error) {
 	xray.Capture(ctx, "spacelift.worker.drain", func(ctx context.Context) error {
@@ -236,15 +693,57 @@ func (c *Controller) DrainWorker(ctx context.Context, workerID string) (drained
 	return
 }
 
+// ForceDrainWorker drains a worker in the Spacelift worker pool regardless of
+// whether it's busy. A busy worker finishes its current run, but won't be
+// scheduled any new ones.
+func (c *Controller) ForceDrainWorker(ctx context.Context, workerID string) (err error) {
+	xray.Capture(ctx, "spacelift.worker.forcedrain", func(ctx context.Context) error {
+		xray.AddAnnotation(ctx, "worker_id", workerID)
+
+		if _, err = c.workerDrainSet(ctx, workerID, true); err != nil {
+			err = fmt.Errorf("could not drain worker: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// UndrainWorker undrains a worker in the Spacelift worker pool, making it
+// available for scheduling again.
+func (c *Controller) UndrainWorker(ctx context.Context, workerID string) (err error) {
+	xray.Capture(ctx, "spacelift.worker.undrain", func(ctx context.Context) error {
+		xray.AddAnnotation(ctx, "worker_id", workerID)
+
+		if _, err = c.workerDrainSet(ctx, workerID, false); err != nil {
+			err = fmt.Errorf("could not undrain worker: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
 func (c *Controller) KillInstance(ctx context.Context, instanceID string) (err error) {
 	xray.Capture(ctx, "aws.killinstance", func(ctx context.Context) error {
 		xray.AddAnnotation(ctx, "instance_id", instanceID)
 
-		_, err = c.Autoscaling.DetachInstances(ctx, &autoscaling.DetachInstancesInput{
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		_, detachErr := c.Autoscaling.DetachInstances(callCtx, &autoscaling.DetachInstancesInput{
 			AutoScalingGroupName:           aws.String(c.AWSAutoscalingGroupName),
 			InstanceIds:                    []string{instanceID},
 			ShouldDecrementDesiredCapacity: aws.Bool(true),
 		})
+		cancel()
+
+		if detachErr != nil {
+			detachErr = fmt.Errorf("could not detach instance from autoscaling group: %w", detachErr)
+		}
 
 		// A special instance of the error is when the instance is not part of
 		// the autoscaling group. This can happen when the instance successfully
@@ -254,23 +753,41 @@ func (c *Controller) KillInstance(ctx context.Context, instanceID string) (err e
 		// worker pool (as long as they terminate upon request), but if there
 		// are multiple ASGs connected to the same worker pool, this will be a
 		// common occurrence and will break the entire autoscaling logic.
-		if err != nil && !strings.Contains(err.Error(), "is not part of Auto Scaling group") {
-			err = fmt.Errorf("could not detach instance from autoscaling group: %v", err)
+		//
+		// Any other detach error stops us here, since terminating an instance
+		// still in the ASG would only get it replaced.
+		if detachErr != nil && !strings.Contains(detachErr.Error(), "is not part of Auto Scaling group") {
+			xray.AddAnnotation(ctx, "failed_phase", "detach")
+			err = detachErr
 			return err
 		}
 
 		// Now that the instance is detached from the ASG (or was never part of
 		// the ASG), we can terminate it.
-		_, err = c.EC2.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		c.recordAPICall()
+		callCtx, cancel = withCallTimeout(ctx, c.CloudAPITimeout)
+		_, err = c.EC2.TerminateInstances(callCtx, &ec2.TerminateInstancesInput{
 			InstanceIds: []string{instanceID},
 		})
+		cancel()
 
-		if err != nil {
-			err = fmt.Errorf("could not terminate detached instance: %v", err)
+		if err == nil {
+			return nil
+		}
+
+		err = fmt.Errorf("could not terminate detached instance: %w", err)
+
+		// The detach error may well explain why the termination failed, so
+		// the operator should see both.
+		if detachErr != nil {
+			xray.AddAnnotation(ctx, "failed_phase", "detach,terminate")
+			err = errors.Join(detachErr, err)
 			return err
 		}
 
-		return nil
+		xray.AddAnnotation(ctx, "failed_phase", "terminate")
+
+		return err
 	})
 
 	return
@@ -280,13 +797,232 @@ func (c *Controller) ScaleUpASG(ctx context.Context, desiredCapacity int32) (err
 	xray.Capture(ctx, "aws.asg.scaleup", func(ctx context.Context) error {
 		xray.AddMetadata(ctx, "desired_capacity", desiredCapacity)
 
-		_, err = c.Autoscaling.SetDesiredCapacity(ctx, &autoscaling.SetDesiredCapacityInput{
+		for attempt := 1; ; attempt++ {
+			c.recordAPICall()
+			callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+			_, err = c.Autoscaling.SetDesiredCapacity(callCtx, &autoscaling.SetDesiredCapacityInput{
+				AutoScalingGroupName: aws.String(c.AWSAutoscalingGroupName),
+				DesiredCapacity:      aws.Int32(int32(desiredCapacity)),
+			})
+			cancel()
+
+			if err == nil || !isTransientScalingError(err) || attempt == scaleUpMaxAttempts {
+				xray.AddMetadata(ctx, "attempts", attempt)
+				break
+			}
+
+			if err = sleepContext(ctx, c.RetryBackoff*time.Duration(1<<(attempt-1))); err != nil {
+				return err
+			}
+		}
+
+		var inProgress *autoscalingtypes.ScalingActivityInProgressFault
+		if errors.As(err, &inProgress) {
+			err = ErrScalingActivityInProgress
+			return err
+		}
+
+		if err != nil {
+			err = fmt.Errorf("could not set desired capacity: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// maxStateSize is the size limit of a standard tier SSM parameter, which the
+// persisted state is stored in.
+const maxStateSize = 4096
+
+// LoadState returns the state persisted by the previous invocation in the SSM
+// Parameter Store. If nothing was persisted yet, an empty state is returned.
+func (c *Controller) LoadState(ctx context.Context) (out *PersistedState, err error) {
+	xray.Capture(ctx, "aws.ssm.state.load", func(ctx context.Context) error {
+		var output *ssm.GetParameterOutput
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.SSM.GetParameter(callCtx, &ssm.GetParameterInput{
+			Name: aws.String(c.StateParameterName),
+		})
+		cancel()
+
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			out, err = &PersistedState{}, nil
+			return nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("could not get state parameter: %w", err)
+			return err
+		}
+
+		out = &PersistedState{}
+
+		if output.Parameter == nil || output.Parameter.Value == nil {
+			return nil
+		}
+
+		if err = json.Unmarshal([]byte(*output.Parameter.Value), out); err != nil {
+			err = fmt.Errorf("could not parse state parameter: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// SaveState persists the state in the SSM Parameter Store, for the next
+// invocation to pick up.
+func (c *Controller) SaveState(ctx context.Context, state *PersistedState) (err error) {
+	xray.Capture(ctx, "aws.ssm.state.save", func(ctx context.Context) error {
+		var value []byte
+
+		if value, err = json.Marshal(state); err != nil {
+			err = fmt.Errorf("could not serialize state: %w", err)
+			return err
+		}
+
+		// Rather than failing on the API call, let's make it clear which
+		// limit the state ran into.
+		if len(value) > maxStateSize {
+			err = fmt.Errorf("state of %d bytes exceeds the %d byte limit of a standard SSM parameter", len(value), maxStateSize)
+			return err
+		}
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		_, err = c.SSM.PutParameter(callCtx, &ssm.PutParameterInput{
+			Name:      aws.String(c.StateParameterName),
+			Value:     aws.String(string(value)),
+			Type:      ssmtypes.ParameterTypeString,
+			Overwrite: aws.Bool(true),
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not put state parameter: %w", err)
+			return err
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// StartInstanceRefresh starts a rolling instance refresh of the autoscaling
+// group, replacing the instances which don't match its launch template or
+// launch configuration. If a refresh is already in progress, it's left alone
+// and reported as not started.
+func (c *Controller) StartInstanceRefresh(ctx context.Context) (started bool, err error) {
+	xray.Capture(ctx, "aws.asg.instancerefresh", func(ctx context.Context) error {
+		var output *autoscaling.StartInstanceRefreshOutput
+
+		preferences := &autoscalingtypes.RefreshPreferences{
+			SkipMatching: aws.Bool(true),
+		}
+
+		if c.RefreshMinHealthyPercentage > 0 {
+			preferences.MinHealthyPercentage = aws.Int32(int32(c.RefreshMinHealthyPercentage))
+		}
+
+		if c.RefreshInstanceWarmup > 0 {
+			preferences.InstanceWarmup = aws.Int32(int32(c.RefreshInstanceWarmup / time.Second))
+		}
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.Autoscaling.StartInstanceRefresh(callCtx, &autoscaling.StartInstanceRefreshInput{
+			AutoScalingGroupName: aws.String(c.AWSAutoscalingGroupName),
+			Strategy:             autoscalingtypes.RefreshStrategyRolling,
+			Preferences:          preferences,
+		})
+		cancel()
+
+		var inProgress *autoscalingtypes.InstanceRefreshInProgressFault
+		if errors.As(err, &inProgress) {
+			err = nil
+			return nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("could not start instance refresh: %w", err)
+			return err
+		}
+
+		if output.InstanceRefreshId != nil {
+			xray.AddMetadata(ctx, "instance_refresh_id", *output.InstanceRefreshId)
+		}
+
+		started = true
+		return nil
+	})
+
+	return
+}
+
+// InstanceRefreshInProgress checks whether an instance refresh of the
+// autoscaling group, started by us or by anyone else, is still running. A
+// refresh rolling back counts as running too.
+func (c *Controller) InstanceRefreshInProgress(ctx context.Context) (inProgress bool, err error) {
+	xray.Capture(ctx, "aws.asg.instancerefreshes", func(ctx context.Context) error {
+		var output *autoscaling.DescribeInstanceRefreshesOutput
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		output, err = c.Autoscaling.DescribeInstanceRefreshes(callCtx, &autoscaling.DescribeInstanceRefreshesInput{
+			AutoScalingGroupName: aws.String(c.AWSAutoscalingGroupName),
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not describe instance refreshes: %w", err)
+			return err
+		}
+
+		for _, refresh := range output.InstanceRefreshes {
+			switch refresh.Status {
+			case autoscalingtypes.InstanceRefreshStatusPending,
+				autoscalingtypes.InstanceRefreshStatusInProgress,
+				autoscalingtypes.InstanceRefreshStatusCancelling,
+				autoscalingtypes.InstanceRefreshStatusRollbackInProgress:
+				if refresh.InstanceRefreshId != nil {
+					xray.AddMetadata(ctx, "instance_refresh_id", *refresh.InstanceRefreshId)
+				}
+
+				inProgress = true
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// SetMinSize changes the minimum size of the autoscaling group.
+func (c *Controller) SetMinSize(ctx context.Context, minSize int32) (err error) {
+	xray.Capture(ctx, "aws.asg.minsize", func(ctx context.Context) error {
+		xray.AddMetadata(ctx, "min_size", minSize)
+
+		c.recordAPICall()
+		callCtx, cancel := withCallTimeout(ctx, c.CloudAPITimeout)
+		_, err = c.Autoscaling.UpdateAutoScalingGroup(callCtx, &autoscaling.UpdateAutoScalingGroupInput{
 			AutoScalingGroupName: aws.String(c.AWSAutoscalingGroupName),
-			DesiredCapacity:      aws.Int32(int32(desiredCapacity)),
+			MinSize:              aws.Int32(minSize),
 		})
+		cancel()
 
 		if err != nil {
-			err = fmt.Errorf("could not set desired capacity: %v", err)
+			err = fmt.Errorf("could not update minimum size: %w", err)
 			return err
 		}
 
@@ -301,20 +1037,105 @@ func (c *Controller) workerDrainSet(ctx context.Context, workerID string, drain
 		var mutation WorkerDrainSet
 
 		variables := map[string]any{
-			"workerPoolId": graphql.ID(c.SpaceliftWorkerPoolID),
+			"workerPoolId": graphql.ID(c.workerPoolID(workerID)),
 			"workerId":     graphql.ID(workerID),
 			"drain":        graphql.Boolean(drain),
 		}
 
+		c.recordAPICall()
 		if err = c.Spacelift.Mutate(ctx, &mutation, variables); err != nil {
-			err = fmt.Errorf("could not set worker drain to %t: %w", drain, err)
+			err = fmt.Errorf("could not set worker drain to %t: %w", drain, classifySpaceliftError(err))
 			return err
 		}
 
-		worker = &mutation.Worker
+		out := mutation.Worker.Worker()
+		worker = &out
 
 		return nil
 	})
 
 	return
 }
+
+// isTransientScalingError checks whether setting the desired capacity failed
+// for a reason which is likely to go away on its own.
+func isTransientScalingError(err error) bool {
+	var inProgress *autoscalingtypes.ScalingActivityInProgressFault
+	if errors.As(err, &inProgress) {
+		return true
+	}
+
+	return IsThrottlingError(err)
+}
+
+// CheckCredentials checks whether the AWS credentials remain valid for longer
+// than the given buffer. Cached credentials which are about to expire are
+// refreshed, and only reported as not fresh if that doesn't help.
+func (c *Controller) CheckCredentials(ctx context.Context, buffer time.Duration) (fresh bool, err error) {
+	xray.Capture(ctx, "aws.credentials.check", func(ctx context.Context) error {
+		if c.Credentials == nil {
+			fresh = true
+			return nil
+		}
+
+		var creds awssdk.Credentials
+
+		if creds, err = c.Credentials.Retrieve(ctx); err != nil {
+			err = fmt.Errorf("could not retrieve AWS credentials: %w", err)
+			return err
+		}
+
+		xray.AddMetadata(ctx, "expires", creds.Expires)
+
+		if fresh = !creds.CanExpire || time.Until(creds.Expires) > buffer; fresh {
+			return nil
+		}
+
+		cache, ok := c.Credentials.(*awssdk.CredentialsCache)
+		if !ok {
+			return nil
+		}
+
+		cache.Invalidate()
+
+		if creds, err = cache.Retrieve(ctx); err != nil {
+			err = fmt.Errorf("could not refresh AWS credentials: %w", err)
+			return err
+		}
+
+		xray.AddMetadata(ctx, "expires", creds.Expires)
+		fresh = time.Until(creds.Expires) > buffer
+
+		return nil
+	})
+
+	return
+}
+
+// withCallTimeout returns the context for a single cloud API call, so that a
+// hung call fails on its own rather than eating up the whole invocation. Zero
+// means no timeout.
+func withCallTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// sleepContext waits for the given duration, or until the context is done.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *Controller) recordAPICall() {
+	c.apiCalls.Add(1)
+}
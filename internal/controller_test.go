@@ -6,10 +6,16 @@ import (
 	"testing"
 	"time"
 
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	servicequotastypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
 	"github.com/franela/goblin"
 	. "github.com/onsi/gomega"
 	"github.com/shurcooL/graphql"
@@ -26,6 +32,7 @@ func TestController(t *testing.T) {
 	g.Describe("Controller", func() {
 		const asgName = "test-asg"
 		const workerPoolID = "test-pool"
+		const stateParameterName = "test-state"
 
 		var ctx context.Context
 		var err error
@@ -33,6 +40,8 @@ func TestController(t *testing.T) {
 		var mockAutoscaling *ifaces.MockAutoscaling
 		var mockEC2 *ifaces.MockEC2
 		var mockSpacelift *ifaces.MockSpacelift
+		var mockSSM *ifaces.MockSSM
+		var mockServiceQuotas *ifaces.MockServiceQuotas
 
 		var sut *internal.Controller
 
@@ -43,13 +52,20 @@ func TestController(t *testing.T) {
 			mockAutoscaling = &ifaces.MockAutoscaling{}
 			mockEC2 = &ifaces.MockEC2{}
 			mockSpacelift = &ifaces.MockSpacelift{}
+			mockSSM = &ifaces.MockSSM{}
+			mockServiceQuotas = &ifaces.MockServiceQuotas{}
 
 			sut = &internal.Controller{
 				Autoscaling:             mockAutoscaling,
 				EC2:                     mockEC2,
 				Spacelift:               mockSpacelift,
+				SSM:                     mockSSM,
+				ServiceQuotas:           mockServiceQuotas,
 				AWSAutoscalingGroupName: asgName,
 				SpaceliftWorkerPoolID:   workerPoolID,
+				StateParameterName:      stateParameterName,
+				VCPUQuotaCode:           "L-1216C47A",
+				InstanceVCPUs:           4,
 			}
 		})
 
@@ -171,6 +187,27 @@ func TestController(t *testing.T) {
 				})
 			})
 
+			g.Describe("when the API call hangs", func() {
+				g.BeforeEach(func() {
+					sut.CloudAPITimeout = 10 * time.Millisecond
+
+					apiCall.Return(func(ctx context.Context, _ *autoscaling.DescribeAutoScalingGroupsInput, _ ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					})
+				})
+
+				g.It("should time out the call", func() {
+					Expect(group).To(BeNil())
+					Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+					Expect(err).To(MatchError("could not get autoscaling group details: context deadline exceeded"))
+				})
+
+				g.It("should not affect the parent context", func() {
+					Expect(ctx.Err()).NotTo(HaveOccurred())
+				})
+			})
+
 			g.Describe("when the API call succeeds", func() {
 				var output *autoscaling.DescribeAutoScalingGroupsOutput
 
@@ -210,6 +247,336 @@ func TestController(t *testing.T) {
 			})
 		})
 
+		g.Describe("EnsureWorkerPoolTag", func() {
+			var applied bool
+			var group autoscalingtypes.AutoScalingGroup
+
+			var tagCall *mock.Call
+			var tagInput *autoscaling.CreateOrUpdateTagsInput
+
+			g.BeforeEach(func() {
+				group = autoscalingtypes.AutoScalingGroup{AutoScalingGroupName: nullable(asgName)}
+				tagInput = nil
+
+				mockAutoscaling.On("DescribeAutoScalingGroups", mock.Anything, mock.Anything, mock.Anything).
+					Return(func(context.Context, *autoscaling.DescribeAutoScalingGroupsInput, ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+						return &autoscaling.DescribeAutoScalingGroupsOutput{
+							AutoScalingGroups: []autoscalingtypes.AutoScalingGroup{group},
+						}, nil
+					})
+
+				tagCall = mockAutoscaling.On(
+					"CreateOrUpdateTags",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.CreateOrUpdateTagsInput) bool {
+						tagInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { applied, err = sut.EnsureWorkerPoolTag(ctx) })
+
+			g.Describe("when the tag is absent", func() {
+				g.Describe("when the tag call fails", func() {
+					g.BeforeEach(func() { tagCall.Return(nil, errors.New("bacon")) })
+
+					g.It("should return an error", func() {
+						Expect(applied).To(BeFalse())
+						Expect(err).To(MatchError("could not tag autoscaling group: bacon"))
+					})
+				})
+
+				g.Describe("when the tag call succeeds", func() {
+					g.BeforeEach(func() { tagCall.Return(&autoscaling.CreateOrUpdateTagsOutput{}, nil) })
+
+					g.It("applies the tag", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(applied).To(BeTrue())
+
+						Expect(tagInput).NotTo(BeNil())
+						Expect(tagInput.Tags).To(HaveLen(1))
+
+						tag := tagInput.Tags[0]
+						Expect(*tag.Key).To(Equal(internal.WorkerPoolIDTagKey))
+						Expect(*tag.Value).To(Equal(workerPoolID))
+						Expect(*tag.PropagateAtLaunch).To(BeTrue())
+						Expect(*tag.ResourceId).To(Equal(asgName))
+						Expect(*tag.ResourceType).To(Equal("auto-scaling-group"))
+					})
+				})
+			})
+
+			g.Describe("when the tag is present", func() {
+				g.BeforeEach(func() {
+					group.Tags = []autoscalingtypes.TagDescription{{
+						Key:               nullable(internal.WorkerPoolIDTagKey),
+						Value:             nullable(workerPoolID),
+						PropagateAtLaunch: nullable(true),
+					}}
+				})
+
+				g.It("skips tagging", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(applied).To(BeFalse())
+					Expect(tagInput).To(BeNil())
+				})
+			})
+		})
+
+		g.Describe("TagLaunchCohort", func() {
+			var tagCall *mock.Call
+			var tagInput *autoscaling.CreateOrUpdateTagsInput
+
+			g.BeforeEach(func() {
+				tagInput = nil
+
+				tagCall = mockAutoscaling.On(
+					"CreateOrUpdateTags",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.CreateOrUpdateTagsInput) bool {
+						tagInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { err = sut.TagLaunchCohort(ctx, "20231014T120000Z") })
+
+			g.Describe("when the tag call fails", func() {
+				g.BeforeEach(func() { tagCall.Return(nil, errors.New("bacon")) })
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not tag launch cohort: bacon"))
+				})
+			})
+
+			g.Describe("when the tag call succeeds", func() {
+				g.BeforeEach(func() { tagCall.Return(&autoscaling.CreateOrUpdateTagsOutput{}, nil) })
+
+				g.It("applies the tag, propagated at launch", func() {
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(tagInput).NotTo(BeNil())
+					Expect(tagInput.Tags).To(HaveLen(1))
+
+					tag := tagInput.Tags[0]
+					Expect(*tag.Key).To(Equal(internal.LaunchCohortTagKey))
+					Expect(*tag.Value).To(Equal("20231014T120000Z"))
+					Expect(*tag.PropagateAtLaunch).To(BeTrue())
+					Expect(*tag.ResourceId).To(Equal(asgName))
+					Expect(*tag.ResourceType).To(Equal("auto-scaling-group"))
+				})
+			})
+		})
+
+		g.Describe("GetInstanceQuota", func() {
+			var quota int
+
+			var input *servicequotas.GetServiceQuotaInput
+			var apiCall *mock.Call
+
+			g.BeforeEach(func() {
+				input = nil
+
+				apiCall = mockServiceQuotas.On(
+					"GetServiceQuota",
+					mock.Anything,
+					mock.MatchedBy(func(in *servicequotas.GetServiceQuotaInput) bool {
+						input = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { quota, err = sut.GetInstanceQuota(ctx) })
+
+			g.Describe("when the API call fails", func() {
+				g.BeforeEach(func() { apiCall.Return(nil, errors.New("bacon")) })
+
+				g.It("sends the correct input", func() {
+					Expect(input).NotTo(BeNil())
+					Expect(*input.ServiceCode).To(Equal("ec2"))
+					Expect(*input.QuotaCode).To(Equal("L-1216C47A"))
+				})
+
+				g.It("should return an error", func() {
+					Expect(quota).To(BeZero())
+					Expect(err).To(MatchError("could not get service quota L-1216C47A: bacon"))
+				})
+			})
+
+			g.Describe("when the quota has no value", func() {
+				g.BeforeEach(func() {
+					apiCall.Return(&servicequotas.GetServiceQuotaOutput{Quota: &servicequotastypes.ServiceQuota{}}, nil)
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not find the value of service quota L-1216C47A"))
+				})
+			})
+
+			g.Describe("when the API call succeeds", func() {
+				g.BeforeEach(func() {
+					apiCall.Return(&servicequotas.GetServiceQuotaOutput{
+						Quota: &servicequotastypes.ServiceQuota{Value: nullable(float64(34))},
+					}, nil)
+				})
+
+				g.It("should return the number of instances the quota allows for", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(quota).To(Equal(8))
+				})
+
+				g.Describe("with a cache", func() {
+					g.BeforeEach(func() { sut.QuotaCache = internal.NewQuotaCache(time.Hour) })
+
+					g.It("should only look the quota up once", func() {
+						quota, err = sut.GetInstanceQuota(ctx)
+
+						Expect(err).NotTo(HaveOccurred())
+						Expect(quota).To(Equal(8))
+						Expect(mockServiceQuotas.Calls).To(HaveLen(1))
+					})
+				})
+			})
+		})
+
+		g.Describe("CheckCredentials", func() {
+			const buffer = 5 * time.Minute
+
+			var fresh bool
+			var expiries []time.Duration
+			var retrievals int
+
+			g.BeforeEach(func() {
+				expiries = nil
+				retrievals = 0
+
+				provider := awssdk.CredentialsProviderFunc(func(context.Context) (awssdk.Credentials, error) {
+					if len(expiries) == 0 {
+						return awssdk.Credentials{}, errors.New("bacon")
+					}
+
+					expiry := expiries[0]
+					if len(expiries) > 1 {
+						expiries = expiries[1:]
+					}
+
+					retrievals++
+
+					return awssdk.Credentials{AccessKeyID: "key", CanExpire: true, Expires: time.Now().Add(expiry)}, nil
+				})
+
+				sut.Credentials = awssdk.NewCredentialsCache(provider)
+			})
+
+			g.JustBeforeEach(func() { fresh, err = sut.CheckCredentials(ctx, buffer) })
+
+			g.Describe("when the credentials can't be retrieved", func() {
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not retrieve AWS credentials: failed to refresh cached credentials, bacon"))
+				})
+			})
+
+			g.Describe("when the credentials are valid for longer than the buffer", func() {
+				g.BeforeEach(func() { expiries = []time.Duration{time.Hour} })
+
+				g.It("should report them as fresh without a refresh", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fresh).To(BeTrue())
+					Expect(retrievals).To(Equal(1))
+				})
+			})
+
+			g.Describe("when the credentials expire within the buffer", func() {
+				g.Describe("when the refresh brings fresh ones", func() {
+					g.BeforeEach(func() { expiries = []time.Duration{time.Minute, time.Hour} })
+
+					g.It("should report them as fresh after a refresh", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(fresh).To(BeTrue())
+						Expect(retrievals).To(Equal(2))
+					})
+				})
+
+				g.Describe("when the refresh doesn't help", func() {
+					g.BeforeEach(func() { expiries = []time.Duration{time.Minute} })
+
+					g.It("should report them as not fresh", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(fresh).To(BeFalse())
+						Expect(retrievals).To(Equal(2))
+					})
+				})
+			})
+		})
+
+		g.Describe("GetScalingPolicies", func() {
+			var names []string
+
+			var input *autoscaling.DescribePoliciesInput
+			var apiCall *mock.Call
+
+			g.BeforeEach(func() {
+				input = nil
+
+				apiCall = mockAutoscaling.On(
+					"DescribePolicies",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.DescribePoliciesInput) bool {
+						input = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { names, err = sut.GetScalingPolicies(ctx) })
+
+			g.Describe("when the API call fails", func() {
+				g.BeforeEach(func() { apiCall.Return(nil, errors.New("bacon")) })
+
+				g.It("sends the correct input", func() {
+					Expect(input).NotTo(BeNil())
+					Expect(*input.AutoScalingGroupName).To(Equal(asgName))
+				})
+
+				g.It("should return an error", func() {
+					Expect(names).To(BeEmpty())
+					Expect(err).To(MatchError("could not describe scaling policies: bacon"))
+				})
+			})
+
+			g.Describe("when there are no policies", func() {
+				g.BeforeEach(func() { apiCall.Return(&autoscaling.DescribePoliciesOutput{}, nil) })
+
+				g.It("should return no policies", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(names).To(BeEmpty())
+				})
+			})
+
+			g.Describe("when there are policies", func() {
+				g.BeforeEach(func() {
+					apiCall.Return(&autoscaling.DescribePoliciesOutput{
+						ScalingPolicies: []autoscalingtypes.ScalingPolicy{
+							{PolicyName: nullable("enabled"), Enabled: nullable(true)},
+							{PolicyName: nullable("disabled"), Enabled: nullable(false)},
+						},
+					}, nil)
+				})
+
+				g.It("should return the enabled ones", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(names).To(Equal([]string{"enabled"}))
+				})
+			})
+		})
+
 		g.Describe("GetWorkerPool", func() {
 			var spaceliftCall *mock.Call
 			var params map[string]any
@@ -222,7 +589,7 @@ func TestController(t *testing.T) {
 				spaceliftCall = mockSpacelift.On(
 					"Query",
 					mock.Anything,
-					mock.Anything,
+					mock.AnythingOfType("*internal.WorkerPoolDetails"),
 					mock.MatchedBy(func(in any) bool {
 						params = in.(map[string]any)
 						return true
@@ -248,7 +615,7 @@ func TestController(t *testing.T) {
 			})
 
 			g.Describe("when the API call succeeds", func() {
-				var returnedPool *internal.WorkerPool
+				var returnedPool *internal.WorkerPoolFields
 
 				g.BeforeEach(func() {
 					returnedPool = nil
@@ -268,8 +635,8 @@ func TestController(t *testing.T) {
 
 				g.Describe("when the worker pool is found", func() {
 					g.BeforeEach(func() {
-						returnedPool = &internal.WorkerPool{
-							Workers: []internal.Worker{
+						returnedPool = &internal.WorkerPoolFields{
+							Workers: []internal.WorkerFields{
 								{ID: "newer", CreatedAt: 5},
 								{ID: "older", CreatedAt: 1},
 							},
@@ -284,6 +651,275 @@ func TestController(t *testing.T) {
 						Expect(workerPool.Workers[1].ID).To(Equal("newer"))
 					})
 				})
+
+				g.Describe("when excluding the paused runs", func() {
+					g.BeforeEach(func() {
+						sut.QueryPausedRuns = true
+						returnedPool = &internal.WorkerPoolFields{PendingRuns: 3}
+
+						mockSpacelift.On(
+							"Query",
+							mock.Anything,
+							mock.AnythingOfType("*internal.WorkerPoolPausedRunsDetails"),
+							map[string]any{"workerPool": workerPoolID},
+							mock.Anything,
+						).Run(func(args mock.Arguments) {
+							args.Get(1).(*internal.WorkerPoolPausedRunsDetails).Pool = &internal.WorkerPoolPausedRuns{PausedRuns: 2}
+						}).Return(nil)
+					})
+
+					g.It("should return the paused runs", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(workerPool.PendingRuns).To(Equal(int32(3)))
+						Expect(workerPool.PausedRuns).To(Equal(int32(2)))
+						Expect(sut.APICalls()).To(Equal(2))
+					})
+				})
+
+				g.Describe("when honoring the disabled label", func() {
+					g.BeforeEach(func() {
+						sut.QueryLabels = true
+						returnedPool = &internal.WorkerPoolFields{}
+
+						mockSpacelift.On(
+							"Query",
+							mock.Anything,
+							mock.AnythingOfType("*internal.WorkerPoolLabelsDetails"),
+							map[string]any{"workerPool": workerPoolID},
+							mock.Anything,
+						).Run(func(args mock.Arguments) {
+							args.Get(1).(*internal.WorkerPoolLabelsDetails).Pool = &internal.WorkerPoolLabels{Labels: []string{internal.DisabledLabel}}
+						}).Return(nil)
+					})
+
+					g.It("should return the labels", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(workerPool.AutoscalingDisabled()).To(BeTrue())
+						Expect(sut.APICalls()).To(Equal(2))
+					})
+				})
+
+				g.Describe("when checking the worker heartbeats", func() {
+					g.BeforeEach(func() {
+						sut.QueryHeartbeats = true
+						returnedPool = &internal.WorkerPoolFields{
+							Workers: []internal.WorkerFields{{ID: "seen", CreatedAt: 1}, {ID: "unseen", CreatedAt: 2}},
+						}
+
+						mockSpacelift.On(
+							"Query",
+							mock.Anything,
+							mock.AnythingOfType("*internal.WorkerPoolHeartbeatsDetails"),
+							map[string]any{"workerPool": workerPoolID},
+							mock.Anything,
+						).Run(func(args mock.Arguments) {
+							args.Get(1).(*internal.WorkerPoolHeartbeatsDetails).Pool = &internal.WorkerPoolHeartbeats{
+								Workers: []internal.WorkerHeartbeat{{ID: "seen", LastSeenAt: 42}},
+							}
+						}).Return(nil)
+					})
+
+					g.It("should merge the heartbeats into the workers", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(workerPool.Workers).To(HaveLen(2))
+						Expect(workerPool.Workers[0].LastSeenAt).To(Equal(int32(42)))
+						Expect(workerPool.Workers[1].LastSeenAt).To(BeZero())
+						Expect(sut.APICalls()).To(Equal(2))
+					})
+				})
+
+				g.Describe("when selecting the runs by their labels", func() {
+					g.BeforeEach(func() {
+						sut.QueryRunLabels = true
+						returnedPool = &internal.WorkerPoolFields{PendingRuns: 1}
+
+						mockSpacelift.On(
+							"Query",
+							mock.Anything,
+							mock.AnythingOfType("*internal.WorkerPoolRunsDetails"),
+							map[string]any{"workerPool": workerPoolID},
+							mock.Anything,
+						).Run(func(args mock.Arguments) {
+							args.Get(1).(*internal.WorkerPoolRunsDetails).Pool = &internal.WorkerPoolRuns{
+								Runs: []internal.RunFields{
+									{ID: "pending", State: internal.RunStateReady, Labels: []string{"team:infra"}},
+									{ID: "running", State: "APPLYING", Labels: []string{"team:infra"}},
+								},
+							}
+						}).Return(nil)
+					})
+
+					g.It("should only return the pending runs", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(workerPool.Runs).To(Equal([]internal.PendingRun{{ID: "pending", Labels: []string{"team:infra"}}}))
+						Expect(sut.APICalls()).To(Equal(2))
+					})
+				})
+
+				g.Describe("when honoring the worker pool suspension", func() {
+					var suspensionCall *mock.Call
+
+					g.BeforeEach(func() {
+						sut.QuerySuspension = true
+						returnedPool = &internal.WorkerPoolFields{}
+
+						suspensionCall = mockSpacelift.On(
+							"Query",
+							mock.Anything,
+							mock.AnythingOfType("*internal.WorkerPoolSuspensionDetails"),
+							map[string]any{"workerPool": workerPoolID},
+							mock.Anything,
+						)
+					})
+
+					g.Describe("when the suspension query fails", func() {
+						g.BeforeEach(func() { suspensionCall.Return(errors.New("bacon")) })
+
+						g.It("should return an error", func() {
+							Expect(workerPool).To(BeNil())
+							Expect(err).To(MatchError("could not get Spacelift worker pool suspension: bacon"))
+						})
+					})
+
+					g.Describe("when the suspension query succeeds", func() {
+						g.BeforeEach(func() {
+							suspensionCall.Run(func(args mock.Arguments) {
+								args.Get(1).(*internal.WorkerPoolSuspensionDetails).Pool = &internal.WorkerPoolSuspension{Suspended: true}
+							}).Return(nil)
+						})
+
+						g.It("should return the suspended worker pool", func() {
+							Expect(err).NotTo(HaveOccurred())
+							Expect(workerPool.Suspended).To(BeTrue())
+							Expect(sut.APICalls()).To(Equal(2))
+						})
+					})
+				})
+			})
+
+			g.Describe("with shared worker pools", func() {
+				const sharedPoolID = "shared-pool"
+
+				g.BeforeEach(func() {
+					sut.SharedWorkerPoolIDs = []string{sharedPoolID}
+
+					spaceliftCall.Run(func(args mock.Arguments) {
+						details := args.Get(1).(*internal.WorkerPoolDetails)
+
+						if args.Get(2).(map[string]any)["workerPool"] == sharedPoolID {
+							details.Pool = &internal.WorkerPoolFields{
+								PendingRuns: 3,
+								Workers:     []internal.WorkerFields{{ID: "shared", CreatedAt: 3}},
+							}
+						} else {
+							details.Pool = &internal.WorkerPoolFields{
+								PendingRuns: 1,
+								Workers:     []internal.WorkerFields{{ID: "primary", CreatedAt: 1}},
+							}
+						}
+					}).Return(nil)
+				})
+
+				g.It("should merge the pools", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(mockSpacelift.Calls).To(HaveLen(2))
+					Expect(workerPool.PendingRuns).To(Equal(int32(4)))
+					Expect(workerPool.Workers).To(HaveLen(2))
+					Expect(workerPool.Workers[0].ID).To(Equal("primary"))
+					Expect(workerPool.Workers[1].ID).To(Equal("shared"))
+				})
+
+				g.Describe("when draining the workers afterwards", func() {
+					var drainParams []map[string]any
+
+					g.BeforeEach(func() {
+						drainParams = nil
+
+						mockSpacelift.On("Mutate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+							Run(func(args mock.Arguments) { drainParams = append(drainParams, args.Get(2).(map[string]any)) }).
+							Return(nil)
+					})
+
+					g.It("should use the pools the workers belong to", func() {
+						Expect(sut.ForceDrainWorker(ctx, "primary")).To(Succeed())
+						Expect(sut.ForceDrainWorker(ctx, "shared")).To(Succeed())
+
+						Expect(drainParams).To(HaveLen(2))
+						Expect(drainParams[0]["workerPoolId"]).To(Equal(graphql.ID(workerPoolID)))
+						Expect(drainParams[1]["workerPoolId"]).To(Equal(graphql.ID(sharedPoolID)))
+					})
+				})
+			})
+		})
+
+		g.Describe("GetWorkerPoolSummary", func() {
+			var spaceliftCall *mock.Call
+			var workerPool *internal.WorkerPool
+
+			g.BeforeEach(func() {
+				workerPool = nil
+
+				spaceliftCall = mockSpacelift.On(
+					"Query",
+					mock.Anything,
+					mock.AnythingOfType("*internal.WorkerPoolSummaryDetails"),
+					map[string]any{"workerPool": workerPoolID},
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { workerPool, err = sut.GetWorkerPoolSummary(ctx) })
+
+			g.Describe("when the API call fails", func() {
+				g.BeforeEach(func() { spaceliftCall.Return(errors.New("bacon")) })
+
+				g.It("should return an error", func() {
+					Expect(workerPool).To(BeNil())
+					Expect(err).To(MatchError("could not get Spacelift worker pool summary: bacon"))
+				})
+			})
+
+			g.Describe("when the API call succeeds", func() {
+				var returnedPool *internal.WorkerPoolSummary
+
+				g.BeforeEach(func() {
+					returnedPool = nil
+
+					spaceliftCall.Run(func(args mock.Arguments) {
+						details := args.Get(1).(*internal.WorkerPoolSummaryDetails)
+						details.Pool = returnedPool
+					}).Return(nil)
+				})
+
+				g.Describe("when the worker pool is not found (default)", func() {
+					g.It("should return an error", func() {
+						Expect(workerPool).To(BeNil())
+						Expect(err).To(MatchError("worker pool not found or not accessible"))
+					})
+				})
+
+				g.Describe("when the worker pool is found", func() {
+					g.BeforeEach(func() {
+						returnedPool = &internal.WorkerPoolSummary{
+							PendingRuns: 3,
+							Workers: []internal.WorkerSummary{
+								{ID: "busy", Busy: true},
+								{ID: "drained", Drained: true},
+							},
+						}
+					})
+
+					g.It("should return the worker pool without metadata", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(workerPool).To(Equal(&internal.WorkerPool{
+							PendingRuns: 3,
+							Workers: []internal.Worker{
+								{ID: "busy", Busy: true},
+								{ID: "drained", Drained: true},
+							},
+						}))
+					})
+				})
 			})
 		})
 
@@ -332,7 +968,7 @@ func TestController(t *testing.T) {
 			})
 
 			g.Describe("when the API call succeeds", func() {
-				var worker *internal.Worker
+				var worker *internal.WorkerFields
 
 				g.BeforeEach(func() {
 					worker = nil
@@ -343,7 +979,7 @@ func TestController(t *testing.T) {
 				})
 
 				g.Describe("when the worker is not busy", func() {
-					g.BeforeEach(func() { worker = &internal.Worker{Busy: false} })
+					g.BeforeEach(func() { worker = &internal.WorkerFields{Busy: false} })
 
 					g.It("succeeds and reports the worker as drained", func() {
 						Expect(drained).To(BeTrue())
@@ -356,7 +992,7 @@ func TestController(t *testing.T) {
 					var undrainParams map[string]any
 
 					g.BeforeEach(func() {
-						worker = &internal.Worker{Busy: true}
+						worker = &internal.WorkerFields{Busy: true}
 
 						undrainParams = nil
 
@@ -403,6 +1039,97 @@ func TestController(t *testing.T) {
 			})
 		})
 
+		g.Describe("UndrainWorker", func() {
+			const workerID = "test-worker"
+
+			var undrainCall *mock.Call
+			var undrainParams map[string]any
+
+			g.BeforeEach(func() {
+				undrainParams = nil
+
+				undrainCall = mockSpacelift.On(
+					"Mutate",
+					mock.Anything,
+					mock.Anything,
+					mock.MatchedBy(func(in any) bool {
+						undrainParams = in.(map[string]any)
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { err = sut.UndrainWorker(ctx, workerID) })
+
+			g.Describe("when the undrain call fails", func() {
+				g.BeforeEach(func() { undrainCall.Return(errors.New("bacon")) })
+
+				g.It("send the correct input", func() {
+					Expect(undrainParams).NotTo(BeNil())
+					Expect(undrainParams["workerPoolId"]).To(Equal(workerPoolID))
+					Expect(undrainParams["workerId"]).To(Equal(workerID))
+					Expect(bool(undrainParams["drain"].(graphql.Boolean))).To(BeFalse())
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not undrain worker: could not set worker drain to false: bacon"))
+				})
+			})
+
+			g.Describe("when the undrain call succeeds", func() {
+				g.BeforeEach(func() { undrainCall.Return(nil) })
+
+				g.It("succeeds", func() { Expect(err).NotTo(HaveOccurred()) })
+			})
+		})
+
+		g.Describe("ForceDrainWorker", func() {
+			const workerID = "test-worker"
+
+			var drainCall *mock.Call
+			var drainParams map[string]any
+
+			g.BeforeEach(func() {
+				drainParams = nil
+
+				drainCall = mockSpacelift.On(
+					"Mutate",
+					mock.Anything,
+					mock.Anything,
+					mock.MatchedBy(func(in any) bool {
+						drainParams = in.(map[string]any)
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { err = sut.ForceDrainWorker(ctx, workerID) })
+
+			g.Describe("when the drain call fails", func() {
+				g.BeforeEach(func() { drainCall.Return(errors.New("bacon")) })
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not drain worker: could not set worker drain to true: bacon"))
+				})
+			})
+
+			g.Describe("when the worker is busy", func() {
+				g.BeforeEach(func() {
+					drainCall.Run(func(args mock.Arguments) {
+						args.Get(1).(*internal.WorkerDrainSet).Worker = internal.WorkerFields{Busy: true}
+					}).Return(nil)
+				})
+
+				g.It("leaves the worker drained", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bool(drainParams["drain"].(graphql.Boolean))).To(BeTrue())
+					Expect(mockSpacelift.Calls).To(HaveLen(1))
+				})
+			})
+		})
+
 		g.Describe("KillInstance", func() {
 			const instanceID = "test-instance"
 
@@ -464,8 +1191,9 @@ func TestController(t *testing.T) {
 						terminateCall.Return(nil, errors.New("bacon"))
 					})
 
-					g.It("should return an error", func() {
-						Expect(err).To(MatchError("could not terminate detached instance: bacon"))
+					g.It("should return both errors", func() {
+						Expect(err).To(MatchError(ContainSubstring("could not detach instance from autoscaling group: instance is not part of Auto Scaling group")))
+						Expect(err).To(MatchError(ContainSubstring("could not terminate detached instance: bacon")))
 					})
 				})
 
@@ -494,6 +1222,323 @@ func TestController(t *testing.T) {
 			})
 		})
 
+		g.Describe("SetMinSize", func() {
+			var updateCall *mock.Call
+			var updateInput *autoscaling.UpdateAutoScalingGroupInput
+
+			g.BeforeEach(func() {
+				updateInput = nil
+
+				updateCall = mockAutoscaling.On(
+					"UpdateAutoScalingGroup",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.UpdateAutoScalingGroupInput) bool {
+						updateInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { err = sut.SetMinSize(ctx, 1) })
+
+			g.Describe("when the update call fails", func() {
+				g.BeforeEach(func() { updateCall.Return(nil, errors.New("bacon")) })
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not update minimum size: bacon"))
+				})
+			})
+
+			g.Describe("when the update call succeeds", func() {
+				g.BeforeEach(func() { updateCall.Return(&autoscaling.UpdateAutoScalingGroupOutput{}, nil) })
+
+				g.It("should only change the minimum size", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(updateInput).NotTo(BeNil())
+					Expect(*updateInput.AutoScalingGroupName).To(Equal(asgName))
+					Expect(*updateInput.MinSize).To(Equal(int32(1)))
+					Expect(updateInput.MaxSize).To(BeNil())
+					Expect(updateInput.DesiredCapacity).To(BeNil())
+				})
+			})
+		})
+
+		g.Describe("StartInstanceRefresh", func() {
+			var started bool
+			var refreshCall *mock.Call
+			var refreshInput *autoscaling.StartInstanceRefreshInput
+
+			g.BeforeEach(func() {
+				refreshInput = nil
+
+				refreshCall = mockAutoscaling.On(
+					"StartInstanceRefresh",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.StartInstanceRefreshInput) bool {
+						refreshInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { started, err = sut.StartInstanceRefresh(ctx) })
+
+			g.Describe("when the refresh call fails", func() {
+				g.BeforeEach(func() { refreshCall.Return(nil, errors.New("bacon")) })
+
+				g.It("send the correct input", func() {
+					Expect(refreshInput).NotTo(BeNil())
+					Expect(*refreshInput.AutoScalingGroupName).To(Equal(asgName))
+					Expect(refreshInput.Strategy).To(Equal(autoscalingtypes.RefreshStrategyRolling))
+					Expect(*refreshInput.Preferences.SkipMatching).To(BeTrue())
+					Expect(refreshInput.Preferences.MinHealthyPercentage).To(BeNil())
+					Expect(refreshInput.Preferences.InstanceWarmup).To(BeNil())
+				})
+
+				g.It("should return an error", func() {
+					Expect(started).To(BeFalse())
+					Expect(err).To(MatchError("could not start instance refresh: bacon"))
+				})
+			})
+
+			g.Describe("when the refresh preferences are configured", func() {
+				g.BeforeEach(func() {
+					sut.RefreshMinHealthyPercentage = 75
+					sut.RefreshInstanceWarmup = 5 * time.Minute
+
+					refreshCall.Return(&autoscaling.StartInstanceRefreshOutput{}, nil)
+				})
+
+				g.It("passes them through", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(refreshInput).NotTo(BeNil())
+					Expect(*refreshInput.Preferences.MinHealthyPercentage).To(Equal(int32(75)))
+					Expect(*refreshInput.Preferences.InstanceWarmup).To(Equal(int32(300)))
+					Expect(*refreshInput.Preferences.SkipMatching).To(BeTrue())
+				})
+			})
+
+			g.Describe("when a refresh is already in progress", func() {
+				g.BeforeEach(func() {
+					refreshCall.Return(nil, &autoscalingtypes.InstanceRefreshInProgressFault{})
+				})
+
+				g.It("succeeds but reports the refresh as not started", func() {
+					Expect(started).To(BeFalse())
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			g.Describe("when the refresh call succeeds", func() {
+				g.BeforeEach(func() {
+					refreshCall.Return(&autoscaling.StartInstanceRefreshOutput{
+						InstanceRefreshId: nullable("refresh-id"),
+					}, nil)
+				})
+
+				g.It("succeeds and reports the refresh as started", func() {
+					Expect(started).To(BeTrue())
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+
+		g.Describe("InstanceRefreshInProgress", func() {
+			var inProgress bool
+			var describeCall *mock.Call
+			var describeInput *autoscaling.DescribeInstanceRefreshesInput
+
+			g.BeforeEach(func() {
+				describeInput = nil
+
+				describeCall = mockAutoscaling.On(
+					"DescribeInstanceRefreshes",
+					mock.Anything,
+					mock.MatchedBy(func(in *autoscaling.DescribeInstanceRefreshesInput) bool {
+						describeInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { inProgress, err = sut.InstanceRefreshInProgress(ctx) })
+
+			g.Describe("when the describe call fails", func() {
+				g.BeforeEach(func() { describeCall.Return(nil, errors.New("bacon")) })
+
+				g.It("sends the correct input", func() {
+					Expect(describeInput).NotTo(BeNil())
+					Expect(*describeInput.AutoScalingGroupName).To(Equal(asgName))
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not describe instance refreshes: bacon"))
+				})
+			})
+
+			g.Describe("when all the refreshes are finished", func() {
+				g.BeforeEach(func() {
+					describeCall.Return(&autoscaling.DescribeInstanceRefreshesOutput{
+						InstanceRefreshes: []autoscalingtypes.InstanceRefresh{
+							{Status: autoscalingtypes.InstanceRefreshStatusSuccessful},
+							{Status: autoscalingtypes.InstanceRefreshStatusCancelled},
+						},
+					}, nil)
+				})
+
+				g.It("reports no refresh in progress", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(inProgress).To(BeFalse())
+				})
+			})
+
+			g.Describe("when a refresh is rolling back", func() {
+				g.BeforeEach(func() {
+					describeCall.Return(&autoscaling.DescribeInstanceRefreshesOutput{
+						InstanceRefreshes: []autoscalingtypes.InstanceRefresh{
+							{Status: autoscalingtypes.InstanceRefreshStatusRollbackInProgress, InstanceRefreshId: nullable("refresh-id")},
+							{Status: autoscalingtypes.InstanceRefreshStatusSuccessful},
+						},
+					}, nil)
+				})
+
+				g.It("reports a refresh in progress", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(inProgress).To(BeTrue())
+				})
+			})
+		})
+
+		g.Describe("LoadState", func() {
+			var state *internal.PersistedState
+			var getCall *mock.Call
+			var getInput *ssm.GetParameterInput
+
+			g.BeforeEach(func() {
+				getInput = nil
+
+				getCall = mockSSM.On(
+					"GetParameter",
+					mock.Anything,
+					mock.MatchedBy(func(in *ssm.GetParameterInput) bool {
+						getInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { state, err = sut.LoadState(ctx) })
+
+			g.Describe("when the get call fails", func() {
+				g.BeforeEach(func() { getCall.Return(nil, errors.New("bacon")) })
+
+				g.It("send the correct input", func() {
+					Expect(getInput).NotTo(BeNil())
+					Expect(*getInput.Name).To(Equal(stateParameterName))
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not get state parameter: bacon"))
+				})
+			})
+
+			g.Describe("when the parameter does not exist yet", func() {
+				g.BeforeEach(func() { getCall.Return(nil, &ssmtypes.ParameterNotFound{}) })
+
+				g.It("returns an empty state", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(state).To(Equal(&internal.PersistedState{}))
+				})
+			})
+
+			g.Describe("when the parameter is malformed", func() {
+				g.BeforeEach(func() {
+					getCall.Return(&ssm.GetParameterOutput{
+						Parameter: &ssmtypes.Parameter{Value: nullable("bacon")},
+					}, nil)
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("could not parse state parameter"))
+				})
+			})
+
+			g.Describe("when the parameter is valid", func() {
+				g.BeforeEach(func() {
+					getCall.Return(&ssm.GetParameterOutput{
+						Parameter: &ssmtypes.Parameter{Value: nullable(`{"peak":{"workers":3,"observed_at":42}}`)},
+					}, nil)
+				})
+
+				g.It("returns the persisted state", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(state.Peak).To(Equal(&internal.PeakState{Workers: 3, ObservedAt: 42}))
+				})
+			})
+		})
+
+		g.Describe("SaveState", func() {
+			var putCall *mock.Call
+			var putInput *ssm.PutParameterInput
+			var state *internal.PersistedState
+
+			g.BeforeEach(func() {
+				putInput = nil
+				state = &internal.PersistedState{
+					Peak: &internal.PeakState{Workers: 3, ObservedAt: 42},
+				}
+
+				putCall = mockSSM.On(
+					"PutParameter",
+					mock.Anything,
+					mock.MatchedBy(func(in *ssm.PutParameterInput) bool {
+						putInput = in
+						return true
+					}),
+					mock.Anything,
+				)
+			})
+
+			g.JustBeforeEach(func() { err = sut.SaveState(ctx, state) })
+
+			g.Describe("when the put call fails", func() {
+				g.BeforeEach(func() { putCall.Return(nil, errors.New("bacon")) })
+
+				g.It("send the correct input", func() {
+					Expect(putInput).NotTo(BeNil())
+					Expect(*putInput.Name).To(Equal(stateParameterName))
+					Expect(*putInput.Value).To(Equal(`{"peak":{"workers":3,"observed_at":42}}`))
+					Expect(*putInput.Overwrite).To(BeTrue())
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("could not put state parameter: bacon"))
+				})
+			})
+
+			g.Describe("when the put call succeeds", func() {
+				g.BeforeEach(func() { putCall.Return(&ssm.PutParameterOutput{}, nil) })
+
+				g.It("succeeds", func() { Expect(err).NotTo(HaveOccurred()) })
+			})
+
+			g.Describe("when the state is too large", func() {
+				g.BeforeEach(func() {
+					state = &internal.PersistedState{PendingTermination: make([]string, 2000)}
+				})
+
+				g.It("should fail without calling the API", func() {
+					Expect(putInput).To(BeNil())
+					Expect(err).To(MatchError(ContainSubstring("exceeds the 4096 byte limit of a standard SSM parameter")))
+				})
+			})
+		})
+
 		g.Describe("ScaleUpASG", func() {
 			const desiredCapacity = 42
 
@@ -534,6 +1579,48 @@ func TestController(t *testing.T) {
 				g.BeforeEach(func() { setCapacityCall.Return(nil, nil) })
 
 				g.It("succeeds", func() { Expect(err).NotTo(HaveOccurred()) })
+
+				g.It("records the API call", func() { Expect(sut.APICalls()).To(Equal(1)) })
+			})
+
+			g.Describe("when a scaling activity is in progress", func() {
+				g.BeforeEach(func() {
+					setCapacityCall.Return(nil, &autoscalingtypes.ScalingActivityInProgressFault{}).Once()
+				})
+
+				g.Describe("when it completes before the next attempt", func() {
+					g.BeforeEach(func() {
+						mockAutoscaling.On("SetDesiredCapacity", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+					})
+
+					g.It("retries and succeeds", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(sut.APICalls()).To(Equal(2))
+					})
+				})
+
+				g.Describe("when it persists", func() {
+					g.BeforeEach(func() {
+						mockAutoscaling.On("SetDesiredCapacity", mock.Anything, mock.Anything, mock.Anything).
+							Return(nil, &autoscalingtypes.ScalingActivityInProgressFault{})
+					})
+
+					g.It("gives up after a bounded number of attempts", func() {
+						Expect(err).To(MatchError(internal.ErrScalingActivityInProgress))
+						Expect(sut.APICalls()).To(Equal(3))
+					})
+				})
+			})
+
+			g.Describe("when the set capacity call is throttled", func() {
+				g.BeforeEach(func() {
+					setCapacityCall.Return(nil, &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"})
+				})
+
+				g.It("retries and eventually returns an error", func() {
+					Expect(err).To(MatchError("could not set desired capacity: api error Throttling: Rate exceeded"))
+					Expect(sut.APICalls()).To(Equal(3))
+				})
 			})
 		})
 	})
@@ -10,14 +10,58 @@ const (
 	ScalingDirectionDown
 )
 
+func (d ScalingDirection) String() string {
+	switch d {
+	case ScalingDirectionUp:
+		return "up"
+	case ScalingDirectionDown:
+		return "down"
+	default:
+		return "none"
+	}
+}
+
+func (d ScalingDirection) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Gates which can keep the autoscaler from scaling, as reported in the
+// decision.
+const (
+	GateCredentialsExpiry = "credentials_expiry"
+	GateDecisionHook      = "decision_hook"
+	GateDisabled          = "disabled"
+	GateInstanceQuota     = "instance_quota"
+	GateInstanceRefresh   = "instance_refresh"
+	GateLaunchRetryBudget = "launch_retry_budget"
+	GateMaxSize           = "max_size"
+	GateMinPerZone        = "min_per_zone"
+	GateMinSize           = "min_size"
+	GateMismatch          = "mismatch"
+	GateOffHours          = "off_hours"
+	GatePoolCache         = "pool_cache"
+	GatePoolSummary       = "pool_summary"
+	GatePoolSuspended     = "pool_suspended"
+	GateProtection        = "protection"
+	GateSafeMode          = "safe_mode"
+	GateScaleDownDelay    = "scale_down_delay"
+	GateSoftDrain         = "soft_drain"
+	GateStrayCleanup      = "stray_cleanup"
+	GateSuspendedProcess  = "suspended_process"
+)
+
 // Decision represents the decision made by the autoscaler.
 type Decision struct {
 	// Which direction to scale in.
-	ScalingDirection ScalingDirection
+	ScalingDirection ScalingDirection `json:"direction"`
 
 	// How many instances to create or destroy.
-	ScalingSize int
+	ScalingSize int `json:"size"`
 
 	// A comment to be added to the decision.
-	Comments []string
+	Comments []string `json:"comments"`
+
+	// The gates which kept the autoscaler from scaling, if any. A decision
+	// not to scale without any of them means the pool is the right size.
+	SuppressedBy []string `json:"suppressed_by,omitempty"`
 }
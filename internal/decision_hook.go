@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Actions a decision hook can respond with.
+const (
+	HookActionApprove = "approve"
+	HookActionReject  = "reject"
+	HookActionModify  = "modify"
+)
+
+// Supported values of the AUTOSCALING_DECISION_HOOK_ON_FAILURE setting.
+const (
+	HookFailureHold    = "hold"
+	HookFailureProceed = "proceed"
+)
+
+// hookWaitDelay bounds the wait for the output of a hook which was killed, in
+// case it left children behind holding on to its output.
+const hookWaitDelay = time.Second
+
+// maxHookStderr is how much of the standard error of a failed hook is included
+// in the error.
+const maxHookStderr = 512
+
+// HookResponse is what a decision hook writes to its standard output.
+type HookResponse struct {
+	Action string `json:"action"`
+
+	// Size replaces the size of the decision when modifying it. It can only
+	// be lowered, so that the hook can't take the pool past the limits the
+	// decision was made within.
+	Size int `json:"size,omitempty"`
+
+	// Comment is added to the comments of the decision.
+	Comment string `json:"comment,omitempty"`
+}
+
+// DecisionHook is an operator-provided command consulted before acting on a
+// scaling decision. It gets a snapshot of the state, including the decision,
+// as JSON on its standard input, and responds on its standard output.
+type DecisionHook struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Run runs the hook with the given snapshot and parses its response.
+func (h DecisionHook) Run(ctx context.Context, snapshot *Preview) (*HookResponse, error) {
+	input, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize decision hook input: %w", err)
+	}
+
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, h.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = hookWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("decision hook timed out: %w", ctx.Err())
+		}
+
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			if len(output) > maxHookStderr {
+				output = output[:maxHookStderr]
+			}
+
+			return nil, fmt.Errorf("could not run decision hook: %w: %s", err, output)
+		}
+
+		return nil, fmt.Errorf("could not run decision hook: %w", err)
+	}
+
+	var response HookResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("could not parse decision hook response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// Apply applies the response of the hook to the decision.
+func (r HookResponse) Apply(decision Decision) (Decision, error) {
+	comments := append([]string{}, decision.Comments...)
+
+	if r.Comment != "" {
+		comments = append(comments, r.Comment)
+	}
+
+	switch r.Action {
+	case HookActionApprove:
+		decision.Comments = comments
+		return decision, nil
+	case HookActionReject:
+		return Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         append(comments, "rejected by the decision hook"),
+			SuppressedBy:     []string{GateDecisionHook},
+		}, nil
+	case HookActionModify:
+		if r.Size < 0 || r.Size > decision.ScalingSize {
+			return decision, fmt.Errorf("decision hook can't change the size from %d to %d", decision.ScalingSize, r.Size)
+		}
+
+		if r.Size == 0 {
+			return Decision{
+				ScalingDirection: ScalingDirectionNone,
+				Comments:         append(comments, "rejected by the decision hook"),
+				SuppressedBy:     []string{GateDecisionHook},
+			}, nil
+		}
+
+		return Decision{
+			ScalingDirection: decision.ScalingDirection,
+			ScalingSize:      r.Size,
+			Comments:         append(comments, fmt.Sprintf("size changed from %d to %d by the decision hook", decision.ScalingSize, r.Size)),
+		}, nil
+	default:
+		return decision, fmt.Errorf("unknown decision hook action %q", r.Action)
+	}
+}
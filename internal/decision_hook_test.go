@@ -0,0 +1,144 @@
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+// writeHook writes an executable shell script to a temporary directory.
+func writeHook(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755))
+
+	return path
+}
+
+func TestDecisionHookRun(t *testing.T) {
+	snapshot := &internal.Preview{
+		Decision:    internal.Decision{ScalingDirection: internal.ScalingDirectionUp, ScalingSize: 2},
+		Workers:     1,
+		PendingRuns: 3,
+	}
+
+	t.Run("passes the snapshot on the standard input", func(t *testing.T) {
+		input := filepath.Join(t.TempDir(), "input.json")
+		hook := internal.DecisionHook{Command: writeHook(t, `cat > `+input+`; echo '{"action": "approve"}'`)}
+
+		response, err := hook.Run(context.Background(), snapshot)
+		require.NoError(t, err)
+		require.Equal(t, internal.HookActionApprove, response.Action)
+
+		raw, err := os.ReadFile(input)
+		require.NoError(t, err)
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(raw, &got))
+		require.Equal(t, map[string]any{"direction": "up", "size": float64(2), "comments": nil}, got["decision"])
+		require.Equal(t, float64(3), got["pending_runs"])
+	})
+
+	t.Run("parses a modification", func(t *testing.T) {
+		hook := internal.DecisionHook{Command: writeHook(t, `echo '{"action": "modify", "size": 1, "comment": "budget"}'`)}
+
+		response, err := hook.Run(context.Background(), snapshot)
+		require.NoError(t, err)
+		require.Equal(t, &internal.HookResponse{Action: internal.HookActionModify, Size: 1, Comment: "budget"}, response)
+	})
+
+	t.Run("fails on a non-zero exit code", func(t *testing.T) {
+		hook := internal.DecisionHook{Command: writeHook(t, `echo "no way" >&2; exit 3`)}
+
+		_, err := hook.Run(context.Background(), snapshot)
+		require.EqualError(t, err, "could not run decision hook: exit status 3: no way")
+	})
+
+	t.Run("fails on an invalid response", func(t *testing.T) {
+		hook := internal.DecisionHook{Command: writeHook(t, `echo "sure"`)}
+
+		_, err := hook.Run(context.Background(), snapshot)
+		require.ErrorContains(t, err, "could not parse decision hook response")
+	})
+
+	t.Run("fails when it times out", func(t *testing.T) {
+		hook := internal.DecisionHook{Command: writeHook(t, `sleep 5`), Timeout: 100 * time.Millisecond}
+
+		start := time.Now()
+
+		_, err := hook.Run(context.Background(), snapshot)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 3*time.Second)
+	})
+}
+
+func TestHookResponseApply(t *testing.T) {
+	decision := internal.Decision{
+		ScalingDirection: internal.ScalingDirectionDown,
+		ScalingSize:      3,
+		Comments:         []string{"removing idle workers"},
+	}
+
+	for name, tt := range map[string]struct {
+		response internal.HookResponse
+		want     internal.Decision
+		err      string
+	}{
+		"approve": {
+			response: internal.HookResponse{Action: internal.HookActionApprove},
+			want:     decision,
+		},
+		"veto": {
+			response: internal.HookResponse{Action: internal.HookActionReject, Comment: "change freeze"},
+			want: internal.Decision{
+				ScalingDirection: internal.ScalingDirectionNone,
+				Comments:         []string{"removing idle workers", "change freeze", "rejected by the decision hook"},
+				SuppressedBy:     []string{internal.GateDecisionHook},
+			},
+		},
+		"modify": {
+			response: internal.HookResponse{Action: internal.HookActionModify, Size: 1},
+			want: internal.Decision{
+				ScalingDirection: internal.ScalingDirectionDown,
+				ScalingSize:      1,
+				Comments:         []string{"removing idle workers", "size changed from 3 to 1 by the decision hook"},
+			},
+		},
+		"modify to zero": {
+			response: internal.HookResponse{Action: internal.HookActionModify},
+			want: internal.Decision{
+				ScalingDirection: internal.ScalingDirectionNone,
+				Comments:         []string{"removing idle workers", "rejected by the decision hook"},
+				SuppressedBy:     []string{internal.GateDecisionHook},
+			},
+		},
+		"modify above the decision": {
+			response: internal.HookResponse{Action: internal.HookActionModify, Size: 4},
+			err:      "decision hook can't change the size from 3 to 4",
+		},
+		"unknown action": {
+			response: internal.HookResponse{Action: "maybe"},
+			err:      `unknown decision hook action "maybe"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.response.Apply(decision)
+
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
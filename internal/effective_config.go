@@ -0,0 +1,83 @@
+package internal
+
+import "time"
+
+// EffectiveConfig is a snapshot of the scaling parameters which actually
+// applied to a run, after resolving the profile, the schedules, the quota and
+// the percentage caps. It helps explain why a decision was made.
+type EffectiveConfig struct {
+	Profile          string `json:"profile,omitempty"`
+	OffHours         bool   `json:"off_hours"`
+	MinSize          int    `json:"min_size"`
+	MaxSize          int    `json:"max_size"`
+	MaxCreate        int    `json:"max_create"`
+	MinScaleUpStep   int    `json:"min_scale_up_step,omitempty"`
+	MaxKill          int    `json:"max_kill"`
+	PrewarmSize      int    `json:"prewarm_size,omitempty"`
+	SaturationBuffer int    `json:"saturation_buffer,omitempty"`
+	SafeModeFloor    int    `json:"safe_mode_floor,omitempty"`
+	PendingRuns      int    `json:"pending_runs"`
+	QueueDepth       int    `json:"queue_depth,omitempty"`
+	InstanceQuota    int    `json:"instance_quota,omitempty"`
+
+	UtilizationThreshold int     `json:"utilization_threshold,omitempty"`
+	Utilization          float64 `json:"utilization,omitempty"`
+
+	BoundsWindow string `json:"bounds_window,omitempty"`
+	Reserve      int    `json:"reserve,omitempty"`
+}
+
+// EffectiveConfig returns the scaling parameters applying to the state at the
+// given time, mirroring the way Decide and DecideOffHours resolve them.
+func (s *State) EffectiveConfig(cfg RuntimeConfig, now time.Time, offHours bool) EffectiveConfig {
+	maxSize, _ := s.maxSize()
+
+	out := EffectiveConfig{
+		Profile:          cfg.Profile,
+		OffHours:         offHours,
+		MinSize:          int(*s.ASG.MinSize),
+		MaxSize:          maxSize,
+		MaxCreate:        cfg.AutoscalingMaxCreate,
+		MinScaleUpStep:   cfg.AutoscalingMinScaleUpStep,
+		MaxKill:          s.maxKill(cfg),
+		SaturationBuffer: cfg.AutoscalingSaturationBuffer,
+		PendingRuns:      s.PendingRuns(cfg),
+		QueueDepth:       s.QueueDepth,
+		InstanceQuota:    s.InstanceQuota,
+	}
+
+	if cfg.AutoscalingUtilizationThreshold > 0 {
+		out.UtilizationThreshold = cfg.AutoscalingUtilizationThreshold
+		out.Utilization = s.Utilization
+	}
+
+	// Outside of business hours the pool is held at a fixed size, and never
+	// scaled up.
+	if offHours {
+		out.MaxCreate = 0
+		out.MinScaleUpStep = 0
+		out.SaturationBuffer = 0
+		out.UtilizationThreshold = 0
+
+		if cfg.AutoscalingOffHoursSize > out.MinSize {
+			out.MinSize = cfg.AutoscalingOffHoursSize
+		}
+
+		return out
+	}
+
+	if s.Bounds != nil {
+		out.BoundsWindow = s.Bounds.Window
+		out.Reserve = s.Bounds.Reserve
+
+		if s.Bounds.MinSize > out.MinSize {
+			out.MinSize = s.Bounds.MinSize
+		}
+	}
+
+	if out.PrewarmSize = s.PrewarmSize(cfg, now); out.PrewarmSize > out.MinSize {
+		out.MinSize = out.PrewarmSize
+	}
+
+	return out
+}
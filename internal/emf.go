@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// emfDimension is the dimension all the metrics are reported under.
+const emfDimension = "WorkerPoolId"
+
+// emfMetrics are the names of the metrics reported for each run.
+var emfMetrics = []string{
+	"ScaledUp",
+	"ScaledDown",
+	"DrainedWorkers",
+	"KilledInstances",
+	"StraysKilled",
+	"PendingRuns",
+	"Errors",
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// EMFNotifier writes the metrics of each run as a log line in the CloudWatch
+// Embedded Metric Format. On Lambda, CloudWatch extracts the metrics from the
+// logs, so they're reported without any additional API calls.
+type EMFNotifier struct {
+	Writer    io.Writer
+	Namespace string
+}
+
+// NewEMFNotifier creates an EMF notifier writing to the given writer, with the
+// metrics in the given namespace.
+func NewEMFNotifier(w io.Writer, namespace string) *EMFNotifier {
+	return &EMFNotifier{Writer: w, Namespace: namespace}
+}
+
+// Notify writes the metrics of the run, whether or not any action was taken,
+// so that the metrics don't have gaps.
+func (n *EMFNotifier) Notify(_ context.Context, result *RunResult) error {
+	payload, err := json.Marshal(NewEMFRecord(result, n.Namespace, time.Now()))
+	if err != nil {
+		return fmt.Errorf("could not serialize EMF record: %w", err)
+	}
+
+	if _, err := n.Writer.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("could not write EMF record: %w", err)
+	}
+
+	return nil
+}
+
+// NewEMFRecord builds the EMF record with the metrics of the run.
+func NewEMFRecord(result *RunResult, namespace string, now time.Time) map[string]any {
+	metrics := make([]emfMetric, 0, len(emfMetrics))
+	for _, name := range emfMetrics {
+		metrics = append(metrics, emfMetric{Name: name, Unit: "Count"})
+	}
+
+	var scaledUp, scaledDown, pendingRuns, failed int
+
+	switch result.Decision.ScalingDirection {
+	case ScalingDirectionUp:
+		scaledUp = result.Decision.ScalingSize
+	case ScalingDirectionDown:
+		scaledDown = result.Decision.ScalingSize
+	}
+
+	if result.EffectiveConfig != nil {
+		pendingRuns = result.EffectiveConfig.PendingRuns
+	}
+
+	if result.Error != "" {
+		failed = 1
+	}
+
+	return map[string]any{
+		"_aws": emfMetadata{
+			Timestamp: now.UnixMilli(),
+			CloudWatchMetrics: []emfDirective{{
+				Namespace:  namespace,
+				Dimensions: [][]string{{emfDimension}},
+				Metrics:    metrics,
+			}},
+		},
+		emfDimension:      result.WorkerPoolID,
+		"ScaledUp":        scaledUp,
+		"ScaledDown":      scaledDown,
+		"DrainedWorkers":  len(result.DrainedWorkers),
+		"KilledInstances": len(result.KilledInstances),
+		"StraysKilled":    result.StraysKilled,
+		"PendingRuns":     pendingRuns,
+		"Errors":          failed,
+	}
+}
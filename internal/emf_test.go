@@ -0,0 +1,103 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestEMFNotifier(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("EMFNotifier", func() {
+		var buf *bytes.Buffer
+		var result *internal.RunResult
+		var record map[string]any
+		var err error
+
+		g.BeforeEach(func() {
+			buf = &bytes.Buffer{}
+
+			result = internal.NewRunResult(internal.RuntimeConfig{
+				SpaceliftWorkerPoolID: "pool",
+				AutoscalingGroupARN:   "arn",
+			})
+			result.Decision = internal.Decision{
+				ScalingDirection: internal.ScalingDirectionDown,
+				ScalingSize:      2,
+			}
+			result.DrainedWorkers = []string{"worker-1", "worker-2"}
+			result.KilledInstances = []string{"instance-1"}
+			result.EffectiveConfig = &internal.EffectiveConfig{PendingRuns: 3}
+		})
+
+		g.JustBeforeEach(func() {
+			err = internal.NewEMFNotifier(buf, "Spacelift/Autoscaler").Notify(context.Background(), result)
+
+			record = nil
+			Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		})
+
+		g.It("should write a single line", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bytes.Count(buf.Bytes(), []byte("\n"))).To(Equal(1))
+		})
+
+		g.It("should define the metrics", func() {
+			metadata := record["_aws"].(map[string]any)
+			Expect(metadata["Timestamp"]).To(BeNumerically(">", 0))
+
+			directives := metadata["CloudWatchMetrics"].([]any)
+			Expect(directives).To(HaveLen(1))
+
+			directive := directives[0].(map[string]any)
+			Expect(directive["Namespace"]).To(Equal("Spacelift/Autoscaler"))
+			Expect(directive["Dimensions"]).To(Equal([]any{[]any{"WorkerPoolId"}}))
+
+			var names []string
+			for _, metric := range directive["Metrics"].([]any) {
+				metric := metric.(map[string]any)
+				Expect(metric["Unit"]).To(Equal("Count"))
+
+				names = append(names, metric["Name"].(string))
+			}
+
+			Expect(names).To(ConsistOf("ScaledUp", "ScaledDown", "DrainedWorkers", "KilledInstances", "StraysKilled", "PendingRuns", "Errors"))
+
+			// Every metric defined needs a value in the record.
+			for _, name := range names {
+				Expect(record).To(HaveKey(name))
+			}
+		})
+
+		g.It("should report the values of the run", func() {
+			Expect(record["WorkerPoolId"]).To(Equal("pool"))
+			Expect(record["ScaledUp"]).To(BeEquivalentTo(0))
+			Expect(record["ScaledDown"]).To(BeEquivalentTo(2))
+			Expect(record["DrainedWorkers"]).To(BeEquivalentTo(2))
+			Expect(record["KilledInstances"]).To(BeEquivalentTo(1))
+			Expect(record["StraysKilled"]).To(BeEquivalentTo(0))
+			Expect(record["PendingRuns"]).To(BeEquivalentTo(3))
+			Expect(record["Errors"]).To(BeEquivalentTo(0))
+		})
+
+		g.Describe("for a failed run", func() {
+			g.BeforeEach(func() {
+				result.Decision = internal.Decision{}
+				result.Error = "boom"
+			})
+
+			g.It("should report the error", func() {
+				Expect(record["ScaledDown"]).To(BeEquivalentTo(0))
+				Expect(record["Errors"]).To(BeEquivalentTo(1))
+			})
+		})
+	})
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slog"
+)
+
+// EventActionDrainAll drains all the workers in the pool. Busy workers finish
+// their current runs, but none of the workers accept new ones.
+const EventActionDrainAll = "drain_all"
+
+// Event is the payload the autoscaler is invoked with. Normally it's empty, or
+// not meant for us (eg. a scheduled event), and the autoscaler runs as usual.
+// Operators can also invoke it manually with an override, to trigger a one-off
+// action instead of scaling.
+type Event struct {
+	// Action is the name of the one-off action to run.
+	Action string `json:"action,omitempty"`
+
+	// ForceDesired sets the desired capacity of the ASG to the given value.
+	ForceDesired *int `json:"force_desired,omitempty"`
+
+	// TerminateInstances drains the workers on the given instances and
+	// terminates the instances.
+	TerminateInstances []string `json:"terminate_instances,omitempty"`
+
+	// Preview asks for the decision the autoscaler would make, without acting
+	// on it. It can't be combined with an override.
+	Preview bool `json:"preview,omitempty"`
+}
+
+// IsOverride checks whether the event asks for a one-off action rather than
+// a regular scaling run.
+func (e Event) IsOverride() bool {
+	return e.Action != "" || e.ForceDesired != nil || len(e.TerminateInstances) > 0
+}
+
+// Validate checks that the event asks for at most one known action.
+func (e Event) Validate() error {
+	var overrides int
+
+	for _, set := range []bool{e.Action != "", e.ForceDesired != nil, len(e.TerminateInstances) > 0} {
+		if set {
+			overrides++
+		}
+	}
+
+	if overrides > 1 {
+		return fmt.Errorf("only one of action, force_desired and terminate_instances can be set")
+	}
+
+	if e.Preview && overrides > 0 {
+		return fmt.Errorf("preview can't be combined with an override")
+	}
+
+	switch e.Action {
+	case "", EventActionDrainAll:
+	default:
+		return fmt.Errorf("unknown override action %s", e.Action)
+	}
+
+	if e.ForceDesired != nil && *e.ForceDesired < 0 {
+		return fmt.Errorf("invalid force_desired value: %d", *e.ForceDesired)
+	}
+
+	for _, instanceID := range e.TerminateInstances {
+		if instanceID == "" {
+			return fmt.Errorf("empty instance ID in terminate_instances")
+		}
+	}
+
+	return nil
+}
+
+// Override runs the one-off action the event asks for, bypassing the regular
+// scaling decision.
+func (s AutoScaler) Override(ctx context.Context, cfg RuntimeConfig, event Event) error {
+	if err := event.Validate(); err != nil {
+		return fmt.Errorf("invalid override: %w", err)
+	}
+
+	logger := s.logger.With(
+		"asg_arn", cfg.AutoscalingGroupARN,
+		"worker_pool_id", cfg.SpaceliftWorkerPoolID,
+	)
+
+	if event.ForceDesired != nil {
+		return s.forceDesired(ctx, cfg, logger, *event.ForceDesired)
+	}
+
+	if len(event.TerminateInstances) > 0 {
+		return s.terminateInstances(ctx, cfg, logger, event.TerminateInstances)
+	}
+
+	return s.drainAll(ctx, logger)
+}
+
+// drainAll drains all the workers in the pool which aren't drained yet.
+func (s AutoScaler) drainAll(ctx context.Context, logger *slog.Logger) error {
+	workerPool, err := s.controller.GetWorkerPool(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get worker pool: %w", err)
+	}
+
+	var drained int
+
+	for _, worker := range workerPool.Workers {
+		if worker.Drained {
+			continue
+		}
+
+		if err := s.controller.ForceDrainWorker(ctx, worker.ID); err != nil {
+			return fmt.Errorf("could not drain worker: %w", err)
+		}
+
+		logger.With("worker_id", worker.ID, "busy", worker.Busy).Info("drained worker on request")
+		drained++
+	}
+
+	logger.With("workers", drained).Info("drained all the workers in the pool")
+
+	return nil
+}
+
+// forceDesired sets the desired capacity of the ASG, as long as it's within
+// the bounds of the ASG. The ASG picks the instances to terminate on its own,
+// so the desired capacity is not lowered while any instance is protected.
+func (s AutoScaler) forceDesired(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, desired int) error {
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get autoscaling group: %w", err)
+	}
+
+	if desired < int(*asg.MinSize) || desired > int(*asg.MaxSize) {
+		return fmt.Errorf("desired capacity %d is outside of the ASG bounds of %d to %d", desired, *asg.MinSize, *asg.MaxSize)
+	}
+
+	if tag, ok := cfg.ProtectionTag(); ok && desired < int(*asg.DesiredCapacity) {
+		var instanceIDs []string
+
+		for _, instance := range asg.Instances {
+			if instance.InstanceId != nil {
+				instanceIDs = append(instanceIDs, *instance.InstanceId)
+			}
+		}
+
+		instances, err := s.controller.DescribeInstances(ctx, instanceIDs)
+		if err != nil {
+			return fmt.Errorf("could not list EC2 instances: %w", err)
+		}
+
+		for _, instance := range instances {
+			if tag.Protects(instance.Tags) {
+				return fmt.Errorf("can't lower the desired capacity while instance %s is protected by the %s tag", *instance.InstanceId, tag)
+			}
+		}
+	}
+
+	logger = logger.With("desired_capacity", desired, "previous_desired_capacity", *asg.DesiredCapacity)
+
+	if err := s.controller.ScaleUpASG(ctx, int32(desired)); err != nil {
+		return fmt.Errorf("could not set desired capacity: %w", err)
+	}
+
+	logger.Info("set the desired capacity on request")
+
+	return nil
+}
+
+// terminateInstances drains the workers on the given instances and terminates
+// the instances, bypassing the load-based decision. Busy workers are left
+// alone, just like during a regular scale-down. The instances need to be a
+// part of the ASG and not protected, otherwise nothing is terminated.
+func (s AutoScaler) terminateInstances(ctx context.Context, cfg RuntimeConfig, logger *slog.Logger, instanceIDs []string) error {
+	workerPool, err := s.controller.GetWorkerPool(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get worker pool: %w", err)
+	}
+
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get autoscaling group: %w", err)
+	}
+
+	inASG := make(map[string]struct{}, len(asg.Instances))
+	for _, instance := range asg.Instances {
+		if instance.InstanceId != nil {
+			inASG[*instance.InstanceId] = struct{}{}
+		}
+	}
+
+	for _, instanceID := range instanceIDs {
+		if _, ok := inASG[instanceID]; !ok {
+			return fmt.Errorf("instance %s is not a part of the ASG", instanceID)
+		}
+	}
+
+	// Even an explicit request doesn't override the protection.
+	if tag, ok := cfg.ProtectionTag(); ok {
+		instances, err := s.controller.DescribeInstances(ctx, instanceIDs)
+		if err != nil {
+			return fmt.Errorf("could not list EC2 instances: %w", err)
+		}
+
+		for _, instance := range instances {
+			if tag.Protects(instance.Tags) {
+				return fmt.Errorf("instance %s is protected by the %s tag", *instance.InstanceId, tag)
+			}
+		}
+	}
+
+	workersByInstanceID := make(map[string]Worker, len(workerPool.Workers))
+	for _, worker := range workerPool.Workers {
+		if _, instanceID, err := worker.InstanceIdentity(cfg.MetadataKeys()); err == nil {
+			workersByInstanceID[string(instanceID)] = worker
+		}
+	}
+
+	var terminated int
+
+	for _, instanceID := range instanceIDs {
+		logger := logger.With("instance_id", instanceID)
+
+		// Instances without a worker have nothing to drain.
+		if worker, ok := workersByInstanceID[instanceID]; ok {
+			logger = logger.With("worker_id", worker.ID)
+
+			drained, err := s.controller.DrainWorker(ctx, worker.ID)
+			if err != nil {
+				return fmt.Errorf("could not drain worker: %w", err)
+			}
+
+			if !drained {
+				logger.Warn("worker was busy, not terminating its instance")
+				continue
+			}
+		}
+
+		if err := s.controller.KillInstance(ctx, instanceID); err != nil {
+			return fmt.Errorf("could not kill instance: %w", err)
+		}
+
+		logger.Info("terminated instance on request")
+		terminated++
+	}
+
+	logger.With("instances", terminated, "requested", len(instanceIDs)).Info("terminated the requested instances")
+
+	return nil
+}
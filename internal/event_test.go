@@ -0,0 +1,278 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestEventUnmarshalling(t *testing.T) {
+	for name, tc := range map[string]struct {
+		payload   string
+		override  bool
+		action    string
+		desired   *int
+		instances []string
+		err       string
+	}{
+		"empty":           {payload: `{}`},
+		"scheduled event": {payload: `{"version": "0", "detail-type": "Scheduled Event", "source": "aws.events"}`},
+		"drain all":       {payload: `{"action": "drain_all"}`, override: true, action: internal.EventActionDrainAll},
+		"force desired":   {payload: `{"force_desired": 3}`, override: true, desired: ptr(3)},
+		"force to zero":   {payload: `{"force_desired": 0}`, override: true, desired: ptr(0)},
+		"unknown action":  {payload: `{"action": "bacon"}`, override: true, action: "bacon", err: "unknown override action bacon"},
+		"terminate instances": {
+			payload:   `{"terminate_instances": ["i-1", "i-2"]}`,
+			override:  true,
+			instances: []string{"i-1", "i-2"},
+		},
+		"empty instance ID": {
+			payload:   `{"terminate_instances": [""]}`,
+			override:  true,
+			instances: []string{""},
+			err:       "empty instance ID in terminate_instances",
+		},
+		"preview": {payload: `{"preview": true}`},
+		"preview with override": {
+			payload:  `{"preview": true, "force_desired": 3}`,
+			override: true,
+			desired:  ptr(3),
+			err:      "preview can't be combined with an override",
+		},
+		"both overrides": {
+			payload:  `{"action": "drain_all", "force_desired": 3}`,
+			override: true,
+			action:   internal.EventActionDrainAll,
+			desired:  ptr(3),
+			err:      "only one of action, force_desired and terminate_instances can be set",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var event internal.Event
+			require.NoError(t, json.Unmarshal([]byte(tc.payload), &event))
+
+			require.Equal(t, tc.override, event.IsOverride())
+			require.Equal(t, tc.action, event.Action)
+			require.Equal(t, tc.desired, event.ForceDesired)
+			require.Equal(t, tc.instances, event.TerminateInstances)
+
+			if tc.err == "" {
+				require.NoError(t, event.Validate())
+			} else {
+				require.EqualError(t, event.Validate(), tc.err)
+			}
+		})
+	}
+}
+
+func TestAutoScalerOverrideDrainAll(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Busy: true},
+			{ID: "2"},
+			{ID: "3", Drained: true},
+		},
+	}, nil)
+	ctrl.On("ForceDrainWorker", mock.Anything, "1").Return(nil)
+	ctrl.On("ForceDrainWorker", mock.Anything, "2").Return(nil)
+
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{Action: internal.EventActionDrainAll})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "drained all the workers in the pool")
+}
+
+func TestAutoScalerOverrideForceDesired(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+	}, nil)
+	ctrl.On("ScaleUpASG", mock.Anything, int32(4)).Return(nil)
+
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{ForceDesired: ptr(4)})
+	require.NoError(t, err)
+}
+
+func TestAutoScalerOverrideForceDesiredOutsideBounds(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+	}, nil)
+
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{ForceDesired: ptr(6)})
+	require.EqualError(t, err, "desired capacity 6 is outside of the ASG bounds of 1 to 5")
+}
+
+func TestAutoScalerOverrideForceDesiredWithProtectedInstances(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("i-1")},
+			{InstanceId: ptr("i-2")},
+		},
+	}, nil)
+	ctrl.On("DescribeInstances", mock.Anything, []string{"i-1", "i-2"}).Return([]ec2types.Instance{
+		{InstanceId: ptr("i-1")},
+		{InstanceId: ptr("i-2"), Tags: []ec2types.Tag{{Key: ptr("keep"), Value: ptr("true")}}},
+	}, nil)
+
+	cfg := internal.RuntimeConfig{AutoscalingProtectionTag: "keep"}
+
+	err := scaler.Override(context.Background(), cfg, internal.Event{ForceDesired: ptr(1)})
+	require.EqualError(t, err, "can't lower the desired capacity while instance i-2 is protected by the keep tag")
+	ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerOverrideTerminateInstances(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Metadata: `{"asg_id": "group", "instance_id": "i-1"}`},
+			{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "i-2"}`},
+			{ID: "4", Metadata: `{"asg_id": "group", "instance_id": "i-4"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(4)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("i-1")},
+			{InstanceId: ptr("i-2")},
+			{InstanceId: ptr("i-3")},
+			{InstanceId: ptr("i-4")},
+		},
+	}, nil)
+
+	// The busy worker stays, the instance without a worker is terminated
+	// right away, and i-4 wasn't requested at all.
+	ctrl.On("DrainWorker", mock.Anything, "1").Return(true, nil)
+	ctrl.On("DrainWorker", mock.Anything, "2").Return(false, nil)
+	ctrl.On("KillInstance", mock.Anything, "i-1").Return(nil)
+	ctrl.On("KillInstance", mock.Anything, "i-3").Return(nil)
+
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{TerminateInstances: []string{"i-1", "i-2", "i-3"}})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "worker was busy, not terminating its instance")
+	ctrl.AssertNumberOfCalls(t, "KillInstance", 2)
+}
+
+func TestAutoScalerOverrideTerminateInstancesOutsideASG(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances:            []types.Instance{{InstanceId: ptr("i-1")}},
+	}, nil)
+
+	// Nothing is terminated, not even the instance which is in the ASG.
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{TerminateInstances: []string{"i-1", "i-9"}})
+	require.EqualError(t, err, "instance i-9 is not a part of the ASG")
+}
+
+func TestAutoScalerOverrideTerminateInstancesProtected(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(0)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances:            []types.Instance{{InstanceId: ptr("i-1")}, {InstanceId: ptr("i-2")}},
+	}, nil)
+	ctrl.On("DescribeInstances", mock.Anything, []string{"i-1", "i-2"}).Return([]ec2types.Instance{
+		{InstanceId: ptr("i-1")},
+		{InstanceId: ptr("i-2"), Tags: []ec2types.Tag{{Key: ptr("keep"), Value: ptr("true")}}},
+	}, nil)
+
+	// Nothing is terminated, not even the unprotected instance.
+	cfg := internal.RuntimeConfig{AutoscalingProtectionTag: "keep=true"}
+
+	err := scaler.Override(context.Background(), cfg, internal.Event{TerminateInstances: []string{"i-1", "i-2"}})
+	require.EqualError(t, err, "instance i-2 is protected by the keep=true tag")
+}
+
+func TestAutoScalerOverrideRejectsInvalidEvent(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	err := scaler.Override(context.Background(), internal.RuntimeConfig{}, internal.Event{Action: "bacon"})
+	require.EqualError(t, err, "invalid override: unknown override action bacon")
+}
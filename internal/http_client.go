@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// NewHTTPClient creates the HTTP client used to talk to the AWS and Spacelift
+// APIs. By default the proxy settings are taken from the standard environment
+// variables (HTTP_PROXY, HTTPS_PROXY and NO_PROXY), but an explicitly
+// configured proxy URL takes precedence.
+func NewHTTPClient(cfg *RuntimeConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse proxy URL: %w", err)
+		}
+
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: %s", cfg.ProxyURL)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
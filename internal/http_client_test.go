@@ -0,0 +1,57 @@
+package internal_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("NewHTTPClient", func() {
+		var cfg *internal.RuntimeConfig
+
+		var client *http.Client
+		var err error
+
+		g.BeforeEach(func() { cfg = &internal.RuntimeConfig{} })
+
+		g.JustBeforeEach(func() { client, err = internal.NewHTTPClient(cfg) })
+
+		g.Describe("with no proxy URL", func() {
+			g.It("should use the proxy from the environment", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.Transport.(*http.Transport).Proxy).NotTo(BeNil())
+			})
+		})
+
+		g.Describe("with a valid proxy URL", func() {
+			g.BeforeEach(func() { cfg.ProxyURL = "http://proxy.example.com:3128" })
+
+			g.It("should route requests through the proxy", func() {
+				Expect(err).NotTo(HaveOccurred())
+
+				req, _ := http.NewRequest(http.MethodGet, "https://demo.app.spacelift.io/graphql", nil)
+				proxyURL, proxyErr := client.Transport.(*http.Transport).Proxy(req)
+
+				Expect(proxyErr).NotTo(HaveOccurred())
+				Expect(proxyURL.String()).To(Equal("http://proxy.example.com:3128"))
+			})
+		})
+
+		g.Describe("with an invalid proxy URL", func() {
+			g.BeforeEach(func() { cfg.ProxyURL = "proxy.example.com" })
+
+			g.It("should return an error", func() {
+				Expect(client).To(BeNil())
+				Expect(err).To(MatchError("invalid proxy URL: proxy.example.com"))
+			})
+		})
+	})
+}
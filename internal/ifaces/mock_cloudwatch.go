@@ -0,0 +1,63 @@
+// Code generated by mockery v2.30.16. DO NOT EDIT.
+
+package ifaces
+
+import (
+	context "context"
+
+	cloudwatch "github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockCloudWatch is an autogenerated mock type for the CloudWatch type
+type MockCloudWatch struct {
+	mock.Mock
+}
+
+// GetMetricStatistics provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockCloudWatch) GetMetricStatistics(_a0 context.Context, _a1 *cloudwatch.GetMetricStatisticsInput, _a2 ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *cloudwatch.GetMetricStatisticsOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *cloudwatch.GetMetricStatisticsInput, ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *cloudwatch.GetMetricStatisticsInput, ...func(*cloudwatch.Options)) *cloudwatch.GetMetricStatisticsOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cloudwatch.GetMetricStatisticsOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *cloudwatch.GetMetricStatisticsInput, ...func(*cloudwatch.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewMockCloudWatch creates a new instance of MockCloudWatch. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockCloudWatch(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockCloudWatch {
+	mock := &MockCloudWatch{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
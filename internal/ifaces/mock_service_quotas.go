@@ -0,0 +1,62 @@
+// Code generated by mockery v2.30.16. DO NOT EDIT.
+
+package ifaces
+
+import (
+	context "context"
+
+	servicequotas "github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockServiceQuotas is an autogenerated mock type for the ServiceQuotas type
+type MockServiceQuotas struct {
+	mock.Mock
+}
+
+// GetServiceQuota provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockServiceQuotas) GetServiceQuota(_a0 context.Context, _a1 *servicequotas.GetServiceQuotaInput, _a2 ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *servicequotas.GetServiceQuotaOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *servicequotas.GetServiceQuotaInput, ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *servicequotas.GetServiceQuotaInput, ...func(*servicequotas.Options)) *servicequotas.GetServiceQuotaOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*servicequotas.GetServiceQuotaOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *servicequotas.GetServiceQuotaInput, ...func(*servicequotas.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewMockServiceQuotas creates a new instance of MockServiceQuotas. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockServiceQuotas(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockServiceQuotas {
+	mock := &MockServiceQuotas{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,62 @@
+// Code generated by mockery v2.30.16. DO NOT EDIT.
+
+package ifaces
+
+import (
+	context "context"
+
+	sqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockSQS is an autogenerated mock type for the SQS type
+type MockSQS struct {
+	mock.Mock
+}
+
+// GetQueueAttributes provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockSQS) GetQueueAttributes(_a0 context.Context, _a1 *sqs.GetQueueAttributesInput, _a2 ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *sqs.GetQueueAttributesOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *sqs.GetQueueAttributesInput, ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *sqs.GetQueueAttributesInput, ...func(*sqs.Options)) *sqs.GetQueueAttributesOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*sqs.GetQueueAttributesOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *sqs.GetQueueAttributesInput, ...func(*sqs.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewMockSQS creates a new instance of MockSQS. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockSQS(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockSQS {
+	mock := &MockSQS{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,15 @@
+package ifaces
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+)
+
+// ServiceQuotas is an interface which mocks the subset of the Service Quotas
+// client that we use in the controller.
+//
+//go:generate mockery --inpackage --name ServiceQuotas --filename mock_service_quotas.go
+type ServiceQuotas interface {
+	GetServiceQuota(context.Context, *servicequotas.GetServiceQuotaInput, ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error)
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LabelExpression selects runs based on their labels. It is either a single
+// key=value term, or an AND/OR combination of other expressions.
+type LabelExpression interface {
+	Matches(labels []string) bool
+}
+
+// labelTerm matches runs carrying the key:value label, following the
+// Spacelift label convention.
+type labelTerm struct {
+	key   string
+	value string
+}
+
+func (t labelTerm) Matches(labels []string) bool {
+	want := t.key + ":" + t.value
+
+	for _, label := range labels {
+		if label == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+type labelAnd []LabelExpression
+
+func (a labelAnd) Matches(labels []string) bool {
+	for _, expr := range a {
+		if !expr.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type labelOr []LabelExpression
+
+func (o labelOr) Matches(labels []string) bool {
+	for _, expr := range o {
+		if expr.Matches(labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseLabelExpression parses an expression like
+// "team=infra AND (env=prod OR env=staging)". AND binds tighter than OR, and
+// the operators are case-insensitive.
+func ParseLabelExpression(in string) (LabelExpression, error) {
+	p := &labelParser{tokens: tokenizeLabelExpression(in)}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid label expression %q: %w", in, err)
+	}
+
+	if token, ok := p.peek(); ok {
+		return nil, fmt.Errorf("invalid label expression %q: unexpected %q", in, token)
+	}
+
+	return expr, nil
+}
+
+func tokenizeLabelExpression(in string) []string {
+	in = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(in)
+
+	return strings.Fields(in)
+}
+
+type labelParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *labelParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+func (p *labelParser) accept(operator string) bool {
+	if token, ok := p.peek(); ok && strings.EqualFold(token, operator) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *labelParser) parseOr() (LabelExpression, error) {
+	var out labelOr
+
+	for {
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, expr)
+
+		if !p.accept("OR") {
+			break
+		}
+	}
+
+	if len(out) == 1 {
+		return out[0], nil
+	}
+
+	return out, nil
+}
+
+func (p *labelParser) parseAnd() (LabelExpression, error) {
+	var out labelAnd
+
+	for {
+		expr, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, expr)
+
+		if !p.accept("AND") {
+			break
+		}
+	}
+
+	if len(out) == 1 {
+		return out[0], nil
+	}
+
+	return out, nil
+}
+
+func (p *labelParser) parseTerm() (LabelExpression, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	if token == "(" {
+		p.pos++
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+
+		return expr, nil
+	}
+
+	key, value, found := strings.Cut(token, "=")
+	if !found || key == "" || value == "" {
+		return nil, fmt.Errorf("invalid term %q, expected key=value", token)
+	}
+
+	p.pos++
+
+	return labelTerm{key: key, value: value}, nil
+}
@@ -0,0 +1,59 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestParseLabelExpression(t *testing.T) {
+	runs := map[string][]string{
+		"infra-prod": {"team:infra", "env:prod"},
+		"infra-dev":  {"team:infra", "env:dev"},
+		"data-prod":  {"team:data", "env:prod"},
+		"unlabeled":  nil,
+	}
+
+	for _, tt := range []struct {
+		expression string
+		matches    []string
+	}{
+		{expression: "team=infra", matches: []string{"infra-dev", "infra-prod"}},
+		{expression: "team=infra AND env=prod", matches: []string{"infra-prod"}},
+		{expression: "team=infra or env=prod", matches: []string{"data-prod", "infra-dev", "infra-prod"}},
+		{expression: "team=data OR team=infra AND env=dev", matches: []string{"data-prod", "infra-dev"}},
+		{expression: "(team=data OR team=infra) AND env=dev", matches: []string{"infra-dev"}},
+		{expression: "env=qa", matches: nil},
+	} {
+		t.Run(tt.expression, func(t *testing.T) {
+			expr, err := internal.ParseLabelExpression(tt.expression)
+			require.NoError(t, err)
+
+			var matches []string
+			for _, name := range []string{"data-prod", "infra-dev", "infra-prod", "unlabeled"} {
+				if expr.Matches(runs[name]) {
+					matches = append(matches, name)
+				}
+			}
+
+			require.Equal(t, tt.matches, matches)
+		})
+	}
+}
+
+func TestParseLabelExpressionErrors(t *testing.T) {
+	for expression, message := range map[string]string{
+		"":                        `invalid label expression "": unexpected end of expression`,
+		"team":                    `invalid label expression "team": invalid term "team", expected key=value`,
+		"team= AND env=prod":      `invalid label expression "team= AND env=prod": invalid term "team=", expected key=value`,
+		"(team=infra":             `invalid label expression "(team=infra": missing closing parenthesis`,
+		"team=infra env=prod":     `invalid label expression "team=infra env=prod": unexpected "env=prod"`,
+		"team=infra AND OR x=y":   `invalid label expression "team=infra AND OR x=y": invalid term "OR", expected key=value`,
+		"team=infra) OR env=prod": `invalid label expression "team=infra) OR env=prod": unexpected ")"`,
+	} {
+		_, err := internal.ParseLabelExpression(expression)
+		require.EqualError(t, err, message, "expression %q", expression)
+	}
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// launchCohortFormat is compact, and sorts chronologically as a string.
+const launchCohortFormat = "20060102T150405Z"
+
+// LaunchCohort identifies the instances launched by a single scale-up, by the
+// time it was made at.
+func LaunchCohort(now time.Time) string {
+	return now.UTC().Format(launchCohortFormat)
+}
+
+// LaunchCohortTagged tells whether the ASG already carries the tag of the given
+// launch cohort, propagated to the instances it launches.
+func LaunchCohortTagged(asg *types.AutoScalingGroup, cohort string) bool {
+	for _, tag := range asg.Tags {
+		if tag.Key != nil && *tag.Key == LaunchCohortTagKey {
+			return tag.Value != nil && *tag.Value == cohort && tag.PropagateAtLaunch != nil && *tag.PropagateAtLaunch
+		}
+	}
+
+	return false
+}
@@ -11,6 +11,8 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
@@ -19,6 +21,52 @@ type MockController struct {
 	mock.Mock
 }
 
+// APICalls provides a mock function with given fields:
+func (_m *MockController) APICalls() int {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for APICalls")
+	}
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// CheckCredentials provides a mock function with given fields: ctx, buffer
+func (_m *MockController) CheckCredentials(ctx context.Context, buffer time.Duration) (bool, error) {
+	ret := _m.Called(ctx, buffer)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CheckCredentials")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) (bool, error)); ok {
+		return rf(ctx, buffer)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) bool); ok {
+		r0 = rf(ctx, buffer)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, buffer)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DescribeInstances provides a mock function with given fields: ctx, instanceIDs
 func (_m *MockController) DescribeInstances(ctx context.Context, instanceIDs []string) ([]types.Instance, error) {
 	ret := _m.Called(ctx, instanceIDs)
@@ -77,6 +125,24 @@ func (_m *MockController) DrainWorker(ctx context.Context, workerID string) (boo
 	return r0, r1
 }
 
+// ForceDrainWorker provides a mock function with given fields: ctx, workerID
+func (_m *MockController) ForceDrainWorker(ctx context.Context, workerID string) error {
+	ret := _m.Called(ctx, workerID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ForceDrainWorker")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, workerID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetAutoscalingGroup provides a mock function with given fields: ctx
 func (_m *MockController) GetAutoscalingGroup(ctx context.Context) (*autoscalingtypes.AutoScalingGroup, error) {
 	ret := _m.Called(ctx)
@@ -107,6 +173,64 @@ func (_m *MockController) GetAutoscalingGroup(ctx context.Context) (*autoscaling
 	return r0, r1
 }
 
+// GetInstanceQuota provides a mock function with given fields: ctx
+func (_m *MockController) GetInstanceQuota(ctx context.Context) (int, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetInstanceQuota")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetScalingPolicies provides a mock function with given fields: ctx
+func (_m *MockController) GetScalingPolicies(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetScalingPolicies")
+	}
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]string, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetWorkerPool provides a mock function with given fields: ctx
 func (_m *MockController) GetWorkerPool(ctx context.Context) (*internal.WorkerPool, error) {
 	ret := _m.Called(ctx)
@@ -137,6 +261,64 @@ func (_m *MockController) GetWorkerPool(ctx context.Context) (*internal.WorkerPo
 	return r0, r1
 }
 
+// GetWorkerPoolSummary provides a mock function with given fields: ctx
+func (_m *MockController) GetWorkerPoolSummary(ctx context.Context) (*internal.WorkerPool, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetWorkerPoolSummary")
+	}
+
+	var r0 *internal.WorkerPool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (*internal.WorkerPool, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) *internal.WorkerPool); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*internal.WorkerPool)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InstanceRefreshInProgress provides a mock function with given fields: ctx
+func (_m *MockController) InstanceRefreshInProgress(ctx context.Context) (bool, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InstanceRefreshInProgress")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (bool, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) bool); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // KillInstance provides a mock function with given fields: ctx, instanceID
 func (_m *MockController) KillInstance(ctx context.Context, instanceID string) error {
 	ret := _m.Called(ctx, instanceID)
@@ -155,6 +337,54 @@ func (_m *MockController) KillInstance(ctx context.Context, instanceID string) e
 	return r0
 }
 
+// LoadState provides a mock function with given fields: ctx
+func (_m *MockController) LoadState(ctx context.Context) (*internal.PersistedState, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LoadState")
+	}
+
+	var r0 *internal.PersistedState
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (*internal.PersistedState, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) *internal.PersistedState); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*internal.PersistedState)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveState provides a mock function with given fields: ctx, state
+func (_m *MockController) SaveState(ctx context.Context, state *internal.PersistedState) error {
+	ret := _m.Called(ctx, state)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveState")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *internal.PersistedState) error); ok {
+		r0 = rf(ctx, state)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ScaleUpASG provides a mock function with given fields: ctx, desiredCapacity
 func (_m *MockController) ScaleUpASG(ctx context.Context, desiredCapacity int32) error {
 	ret := _m.Called(ctx, desiredCapacity)
@@ -173,6 +403,88 @@ func (_m *MockController) ScaleUpASG(ctx context.Context, desiredCapacity int32)
 	return r0
 }
 
+// SetMinSize provides a mock function with given fields: ctx, minSize
+func (_m *MockController) SetMinSize(ctx context.Context, minSize int32) error {
+	ret := _m.Called(ctx, minSize)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetMinSize")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int32) error); ok {
+		r0 = rf(ctx, minSize)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StartInstanceRefresh provides a mock function with given fields: ctx
+func (_m *MockController) StartInstanceRefresh(ctx context.Context) (bool, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StartInstanceRefresh")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (bool, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) bool); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TagLaunchCohort provides a mock function with given fields: ctx, cohort
+func (_m *MockController) TagLaunchCohort(ctx context.Context, cohort string) error {
+	ret := _m.Called(ctx, cohort)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TagLaunchCohort")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, cohort)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UndrainWorker provides a mock function with given fields: ctx, workerID
+func (_m *MockController) UndrainWorker(ctx context.Context, workerID string) error {
+	ret := _m.Called(ctx, workerID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UndrainWorker")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, workerID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewMockController creates a new instance of MockController. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockController(t interface {
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Notifier is notified about the result of each autoscaler run.
+type Notifier interface {
+	Notify(ctx context.Context, result *RunResult) error
+}
+
+// WebhookNotifier posts the run result as JSON to an HTTP endpoint.
+type WebhookNotifier struct {
+	Client  *http.Client
+	URL     string
+	Events  []string
+	Timeout time.Duration
+}
+
+// NewWebhookNotifier creates a webhook notifier based on the runtime config.
+func NewWebhookNotifier(cfg *RuntimeConfig, client *http.Client) *WebhookNotifier {
+	return &WebhookNotifier{
+		Client:  client,
+		URL:     cfg.AutoscalingWebhookURL,
+		Events:  cfg.AutoscalingWebhookEvents,
+		Timeout: cfg.AutoscalingWebhookTimeout,
+	}
+}
+
+// Notify sends the run result to the webhook, unless the webhook is not
+// interested in the type of event that took place.
+func (n *WebhookNotifier) Notify(ctx context.Context, result *RunResult) error {
+	if len(n.Events) > 0 && !slices.Contains(n.Events, result.Event()) {
+		return nil
+	}
+
+	payload, err := json.Marshal(struct {
+		Event string `json:"event"`
+		*RunResult
+	}{result.Event(), result})
+	if err != nil {
+		return fmt.Errorf("could not serialize run result: %w", err)
+	}
+
+	if n.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("could not create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
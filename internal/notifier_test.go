@@ -0,0 +1,98 @@
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("WebhookNotifier", func() {
+		var server *httptest.Server
+		var status int
+		var requests int
+		var payload map[string]any
+
+		var result *internal.RunResult
+		var sut *internal.WebhookNotifier
+		var err error
+
+		g.BeforeEach(func() {
+			status, requests, payload = http.StatusOK, 0, nil
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				body, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &payload)
+
+				w.WriteHeader(status)
+			}))
+
+			result = internal.NewRunResult(internal.RuntimeConfig{
+				SpaceliftWorkerPoolID: "pool",
+				AutoscalingGroupARN:   "arn",
+			})
+			result.Decision = internal.Decision{
+				ScalingDirection: internal.ScalingDirectionDown,
+				ScalingSize:      1,
+				Comments:         []string{"removing idle workers"},
+			}
+			result.DrainedWorkers = []string{"worker"}
+			result.KilledInstances = []string{"instance"}
+
+			sut = internal.NewWebhookNotifier(&internal.RuntimeConfig{
+				AutoscalingWebhookURL:    server.URL,
+				AutoscalingWebhookEvents: []string{internal.RunEventScaleDown},
+			}, server.Client())
+		})
+
+		g.AfterEach(func() { server.Close() })
+
+		g.JustBeforeEach(func() { err = sut.Notify(context.Background(), result) })
+
+		g.Describe("when the event is of interest", func() {
+			g.It("should send the run result", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(requests).To(Equal(1))
+				Expect(payload["event"]).To(Equal("scale_down"))
+				Expect(payload["worker_pool_id"]).To(Equal("pool"))
+				Expect(payload["decision"]).To(Equal(map[string]any{
+					"direction": "down",
+					"size":      float64(1),
+					"comments":  []any{"removing idle workers"},
+				}))
+				Expect(payload["drained_workers"]).To(Equal([]any{"worker"}))
+				Expect(payload["killed_instances"]).To(Equal([]any{"instance"}))
+			})
+		})
+
+		g.Describe("when the event is not of interest", func() {
+			g.BeforeEach(func() { result.Decision.ScalingDirection = internal.ScalingDirectionNone })
+
+			g.It("should not send anything", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(requests).To(BeZero())
+			})
+		})
+
+		g.Describe("when the webhook fails", func() {
+			g.BeforeEach(func() { status = http.StatusInternalServerError })
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("webhook responded with status 500"))
+			})
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Error messages with which GraphQL servers reject a persisted query they
+// don't know, or persisted queries altogether.
+const (
+	persistedQueryNotFound     = "PersistedQueryNotFound"
+	persistedQueryNotSupported = "PersistedQueryNotSupported"
+)
+
+// PersistedQueryTransport sends GraphQL requests as automatic persisted
+// queries: only the hash of the query goes over the wire, and the full query
+// is sent when the server doesn't know the hash yet, so that it can cache it
+// for the following requests. If the server doesn't support persisted queries
+// at all, all the following requests are sent in full.
+type PersistedQueryTransport struct {
+	Base http.RoundTripper
+
+	unsupported atomic.Bool
+}
+
+type graphQLRequest struct {
+	Query      string          `json:"query,omitempty"`
+	Variables  json.RawMessage `json:"variables,omitempty"`
+	Extensions *struct {
+		PersistedQuery persistedQuery `json:"persistedQuery"`
+	} `json:"extensions,omitempty"`
+}
+
+type persistedQuery struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+func (t *PersistedQueryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || t.unsupported.Load() {
+		return t.base().RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read GraphQL request: %w", err)
+	}
+
+	var in graphQLRequest
+	if err := json.Unmarshal(body, &in); err != nil || in.Query == "" {
+		return t.base().RoundTrip(withBody(req, body))
+	}
+
+	hash := sha256.Sum256([]byte(in.Query))
+
+	in.Extensions = &struct {
+		PersistedQuery persistedQuery `json:"persistedQuery"`
+	}{PersistedQuery: persistedQuery{Version: 1, SHA256Hash: hex.EncodeToString(hash[:])}}
+
+	// The full query is sent along with the hash on a miss, so that the
+	// server can register it.
+	full, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode GraphQL request: %w", err)
+	}
+
+	in.Query = ""
+
+	hashed, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode GraphQL request: %w", err)
+	}
+
+	resp, err := t.base().RoundTrip(withBody(req, hashed))
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read GraphQL response: %w", err)
+	}
+
+	switch persistedQueryError(respBody) {
+	case persistedQueryNotSupported:
+		t.unsupported.Store(true)
+		return t.base().RoundTrip(withBody(req, body))
+	case persistedQueryNotFound:
+		return t.base().RoundTrip(withBody(req, full))
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	return resp, nil
+}
+
+func (t *PersistedQueryTransport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+// persistedQueryError returns the persisted query error reported in the
+// GraphQL response, if any.
+func persistedQueryError(body []byte) string {
+	var out struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(body, &out); err != nil {
+		return ""
+	}
+
+	for _, e := range out.Errors {
+		if e.Message == persistedQueryNotFound || e.Message == persistedQueryNotSupported {
+			return e.Message
+		}
+	}
+
+	return ""
+}
+
+// withBody clones the request with the given body.
+func withBody(req *http.Request, body []byte) *http.Request {
+	out := req.Clone(req.Context())
+
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return out
+}
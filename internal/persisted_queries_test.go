@@ -0,0 +1,121 @@
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/shurcooL/graphql"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+type persistedQueryServer struct {
+	mu        sync.Mutex
+	supported bool
+	known     map[string]bool
+	requests  []map[string]any
+}
+
+func (s *persistedQueryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Query      string `json:"query"`
+		Extensions struct {
+			PersistedQuery struct {
+				SHA256Hash string `json:"sha256Hash"`
+			} `json:"persistedQuery"`
+		} `json:"extensions"`
+	}
+
+	var raw map[string]any
+
+	body := json.NewDecoder(r.Body)
+	if err := body.Decode(&raw); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	encoded, _ := json.Marshal(raw)
+	_ = json.Unmarshal(encoded, &in)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, raw)
+	hash := in.Extensions.PersistedQuery.SHA256Hash
+
+	switch {
+	case hash != "" && !s.supported:
+		_, _ = w.Write([]byte(`{"errors": [{"message": "PersistedQueryNotSupported"}]}`))
+	case hash != "" && in.Query == "" && !s.known[hash]:
+		_, _ = w.Write([]byte(`{"errors": [{"message": "PersistedQueryNotFound"}]}`))
+	default:
+		if hash != "" {
+			s.known[hash] = true
+		}
+
+		_, _ = w.Write([]byte(`{"data": {"workerPool": {"pendingRuns": 3}}}`))
+	}
+}
+
+func TestPersistedQueryTransport(t *testing.T) {
+	query := func(t *testing.T, client *graphql.Client) int32 {
+		var out struct {
+			Pool struct {
+				PendingRuns int32 `graphql:"pendingRuns"`
+			} `graphql:"workerPool(id: $workerPool)"`
+		}
+
+		require.NoError(t, client.Query(context.Background(), &out, map[string]any{"workerPool": graphql.ID("pool")}))
+
+		return out.Pool.PendingRuns
+	}
+
+	setup := func(supported bool) (*persistedQueryServer, *graphql.Client) {
+		handler := &persistedQueryServer{supported: supported, known: map[string]bool{}}
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		transport := &internal.PersistedQueryTransport{Base: http.DefaultTransport}
+
+		return handler, graphql.NewClient(server.URL, &http.Client{Transport: transport})
+	}
+
+	t.Run("registers the query on a miss, and then only sends the hash", func(t *testing.T) {
+		server, client := setup(true)
+
+		require.Equal(t, int32(3), query(t, client))
+		require.Equal(t, int32(3), query(t, client))
+
+		require.Len(t, server.requests, 3)
+
+		// The first attempt only carries the hash, and the fallback carries
+		// the full query along with it.
+		require.NotContains(t, server.requests[0], "query")
+		require.Contains(t, server.requests[0], "extensions")
+		require.Contains(t, server.requests[1], "query")
+		require.Contains(t, server.requests[1], "extensions")
+		require.Equal(t, server.requests[0]["variables"], server.requests[1]["variables"])
+
+		// Once registered, the hash alone is a hit.
+		require.NotContains(t, server.requests[2], "query")
+	})
+
+	t.Run("falls back to full queries when persisted queries are not supported", func(t *testing.T) {
+		server, client := setup(false)
+
+		require.Equal(t, int32(3), query(t, client))
+		require.Equal(t, int32(3), query(t, client))
+
+		require.Len(t, server.requests, 3)
+		require.NotContains(t, server.requests[0], "query")
+		require.Contains(t, server.requests[1], "query")
+		require.NotContains(t, server.requests[1], "extensions")
+		require.Contains(t, server.requests[2], "query")
+		require.NotContains(t, server.requests[2], "extensions")
+	})
+}
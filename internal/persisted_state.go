@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"math"
+	"time"
+)
+
+// PersistedState is the state carried over between autoscaler invocations.
+// It's stored as a JSON document, so all of its fields should be optional.
+type PersistedState struct {
+	Peak  *PeakState  `json:"peak,omitempty"`
+	Panic *PanicState `json:"panic,omitempty"`
+
+	Throttle *ThrottleState `json:"throttle,omitempty"`
+
+	// PendingTermination lists the IDs of the workers drained by a two-phase
+	// scale-down, whose instances are to be terminated in the next invocation.
+	PendingTermination []string `json:"pending_termination,omitempty"`
+
+	// PoolCache is the last worker pool state successfully fetched from
+	// Spacelift, to fall back to when the query times out.
+	PoolCache *PoolCacheState `json:"pool_cache,omitempty"`
+
+	// LastBusy maps the IDs of the workers to the last time the autoscaler
+	// saw them busy, as a Unix timestamp.
+	LastBusy map[string]int64 `json:"last_busy,omitempty"`
+
+	// NoOp tracks the streak of identical decisions not to scale, so that
+	// they're not all logged.
+	NoOp *NoOpState `json:"no_op,omitempty"`
+
+	// LaunchCohort tracks the instances launched to cover the current
+	// deficit which failed to register as workers.
+	LaunchCohort *LaunchCohortState `json:"launch_cohort,omitempty"`
+
+	// BusySetpointIntegral is the accumulated distance of the desired
+	// capacity from the busy worker setpoint.
+	BusySetpointIntegral float64 `json:"busy_setpoint_integral,omitempty"`
+
+	// EmergencyFloor records the minimum size of the ASG from before the
+	// emergency floor lowered it, to be restored once it's over.
+	EmergencyFloor *EmergencyFloorState `json:"emergency_floor,omitempty"`
+}
+
+// EmergencyFloorState holds the minimum size the ASG had before the emergency
+// floor was applied.
+type EmergencyFloorState struct {
+	MinSize int32 `json:"min_size"`
+}
+
+// LaunchCohortState records when the autoscaler first scaled up to cover the
+// current deficit, and how many of the instances launched since were removed
+// as strays.
+type LaunchCohortState struct {
+	StartedAt int64 `json:"started_at"`
+	Failed    int   `json:"failed,omitempty"`
+}
+
+// NoOpState identifies the decision not to scale repeated in a streak, and
+// counts the repetitions since it was last logged.
+type NoOpState struct {
+	Key        string `json:"key"`
+	Suppressed int    `json:"suppressed,omitempty"`
+	LastLogged int64  `json:"last_logged"`
+}
+
+// PoolCacheState holds the counts from the worker pool which a scale-up needs,
+// and the time they were fetched. Only the counts are kept, so that the size
+// of the state doesn't grow with the pool.
+type PoolCacheState struct {
+	Workers     int  `json:"workers"`
+	IdleWorkers int  `json:"idle_workers"`
+	PendingRuns int  `json:"pending_runs"`
+	Suspended   bool `json:"suspended,omitempty"`
+	Disabled    bool `json:"disabled,omitempty"`
+
+	FetchedAt int64 `json:"fetched_at"`
+}
+
+// ThrottleState tracks the number of consecutive invocations which hit API
+// throttling, and the time until which we should back off because of that.
+type ThrottleState struct {
+	Invocations  int   `json:"invocations"`
+	BackoffUntil int64 `json:"backoff_until,omitempty"`
+}
+
+// PanicState records a panic scale-up, and the size of the pool before it.
+type PanicState struct {
+	Baseline   int   `json:"baseline"`
+	ObservedAt int64 `json:"observed_at"`
+}
+
+// PeakState tracks the recent peak number of workers in the pool.
+type PeakState struct {
+	Workers    int   `json:"workers"`
+	ObservedAt int64 `json:"observed_at"`
+}
+
+// ObservePeak records the current number of workers, replacing the recorded
+// peak if it's been matched or exceeded, or if it has fully decayed.
+func (s *PersistedState) ObservePeak(workers int, now time.Time, decay time.Duration) {
+	peak := s.Peak
+
+	if peak == nil || workers >= peak.Workers || now.Sub(time.Unix(peak.ObservedAt, 0)) >= decay {
+		s.Peak = &PeakState{Workers: workers, ObservedAt: now.Unix()}
+	}
+}
+
+// PeakFloor returns the number of workers we should not scale down below.
+// It starts at the recorded peak minus the margin, and decays linearly to
+// zero over the decay period.
+func (s *PersistedState) PeakFloor(now time.Time, margin int, decay time.Duration) int {
+	if s.Peak == nil || decay <= 0 {
+		return 0
+	}
+
+	floor := s.Peak.Workers - margin
+	if floor <= 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(time.Unix(s.Peak.ObservedAt, 0))
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	if elapsed >= decay {
+		return 0
+	}
+
+	remaining := float64(decay-elapsed) / float64(decay)
+
+	return int(math.Ceil(float64(floor) * remaining))
+}
+
+// ObserveThrottling records whether the current invocation hit API throttling.
+// Once it happens in the given number of consecutive invocations, we back off
+// for the given period. An invocation which is not throttled resets the count,
+// but does not cut the backoff short.
+func (s *PersistedState) ObserveThrottling(throttled bool, now time.Time, threshold int, backoff time.Duration) (backoffStarted bool) {
+	if !throttled {
+		if s.BackingOff(now) {
+			s.Throttle.Invocations = 0
+		} else {
+			s.Throttle = nil
+		}
+
+		return false
+	}
+
+	if s.Throttle == nil {
+		s.Throttle = &ThrottleState{}
+	}
+
+	s.Throttle.Invocations++
+
+	if s.Throttle.Invocations < threshold {
+		return false
+	}
+
+	s.Throttle.Invocations = 0
+	s.Throttle.BackoffUntil = now.Add(backoff).Unix()
+
+	return true
+}
+
+// PendingTerminationSet returns the IDs of the workers pending termination as
+// a set.
+func (s *PersistedState) PendingTerminationSet() map[string]struct{} {
+	if len(s.PendingTermination) == 0 {
+		return nil
+	}
+
+	out := make(map[string]struct{}, len(s.PendingTermination))
+	for _, workerID := range s.PendingTermination {
+		out[workerID] = struct{}{}
+	}
+
+	return out
+}
+
+// BackingOff checks whether we're backing off because of API throttling.
+func (s *PersistedState) BackingOff(now time.Time) bool {
+	return s.Throttle != nil && now.Unix() < s.Throttle.BackoffUntil
+}
+
+// CachePool records the counts from the worker pool fetched from Spacelift.
+// The pending runs are recorded the way the configuration counts them, but
+// without the external queue, whose depth is fetched separately.
+func (s *PersistedState) CachePool(cfg RuntimeConfig, pool *WorkerPool, now time.Time) {
+	state := &State{WorkerPool: pool, HeartbeatStaleness: cfg.AutoscalingHeartbeatStaleness}
+
+	s.PoolCache = &PoolCacheState{
+		Workers:     len(pool.Workers),
+		IdleWorkers: len(state.IdleWorkers()),
+		PendingRuns: state.PendingRuns(cfg),
+		Suspended:   pool.Suspended,
+		Disabled:    pool.AutoscalingDisabled(),
+		FetchedAt:   now.Unix(),
+	}
+}
+
+// CachedPool returns a worker pool rebuilt from the cached counts along with
+// its age, as long as it's not older than the given staleness. The workers of
+// the rebuilt pool only have their busy status set. The pending runs are
+// already counted the way the configuration asks for, so they must be used as
+// they are, see cachedPoolConfig.
+func (s *PersistedState) CachedPool(now time.Time, staleness time.Duration) (pool *WorkerPool, age time.Duration, ok bool) {
+	if s.PoolCache == nil {
+		return nil, 0, false
+	}
+
+	age = now.Sub(time.Unix(s.PoolCache.FetchedAt, 0))
+	if age < 0 || age > staleness {
+		return nil, age, false
+	}
+
+	cache := s.PoolCache
+
+	pool = &WorkerPool{
+		PendingRuns: int32(cache.PendingRuns),
+		Suspended:   cache.Suspended,
+		Workers:     make([]Worker, cache.Workers),
+	}
+
+	for i := range pool.Workers {
+		pool.Workers[i].Busy = i >= cache.IdleWorkers
+	}
+
+	if cache.Disabled {
+		pool.Labels = []string{DisabledLabel}
+	}
+
+	return pool, age, true
+}
+
+// cachedPoolConfig returns the configuration to decide on a pool returned by
+// CachedPool with. Its pending runs have already been filtered, so they're
+// counted as they are.
+func cachedPoolConfig(cfg RuntimeConfig) RuntimeConfig {
+	cfg.AutoscalingRunLabelExpression = ""
+	cfg.runLabelExpression = nil
+	cfg.AutoscalingIncludePausedRuns = true
+
+	return cfg
+}
+
+// ObserveBusyWorkers records the current time for each of the busy workers,
+// and forgets the workers which are no longer in the pool, as well as those
+// which have been idle for longer than the delay, so that only the workers
+// still in their cooldown take space in the state.
+func (s *PersistedState) ObserveBusyWorkers(workers []Worker, now time.Time, delay time.Duration) {
+	lastBusy := make(map[string]int64, len(workers))
+
+	for _, worker := range workers {
+		if worker.Busy {
+			lastBusy[worker.ID] = now.Unix()
+		} else if at, ok := s.LastBusy[worker.ID]; ok && now.Sub(time.Unix(at, 0)) < delay {
+			lastBusy[worker.ID] = at
+		}
+	}
+
+	if len(lastBusy) == 0 {
+		lastBusy = nil
+	}
+
+	s.LastBusy = lastBusy
+}
+
+// ObserveNoOp records a decision not to scale, identified by the key, and
+// returns whether it should be logged. The first decision of a streak is
+// always logged, and the repetitions only once per interval, along with the
+// number of those suppressed since.
+func (s *PersistedState) ObserveNoOp(key string, now time.Time, interval time.Duration) (log bool, suppressed int) {
+	if s.NoOp == nil || s.NoOp.Key != key || now.Sub(time.Unix(s.NoOp.LastLogged, 0)) >= interval {
+		if s.NoOp != nil && s.NoOp.Key == key {
+			suppressed = s.NoOp.Suppressed
+		}
+
+		s.NoOp = &NoOpState{Key: key, LastLogged: now.Unix()}
+
+		return true, suppressed
+	}
+
+	s.NoOp.Suppressed++
+
+	return false, 0
+}
+
+// ObserveLaunch records a scale-up, starting a new launch cohort unless one is
+// already in progress for the current deficit.
+func (s *PersistedState) ObserveLaunch(now time.Time) {
+	if s.LaunchCohort == nil {
+		s.LaunchCohort = &LaunchCohortState{StartedAt: now.Unix()}
+	}
+}
+
+// ObserveFailedLaunch records the removal of a stray instance launched at the
+// given time, and returns whether it counts against the current cohort. Only
+// the instances launched since the cohort started do.
+func (s *PersistedState) ObserveFailedLaunch(launchedAt time.Time) bool {
+	if s.LaunchCohort == nil || launchedAt.Before(time.Unix(s.LaunchCohort.StartedAt, 0)) {
+		return false
+	}
+
+	s.LaunchCohort.Failed++
+
+	return true
+}
+
+// LaunchRetryBudgetExhausted checks whether the current cohort has used up the
+// given budget of failed launches.
+func (s *PersistedState) LaunchRetryBudgetExhausted(budget int) bool {
+	return budget > 0 && s.LaunchCohort != nil && s.LaunchCohort.Failed >= budget
+}
+
+// ObserveBusySetpointError adds the current distance from the busy worker
+// setpoint to the integral. The integral is bounded, so that a long stretch at
+// the limits of the pool doesn't wind it up past what it takes to recover.
+func (s *PersistedState) ObserveBusySetpointError(distance, bound float64) {
+	s.BusySetpointIntegral = math.Max(-bound, math.Min(bound, s.BusySetpointIntegral+distance))
+}
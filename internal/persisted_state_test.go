@@ -0,0 +1,251 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestPersistedState(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("PersistedState", func() {
+		const decay = time.Hour
+		const margin = 2
+
+		var now time.Time
+		var sut *internal.PersistedState
+
+		g.BeforeEach(func() {
+			now = time.Unix(1700000000, 0)
+			sut = &internal.PersistedState{}
+		})
+
+		g.Describe("with no peak recorded", func() {
+			g.It("should have no floor", func() {
+				Expect(sut.PeakFloor(now, margin, decay)).To(Equal(0))
+			})
+		})
+
+		g.Describe("with a peak followed by a lull", func() {
+			g.BeforeEach(func() {
+				sut.ObservePeak(12, now, decay)
+				sut.ObservePeak(4, now.Add(10*time.Minute), decay)
+			})
+
+			g.It("should keep the peak", func() {
+				Expect(sut.Peak).To(Equal(&internal.PeakState{Workers: 12, ObservedAt: now.Unix()}))
+			})
+
+			g.It("should set the floor at the peak minus the margin", func() {
+				Expect(sut.PeakFloor(now, margin, decay)).To(Equal(10))
+			})
+
+			g.It("should lower the floor as the lull persists", func() {
+				Expect(sut.PeakFloor(now.Add(30*time.Minute), margin, decay)).To(Equal(5))
+				Expect(sut.PeakFloor(now.Add(54*time.Minute), margin, decay)).To(Equal(1))
+				Expect(sut.PeakFloor(now.Add(decay), margin, decay)).To(Equal(0))
+			})
+
+			g.It("should replace the peak once it's fully decayed", func() {
+				sut.ObservePeak(4, now.Add(decay), decay)
+				Expect(sut.Peak).To(Equal(&internal.PeakState{Workers: 4, ObservedAt: now.Add(decay).Unix()}))
+			})
+
+			g.It("should replace the peak when it's exceeded", func() {
+				sut.ObservePeak(13, now.Add(20*time.Minute), decay)
+				Expect(sut.Peak.Workers).To(Equal(13))
+				Expect(sut.PeakFloor(now.Add(20*time.Minute), margin, decay)).To(Equal(11))
+			})
+		})
+
+		g.Describe("with a peak smaller than the margin", func() {
+			g.BeforeEach(func() { sut.ObservePeak(1, now, decay) })
+
+			g.It("should have no floor", func() {
+				Expect(sut.PeakFloor(now, margin, decay)).To(Equal(0))
+			})
+		})
+
+		g.Describe("ObserveThrottling", func() {
+			const backoff = 15 * time.Minute
+			const threshold = 2
+
+			g.Describe("after a single throttled invocation", func() {
+				var started bool
+
+				g.BeforeEach(func() { started = sut.ObserveThrottling(true, now, threshold, backoff) })
+
+				g.It("should not back off yet", func() {
+					Expect(started).To(BeFalse())
+					Expect(sut.BackingOff(now)).To(BeFalse())
+				})
+
+				g.It("should forget about it after an invocation which is not throttled", func() {
+					sut.ObserveThrottling(false, now.Add(time.Minute), threshold, backoff)
+					Expect(sut.Throttle).To(BeNil())
+				})
+			})
+
+			g.Describe("after consecutive throttled invocations", func() {
+				var started bool
+
+				g.BeforeEach(func() {
+					sut.ObserveThrottling(true, now, threshold, backoff)
+					started = sut.ObserveThrottling(true, now.Add(time.Minute), threshold, backoff)
+				})
+
+				g.It("should back off", func() {
+					Expect(started).To(BeTrue())
+					Expect(sut.BackingOff(now.Add(time.Minute))).To(BeTrue())
+				})
+
+				g.It("should keep backing off after an invocation which is not throttled", func() {
+					sut.ObserveThrottling(false, now.Add(2*time.Minute), threshold, backoff)
+					Expect(sut.BackingOff(now.Add(2 * time.Minute))).To(BeTrue())
+				})
+
+				g.It("should stop backing off once the backoff is over", func() {
+					Expect(sut.BackingOff(now.Add(time.Minute + backoff))).To(BeFalse())
+
+					sut.ObserveThrottling(false, now.Add(time.Minute+backoff), threshold, backoff)
+					Expect(sut.Throttle).To(BeNil())
+				})
+			})
+		})
+
+		g.Describe("PendingTerminationSet", func() {
+			g.It("should be empty with no workers pending termination", func() {
+				Expect(sut.PendingTerminationSet()).To(BeEmpty())
+			})
+
+			g.It("should contain the workers pending termination", func() {
+				sut.PendingTermination = []string{"1", "2"}
+
+				Expect(sut.PendingTerminationSet()).To(Equal(map[string]struct{}{"1": {}, "2": {}}))
+			})
+		})
+
+		g.Describe("ObserveBusyWorkers", func() {
+			g.BeforeEach(func() {
+				sut.LastBusy = map[string]int64{
+					"idle":    now.Add(-5 * time.Minute).Unix(),
+					"expired": now.Add(-10 * time.Minute).Unix(),
+					"gone":    200,
+				}
+
+				sut.ObserveBusyWorkers([]internal.Worker{
+					{ID: "busy", Busy: true},
+					{ID: "idle"},
+					{ID: "expired"},
+					{ID: "new"},
+				}, now, 10*time.Minute)
+			})
+
+			g.It("should record the busy workers and forget the ones no longer in the pool", func() {
+				Expect(sut.LastBusy).To(Equal(map[string]int64{
+					"busy": now.Unix(),
+					"idle": now.Add(-5 * time.Minute).Unix(),
+				}))
+			})
+		})
+
+		g.Describe("ObserveNoOp", func() {
+			const interval = 10 * time.Minute
+
+			// Once a minute for an hour, with the same decision.
+			observe := func(key string, from time.Time, times int) (logged []int) {
+				for i := 0; i < times; i++ {
+					if log, suppressed := sut.ObserveNoOp(key, from.Add(time.Duration(i)*time.Minute), interval); log {
+						logged = append(logged, suppressed)
+					}
+				}
+
+				return logged
+			}
+
+			g.It("should log repeated decisions once per interval", func() {
+				Expect(observe("steady", now, 60)).To(Equal([]int{0, 9, 9, 9, 9, 9}))
+			})
+
+			g.It("should log a different decision right away", func() {
+				Expect(observe("steady", now, 3)).To(Equal([]int{0}))
+				Expect(observe("mismatch", now.Add(3*time.Minute), 1)).To(Equal([]int{0}))
+				Expect(observe("steady", now.Add(4*time.Minute), 1)).To(Equal([]int{0}))
+			})
+		})
+
+		g.Describe("ObserveBusySetpointError", func() {
+			g.It("should accumulate the distance", func() {
+				sut.ObserveBusySetpointError(3, 10)
+				sut.ObserveBusySetpointError(-1, 10)
+
+				Expect(sut.BusySetpointIntegral).To(Equal(2.0))
+			})
+
+			g.It("should stay within the bound", func() {
+				for i := 0; i < 10; i++ {
+					sut.ObserveBusySetpointError(4, 10)
+				}
+				Expect(sut.BusySetpointIntegral).To(Equal(10.0))
+
+				sut.ObserveBusySetpointError(-30, 10)
+				Expect(sut.BusySetpointIntegral).To(Equal(-10.0))
+			})
+		})
+
+		g.Describe("CachedPool", func() {
+			const staleness = 10 * time.Minute
+
+			g.It("should be missing with no pool cached", func() {
+				_, _, ok := sut.CachedPool(now, staleness)
+				Expect(ok).To(BeFalse())
+			})
+
+			g.Describe("with a pool cached", func() {
+				g.BeforeEach(func() {
+					sut.CachePool(internal.RuntimeConfig{}, &internal.WorkerPool{
+						PendingRuns: 4,
+						PausedRuns:  1,
+						Workers: []internal.Worker{
+							{ID: "1", Busy: true, Metadata: "{}", LastSeenAt: 100},
+							{ID: "2", Metadata: "{}"},
+							{ID: "3", Drained: true, Metadata: "{}"},
+						},
+					}, now)
+				})
+
+				g.It("should only keep the counts", func() {
+					Expect(sut.PoolCache).To(Equal(&internal.PoolCacheState{
+						Workers:     3,
+						IdleWorkers: 1,
+						PendingRuns: 3,
+						FetchedAt:   now.Unix(),
+					}))
+				})
+
+				g.It("should return the rebuilt pool within the staleness bound", func() {
+					pool, age, ok := sut.CachedPool(now.Add(staleness), staleness)
+
+					Expect(ok).To(BeTrue())
+					Expect(age).To(Equal(staleness))
+					Expect(pool.PendingRuns).To(Equal(int32(3)))
+					Expect(pool.Workers).To(Equal([]internal.Worker{{}, {Busy: true}, {Busy: true}}))
+					Expect(pool.AutoscalingDisabled()).To(BeFalse())
+				})
+
+				g.It("should be missing past the staleness bound", func() {
+					_, age, ok := sut.CachedPool(now.Add(staleness+time.Second), staleness)
+
+					Expect(ok).To(BeFalse())
+					Expect(age).To(Equal(staleness + time.Second))
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,20 @@
+package internal
+
+// MergeWorkerPools combines the pools sharing a single ASG into one, so that a
+// single scaling decision can be made for the shared capacity. The workers and
+// runs are summed up, while the settings (labels, suspension) are those of the
+// primary pool.
+func MergeWorkerPools(primary *WorkerPool, shared ...*WorkerPool) *WorkerPool {
+	out := *primary
+	out.Workers = append([]Worker(nil), primary.Workers...)
+	out.Runs = append([]PendingRun(nil), primary.Runs...)
+
+	for _, pool := range shared {
+		out.PendingRuns += pool.PendingRuns
+		out.PausedRuns += pool.PausedRuns
+		out.Runs = append(out.Runs, pool.Runs...)
+		out.Workers = append(out.Workers, pool.Workers...)
+	}
+
+	return &out
+}
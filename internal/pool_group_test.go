@@ -0,0 +1,57 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestPoolGroup(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("PoolGroup", func() {
+		g.Describe("MergeWorkerPools", func() {
+			var primary, shared *internal.WorkerPool
+
+			g.BeforeEach(func() {
+				primary = &internal.WorkerPool{
+					Labels:      []string{"primary"},
+					PendingRuns: 2,
+					PausedRuns:  1,
+					Runs:        []internal.PendingRun{{ID: "run-1"}},
+					Workers:     []internal.Worker{{ID: "1"}},
+				}
+				shared = &internal.WorkerPool{
+					Labels:      []string{internal.DisabledLabel},
+					PendingRuns: 3,
+					Runs:        []internal.PendingRun{{ID: "run-2"}},
+					Suspended:   true,
+					Workers:     []internal.Worker{{ID: "2"}, {ID: "3"}},
+				}
+			})
+
+			g.It("should sum up the runs and workers, keeping the primary pool settings", func() {
+				merged := internal.MergeWorkerPools(primary, shared)
+
+				Expect(merged.Labels).To(Equal([]string{"primary"}))
+				Expect(merged.Suspended).To(BeFalse())
+				Expect(merged.PendingRuns).To(Equal(int32(5)))
+				Expect(merged.PausedRuns).To(Equal(int32(1)))
+				Expect(merged.Runs).To(Equal([]internal.PendingRun{{ID: "run-1"}, {ID: "run-2"}}))
+				Expect(merged.Workers).To(Equal([]internal.Worker{{ID: "1"}, {ID: "2"}, {ID: "3"}}))
+			})
+
+			g.It("should not modify the primary pool", func() {
+				internal.MergeWorkerPools(primary, shared)
+
+				Expect(primary.PendingRuns).To(Equal(int32(2)))
+				Expect(primary.Workers).To(HaveLen(1))
+				Expect(primary.Runs).To(HaveLen(1))
+			})
+		})
+	})
+}
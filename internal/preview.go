@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Preview is a read-only snapshot of the worker pool and the ASG, along with
+// the decision the autoscaler would make about them.
+type Preview struct {
+	Decision        Decision        `json:"decision"`
+	EffectiveConfig EffectiveConfig `json:"effective_config"`
+
+	Workers         int      `json:"workers"`
+	IdleWorkers     int      `json:"idle_workers"`
+	PendingRuns     int      `json:"pending_runs"`
+	Instances       int      `json:"instances"`
+	DesiredCapacity int      `json:"desired_capacity"`
+	StrayInstances  []string `json:"stray_instances,omitempty"`
+}
+
+// Preview computes what a regular run would decide, without acting on it. The
+// decision goes through the same steps as in a regular run, with the calls
+// which would change anything stubbed out, so it's safe to call at any time,
+// eg. from a dashboard. The persisted state is read, but never saved. Unlike a
+// regular run, it doesn't clean up stray instances or finish two-phase
+// scale-downs, so their effect on the decision is not reflected.
+func (s AutoScaler) Preview(ctx context.Context, cfg RuntimeConfig) (*Preview, error) {
+	logger := s.logger.With(
+		"asg_arn", cfg.AutoscalingGroupARN,
+		"worker_pool_id", cfg.SpaceliftWorkerPoolID,
+	)
+
+	persisted := &PersistedState{}
+
+	if cfg.AutoscalingStateParameter != "" {
+		var err error
+
+		if persisted, err = s.controller.LoadState(ctx); err != nil {
+			return nil, fmt.Errorf("could not load state: %w", err)
+		}
+	}
+
+	workerPool, err := s.controller.GetWorkerPool(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get worker pool: %w", err)
+	}
+
+	asg, err := s.controller.GetAutoscalingGroup(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get autoscaling group: %w", err)
+	}
+
+	state, err := NewState(workerPool, asg, cfg.MetadataKeys())
+	if err != nil {
+		return nil, fmt.Errorf("could not create state: %w", err)
+	}
+
+	now := time.Now()
+
+	state.HeartbeatStaleness = cfg.AutoscalingHeartbeatStaleness
+	state.Bounds = ActiveBounds(cfg, now)
+
+	if workerPool.AutoscalingDisabled() {
+		decision := Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         []string{"autoscaling disabled by the worker pool label"},
+			SuppressedBy:     []string{GateDisabled},
+		}
+
+		return newPreview(cfg, state, decision, state.EffectiveConfig(cfg, now, s.outsideBusinessHours(cfg, now))), nil
+	}
+
+	if cfg.AutoscalingScaleDownDelayAnchor == ScaleDownDelayAnchorIdle {
+		state.LastBusy = persisted.LastBusy
+	}
+
+	dryRun := s
+	dryRun.controller = dryRunController{ControllerInterface: s.controller}
+
+	dryRun.addHints(ctx, cfg, logger, state)
+
+	if err := dryRun.applyEmergencyFloor(ctx, cfg, logger, persisted, state, now); err != nil {
+		return nil, err
+	}
+
+	if cfg.AutoscalingPanicThreshold > 0 && persisted.Panic != nil && len(workerPool.Workers) > persisted.Panic.Baseline {
+		state.ReclaimNewestFirst = true
+	}
+
+	if cfg.AutoscalingSafeModeDecay > 0 {
+		persisted.ObservePeak(len(workerPool.Workers), now, cfg.AutoscalingSafeModeDecay)
+	}
+
+	decision, effective, err := dryRun.decide(ctx, cfg, logger, persisted, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPreview(cfg, state, decision, effective), nil
+}
+
+// dryRunController stubs out the calls of the controller which would change
+// anything, so that a preview can go through the same steps as a regular run.
+type dryRunController struct {
+	ControllerInterface
+}
+
+func (dryRunController) DrainWorker(context.Context, string) (bool, error)  { return false, nil }
+func (dryRunController) ForceDrainWorker(context.Context, string) error     { return nil }
+func (dryRunController) UndrainWorker(context.Context, string) error        { return nil }
+func (dryRunController) KillInstance(context.Context, string) error         { return nil }
+func (dryRunController) ScaleUpASG(context.Context, int32) error            { return nil }
+func (dryRunController) SetMinSize(context.Context, int32) error            { return nil }
+func (dryRunController) StartInstanceRefresh(context.Context) (bool, error) { return false, nil }
+func (dryRunController) TagLaunchCohort(context.Context, string) error      { return nil }
+func (dryRunController) SaveState(context.Context, *PersistedState) error   { return nil }
+
+// newPreview takes a snapshot of the state along with the decision made.
+func newPreview(cfg RuntimeConfig, state *State, decision Decision, effective EffectiveConfig) *Preview {
+	return &Preview{
+		Decision:        decision,
+		EffectiveConfig: effective,
+		Workers:         len(state.WorkerPool.Workers),
+		IdleWorkers:     len(state.IdleWorkers()),
+		PendingRuns:     state.PendingRuns(cfg),
+		Instances:       len(state.ASG.Instances),
+		DesiredCapacity: int(*state.ASG.DesiredCapacity),
+		StrayInstances:  state.StrayInstances(),
+	}
+}
@@ -0,0 +1,168 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestAutoScalerPreview(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{
+				ID:       "1",
+				Busy:     true,
+				Metadata: `{"asg_id": "group", "instance_id": "instance"}`,
+			},
+		},
+		PendingRuns: 2,
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(1)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance")},
+		},
+	}, nil)
+
+	preview, err := scaler.Preview(context.Background(), internal.RuntimeConfig{AutoscalingMaxCreate: 5})
+	require.NoError(t, err)
+
+	require.Equal(t, internal.ScalingDirectionUp, preview.Decision.ScalingDirection)
+	require.Equal(t, 2, preview.Decision.ScalingSize)
+	require.Equal(t, 1, preview.Workers)
+	require.Equal(t, 0, preview.IdleWorkers)
+	require.Equal(t, 2, preview.PendingRuns)
+	require.Equal(t, 1, preview.Instances)
+	require.Equal(t, 1, preview.DesiredCapacity)
+	require.Empty(t, preview.StrayInstances)
+
+	ctrl.AssertNotCalled(t, "ScaleUpASG", mock.Anything, mock.Anything)
+	ctrl.AssertNotCalled(t, "DrainWorker", mock.Anything, mock.Anything)
+	ctrl.AssertNotCalled(t, "KillInstance", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerPreviewRunsTheDecisionPipeline(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, nil)
+
+	ctrl := new(MockController)
+	defer ctrl.AssertExpectations(t)
+
+	scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+	ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+			{ID: "2", Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+		},
+	}, nil)
+	ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+		AutoScalingGroupName: ptr("group"),
+		MinSize:              ptr(int32(2)),
+		MaxSize:              ptr(int32(5)),
+		DesiredCapacity:      ptr(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: ptr("instance-1")},
+			{InstanceId: ptr("instance-2")},
+		},
+	}, nil)
+
+	// The emergency floor lets the idle workers go below the minimum size,
+	// and the hook then lowers the size of the scale-down.
+	preview, err := scaler.Preview(context.Background(), internal.RuntimeConfig{
+		AutoscalingMaxKill:             5,
+		AutoscalingEmergencyFloor:      ptr(0),
+		AutoscalingEmergencyFloorUntil: time.Now().Add(time.Hour),
+		AutoscalingDecisionHook:        writeHook(t, `cat > /dev/null; echo '{"action": "modify", "size": 1}'`),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, internal.ScalingDirectionDown, preview.Decision.ScalingDirection)
+	require.Equal(t, 1, preview.Decision.ScalingSize)
+	require.Equal(t, 0, preview.EffectiveConfig.MinSize)
+
+	ctrl.AssertNotCalled(t, "SetMinSize", mock.Anything, mock.Anything)
+	ctrl.AssertNotCalled(t, "SaveState", mock.Anything, mock.Anything)
+}
+
+func TestAutoScalerPreviewGates(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		cfg       internal.RuntimeConfig
+		persisted *internal.PersistedState
+		gate      string
+	}{
+		{
+			name: "soft drain",
+			cfg:  internal.RuntimeConfig{AutoscalingMaxCreate: 5, AutoscalingSoftDrain: true},
+			gate: internal.GateSoftDrain,
+		},
+		{
+			name: "launch retry budget",
+			cfg: internal.RuntimeConfig{
+				AutoscalingMaxCreate:         5,
+				AutoscalingLaunchRetryBudget: 1,
+				AutoscalingStateParameter:    "state",
+			},
+			persisted: &internal.PersistedState{
+				LaunchCohort: &internal.LaunchCohortState{StartedAt: time.Now().Add(-time.Hour).Unix(), Failed: 1},
+			},
+			gate: internal.GateLaunchRetryBudget,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, nil)
+
+			ctrl := new(MockController)
+			defer ctrl.AssertExpectations(t)
+
+			scaler := internal.NewAutoScaler(ctrl, slog.New(h))
+
+			if tt.persisted != nil {
+				ctrl.On("LoadState", mock.Anything).Return(tt.persisted, nil)
+			}
+
+			ctrl.On("GetWorkerPool", mock.Anything).Return(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance"}`},
+				},
+				PendingRuns: 2,
+			}, nil)
+			ctrl.On("GetAutoscalingGroup", mock.Anything).Return(&types.AutoScalingGroup{
+				AutoScalingGroupName: ptr("group"),
+				MinSize:              ptr(int32(0)),
+				MaxSize:              ptr(int32(5)),
+				DesiredCapacity:      ptr(int32(1)),
+				Instances: []types.Instance{
+					{InstanceId: ptr("instance")},
+				},
+			}, nil)
+
+			preview, err := scaler.Preview(context.Background(), tt.cfg)
+			require.NoError(t, err)
+
+			require.Equal(t, internal.ScalingDirectionNone, preview.Decision.ScalingDirection)
+			require.Equal(t, []string{tt.gate}, preview.Decision.SuppressedBy)
+		})
+	}
+}
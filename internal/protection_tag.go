@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ProtectionTag is the EC2 tag marking the instances the autoscaler must never
+// terminate, whichever the reason.
+type ProtectionTag struct {
+	Key string
+
+	// Value is the value the tag needs to have. Empty means any value.
+	Value string
+}
+
+// ParseProtectionTag parses a tag given as key=value, or just the key to
+// protect instances carrying it with any value.
+func ParseProtectionTag(in string) (ProtectionTag, error) {
+	key, value, _ := strings.Cut(in, "=")
+	if key == "" {
+		return ProtectionTag{}, fmt.Errorf("empty tag key in %q", in)
+	}
+
+	return ProtectionTag{Key: key, Value: value}, nil
+}
+
+// Protects checks whether an instance with the given tags is protected.
+func (t ProtectionTag) Protects(tags []ec2types.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == nil || *tag.Key != t.Key {
+			continue
+		}
+
+		if t.Value == "" || (tag.Value != nil && *tag.Value == t.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t ProtectionTag) String() string {
+	if t.Value == "" {
+		return t.Key
+	}
+
+	return t.Key + "=" + t.Value
+}
+
+// ProtectionTag returns the configured protection tag, if any.
+func (c RuntimeConfig) ProtectionTag() (ProtectionTag, bool) {
+	if c.AutoscalingProtectionTag == "" {
+		return ProtectionTag{}, false
+	}
+
+	tag, err := ParseProtectionTag(c.AutoscalingProtectionTag)
+
+	return tag, err == nil
+}
@@ -0,0 +1,46 @@
+package internal_test
+
+import (
+	"testing"
+
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestParseProtectionTag(t *testing.T) {
+	tag, err := internal.ParseProtectionTag("spacelift:no-terminate=true")
+	require.NoError(t, err)
+	require.Equal(t, internal.ProtectionTag{Key: "spacelift:no-terminate", Value: "true"}, tag)
+
+	tag, err = internal.ParseProtectionTag("spacelift:no-terminate")
+	require.NoError(t, err)
+	require.Equal(t, internal.ProtectionTag{Key: "spacelift:no-terminate"}, tag)
+
+	_, err = internal.ParseProtectionTag("=true")
+	require.EqualError(t, err, `empty tag key in "=true"`)
+}
+
+func TestProtectionTagProtects(t *testing.T) {
+	tags := func(key, value string) []ec2types.Tag {
+		return []ec2types.Tag{{Key: ptr("Name"), Value: ptr("worker")}, {Key: ptr(key), Value: ptr(value)}}
+	}
+
+	for _, tt := range []struct {
+		name      string
+		tag       internal.ProtectionTag
+		tags      []ec2types.Tag
+		protected bool
+	}{
+		{"matching key and value", internal.ProtectionTag{Key: "keep", Value: "true"}, tags("keep", "true"), true},
+		{"matching key, other value", internal.ProtectionTag{Key: "keep", Value: "true"}, tags("keep", "false"), false},
+		{"key only", internal.ProtectionTag{Key: "keep"}, tags("keep", "anything"), true},
+		{"other key", internal.ProtectionTag{Key: "keep"}, tags("other", "true"), false},
+		{"no tags", internal.ProtectionTag{Key: "keep"}, nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.protected, tt.tag.Protects(tt.tags))
+		})
+	}
+}
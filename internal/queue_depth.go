@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+// QueueDepthSource reports the depth of an external queue feeding runs into
+// the worker pool, so that we can scale up before the runs even register as
+// pending in Spacelift.
+type QueueDepthSource interface {
+	QueueDepth(ctx context.Context) (depth int, err error)
+}
+
+// SQSQueueDepthSource reports the approximate number of messages in an SQS
+// queue.
+type SQSQueueDepthSource struct {
+	Client   ifaces.SQS
+	QueueURL string
+
+	// Timeout for the API call. Zero means no timeout.
+	Timeout time.Duration
+
+	// OnAPICall, if set, is called before each API call, so that it counts
+	// towards the calls made by the controller.
+	OnAPICall func()
+}
+
+// NewSQSQueueDepthSource creates a new SQS queue depth source for the queue
+// configured in the runtime configuration, sharing the AWS configuration and
+// the API call count of the controller.
+func NewSQSQueueDepthSource(cfg *RuntimeConfig, controller *Controller) *SQSQueueDepthSource {
+	return &SQSQueueDepthSource{
+		Client:    sqs.NewFromConfig(controller.AWSConfig),
+		QueueURL:  cfg.AutoscalingQueueURL,
+		Timeout:   cfg.CloudAPITimeout,
+		OnAPICall: controller.recordAPICall,
+	}
+}
+
+// QueueDepth returns the approximate number of messages in the queue.
+func (s *SQSQueueDepthSource) QueueDepth(ctx context.Context) (depth int, err error) {
+	xray.Capture(ctx, "aws.sqs.depth", func(ctx context.Context) error {
+		var output *sqs.GetQueueAttributesOutput
+
+		if s.OnAPICall != nil {
+			s.OnAPICall()
+		}
+
+		callCtx, cancel := withCallTimeout(ctx, s.Timeout)
+		output, err = s.Client.GetQueueAttributes(callCtx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(s.QueueURL),
+			AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameApproximateNumberOfMessages},
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not get queue attributes: %w", err)
+			return err
+		}
+
+		value, ok := output.Attributes[string(sqstypes.QueueAttributeNameApproximateNumberOfMessages)]
+		if !ok {
+			err = fmt.Errorf("queue attributes do not include the number of messages")
+			return err
+		}
+
+		if depth, err = strconv.Atoi(value); err != nil {
+			err = fmt.Errorf("could not parse the number of messages: %w", err)
+			return err
+		}
+
+		xray.AddMetadata(ctx, "queue_depth", depth)
+
+		return nil
+	})
+
+	return
+}
@@ -0,0 +1,117 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+func TestSQSQueueDepthSource(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("SQSQueueDepthSource", func() {
+		const queueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/runs"
+
+		var depth int
+		var err error
+
+		var mockSQS *ifaces.MockSQS
+		var apiCall *mock.Call
+		var input *sqs.GetQueueAttributesInput
+
+		var sut *internal.SQSQueueDepthSource
+
+		g.BeforeEach(func() {
+			input = nil
+
+			mockSQS = &ifaces.MockSQS{}
+			apiCall = mockSQS.On(
+				"GetQueueAttributes",
+				mock.Anything,
+				mock.MatchedBy(func(in *sqs.GetQueueAttributesInput) bool {
+					input = in
+					return true
+				}),
+				mock.Anything,
+			)
+
+			sut = &internal.SQSQueueDepthSource{Client: mockSQS, QueueURL: queueURL}
+		})
+
+		g.JustBeforeEach(func() { depth, err = sut.QueueDepth(context.Background()) })
+
+		g.Describe("when the API call hangs", func() {
+			g.BeforeEach(func() {
+				sut.Timeout = 10 * time.Millisecond
+
+				apiCall.Return(func(ctx context.Context, _ *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				})
+			})
+
+			g.It("should time out the call", func() {
+				Expect(depth).To(BeZero())
+				Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			})
+		})
+
+		g.Describe("when the API call fails", func() {
+			g.BeforeEach(func() { apiCall.Return(nil, errors.New("bacon")) })
+
+			g.It("sends the correct input", func() {
+				Expect(input).NotTo(BeNil())
+				Expect(*input.QueueUrl).To(Equal(queueURL))
+			})
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("could not get queue attributes: bacon"))
+			})
+		})
+
+		g.Describe("with an API call hook", func() {
+			var calls int
+
+			g.BeforeEach(func() {
+				calls = 0
+				sut.OnAPICall = func() { calls++ }
+				apiCall.Return(&sqs.GetQueueAttributesOutput{}, nil)
+			})
+
+			g.It("should report the API call", func() {
+				Expect(calls).To(Equal(1))
+			})
+		})
+
+		g.Describe("when the number of messages is missing", func() {
+			g.BeforeEach(func() { apiCall.Return(&sqs.GetQueueAttributesOutput{}, nil) })
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("queue attributes do not include the number of messages"))
+			})
+		})
+
+		g.Describe("when the number of messages is present", func() {
+			g.BeforeEach(func() {
+				apiCall.Return(&sqs.GetQueueAttributesOutput{
+					Attributes: map[string]string{"ApproximateNumberOfMessages": "7"},
+				}, nil)
+			})
+
+			g.It("returns the queue depth", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(depth).To(Equal(7))
+			})
+		})
+	})
+}
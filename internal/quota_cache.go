@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaCache caches service quota lookups for a limited time. A nil cache is
+// valid and caches nothing.
+type QuotaCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]quotaCacheEntry
+}
+
+type quotaCacheEntry struct {
+	value   int
+	expires time.Time
+}
+
+// NewQuotaCache creates a cache keeping quotas for the given time.
+func NewQuotaCache(ttl time.Duration) *QuotaCache {
+	return &QuotaCache{
+		ttl:     ttl,
+		entries: make(map[string]quotaCacheEntry),
+	}
+}
+
+func (c *QuotaCache) get(code string) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[code]
+	if !ok || !time.Now().Before(entry.expires) {
+		return 0, false
+	}
+
+	return entry.value, true
+}
+
+func (c *QuotaCache) set(code string, value int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[code] = quotaCacheEntry{value: value, expires: time.Now().Add(c.ttl)}
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rounding is the way fractional scaling sizes are turned into a whole
+// number of workers.
+type Rounding string
+
+const (
+	RoundingCeil    Rounding = "ceil"
+	RoundingFloor   Rounding = "floor"
+	RoundingNearest Rounding = "nearest"
+)
+
+// Validate checks whether the rounding mode is known.
+func (r Rounding) Validate() error {
+	switch r {
+	case "", RoundingCeil, RoundingFloor, RoundingNearest:
+		return nil
+	default:
+		return fmt.Errorf("unknown rounding mode %s", r)
+	}
+}
+
+// Round rounds the value to a whole number. Nearest rounds halves away from
+// zero, so a size of 2.5 workers becomes 3.
+func (r Rounding) Round(value float64) int {
+	switch r {
+	case RoundingFloor:
+		return int(math.Floor(value))
+	case RoundingNearest:
+		return int(math.Round(value))
+	default:
+		return int(math.Ceil(value))
+	}
+}
+
+// ScaleUpRounding returns the rounding mode for scale-up sizes, which is ceil
+// unless configured otherwise.
+func (c RuntimeConfig) ScaleUpRounding() Rounding {
+	if c.AutoscalingScaleUpRounding == "" {
+		return RoundingCeil
+	}
+
+	return c.AutoscalingScaleUpRounding
+}
+
+// ScaleDownRounding returns the rounding mode for scale-down sizes, which is
+// floor unless configured otherwise.
+func (c RuntimeConfig) ScaleDownRounding() Rounding {
+	if c.AutoscalingScaleDownRounding == "" {
+		return RoundingFloor
+	}
+
+	return c.AutoscalingScaleDownRounding
+}
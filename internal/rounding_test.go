@@ -0,0 +1,47 @@
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestRounding_Round(t *testing.T) {
+	for _, tt := range []struct {
+		value   float64
+		ceil    int
+		floor   int
+		nearest int
+	}{
+		{value: 2, ceil: 2, floor: 2, nearest: 2},
+		{value: 2.01, ceil: 3, floor: 2, nearest: 2},
+		{value: 2.49, ceil: 3, floor: 2, nearest: 2},
+		{value: 2.5, ceil: 3, floor: 2, nearest: 3},
+		{value: 2.99, ceil: 3, floor: 2, nearest: 3},
+		{value: 0.5, ceil: 1, floor: 0, nearest: 1},
+	} {
+		require.Equal(t, tt.ceil, internal.RoundingCeil.Round(tt.value), "ceil(%v)", tt.value)
+		require.Equal(t, tt.floor, internal.RoundingFloor.Round(tt.value), "floor(%v)", tt.value)
+		require.Equal(t, tt.nearest, internal.RoundingNearest.Round(tt.value), "nearest(%v)", tt.value)
+	}
+}
+
+func TestRounding_Defaults(t *testing.T) {
+	var cfg internal.RuntimeConfig
+
+	require.Equal(t, internal.RoundingCeil, cfg.ScaleUpRounding())
+	require.Equal(t, internal.RoundingFloor, cfg.ScaleDownRounding())
+
+	cfg.AutoscalingScaleUpRounding = internal.RoundingNearest
+	cfg.AutoscalingScaleDownRounding = internal.RoundingCeil
+
+	require.Equal(t, internal.RoundingNearest, cfg.ScaleUpRounding())
+	require.Equal(t, internal.RoundingCeil, cfg.ScaleDownRounding())
+}
+
+func TestRounding_Validate(t *testing.T) {
+	require.NoError(t, internal.RoundingNearest.Validate())
+	require.EqualError(t, internal.Rounding("up").Validate(), "unknown rounding mode up")
+}
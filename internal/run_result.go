@@ -0,0 +1,57 @@
+package internal
+
+// Events describing the outcome of a single autoscaler run.
+const (
+	RunEventNone         = "none"
+	RunEventScaleUp      = "scale_up"
+	RunEventScaleDown    = "scale_down"
+	RunEventStrayCleanup = "stray_cleanup"
+	RunEventError        = "error"
+)
+
+// RunResult represents the outcome of a single autoscaler run, including the
+// decision made and the actions taken to implement it.
+type RunResult struct {
+	WorkerPoolID        string   `json:"worker_pool_id"`
+	AutoscalingGroupARN string   `json:"autoscaling_group_arn"`
+	Decision            Decision `json:"decision"`
+	DrainedWorkers      []string `json:"drained_workers"`
+	KilledInstances     []string `json:"killed_instances"`
+	StraysKilled        int      `json:"strays_killed"`
+	Error               string   `json:"error,omitempty"`
+	ErrorClass          string   `json:"error_class,omitempty"`
+
+	// LaunchCohort identifies the instances launched by the scale-up, if they
+	// were tagged with it.
+	LaunchCohort string `json:"launch_cohort,omitempty"`
+
+	// EffectiveConfig holds the scaling parameters which applied to the
+	// decision, if one was made.
+	EffectiveConfig *EffectiveConfig `json:"effective_config,omitempty"`
+}
+
+// NewRunResult creates an empty result for a run with the given config.
+func NewRunResult(cfg RuntimeConfig) *RunResult {
+	return &RunResult{
+		WorkerPoolID:        cfg.SpaceliftWorkerPoolID,
+		AutoscalingGroupARN: cfg.AutoscalingGroupARN,
+		DrainedWorkers:      []string{},
+		KilledInstances:     []string{},
+	}
+}
+
+// Event returns the most significant event which took place during the run.
+func (r *RunResult) Event() string {
+	switch {
+	case r.Error != "":
+		return RunEventError
+	case r.Decision.ScalingDirection == ScalingDirectionUp:
+		return RunEventScaleUp
+	case r.Decision.ScalingDirection == ScalingDirectionDown:
+		return RunEventScaleDown
+	case r.StraysKilled > 0:
+		return RunEventStrayCleanup
+	default:
+		return RunEventNone
+	}
+}
@@ -1,13 +1,615 @@
 package internal
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Supported values of the AUTOSCALING_CHECK_ASG_POLICIES setting.
+const (
+	ASGPolicyCheckWarn   = "warn"
+	ASGPolicyCheckRefuse = "refuse"
+)
+
+// Supported values of the AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR setting.
+const (
+	ScaleDownDelayAnchorCreated = "created"
+	ScaleDownDelayAnchorIdle    = "idle"
+)
+
+// Supported values of the AUTOSCALING_GHOST_WORKERS setting.
+const (
+	GhostWorkersIgnore = "ignore"
+	GhostWorkersDrain  = "drain"
+)
+
+// Supported values of the AUTOSCALING_DURING_INSTANCE_REFRESH setting.
+const (
+	DuringInstanceRefreshIgnore      = "ignore"
+	DuringInstanceRefreshDefer       = "defer"
+	DuringInstanceRefreshScaleUpOnly = "scale_up_only"
+)
+
 type RuntimeConfig struct {
+	// Profile is the name of the configuration profile applied, if any.
+	Profile string `env:"AUTOSCALING_PROFILE"`
+
 	SpaceliftAPIKeyID      string `env:"SPACELIFT_API_KEY_ID,notEmpty"`
 	SpaceliftAPISecretName string `env:"SPACELIFT_API_KEY_SECRET_NAME,notEmpty"`
 	SpaceliftAPIEndpoint   string `env:"SPACELIFT_API_KEY_ENDPOINT,notEmpty"`
 	SpaceliftWorkerPoolID  string `env:"SPACELIFT_WORKER_POOL_ID,notEmpty"`
 
+	// SpaceliftPersistedQueries makes the autoscaler send the hashes of its
+	// GraphQL queries rather than the full queries, which the Spacelift API
+	// only needs to see once.
+	SpaceliftPersistedQueries bool `env:"SPACELIFT_PERSISTED_QUERIES" envDefault:"false"`
+
+	// SecretFetchMaxRetries is the number of times reading the Spacelift API
+	// key secret is retried when it fails with a transient error.
+	SecretFetchMaxRetries int `env:"SECRET_FETCH_MAX_RETRIES" envDefault:"2"`
+
+	// SpaceliftSharedWorkerPoolIDs are the IDs of other worker pools whose
+	// workers run in the same ASG. Their load is added to that of the main
+	// pool when deciding how to scale.
+	SpaceliftSharedWorkerPoolIDs []string `env:"SPACELIFT_SHARED_WORKER_POOL_IDS" envSeparator:","`
+
+	// CloudAPITimeout bounds each individual AWS API call, so that a single
+	// hung call can't use up the whole invocation. Zero means no timeout.
+	CloudAPITimeout time.Duration `env:"CLOUD_API_TIMEOUT" envDefault:"30s"`
+
+	// CloudCredentialsExpiryBuffer is how long the cloud credentials need to
+	// remain valid for a run to go ahead. Zero disables the check.
+	CloudCredentialsExpiryBuffer time.Duration `env:"CLOUD_CREDENTIALS_EXPIRY_BUFFER" envDefault:"0"`
+
 	AutoscalingGroupARN  string `env:"AUTOSCALING_GROUP_ARN,notEmpty"`
 	AutoscalingRegion    string `env:"AUTOSCALING_REGION,notEmpty"`
 	AutoscalingMaxKill   int    `env:"AUTOSCALING_MAX_KILL" envDefault:"1"`
 	AutoscalingMaxCreate int    `env:"AUTOSCALING_MAX_CREATE" envDefault:"1"`
+
+	// AutoscalingMaxKillPercent additionally caps the number of workers removed
+	// in a single run at this percentage of the pool. Zero means no cap.
+	AutoscalingMaxKillPercent int `env:"AUTOSCALING_MAX_KILL_PERCENT" envDefault:"0"`
+
+	// AutoscalingMaxConcurrentDrains is the number of workers drained at the
+	// same time when scaling down, bounding the load on the Spacelift API.
+	AutoscalingMaxConcurrentDrains int `env:"AUTOSCALING_MAX_CONCURRENT_DRAINS" envDefault:"1"`
+
+	// AutoscalingEmergencyFloor is the minimum size the ASG is lowered to in a
+	// cost emergency, until AutoscalingEmergencyFloorUntil. It must not be
+	// set without the expiry, so that the override can't be left on by
+	// accident.
+	AutoscalingEmergencyFloor      *int      `env:"AUTOSCALING_EMERGENCY_FLOOR"`
+	AutoscalingEmergencyFloorUntil time.Time `env:"AUTOSCALING_EMERGENCY_FLOOR_UNTIL"`
+
+	// AutoscalingProtectionTag is the EC2 tag, as key=value or just the key,
+	// marking the instances which are never terminated by the autoscaler.
+	AutoscalingProtectionTag string `env:"AUTOSCALING_PROTECTION_TAG"`
+
+	// AutoscalingScaleUpRounding and AutoscalingScaleDownRounding control how
+	// fractional sizes are rounded when scaling up and down respectively. The
+	// defaults avoid under-provisioning and over-reclaiming.
+	AutoscalingScaleUpRounding   Rounding `env:"AUTOSCALING_SCALE_UP_ROUNDING" envDefault:"ceil"`
+	AutoscalingScaleDownRounding Rounding `env:"AUTOSCALING_SCALE_DOWN_ROUNDING" envDefault:"floor"`
+
+	// AutoscalingContinueAfterStrayCleanup makes the autoscaler go on to the
+	// scaling decision in the same invocation after killing a stray instance,
+	// provided there are no other strays left.
+	AutoscalingContinueAfterStrayCleanup bool `env:"AUTOSCALING_CONTINUE_AFTER_STRAY_CLEANUP" envDefault:"false"`
+
+	// AutoscalingMaxStrayPercent makes the autoscaler refuse to terminate any
+	// strays if more than this percentage of the instances are classified as
+	// such. Zero means no limit.
+	AutoscalingMaxStrayPercent int `env:"AUTOSCALING_MAX_STRAY_PERCENT" envDefault:"0"`
+
+	// AutoscalingMetadataGroupKey and AutoscalingMetadataInstanceKey are the
+	// worker metadata keys holding the identifiers of the ASG and the instance,
+	// for workers using the default metadata format.
+	AutoscalingMetadataGroupKey    string `env:"AUTOSCALING_METADATA_GROUP_KEY" envDefault:"asg_id"`
+	AutoscalingMetadataInstanceKey string `env:"AUTOSCALING_METADATA_INSTANCE_KEY" envDefault:"instance_id"`
+
+	// AutoscalingMaxAPICalls is a soft cap on the number of external API calls
+	// made in a single invocation. Zero means no cap.
+	AutoscalingMaxAPICalls int `env:"AUTOSCALING_MAX_API_CALLS" envDefault:"0"`
+
+	// AutoscalingHonorDisabledLabel makes the autoscaler query the labels of
+	// the worker pool, and skip scaling while it carries the disabled label.
+	AutoscalingHonorDisabledLabel bool `env:"AUTOSCALING_HONOR_DISABLED_LABEL" envDefault:"false"`
+
+	// AutoscalingHonorPoolSuspension makes the autoscaler query whether the
+	// worker pool is suspended, and not scale it up while it is.
+	AutoscalingHonorPoolSuspension bool `env:"AUTOSCALING_HONOR_POOL_SUSPENSION" envDefault:"false"`
+
+	// AutoscalingSuspendedScaleToMin makes the autoscaler remove idle workers
+	// down to the ASG minimum size while the worker pool is suspended.
+	AutoscalingSuspendedScaleToMin bool `env:"AUTOSCALING_SUSPENDED_SCALE_TO_MIN" envDefault:"false"`
+
+	// AutoscalingMinPerZone is the minimum number of workers to keep in each
+	// availability zone when scaling down. Zero means no per-zone minimum.
+	AutoscalingMinPerZone int `env:"AUTOSCALING_MIN_PER_ZONE" envDefault:"0"`
+
+	// AutoscalingBalanceInstanceTypes makes scale-downs prefer the workers on
+	// the most common instance types, preserving the diversity of mixed
+	// instance pools.
+	AutoscalingBalanceInstanceTypes bool `env:"AUTOSCALING_BALANCE_INSTANCE_TYPES" envDefault:"false"`
+
+	// AutoscalingScaleDownDelay is the number of minutes a worker needs to be
+	// registered with Spacelift before it can be scaled down.
+	AutoscalingScaleDownDelay int `env:"AUTOSCALING_SCALE_DOWN_DELAY" envDefault:"0"`
+
+	// AutoscalingScaleDownDelayAnchor is what the scale-down delay counts
+	// from: the creation of the worker, or the last time the autoscaler saw it
+	// busy, so that recently active workers get a cooldown.
+	AutoscalingScaleDownDelayAnchor string `env:"AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR" envDefault:"created"`
+
+	// AutoscalingScaleDownUnknownAge determines whether workers with no known
+	// creation time can be scaled down while a scale-down delay is set.
+	AutoscalingScaleDownUnknownAge bool `env:"AUTOSCALING_SCALE_DOWN_UNKNOWN_AGE" envDefault:"false"`
+
+	// AutoscalingGhostWorkers is what to do with the workers whose instances
+	// are gone from the ASG: just warn about them, or drain them so that no
+	// runs are scheduled on them.
+	AutoscalingGhostWorkers string `env:"AUTOSCALING_GHOST_WORKERS" envDefault:"ignore"`
+
+	// AutoscalingDuringInstanceRefresh is how to scale while an instance
+	// refresh of the ASG is in progress: as usual, not at all, or only up.
+	AutoscalingDuringInstanceRefresh string `env:"AUTOSCALING_DURING_INSTANCE_REFRESH" envDefault:"ignore"`
+
+	// ProxyURL is an optional proxy to use for all outgoing HTTP requests. If
+	// not set, the standard proxy environment variables are respected.
+	ProxyURL string `env:"AUTOSCALING_PROXY_URL"`
+
+	// AutoscalingUseInstanceRefresh makes the autoscaler start an ASG instance
+	// refresh when it finds instances with an outdated launch template.
+	AutoscalingUseInstanceRefresh bool `env:"AUTOSCALING_USE_INSTANCE_REFRESH" envDefault:"false"`
+
+	// AutoscalingRefreshMinHealthy and AutoscalingRefreshWarmup are the minimum
+	// percentage of healthy instances to keep during an instance refresh, and
+	// the time a new instance needs to warm up. Zero means the AWS default.
+	AutoscalingRefreshMinHealthy int           `env:"AUTOSCALING_REFRESH_MIN_HEALTHY" envDefault:"0"`
+	AutoscalingRefreshWarmup     time.Duration `env:"AUTOSCALING_REFRESH_WARMUP" envDefault:"0"`
+
+	// AutoscalingRecoverDrainedWorkers makes the autoscaler undrain workers
+	// left drained by a previous run before adding new capacity. It's off by
+	// default, since it would also undrain the workers drained by hand.
+	AutoscalingRecoverDrainedWorkers bool `env:"AUTOSCALING_RECOVER_DRAINED_WORKERS" envDefault:"false"`
+
+	// AutoscalingReclaimDrainedWorkers makes the autoscaler remove idle drained
+	// workers whose instances are still in service first when scaling down,
+	// and count them as surplus capacity.
+	AutoscalingReclaimDrainedWorkers bool `env:"AUTOSCALING_RECLAIM_DRAINED_WORKERS" envDefault:"false"`
+
+	// AutoscalingHeartbeatStaleness is the age of the last heartbeat after which
+	// a worker is considered dead. Such workers don't count as available
+	// capacity, and are the first ones to be removed. Zero disables the check.
+	AutoscalingHeartbeatStaleness time.Duration `env:"AUTOSCALING_HEARTBEAT_STALENESS" envDefault:"0"`
+
+	// AutoscalingSoftDrain makes the autoscaler wind the pool down, eg. ahead
+	// of maintenance. All the workers are drained, and each one is terminated
+	// once it's done with its current run.
+	AutoscalingSoftDrain bool `env:"AUTOSCALING_SOFT_DRAIN" envDefault:"false"`
+
+	// AutoscalingTwoPhaseScaleDown makes the autoscaler only drain workers when
+	// scaling down, and terminate their instances in the next invocation if
+	// they stayed idle and drained.
+	AutoscalingTwoPhaseScaleDown bool `env:"AUTOSCALING_TWO_PHASE_SCALE_DOWN" envDefault:"false"`
+
+	// AutoscalingConfirmDrains makes the autoscaler read the workers again
+	// right before terminating their instances, and skip the ones which are not
+	// yet reported as both drained and idle.
+	AutoscalingConfirmDrains bool `env:"AUTOSCALING_CONFIRM_DRAINS" envDefault:"false"`
+
+	// AutoscalingCheckASGPolicies makes the autoscaler check for scaling
+	// policies attached to the ASG, and either warn about them or refuse to
+	// run. Empty means no check.
+	AutoscalingCheckASGPolicies string `env:"AUTOSCALING_CHECK_ASG_POLICIES"`
+
+	// AutoscalingIncludePausedRuns makes paused runs (eg. awaiting approval)
+	// count towards the pending runs driving the scale-up. Otherwise they're
+	// queried separately and excluded.
+	AutoscalingIncludePausedRuns bool `env:"AUTOSCALING_INCLUDE_PAUSED_RUNS" envDefault:"true"`
+
+	// AutoscalingRunLabelExpression selects the pending runs driving the
+	// scaling by their labels, eg. "team=infra AND (env=prod OR env=dev)".
+	// Empty means all pending runs count.
+	AutoscalingRunLabelExpression string `env:"AUTOSCALING_RUN_LABEL_EXPRESSION"`
+
+	// runLabelExpression is AutoscalingRunLabelExpression as parsed by
+	// Validate, so that it isn't parsed again for every decision.
+	runLabelExpression LabelExpression
+
+	// AutoscalingVerifyMinSize makes the autoscaler re-read the ASG after a
+	// scale-down, and bring it back up to its minimum size if concurrent
+	// changes took it below.
+	AutoscalingVerifyMinSize bool `env:"AUTOSCALING_VERIFY_MIN_SIZE" envDefault:"false"`
+
+	// AutoscalingMaxInstanceLifetime is the maximum time an instance may be
+	// running for before it's recycled. Zero disables recycling.
+	AutoscalingMaxInstanceLifetime time.Duration `env:"AUTOSCALING_MAX_INSTANCE_LIFETIME" envDefault:"0"`
+
+	// AutoscalingSaturationBuffer is the number of workers to add when all the
+	// workers are busy, even though there are no pending runs yet. Zero
+	// disables the buffer.
+	AutoscalingSaturationBuffer int `env:"AUTOSCALING_SATURATION_BUFFER" envDefault:"0"`
+
+	// AutoscalingUtilizationThreshold is the average CPU utilization of the
+	// instances, as a percentage, above which workers are added even if few
+	// runs are pending. Zero disables the utilization signal.
+	AutoscalingUtilizationThreshold int `env:"AUTOSCALING_UTILIZATION_THRESHOLD" envDefault:"0"`
+
+	// AutoscalingMinScaleUpStep is the minimum number of workers to add
+	// whenever scaling up is warranted, for pools where launching instances
+	// one by one is inefficient. Zero disables the minimum.
+	AutoscalingMinScaleUpStep int `env:"AUTOSCALING_MIN_SCALE_UP_STEP" envDefault:"0"`
+
+	// AutoscalingLaunchRetryBudget is how many of the instances launched to
+	// cover a deficit can fail to register as workers before the autoscaler
+	// stops scaling up for it. Zero disables the budget.
+	AutoscalingLaunchRetryBudget int `env:"AUTOSCALING_LAUNCH_RETRY_BUDGET" envDefault:"0"`
+
+	// AutoscalingAbsoluteTarget makes the desired capacity track the number of
+	// busy workers plus pending runs exactly, rather than adding the deficit
+	// to the current desired capacity.
+	AutoscalingAbsoluteTarget bool `env:"AUTOSCALING_ABSOLUTE_TARGET" envDefault:"false"`
+
+	// AutoscalingTargetBusyPercent is the share of busy workers, counting the
+	// pending runs as busy, which the desired capacity is steered towards by
+	// a proportional-integral controller. Zero disables the controller.
+	AutoscalingTargetBusyPercent int `env:"AUTOSCALING_TARGET_BUSY_PERCENT" envDefault:"0"`
+
+	// AutoscalingTargetBusyProportionalGain is the share of the distance to
+	// the setpoint closed in a single run.
+	AutoscalingTargetBusyProportionalGain float64 `env:"AUTOSCALING_TARGET_BUSY_PROPORTIONAL_GAIN" envDefault:"0.5"`
+
+	// AutoscalingTargetBusyIntegralGain is the weight of the accumulated
+	// distance to the setpoint. Zero makes the controller proportional only.
+	AutoscalingTargetBusyIntegralGain float64 `env:"AUTOSCALING_TARGET_BUSY_INTEGRAL_GAIN" envDefault:"0"`
+
+	// AutoscalingQueueURL is the URL of an external SQS queue feeding runs into
+	// the worker pool, whose depth is added to the pending runs.
+	AutoscalingQueueURL string `env:"AUTOSCALING_QUEUE_URL"`
+
+	// AutoscalingScheduleTimezone is the timezone schedules are evaluated in.
+	AutoscalingScheduleTimezone string `env:"AUTOSCALING_SCHEDULE_TIMEZONE" envDefault:"UTC"`
+
+	// AutoscalingPrewarmSchedule lists the windows during which the pool is
+	// kept at a minimum size, in the "[days ]HH:MM-HH:MM=size" format.
+	AutoscalingPrewarmSchedule []string `env:"AUTOSCALING_PREWARM_SCHEDULE" envSeparator:";"`
+
+	// AutoscalingBoundsSchedule maps time windows to the bounds of the pool,
+	// see ParseBoundsSchedule. It can also be read from the file pointed to by
+	// AutoscalingBoundsScheduleFile.
+	AutoscalingBoundsSchedule     string `env:"AUTOSCALING_BOUNDS_SCHEDULE"`
+	AutoscalingBoundsScheduleFile string `env:"AUTOSCALING_BOUNDS_SCHEDULE_FILE"`
+
+	// AutoscalingBusinessHours lists the windows during which the pool is
+	// scaled normally. Outside of them, it's held at AutoscalingOffHoursSize.
+	AutoscalingBusinessHours []string `env:"AUTOSCALING_BUSINESS_HOURS" envSeparator:";"`
+	AutoscalingOffHoursSize  int      `env:"AUTOSCALING_OFF_HOURS_SIZE" envDefault:"0"`
+
+	// AutoscalingTagWorkerPool makes sure the ASG carries a tag with the worker
+	// pool ID, so that all the instances it launches inherit it.
+	AutoscalingTagWorkerPool bool `env:"AUTOSCALING_TAG_WORKER_POOL" envDefault:"false"`
+
+	// AutoscalingTagLaunchCohort makes the autoscaler tag the instances it
+	// launches with the launch cohort of the scale-up, for cost attribution.
+	AutoscalingTagLaunchCohort bool `env:"AUTOSCALING_TAG_LAUNCH_COHORT" envDefault:"false"`
+
+	// AutoscalingStateParameter is the name of the SSM parameter used to persist
+	// the state between invocations. Features relying on it require it to be set.
+	AutoscalingStateParameter string `env:"AUTOSCALING_STATE_PARAMETER"`
+
+	// AutoscalingSafeModeDecay enables safe mode, which refuses to scale down
+	// below the recent peak minus AutoscalingSafeModeMargin workers. The floor
+	// decays to zero over this period.
+	AutoscalingSafeModeDecay  time.Duration `env:"AUTOSCALING_SAFE_MODE_DECAY" envDefault:"0"`
+	AutoscalingSafeModeMargin int           `env:"AUTOSCALING_SAFE_MODE_MARGIN" envDefault:"0"`
+
+	// AutoscalingConfirmScaleUp makes the autoscaler wait for the new instances
+	// to appear in the ASG after scaling up, polling every interval until the
+	// timeout.
+	AutoscalingConfirmScaleUp         bool          `env:"AUTOSCALING_CONFIRM_SCALE_UP" envDefault:"false"`
+	AutoscalingConfirmScaleUpTimeout  time.Duration `env:"AUTOSCALING_CONFIRM_SCALE_UP_TIMEOUT" envDefault:"30s"`
+	AutoscalingConfirmScaleUpInterval time.Duration `env:"AUTOSCALING_CONFIRM_SCALE_UP_INTERVAL" envDefault:"5s"`
+
+	// AutoscalingPanicThreshold is the number of workers added in a single
+	// scale-up which qualifies it as a panic. Until the pool returns to its size
+	// from before the panic, the newest workers are scaled down first.
+	AutoscalingPanicThreshold int `env:"AUTOSCALING_PANIC_THRESHOLD" envDefault:"0"`
+
+	// AutoscalingSummaryScaleUp makes the autoscaler decide on scaling up
+	// based on a lightweight summary of the worker pool, and only fetch the
+	// full worker details when the summary doesn't call for a scale-up.
+	AutoscalingSummaryScaleUp bool `env:"AUTOSCALING_SUMMARY_SCALE_UP" envDefault:"false"`
+
+	// AutoscalingPoolCacheStaleness is how old the cached worker pool state
+	// can be for the autoscaler to fall back to it when the worker pool query
+	// times out. Zero disables the cache.
+	AutoscalingPoolCacheStaleness time.Duration `env:"AUTOSCALING_POOL_CACHE_STALENESS" envDefault:"0"`
+
+	// AutoscalingNoOpLogInterval is how often to log a decision not to scale
+	// which is the same as in the previous invocations. Zero logs every one.
+	AutoscalingNoOpLogInterval time.Duration `env:"AUTOSCALING_NO_OP_LOG_INTERVAL" envDefault:"0"`
+
+	// AutoscalingVCPUQuotaCode is the code of the EC2 service quota limiting
+	// the number of vCPUs available to the pool's instances, for example
+	// L-1216C47A for the standard instance families. Together with the number
+	// of vCPUs per instance it caps scale-ups at what the quota allows. The
+	// quota is account-wide, but the vCPUs used outside the pool aren't
+	// taken into account.
+	AutoscalingVCPUQuotaCode string `env:"AUTOSCALING_VCPU_QUOTA_CODE"`
+	AutoscalingInstanceVCPUs int    `env:"AUTOSCALING_INSTANCE_VCPUS" envDefault:"0"`
+
+	// AutoscalingThrottleBackoff is the time to skip non-essential work for
+	// once API throttling was hit in AutoscalingThrottleThreshold consecutive
+	// invocations. Zero disables the backoff.
+	AutoscalingThrottleBackoff   time.Duration `env:"AUTOSCALING_THROTTLE_BACKOFF" envDefault:"0"`
+	AutoscalingThrottleThreshold int           `env:"AUTOSCALING_THROTTLE_THRESHOLD" envDefault:"2"`
+
+	// AutoscalingCloudEventsSink is where to emit a CloudEvent for each run
+	// which took a scaling action. Empty disables the events.
+	AutoscalingCloudEventsSink string `env:"AUTOSCALING_CLOUDEVENTS_SINK"`
+
+	// AutoscalingEMFNamespace is the CloudWatch namespace of the metrics
+	// logged for each run in the Embedded Metric Format. Empty disables them.
+	AutoscalingEMFNamespace string `env:"AUTOSCALING_EMF_NAMESPACE"`
+
+	// AutoscalingDecisionHook is the path of a command consulted before acting
+	// on each scaling decision, which can approve, reject or scale it back.
+	// AutoscalingDecisionHookOnFailure is what to do with the decision when the
+	// hook fails or times out.
+	AutoscalingDecisionHook          string        `env:"AUTOSCALING_DECISION_HOOK"`
+	AutoscalingDecisionHookTimeout   time.Duration `env:"AUTOSCALING_DECISION_HOOK_TIMEOUT" envDefault:"10s"`
+	AutoscalingDecisionHookOnFailure string        `env:"AUTOSCALING_DECISION_HOOK_ON_FAILURE" envDefault:"hold"`
+
+	// Webhook to notify about the result of each run, the events which should
+	// trigger the notification, and the timeout for the webhook request.
+	AutoscalingWebhookURL     string        `env:"AUTOSCALING_WEBHOOK_URL"`
+	AutoscalingWebhookEvents  []string      `env:"AUTOSCALING_WEBHOOK_EVENTS" envDefault:"scale_up,scale_down,stray_cleanup,error"`
+	AutoscalingWebhookTimeout time.Duration `env:"AUTOSCALING_WEBHOOK_TIMEOUT" envDefault:"5s"`
+}
+
+// Validate checks the configuration for values which can't be expressed using
+// the struct tags alone.
+func (c *RuntimeConfig) Validate() error {
+	// A mismatch would otherwise only surface as the ASG not being found.
+	if parsed, err := arn.Parse(c.AutoscalingGroupARN); err == nil && parsed.Region != "" && parsed.Region != c.AutoscalingRegion {
+		return fmt.Errorf("AUTOSCALING_GROUP_ARN is in region %s, but AUTOSCALING_REGION is %s", parsed.Region, c.AutoscalingRegion)
+	}
+
+	switch c.AutoscalingCheckASGPolicies {
+	case "", ASGPolicyCheckWarn, ASGPolicyCheckRefuse:
+	default:
+		return fmt.Errorf("invalid AUTOSCALING_CHECK_ASG_POLICIES value: %s", c.AutoscalingCheckASGPolicies)
+	}
+
+	switch c.AutoscalingDecisionHookOnFailure {
+	case "", HookFailureHold, HookFailureProceed:
+	default:
+		return fmt.Errorf("invalid AUTOSCALING_DECISION_HOOK_ON_FAILURE value: %s", c.AutoscalingDecisionHookOnFailure)
+	}
+
+	switch c.AutoscalingGhostWorkers {
+	case "", GhostWorkersIgnore, GhostWorkersDrain:
+	default:
+		return fmt.Errorf("invalid AUTOSCALING_GHOST_WORKERS value: %s", c.AutoscalingGhostWorkers)
+	}
+
+	switch c.AutoscalingDuringInstanceRefresh {
+	case "", DuringInstanceRefreshIgnore, DuringInstanceRefreshDefer, DuringInstanceRefreshScaleUpOnly:
+	default:
+		return fmt.Errorf("invalid AUTOSCALING_DURING_INSTANCE_REFRESH value: %s", c.AutoscalingDuringInstanceRefresh)
+	}
+
+	switch c.AutoscalingScaleDownDelayAnchor {
+	case "", ScaleDownDelayAnchorCreated, ScaleDownDelayAnchorIdle:
+	default:
+		return fmt.Errorf("invalid AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR value: %s", c.AutoscalingScaleDownDelayAnchor)
+	}
+
+	if c.AutoscalingCloudEventsSink != "" && c.AutoscalingCloudEventsSink != CloudEventsSinkStdout {
+		return fmt.Errorf("invalid AUTOSCALING_CLOUDEVENTS_SINK value: %s", c.AutoscalingCloudEventsSink)
+	}
+
+	if c.CloudAPITimeout < 0 {
+		return fmt.Errorf("invalid CLOUD_API_TIMEOUT value: %s", c.CloudAPITimeout)
+	}
+
+	if c.CloudCredentialsExpiryBuffer < 0 {
+		return fmt.Errorf("invalid CLOUD_CREDENTIALS_EXPIRY_BUFFER value: %s", c.CloudCredentialsExpiryBuffer)
+	}
+
+	if c.SecretFetchMaxRetries < 0 {
+		return fmt.Errorf("invalid SECRET_FETCH_MAX_RETRIES value: %d", c.SecretFetchMaxRetries)
+	}
+
+	if c.AutoscalingMaxKillPercent < 0 || c.AutoscalingMaxKillPercent > 100 {
+		return fmt.Errorf("invalid AUTOSCALING_MAX_KILL_PERCENT value: %d", c.AutoscalingMaxKillPercent)
+	}
+
+	if c.AutoscalingProtectionTag != "" {
+		if _, err := ParseProtectionTag(c.AutoscalingProtectionTag); err != nil {
+			return fmt.Errorf("invalid AUTOSCALING_PROTECTION_TAG value: %w", err)
+		}
+	}
+
+	if c.AutoscalingMinScaleUpStep < 0 {
+		return fmt.Errorf("invalid AUTOSCALING_MIN_SCALE_UP_STEP value: %d", c.AutoscalingMinScaleUpStep)
+	}
+
+	if c.AutoscalingMinScaleUpStep > c.AutoscalingMaxCreate {
+		return fmt.Errorf("AUTOSCALING_MIN_SCALE_UP_STEP can't exceed AUTOSCALING_MAX_CREATE")
+	}
+
+	if c.AutoscalingTargetBusyPercent < 0 || c.AutoscalingTargetBusyPercent > 100 {
+		return fmt.Errorf("invalid AUTOSCALING_TARGET_BUSY_PERCENT value: %d", c.AutoscalingTargetBusyPercent)
+	}
+
+	if c.AutoscalingTargetBusyPercent > 0 {
+		// Gains above one would overshoot the setpoint, and make the pool
+		// oscillate around it.
+		if gain := c.AutoscalingTargetBusyProportionalGain; gain <= 0 || gain > 1 {
+			return fmt.Errorf("invalid AUTOSCALING_TARGET_BUSY_PROPORTIONAL_GAIN value: %g", gain)
+		}
+
+		if gain := c.AutoscalingTargetBusyIntegralGain; gain < 0 || gain > 1 {
+			return fmt.Errorf("invalid AUTOSCALING_TARGET_BUSY_INTEGRAL_GAIN value: %g", gain)
+		}
+
+		if c.AutoscalingAbsoluteTarget {
+			return fmt.Errorf("AUTOSCALING_TARGET_BUSY_PERCENT can't be combined with AUTOSCALING_ABSOLUTE_TARGET")
+		}
+	}
+
+	if c.AutoscalingLaunchRetryBudget < 0 {
+		return fmt.Errorf("invalid AUTOSCALING_LAUNCH_RETRY_BUDGET value: %d", c.AutoscalingLaunchRetryBudget)
+	}
+
+	if c.AutoscalingEmergencyFloor != nil {
+		if *c.AutoscalingEmergencyFloor < 0 {
+			return fmt.Errorf("invalid AUTOSCALING_EMERGENCY_FLOOR value: %d", *c.AutoscalingEmergencyFloor)
+		}
+
+		if c.AutoscalingEmergencyFloorUntil.IsZero() {
+			return fmt.Errorf("AUTOSCALING_EMERGENCY_FLOOR requires AUTOSCALING_EMERGENCY_FLOOR_UNTIL to be set")
+		}
+
+		if c.AutoscalingStateParameter == "" {
+			return fmt.Errorf("AUTOSCALING_EMERGENCY_FLOOR requires AUTOSCALING_STATE_PARAMETER to be set")
+		}
+	}
+
+	if c.AutoscalingMaxConcurrentDrains < 1 {
+		return fmt.Errorf("invalid AUTOSCALING_MAX_CONCURRENT_DRAINS value: %d", c.AutoscalingMaxConcurrentDrains)
+	}
+
+	if err := c.AutoscalingScaleUpRounding.Validate(); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_SCALE_UP_ROUNDING value: %w", err)
+	}
+
+	if err := c.AutoscalingScaleDownRounding.Validate(); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_SCALE_DOWN_ROUNDING value: %w", err)
+	}
+
+	if c.AutoscalingRefreshMinHealthy < 0 || c.AutoscalingRefreshMinHealthy > 100 {
+		return fmt.Errorf("invalid AUTOSCALING_REFRESH_MIN_HEALTHY value: %d", c.AutoscalingRefreshMinHealthy)
+	}
+
+	if c.AutoscalingRefreshWarmup < 0 {
+		return fmt.Errorf("invalid AUTOSCALING_REFRESH_WARMUP value: %s", c.AutoscalingRefreshWarmup)
+	}
+
+	if c.AutoscalingMaxStrayPercent < 0 || c.AutoscalingMaxStrayPercent > 100 {
+		return fmt.Errorf("invalid AUTOSCALING_MAX_STRAY_PERCENT value: %d", c.AutoscalingMaxStrayPercent)
+	}
+
+	if c.AutoscalingUtilizationThreshold < 0 || c.AutoscalingUtilizationThreshold > 100 {
+		return fmt.Errorf("invalid AUTOSCALING_UTILIZATION_THRESHOLD value: %d", c.AutoscalingUtilizationThreshold)
+	}
+
+	if _, err := time.LoadLocation(c.AutoscalingScheduleTimezone); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_SCHEDULE_TIMEZONE value: %w", err)
+	}
+
+	if c.AutoscalingRunLabelExpression != "" {
+		expr, err := ParseLabelExpression(c.AutoscalingRunLabelExpression)
+		if err != nil {
+			return fmt.Errorf("invalid AUTOSCALING_RUN_LABEL_EXPRESSION value: %w", err)
+		}
+
+		c.runLabelExpression = expr
+	}
+
+	if _, err := ParsePrewarmSchedule(c.AutoscalingPrewarmSchedule); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_PREWARM_SCHEDULE value: %w", err)
+	}
+
+	if _, err := ParseBoundsSchedule(c.AutoscalingBoundsSchedule); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_BOUNDS_SCHEDULE value: %w", err)
+	}
+
+	if _, err := ParseTimeWindows(c.AutoscalingBusinessHours); err != nil {
+		return fmt.Errorf("invalid AUTOSCALING_BUSINESS_HOURS value: %w", err)
+	}
+
+	if c.AutoscalingSafeModeDecay > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_SAFE_MODE_DECAY requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingPanicThreshold > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_PANIC_THRESHOLD requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingThrottleBackoff > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_THROTTLE_BACKOFF requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingTwoPhaseScaleDown && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_TWO_PHASE_SCALE_DOWN requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingScaleDownDelayAnchor == ScaleDownDelayAnchorIdle && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR=idle requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingNoOpLogInterval > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_NO_OP_LOG_INTERVAL requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingTargetBusyPercent > 0 && c.AutoscalingTargetBusyIntegralGain > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_TARGET_BUSY_INTEGRAL_GAIN requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingLaunchRetryBudget > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_LAUNCH_RETRY_BUDGET requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingPoolCacheStaleness > 0 && c.AutoscalingStateParameter == "" {
+		return fmt.Errorf("AUTOSCALING_POOL_CACHE_STALENESS requires AUTOSCALING_STATE_PARAMETER to be set")
+	}
+
+	if c.AutoscalingSuspendedScaleToMin && !c.AutoscalingHonorPoolSuspension {
+		return fmt.Errorf("AUTOSCALING_SUSPENDED_SCALE_TO_MIN requires AUTOSCALING_HONOR_POOL_SUSPENSION to be set")
+	}
+
+	if c.AutoscalingVCPUQuotaCode != "" && c.AutoscalingInstanceVCPUs <= 0 {
+		return fmt.Errorf("AUTOSCALING_VCPU_QUOTA_CODE requires AUTOSCALING_INSTANCE_VCPUS to be set")
+	}
+
+	return nil
+}
+
+// RunLabelExpression returns the expression selecting the pending runs which
+// drive the scaling, or nil if all of them do. A configuration which hasn't
+// been validated has its expression parsed on each call.
+func (c RuntimeConfig) RunLabelExpression() LabelExpression {
+	if c.AutoscalingRunLabelExpression == "" {
+		return nil
+	}
+
+	if c.runLabelExpression != nil {
+		return c.runLabelExpression
+	}
+
+	// Invalid expressions are rejected when validating the configuration.
+	expr, _ := ParseLabelExpression(c.AutoscalingRunLabelExpression)
+
+	return expr
+}
+
+// MetadataKeys returns the keys holding the identifiers of the ASG and the
+// instance in the metadata of the workers.
+func (c RuntimeConfig) MetadataKeys() MetadataKeys {
+	return MetadataKeys{Group: c.AutoscalingMetadataGroupKey, Instance: c.AutoscalingMetadataInstanceKey}
+}
+
+// EmergencyFloor returns the emergency floor, if one is in effect at the given
+// time.
+func (c RuntimeConfig) EmergencyFloor(now time.Time) (int, bool) {
+	if c.AutoscalingEmergencyFloor == nil || !now.Before(c.AutoscalingEmergencyFloorUntil) {
+		return 0, false
+	}
+
+	return *c.AutoscalingEmergencyFloor, true
 }
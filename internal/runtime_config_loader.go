@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnvVar is the name of the environment variable pointing to an
+// optional configuration file.
+const ConfigFileEnvVar = "AUTOSCALING_CONFIG_FILE"
+
+// ProfileEnvVar is the name of the environment variable selecting an optional
+// configuration profile.
+const ProfileEnvVar = "AUTOSCALING_PROFILE"
+
+// profilesKey is the configuration file key holding the profiles.
+const profilesKey = "profiles"
+
+// LoadRuntimeConfig loads the runtime configuration from the environment.
+//
+// If the AUTOSCALING_CONFIG_FILE environment variable is set, it must point to
+// a YAML (or JSON) file mapping environment variable names to their values.
+// The values from the file serve as defaults, so actual environment variables
+// always take precedence over them.
+//
+// If the AUTOSCALING_PROFILE environment variable is set, the overrides of the
+// selected profile are layered on top of that. They come from the profile's
+// section in the configuration file, and from the environment variables
+// prefixed with AUTOSCALING_PROFILE_ and the upper-cased profile name (eg.
+// AUTOSCALING_PROFILE_PROD_AUTOSCALING_MAX_KILL), the latter taking
+// precedence.
+func LoadRuntimeConfig() (*RuntimeConfig, error) {
+	environment := make(map[string]string)
+	var profiles map[string]map[string]string
+
+	if path := os.Getenv(ConfigFileEnvVar); path != "" {
+		var fileValues map[string]string
+		var err error
+
+		if fileValues, profiles, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+
+		for key, value := range fileValues {
+			environment[key] = value
+		}
+	}
+
+	for _, pair := range os.Environ() {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			environment[key] = value
+		}
+	}
+
+	if profile := environment[ProfileEnvVar]; profile != "" {
+		if err := applyProfile(environment, profile, profiles[profile]); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfg RuntimeConfig
+	if err := env.ParseWithOptions(&cfg, env.Options{Environment: environment}); err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %w", err)
+	}
+
+	if err := readBoundsScheduleFile(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// applyProfile layers the overrides of the given profile over the base
+// configuration, first from the configuration file and then from the
+// prefixed environment variables.
+func applyProfile(environment map[string]string, profile string, fileOverrides map[string]string) error {
+	if !profileNamePattern.MatchString(profile) {
+		return fmt.Errorf("invalid %s value: %s", ProfileEnvVar, profile)
+	}
+
+	prefix := ProfileEnvVar + "_" + strings.ToUpper(strings.ReplaceAll(profile, "-", "_")) + "_"
+	envOverrides := make(map[string]string)
+
+	for key, value := range environment {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			envOverrides[name] = value
+		}
+	}
+
+	if fileOverrides == nil && len(envOverrides) == 0 {
+		return fmt.Errorf("unknown configuration profile %s", profile)
+	}
+
+	for key, value := range fileOverrides {
+		environment[key] = value
+	}
+
+	for key, value := range envOverrides {
+		environment[key] = value
+	}
+
+	return nil
+}
+
+// readBoundsScheduleFile reads the bounds schedule from its file, if one is
+// configured.
+func readBoundsScheduleFile(cfg *RuntimeConfig) error {
+	if cfg.AutoscalingBoundsScheduleFile == "" {
+		return nil
+	}
+
+	if cfg.AutoscalingBoundsSchedule != "" {
+		return fmt.Errorf("AUTOSCALING_BOUNDS_SCHEDULE can't be combined with AUTOSCALING_BOUNDS_SCHEDULE_FILE")
+	}
+
+	data, err := os.ReadFile(cfg.AutoscalingBoundsScheduleFile)
+	if err != nil {
+		return fmt.Errorf("could not read bounds schedule file: %w", err)
+	}
+
+	cfg.AutoscalingBoundsSchedule = string(data)
+
+	return nil
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+func readConfigFile(path string) (values map[string]string, profiles map[string]map[string]string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("could not parse configuration file: %w", err)
+	}
+
+	if rawProfiles, ok := raw[profilesKey]; ok {
+		delete(raw, profilesKey)
+
+		if profiles, err = readConfigProfiles(rawProfiles); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if values, err = flattenConfigValues(raw); err != nil {
+		return nil, nil, err
+	}
+
+	return values, profiles, nil
+}
+
+func readConfigProfiles(raw any) (map[string]map[string]string, error) {
+	typed, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("configuration file key %s must be a map", profilesKey)
+	}
+
+	out := make(map[string]map[string]string, len(typed))
+
+	for name, rawProfile := range typed {
+		profile, ok := rawProfile.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("configuration profile %s must be a map", name)
+		}
+
+		values, err := flattenConfigValues(profile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration profile %s: %w", name, err)
+		}
+
+		out[name] = values
+	}
+
+	return out, nil
+}
+
+// documentConfigKeys are the settings holding a whole YAML or JSON document,
+// which the configuration file can give in its native form.
+var documentConfigKeys = map[string]bool{
+	"AUTOSCALING_BOUNDS_SCHEDULE": true,
+}
+
+// configSeparators maps the names of the list settings to the separators their
+// items are split on, as declared on RuntimeConfig.
+var configSeparators = func() map[string]string {
+	out := make(map[string]string)
+	configType := reflect.TypeOf(RuntimeConfig{})
+
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+
+		if name == "" || field.Type.Kind() != reflect.Slice {
+			continue
+		}
+
+		if out[name] = field.Tag.Get("envSeparator"); out[name] == "" {
+			out[name] = ","
+		}
+	}
+
+	return out
+}()
+
+func flattenConfigValues(raw map[string]any) (map[string]string, error) {
+	out := make(map[string]string, len(raw))
+
+	for key, value := range raw {
+		if value == nil {
+			continue
+		}
+
+		// JSON is valid YAML, so native documents are passed on as JSON.
+		if _, isString := value.(string); documentConfigKeys[key] && !isString {
+			document, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("could not encode configuration file key %s: %w", key, err)
+			}
+
+			out[key] = string(document)
+			continue
+		}
+
+		switch typed := value.(type) {
+		case []any:
+			separator, ok := configSeparators[key]
+			if !ok {
+				separator = ","
+			}
+
+			items := make([]string, 0, len(typed))
+			for _, item := range typed {
+				items = append(items, formatConfigValue(item))
+			}
+			out[key] = strings.Join(items, separator)
+		case map[string]any:
+			return nil, fmt.Errorf("configuration file key %s must not be a map", key)
+		default:
+			out[key] = formatConfigValue(typed)
+		}
+	}
+
+	return out, nil
+}
+
+// formatConfigValue formats a single value from the configuration file the way
+// it would be given in the environment.
+func formatConfigValue(value any) string {
+	// Unquoted timestamps are parsed by YAML, but the settings expect them in
+	// the RFC 3339 format.
+	if timestamp, ok := value.(time.Time); ok {
+		return timestamp.Format(time.RFC3339)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,564 @@
+package internal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+const baseConfigFile = `
+SPACELIFT_API_KEY_ID: key-id
+SPACELIFT_API_KEY_SECRET_NAME: secret-name
+SPACELIFT_API_KEY_ENDPOINT: https://demo.app.spacelift.io
+SPACELIFT_WORKER_POOL_ID: pool-id
+AUTOSCALING_GROUP_ARN: arn:aws:autoscaling:eu-west-1:123456789012:autoScalingGroup:uuid:autoScalingGroupName/group
+AUTOSCALING_REGION: eu-west-1
+AUTOSCALING_MAX_KILL: 3
+`
+
+func TestLoadRuntimeConfigFromFileOnly(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, "pool-id", cfg.SpaceliftWorkerPoolID)
+	require.Equal(t, 3, cfg.AutoscalingMaxKill)
+	require.Equal(t, 1, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigEnvOverridesFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_MAX_KILL", "5")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 5, cfg.AutoscalingMaxKill)
+}
+
+func TestLoadRuntimeConfigFromJSONFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, `{
+		"SPACELIFT_API_KEY_ID": "key-id",
+		"SPACELIFT_API_KEY_SECRET_NAME": "secret-name",
+		"SPACELIFT_API_KEY_ENDPOINT": "https://demo.app.spacelift.io",
+		"SPACELIFT_WORKER_POOL_ID": "pool-id",
+		"AUTOSCALING_GROUP_ARN": "arn",
+		"AUTOSCALING_REGION": "eu-west-1",
+		"AUTOSCALING_MAX_CREATE": 4
+	}`))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 4, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigMalformedFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, "AUTOSCALING_MAX_KILL: [3"))
+
+	_, err := internal.LoadRuntimeConfig()
+	require.ErrorContains(t, err, "could not parse configuration file")
+}
+
+func TestLoadRuntimeConfigMissingFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, filepath.Join(t.TempDir(), "missing.yml"))
+
+	_, err := internal.LoadRuntimeConfig()
+	require.ErrorContains(t, err, "could not read configuration file")
+}
+
+func TestLoadRuntimeConfigValidatesMergedValues(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, "AUTOSCALING_MAX_KILL: 3"))
+
+	_, err := internal.LoadRuntimeConfig()
+	require.ErrorContains(t, err, "SPACELIFT_API_KEY_ID")
+}
+
+func TestLoadRuntimeConfigInvalidValue(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_CHECK_ASG_POLICIES", "bacon")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_CHECK_ASG_POLICIES value: bacon")
+}
+
+func TestLoadRuntimeConfigSafeModeWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_SAFE_MODE_DECAY", "1h")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_SAFE_MODE_DECAY requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigPanicThresholdWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_PANIC_THRESHOLD", "5")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_PANIC_THRESHOLD requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigThrottleBackoffWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_THROTTLE_BACKOFF", "15m")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_THROTTLE_BACKOFF requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigTwoPhaseScaleDownWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_TWO_PHASE_SCALE_DOWN", "true")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_TWO_PHASE_SCALE_DOWN requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigPoolCacheWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_POOL_CACHE_STALENESS", "10m")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_POOL_CACHE_STALENESS requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigInvalidMaxConcurrentDrains(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_MAX_CONCURRENT_DRAINS", "0")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_MAX_CONCURRENT_DRAINS value: 0")
+}
+
+func TestLoadRuntimeConfigInvalidScaleDownDelayAnchor(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR", "launch")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR value: launch")
+}
+
+func TestLoadRuntimeConfigIdleScaleDownDelayAnchorWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR", "idle")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_SCALE_DOWN_DELAY_ANCHOR=idle requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigNegativeCredentialsExpiryBuffer(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("CLOUD_CREDENTIALS_EXPIRY_BUFFER", "-1m")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid CLOUD_CREDENTIALS_EXPIRY_BUFFER value: -1m0s")
+}
+
+func TestLoadRuntimeConfigInvalidProtectionTag(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_PROTECTION_TAG", "=true")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, `invalid AUTOSCALING_PROTECTION_TAG value: empty tag key in "=true"`)
+}
+
+func TestLoadRuntimeConfigMinScaleUpStepAboveMaxCreate(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_MAX_CREATE", "2")
+	t.Setenv("AUTOSCALING_MIN_SCALE_UP_STEP", "3")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_MIN_SCALE_UP_STEP can't exceed AUTOSCALING_MAX_CREATE")
+}
+
+func TestLoadRuntimeConfigRegionMismatch(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_REGION", "us-east-1")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_GROUP_ARN is in region eu-west-1, but AUTOSCALING_REGION is us-east-1")
+}
+
+func TestLoadRuntimeConfigRegionMatch(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_GROUP_ARN", "arn:aws:autoscaling:us-east-1:123456789012:autoScalingGroup:uuid:autoScalingGroupName/group")
+	t.Setenv("AUTOSCALING_REGION", "us-east-1")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, "us-east-1", cfg.AutoscalingRegion)
+}
+
+func TestLoadRuntimeConfigInvalidGhostWorkers(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_GHOST_WORKERS", "kill")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_GHOST_WORKERS value: kill")
+}
+
+func TestLoadRuntimeConfigEmergencyFloorWithoutExpiry(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_EMERGENCY_FLOOR", "0")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_EMERGENCY_FLOOR requires AUTOSCALING_EMERGENCY_FLOOR_UNTIL to be set")
+}
+
+func TestLoadRuntimeConfigEmergencyFloorWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_EMERGENCY_FLOOR", "0")
+	t.Setenv("AUTOSCALING_EMERGENCY_FLOOR_UNTIL", "2030-01-02T15:04:05Z")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_EMERGENCY_FLOOR requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigEmergencyFloor(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_EMERGENCY_FLOOR", "0")
+	t.Setenv("AUTOSCALING_EMERGENCY_FLOOR_UNTIL", "2030-01-02T15:04:05Z")
+	t.Setenv("AUTOSCALING_STATE_PARAMETER", "state")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+
+	floor, ok := cfg.EmergencyFloor(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	require.Equal(t, 0, floor)
+
+	_, ok = cfg.EmergencyFloor(time.Date(2030, 1, 3, 0, 0, 0, 0, time.UTC))
+	require.False(t, ok)
+}
+
+func TestLoadRuntimeConfigInvalidDecisionHookOnFailure(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_DECISION_HOOK_ON_FAILURE", "panic")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_DECISION_HOOK_ON_FAILURE value: panic")
+}
+
+func TestLoadRuntimeConfigNoOpLogIntervalWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_NO_OP_LOG_INTERVAL", "1h")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_NO_OP_LOG_INTERVAL requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigLaunchRetryBudgetWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_LAUNCH_RETRY_BUDGET", "3")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_LAUNCH_RETRY_BUDGET requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigInvalidUtilizationThreshold(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_UTILIZATION_THRESHOLD", "150")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_UTILIZATION_THRESHOLD value: 150")
+}
+
+func TestLoadRuntimeConfigInvalidDuringInstanceRefresh(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_DURING_INSTANCE_REFRESH", "pause")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_DURING_INSTANCE_REFRESH value: pause")
+}
+
+func TestLoadRuntimeConfigInvalidTargetBusyGain(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_TARGET_BUSY_PERCENT", "80")
+	t.Setenv("AUTOSCALING_TARGET_BUSY_PROPORTIONAL_GAIN", "1.5")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_TARGET_BUSY_PROPORTIONAL_GAIN value: 1.5")
+}
+
+func TestLoadRuntimeConfigTargetBusyIntegralGainWithoutState(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_TARGET_BUSY_PERCENT", "80")
+	t.Setenv("AUTOSCALING_TARGET_BUSY_INTEGRAL_GAIN", "0.1")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_TARGET_BUSY_INTEGRAL_GAIN requires AUTOSCALING_STATE_PARAMETER to be set")
+}
+
+func TestLoadRuntimeConfigBoundsScheduleFile(t *testing.T) {
+	const schedule = "- window: Mon-Fri 08:00-20:00\n  min_size: 2\n"
+
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_BOUNDS_SCHEDULE_FILE", writeConfigFile(t, schedule))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, schedule, cfg.AutoscalingBoundsSchedule)
+}
+
+func TestLoadRuntimeConfigBoundsScheduleAndFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_BOUNDS_SCHEDULE", `[{"window": "08:00-20:00", "min_size": 2}]`)
+	t.Setenv("AUTOSCALING_BOUNDS_SCHEDULE_FILE", writeConfigFile(t, "[]"))
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_BOUNDS_SCHEDULE can't be combined with AUTOSCALING_BOUNDS_SCHEDULE_FILE")
+}
+
+func TestLoadRuntimeConfigInvalidBoundsSchedule(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_BOUNDS_SCHEDULE", `[{"window": "08:00-20:00", "min_size": -2}]`)
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, `invalid AUTOSCALING_BOUNDS_SCHEDULE value: invalid bounds window "08:00-20:00": negative size`)
+}
+
+func TestLoadRuntimeConfigDoesNotRecoverDrainedWorkersByDefault(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.False(t, cfg.AutoscalingRecoverDrainedWorkers)
+}
+
+func TestLoadRuntimeConfigSuspendedScaleToMinWithoutSuspension(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_SUSPENDED_SCALE_TO_MIN", "true")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_SUSPENDED_SCALE_TO_MIN requires AUTOSCALING_HONOR_POOL_SUSPENSION to be set")
+}
+
+func TestLoadRuntimeConfigIncludesPausedRunsByDefault(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.True(t, cfg.AutoscalingIncludePausedRuns)
+}
+
+func TestLoadRuntimeConfigListsFromFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile+`
+SPACELIFT_SHARED_WORKER_POOL_IDS: [pool-1, pool-2]
+AUTOSCALING_PREWARM_SCHEDULE:
+  - Mon-Fri 08:30-10:00=5
+  - Sat,Sun 10:00-12:00=2
+AUTOSCALING_BUSINESS_HOURS:
+  - Mon-Fri 08:00-18:00
+  - Sat 10:00-14:00
+`))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, []string{"pool-1", "pool-2"}, cfg.SpaceliftSharedWorkerPoolIDs)
+	require.Equal(t, []string{"Mon-Fri 08:30-10:00=5", "Sat,Sun 10:00-12:00=2"}, cfg.AutoscalingPrewarmSchedule)
+	require.Equal(t, []string{"Mon-Fri 08:00-18:00", "Sat 10:00-14:00"}, cfg.AutoscalingBusinessHours)
+}
+
+func TestLoadRuntimeConfigNativeBoundsScheduleFromFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile+`
+AUTOSCALING_BOUNDS_SCHEDULE:
+  - window: Mon-Fri 08:00-20:00
+    min_size: 2
+    max_size: 20
+`))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+
+	schedule, err := internal.ParseBoundsSchedule(cfg.AutoscalingBoundsSchedule)
+	require.NoError(t, err)
+	require.Len(t, schedule, 1)
+	require.Equal(t, "Mon-Fri 08:00-20:00", schedule[0].Window)
+	require.Equal(t, 2, schedule[0].MinSize)
+	require.Equal(t, 20, *schedule[0].MaxSize)
+}
+
+func TestLoadRuntimeConfigUnquotedTimestampFromFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile+`
+AUTOSCALING_STATE_PARAMETER: state
+AUTOSCALING_EMERGENCY_FLOOR: 1
+AUTOSCALING_EMERGENCY_FLOOR_UNTIL: 2030-01-02T00:00:00Z
+`))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC), cfg.AutoscalingEmergencyFloorUntil.UTC())
+}
+
+func TestLoadRuntimeConfigInvalidCloudEventsSink(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_CLOUDEVENTS_SINK", "kafka")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_CLOUDEVENTS_SINK value: kafka")
+}
+
+func TestLoadRuntimeConfigInvalidMaxStrayPercent(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_MAX_STRAY_PERCENT", "120")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_MAX_STRAY_PERCENT value: 120")
+}
+
+func TestLoadRuntimeConfigInvalidRounding(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_SCALE_DOWN_ROUNDING", "up")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_SCALE_DOWN_ROUNDING value: unknown rounding mode up")
+}
+
+func TestLoadRuntimeConfigInvalidRunLabelExpression(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_RUN_LABEL_EXPRESSION", "team=infra AND")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, `invalid AUTOSCALING_RUN_LABEL_EXPRESSION value: invalid label expression "team=infra AND": unexpected end of expression`)
+}
+
+func TestLoadRuntimeConfigParsesRunLabelExpression(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_RUN_LABEL_EXPRESSION", "team=infra AND env=prod")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.NotNil(t, cfg.RunLabelExpression())
+	require.True(t, cfg.RunLabelExpression().Matches([]string{"team:infra", "env:prod"}))
+	require.False(t, cfg.RunLabelExpression().Matches([]string{"team:infra"}))
+}
+
+func TestLoadRuntimeConfigInvalidCloudAPITimeout(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("CLOUD_API_TIMEOUT", "-1s")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid CLOUD_API_TIMEOUT value: -1s")
+}
+
+func TestLoadRuntimeConfigInvalidRefreshMinHealthy(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_REFRESH_MIN_HEALTHY", "101")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_REFRESH_MIN_HEALTHY value: 101")
+}
+
+func TestLoadRuntimeConfigQuotaCodeWithoutVCPUs(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv("AUTOSCALING_VCPU_QUOTA_CODE", "L-1216C47A")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "AUTOSCALING_VCPU_QUOTA_CODE requires AUTOSCALING_INSTANCE_VCPUS to be set")
+}
+
+const profilesConfigFile = baseConfigFile + `
+profiles:
+  staging:
+    AUTOSCALING_MAX_KILL: 4
+    AUTOSCALING_MAX_CREATE: 2
+  prod:
+    AUTOSCALING_MAX_KILL: 10
+`
+
+func TestLoadRuntimeConfigProfileFromFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "staging")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 4, cfg.AutoscalingMaxKill)
+	require.Equal(t, 2, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigWithoutProfile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 3, cfg.AutoscalingMaxKill)
+	require.Equal(t, 1, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigProfileOverridesBaseEnv(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "prod")
+	t.Setenv("AUTOSCALING_MAX_KILL", "5")
+	t.Setenv("AUTOSCALING_MAX_CREATE", "6")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 10, cfg.AutoscalingMaxKill)
+	require.Equal(t, 6, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigPrefixedEnvOverridesProfileFile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "prod")
+	t.Setenv("AUTOSCALING_PROFILE_PROD_AUTOSCALING_MAX_KILL", "7")
+	t.Setenv("AUTOSCALING_PROFILE_STAGING_AUTOSCALING_MAX_CREATE", "8")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 7, cfg.AutoscalingMaxKill)
+	require.Equal(t, 1, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigProfileFromPrefixedEnvOnly(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "dev-eu")
+	t.Setenv("AUTOSCALING_PROFILE_DEV_EU_AUTOSCALING_MAX_CREATE", "9")
+
+	cfg, err := internal.LoadRuntimeConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9, cfg.AutoscalingMaxCreate)
+}
+
+func TestLoadRuntimeConfigProfileIgnoresUnprefixedEnv(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "prod")
+	t.Setenv("PROD_AUTOSCALING_MAX_CREATE", "9")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "unknown configuration profile prod")
+}
+
+func TestLoadRuntimeConfigUnknownProfile(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "qa")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "unknown configuration profile qa")
+}
+
+func TestLoadRuntimeConfigInvalidProfileName(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, profilesConfigFile))
+	t.Setenv(internal.ProfileEnvVar, "prod env")
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "invalid AUTOSCALING_PROFILE value: prod env")
+}
+
+func TestLoadRuntimeConfigInvalidProfileSection(t *testing.T) {
+	t.Setenv(internal.ConfigFileEnvVar, writeConfigFile(t, baseConfigFile+"profiles:\n  prod: 5\n"))
+
+	_, err := internal.LoadRuntimeConfig()
+	require.EqualError(t, err, "configuration profile prod must be a map")
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
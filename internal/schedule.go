@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	// The Lambda runtime does not ship with the timezone database, so let's
+	// embed it to be able to evaluate schedules in any timezone.
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeWindow is a recurring daily time window, optionally limited to some days
+// of the week. If the end is before the start, the window spans midnight and
+// belongs to the day it starts on.
+type TimeWindow struct {
+	Days  [7]bool
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeWindow parses a time window in the "[days ]HH:MM-HH:MM" format,
+// where days are a comma-separated list of weekdays or weekday ranges, eg.
+// "Mon-Fri 08:00-20:00" or "Sat,Sun 10:00-12:00". Without days, the window
+// applies every day.
+func ParseTimeWindow(in string) (window TimeWindow, err error) {
+	fields := strings.Fields(in)
+
+	var days, hours string
+
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for day := range window.Days {
+			window.Days[day] = true
+		}
+	case 2:
+		days, hours = fields[0], fields[1]
+		if window.Days, err = parseWeekdays(days); err != nil {
+			return window, fmt.Errorf("invalid time window %q: %w", in, err)
+		}
+	default:
+		return window, fmt.Errorf("invalid time window %q", in)
+	}
+
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return window, fmt.Errorf("invalid time window %q: missing end time", in)
+	}
+
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return window, fmt.Errorf("invalid time window %q: %w", in, err)
+	}
+
+	if window.End, err = parseTimeOfDay(end); err != nil {
+		return window, fmt.Errorf("invalid time window %q: %w", in, err)
+	}
+
+	return window, nil
+}
+
+// Contains checks whether the given time falls within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.Start <= w.End {
+		return w.Days[t.Weekday()] && sinceMidnight >= w.Start && sinceMidnight < w.End
+	}
+
+	// The window spans midnight, so the part after midnight belongs to the
+	// previous day.
+	if sinceMidnight >= w.Start {
+		return w.Days[t.Weekday()]
+	}
+
+	return sinceMidnight < w.End && w.Days[(t.Weekday()+6)%7]
+}
+
+func parseWeekdays(in string) (days [7]bool, err error) {
+	for _, part := range strings.Split(in, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday %q", from)
+		}
+
+		last := first
+		if isRange {
+			if last, ok = weekdays[strings.ToLower(to)]; !ok {
+				return days, fmt.Errorf("invalid weekday %q", to)
+			}
+		}
+
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseTimeOfDay(in string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(in, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time of day %q", in)
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time of day %q", in)
+	}
+
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", in)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// ParseTimeWindows parses a list of time windows, see ParseTimeWindow.
+func ParseTimeWindows(entries []string) ([]TimeWindow, error) {
+	var out []TimeWindow
+
+	for _, entry := range entries {
+		window, err := ParseTimeWindow(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, window)
+	}
+
+	return out, nil
+}
+
+// InScheduleTimezone converts the given time to the timezone schedules are
+// evaluated in.
+func InScheduleTimezone(cfg RuntimeConfig, t time.Time) time.Time {
+	// The timezone has been validated when loading the configuration.
+	location, err := time.LoadLocation(cfg.AutoscalingScheduleTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	return t.In(location)
+}
+
+// PrewarmWindow is a time window during which the pool should be kept at a
+// minimum size, ahead of known busy periods.
+type PrewarmWindow struct {
+	Window  TimeWindow
+	MinSize int
+}
+
+// ParsePrewarmSchedule parses pre-warm windows in the "[days ]HH:MM-HH:MM=size"
+// format, eg. "Mon-Fri 08:30-10:00=5".
+func ParsePrewarmSchedule(entries []string) ([]PrewarmWindow, error) {
+	var out []PrewarmWindow
+
+	for _, entry := range entries {
+		window, size, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pre-warm window %q: missing size", entry)
+		}
+
+		minSize, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || minSize < 0 {
+			return nil, fmt.Errorf("invalid pre-warm window %q: invalid size", entry)
+		}
+
+		parsed, err := ParseTimeWindow(window)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, PrewarmWindow{Window: parsed, MinSize: minSize})
+	}
+
+	return out, nil
+}
+
+// ScheduledBounds are the bounds of the pool during a window of the bounds
+// schedule. They apply within the limits of the ASG itself.
+type ScheduledBounds struct {
+	Window string `yaml:"window"`
+
+	// MinSize is the size the pool is kept at regardless of the demand.
+	MinSize int `yaml:"min_size"`
+
+	// MaxSize is the size the pool is never scaled up past, with idle workers
+	// above it removed. Unset means the ASG max size.
+	MaxSize *int `yaml:"max_size"`
+
+	// Reserve is the number of idle workers kept on top of the pending runs.
+	Reserve int `yaml:"reserve"`
+
+	window TimeWindow
+}
+
+// ParseBoundsSchedule parses a bounds schedule, given as a YAML (or JSON) list
+// of windows along with their bounds, eg.
+//
+//	[{"window": "Mon-Fri 08:00-20:00", "min_size": 2, "reserve": 1}]
+func ParseBoundsSchedule(in string) ([]ScheduledBounds, error) {
+	var out []ScheduledBounds
+
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(in)))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&out); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse bounds schedule: %w", err)
+	}
+
+	for i, entry := range out {
+		window, err := ParseTimeWindow(entry.Window)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.MinSize < 0 || entry.Reserve < 0 || (entry.MaxSize != nil && *entry.MaxSize < 0) {
+			return nil, fmt.Errorf("invalid bounds window %q: negative size", entry.Window)
+		}
+
+		if entry.MaxSize != nil && entry.MinSize > *entry.MaxSize {
+			return nil, fmt.Errorf("invalid bounds window %q: minimum size above the maximum size", entry.Window)
+		}
+
+		out[i].window = window
+	}
+
+	return out, nil
+}
+
+// ActiveBounds returns the bounds of the schedule window active at the given
+// time, if any. If multiple windows overlap, the first one listed wins, so the
+// more specific windows should come first.
+func ActiveBounds(cfg RuntimeConfig, now time.Time) *ScheduledBounds {
+	// The schedule has been validated when loading the configuration.
+	schedule, _ := ParseBoundsSchedule(cfg.AutoscalingBoundsSchedule)
+	now = InScheduleTimezone(cfg, now)
+
+	for _, entry := range schedule {
+		if entry.window.Contains(now) {
+			return &entry
+		}
+	}
+
+	return nil
+}
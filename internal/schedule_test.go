@@ -0,0 +1,184 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestSchedule(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	// 2023-06-05 was a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2023, time.June, 5+day, hour, minute, 0, 0, time.UTC)
+	}
+
+	g.Describe("ParseTimeWindow", func() {
+		var window internal.TimeWindow
+		var err error
+
+		g.Describe("with no days", func() {
+			g.BeforeEach(func() { window, err = internal.ParseTimeWindow("08:00-20:00") })
+
+			g.It("should apply every day", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(window.Contains(at(0, 8, 0))).To(BeTrue())
+				Expect(window.Contains(at(6, 19, 59))).To(BeTrue())
+				Expect(window.Contains(at(0, 20, 0))).To(BeFalse())
+				Expect(window.Contains(at(0, 7, 59))).To(BeFalse())
+			})
+		})
+
+		g.Describe("with a range of days", func() {
+			g.BeforeEach(func() { window, err = internal.ParseTimeWindow("Mon-Fri 08:00-20:00") })
+
+			g.It("should only apply on those days", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(window.Contains(at(0, 9, 0))).To(BeTrue())
+				Expect(window.Contains(at(4, 9, 0))).To(BeTrue())
+				Expect(window.Contains(at(5, 9, 0))).To(BeFalse())
+			})
+		})
+
+		g.Describe("with a list of days", func() {
+			g.BeforeEach(func() { window, err = internal.ParseTimeWindow("sat,sun 10:00-12:00") })
+
+			g.It("should only apply on those days", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(window.Contains(at(5, 11, 0))).To(BeTrue())
+				Expect(window.Contains(at(6, 11, 0))).To(BeTrue())
+				Expect(window.Contains(at(0, 11, 0))).To(BeFalse())
+			})
+		})
+
+		g.Describe("with a window spanning midnight", func() {
+			g.BeforeEach(func() { window, err = internal.ParseTimeWindow("Fri 22:00-02:00") })
+
+			g.It("should belong to the day it starts on", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(window.Contains(at(4, 23, 0))).To(BeTrue())
+				Expect(window.Contains(at(5, 1, 0))).To(BeTrue())
+				Expect(window.Contains(at(5, 2, 0))).To(BeFalse())
+				Expect(window.Contains(at(3, 23, 0))).To(BeFalse())
+				Expect(window.Contains(at(4, 1, 0))).To(BeFalse())
+			})
+		})
+
+		g.Describe("with invalid input", func() {
+			g.It("should return an error", func() {
+				for _, in := range []string{"", "08:00", "Mon-Bacon 08:00-10:00", "25:00-26:00", "08:60-09:00", "a b c"} {
+					_, err := internal.ParseTimeWindow(in)
+					Expect(err).To(HaveOccurred(), in)
+				}
+			})
+		})
+	})
+
+	g.Describe("ParsePrewarmSchedule", func() {
+		g.It("should parse the windows and their sizes", func() {
+			windows, err := internal.ParsePrewarmSchedule([]string{"Mon-Fri 08:30-10:00=5", "12:00-13:00=2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(windows).To(HaveLen(2))
+			Expect(windows[0].MinSize).To(Equal(5))
+			Expect(windows[0].Window.Contains(at(0, 9, 0))).To(BeTrue())
+			Expect(windows[1].MinSize).To(Equal(2))
+		})
+
+		g.It("should require a size", func() {
+			_, err := internal.ParsePrewarmSchedule([]string{"08:30-10:00"})
+			Expect(err).To(MatchError(`invalid pre-warm window "08:30-10:00": missing size`))
+		})
+
+		g.It("should reject an invalid size", func() {
+			_, err := internal.ParsePrewarmSchedule([]string{"08:30-10:00=-1"})
+			Expect(err).To(MatchError(`invalid pre-warm window "08:30-10:00=-1": invalid size`))
+		})
+	})
+
+	g.Describe("ParseBoundsSchedule", func() {
+		g.It("should parse the windows and their bounds", func() {
+			schedule, err := internal.ParseBoundsSchedule(`[{"window": "Mon-Fri 08:00-20:00", "min_size": 2, "max_size": 10, "reserve": 1}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schedule).To(HaveLen(1))
+			Expect(schedule[0].Window).To(Equal("Mon-Fri 08:00-20:00"))
+			Expect(schedule[0].MinSize).To(Equal(2))
+			Expect(*schedule[0].MaxSize).To(Equal(10))
+			Expect(schedule[0].Reserve).To(Equal(1))
+		})
+
+		g.It("should accept YAML", func() {
+			schedule, err := internal.ParseBoundsSchedule("- window: 20:00-08:00\n  max_size: 0\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schedule).To(HaveLen(1))
+			Expect(*schedule[0].MaxSize).To(Equal(0))
+		})
+
+		g.It("should accept an empty schedule", func() {
+			schedule, err := internal.ParseBoundsSchedule("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schedule).To(BeEmpty())
+		})
+
+		g.It("should reject unknown fields", func() {
+			_, err := internal.ParseBoundsSchedule(`[{"window": "08:00-20:00", "min": 2}]`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		g.It("should reject an invalid window", func() {
+			_, err := internal.ParseBoundsSchedule(`[{"window": "08:00", "min_size": 2}]`)
+			Expect(err).To(MatchError(`invalid time window "08:00": missing end time`))
+		})
+
+		g.It("should reject a negative size", func() {
+			_, err := internal.ParseBoundsSchedule(`[{"window": "08:00-20:00", "reserve": -1}]`)
+			Expect(err).To(MatchError(`invalid bounds window "08:00-20:00": negative size`))
+		})
+
+		g.It("should reject a minimum size above the maximum size", func() {
+			_, err := internal.ParseBoundsSchedule(`[{"window": "08:00-20:00", "min_size": 5, "max_size": 4}]`)
+			Expect(err).To(MatchError(`invalid bounds window "08:00-20:00": minimum size above the maximum size`))
+		})
+	})
+
+	g.Describe("ActiveBounds", func() {
+		cfg := internal.RuntimeConfig{
+			AutoscalingScheduleTimezone: "UTC",
+			AutoscalingBoundsSchedule: `[
+				{"window": "Mon 12:00-14:00", "min_size": 5},
+				{"window": "Mon-Fri 08:00-20:00", "min_size": 2, "reserve": 1},
+				{"window": "Mon-Fri 10:00-16:00", "min_size": 8}
+			]`,
+		}
+
+		g.It("should pick the matching window", func() {
+			Expect(internal.ActiveBounds(cfg, at(1, 9, 0)).MinSize).To(Equal(2))
+			Expect(internal.ActiveBounds(cfg, at(1, 9, 0)).Reserve).To(Equal(1))
+		})
+
+		g.It("should pick the first of the overlapping windows", func() {
+			Expect(internal.ActiveBounds(cfg, at(0, 13, 0)).MinSize).To(Equal(5))
+			Expect(internal.ActiveBounds(cfg, at(0, 15, 0)).MinSize).To(Equal(2))
+			Expect(internal.ActiveBounds(cfg, at(1, 13, 0)).MinSize).To(Equal(2))
+		})
+
+		g.It("should return nothing outside of all the windows", func() {
+			Expect(internal.ActiveBounds(cfg, at(0, 21, 0))).To(BeNil())
+			Expect(internal.ActiveBounds(cfg, at(5, 12, 0))).To(BeNil())
+			Expect(internal.ActiveBounds(internal.RuntimeConfig{}, at(0, 12, 0))).To(BeNil())
+		})
+
+		g.It("should evaluate the windows in the schedule timezone", func() {
+			local := cfg
+			local.AutoscalingScheduleTimezone = "Asia/Tokyo"
+
+			// 23:00 UTC on Sunday is 08:00 on Monday in Tokyo.
+			Expect(internal.ActiveBounds(local, at(-1, 23, 0)).MinSize).To(Equal(2))
+		})
+	})
+}
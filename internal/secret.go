@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go"
+
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+// GetAPIKeySecret reads the Spacelift API key secret from SSM. Transient
+// failures are retried up to maxRetries times, with the delay between the
+// attempts starting at backoff and doubling each time.
+func GetAPIKeySecret(ctx context.Context, client ifaces.SSM, name string, maxRetries int, backoff time.Duration) (secret string, err error) {
+	xray.Capture(ctx, "aws.ssm.secret", func(ctx context.Context) error {
+		var output *ssm.GetParameterOutput
+
+		for attempt := 0; ; attempt++ {
+			output, err = client.GetParameter(ctx, &ssm.GetParameterInput{
+				Name:           aws.String(name),
+				WithDecryption: aws.Bool(true),
+			})
+
+			if err == nil || !isRetryableSecretError(err) || attempt == maxRetries {
+				xray.AddMetadata(ctx, "attempts", attempt+1)
+				break
+			}
+
+			if err = sleepContext(ctx, backoff*time.Duration(1<<attempt)); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			err = fmt.Errorf("could not get Spacelift API key secret from SSM: %w", err)
+			return err
+		}
+
+		if output.Parameter == nil {
+			err = errors.New("could not find Spacelift API key secret in SSM")
+			return err
+		}
+
+		if output.Parameter.Value == nil {
+			err = errors.New("could not find Spacelift API key secret value in SSM")
+			return err
+		}
+
+		secret = *output.Parameter.Value
+
+		return nil
+	})
+
+	return
+}
+
+// isRetryableSecretError checks whether reading the secret may succeed if we
+// try again. Errors caused by the request itself, like a missing parameter or
+// missing permissions, won't go away on their own, unless it's throttling.
+func isRetryableSecretError(err error) bool {
+	if IsThrottlingError(err) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() != smithy.FaultClient
+	}
+
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
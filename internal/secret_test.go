@@ -0,0 +1,76 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+const secretName = "spacelift-secret"
+
+func secretOutput(value string) *ssm.GetParameterOutput {
+	return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: &value}}
+}
+
+func TestGetAPIKeySecretSucceedsOnRetry(t *testing.T) {
+	client := &ifaces.MockSSM{}
+	defer client.AssertExpectations(t)
+
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Fault: smithy.FaultClient}
+
+	client.On("GetParameter", mock.Anything, mock.MatchedBy(func(in *ssm.GetParameterInput) bool {
+		return *in.Name == secretName && *in.WithDecryption
+	})).Return(nil, throttled).Once()
+	client.On("GetParameter", mock.Anything, mock.Anything).Return(nil, errors.New("connection reset")).Once()
+	client.On("GetParameter", mock.Anything, mock.Anything).Return(secretOutput("bacon"), nil).Once()
+
+	secret, err := internal.GetAPIKeySecret(context.Background(), client, secretName, 2, 0)
+	require.NoError(t, err)
+	require.Equal(t, "bacon", secret)
+}
+
+func TestGetAPIKeySecretGivesUpAfterMaxRetries(t *testing.T) {
+	client := &ifaces.MockSSM{}
+	defer client.AssertExpectations(t)
+
+	client.On("GetParameter", mock.Anything, mock.Anything).Return(nil, errors.New("connection reset")).Times(3)
+
+	_, err := internal.GetAPIKeySecret(context.Background(), client, secretName, 2, 0)
+	require.EqualError(t, err, "could not get Spacelift API key secret from SSM: connection reset")
+}
+
+func TestGetAPIKeySecretFailsFastOnNonRetryableErrors(t *testing.T) {
+	for name, apiErr := range map[string]error{
+		"not found":     &ssmtypes.ParameterNotFound{Message: ptr("not found")},
+		"access denied": &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied", Fault: smithy.FaultClient},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &ifaces.MockSSM{}
+			defer client.AssertExpectations(t)
+
+			client.On("GetParameter", mock.Anything, mock.Anything).Return(nil, apiErr).Once()
+
+			_, err := internal.GetAPIKeySecret(context.Background(), client, secretName, 2, 0)
+			require.ErrorIs(t, err, apiErr)
+		})
+	}
+}
+
+func TestGetAPIKeySecretWithoutValue(t *testing.T) {
+	client := &ifaces.MockSSM{}
+	defer client.AssertExpectations(t)
+
+	client.On("GetParameter", mock.Anything, mock.Anything).Return(&ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{}}, nil).Once()
+
+	_, err := internal.GetAPIKeySecret(context.Background(), client, secretName, 2, 0)
+	require.EqualError(t, err, "could not find Spacelift API key secret value in SSM")
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/shurcooL/graphql"
+)
+
+// Categories of errors returned by the Spacelift API. Use errors.Is to check
+// which one a given error belongs to.
+var (
+	// ErrAuth means that the API key is invalid or lacks the permissions, and
+	// needs to be fixed by a human.
+	ErrAuth = errors.New("authentication error")
+
+	// ErrTransient means that the request failed for a reason which is likely
+	// to go away on its own, like a network issue or a server error.
+	ErrTransient = errors.New("transient error")
+
+	// ErrNotFound means that the requested resource does not exist, or is not
+	// visible to the API key.
+	ErrNotFound = errors.New("not found")
+)
+
+// spaceliftError attaches a category to an error returned by the Spacelift
+// API, while preserving its original message.
+type spaceliftError struct {
+	category error
+	err      error
+}
+
+func (e *spaceliftError) Error() string {
+	return e.err.Error()
+}
+
+func (e *spaceliftError) Unwrap() []error {
+	return []error{e.category, e.err}
+}
+
+// SpaceliftErrorClass returns a short name of the category of the given error,
+// suitable for metrics and alerting.
+func SpaceliftErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrAuth):
+		return "auth"
+	case errors.Is(err, ErrTransient):
+		return "transient"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	default:
+		return "unknown"
+	}
+}
+
+// IsTimeout checks whether the request failed because it took too long,
+// either by hitting the context deadline or a network timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// classifySpaceliftError attaches a category to an error returned by the
+// Spacelift API, based on the HTTP status code or the GraphQL error message.
+// Errors which can't be classified are returned as they are.
+func classifySpaceliftError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if category := spaceliftErrorCategory(err); category != nil {
+		return &spaceliftError{category: category, err: err}
+	}
+
+	return err
+}
+
+func spaceliftErrorCategory(err error) error {
+	var serverErr *graphql.ServerError
+	if errors.As(err, &serverErr) {
+		switch code := serverErr.StatusCode; {
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return ErrAuth
+		case code == http.StatusNotFound:
+			return ErrNotFound
+		case code == http.StatusTooManyRequests, code >= http.StatusInternalServerError:
+			return ErrTransient
+		default:
+			return nil
+		}
+	}
+
+	var responseErr *graphql.ResponseError
+	if errors.As(err, &responseErr) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrTransient
+	}
+
+	// GraphQL errors are only distinguishable by their messages.
+	switch message := strings.ToLower(err.Error()); {
+	case strings.Contains(message, "unauthorized"), strings.Contains(message, "forbidden"):
+		return ErrAuth
+	case strings.Contains(message, "not found"):
+		return ErrNotFound
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,80 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/shurcooL/graphql"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+func TestSpaceliftErrorClassMissingWorkerPool(t *testing.T) {
+	mockSpacelift := &ifaces.MockSpacelift{}
+	mockSpacelift.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	sut := &internal.Controller{Spacelift: mockSpacelift, SpaceliftWorkerPoolID: "pool"}
+
+	_, err := sut.GetWorkerPool(context.Background())
+
+	require.ErrorIs(t, err, internal.ErrNotFound)
+	require.EqualError(t, err, "worker pool not found or not accessible")
+}
+
+func TestSpaceliftErrorClass(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		err   error
+		class string
+	}{
+		{"unauthorized", &graphql.ServerError{StatusCode: http.StatusUnauthorized}, "auth"},
+		{"forbidden", &graphql.ServerError{StatusCode: http.StatusForbidden}, "auth"},
+		{"not found status", &graphql.ServerError{StatusCode: http.StatusNotFound}, "not_found"},
+		{"too many requests", &graphql.ServerError{StatusCode: http.StatusTooManyRequests}, "transient"},
+		{"server error", &graphql.ServerError{StatusCode: http.StatusBadGateway}, "transient"},
+		{"bad request", &graphql.ServerError{StatusCode: http.StatusBadRequest}, "unknown"},
+		{"network error", &graphql.ResponseError{Err: errors.New("connection reset")}, "transient"},
+		{"deadline exceeded", context.DeadlineExceeded, "transient"},
+		{"unauthorized message", errors.New("unauthorized"), "auth"},
+		{"not found message", errors.New("worker pool not found"), "not_found"},
+		{"other", errors.New("bacon"), "unknown"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSpacelift := &ifaces.MockSpacelift{}
+			mockSpacelift.On("Query", mock.Anything, mock.Anything, mock.Anything).Return(tt.err)
+
+			sut := &internal.Controller{Spacelift: mockSpacelift, SpaceliftWorkerPoolID: "pool"}
+
+			_, err := sut.GetWorkerPool(context.Background())
+
+			require.Equal(t, tt.class, internal.SpaceliftErrorClass(fmt.Errorf("wrapped: %w", err)))
+			require.ErrorIs(t, err, tt.err)
+			require.EqualError(t, err, "could not get Spacelift worker pool details: "+tt.err.Error())
+		})
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		err     error
+		timeout bool
+	}{
+		{"deadline exceeded", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), true},
+		{"network timeout", &url.Error{Op: "Post", URL: "https://example.com", Err: &net.DNSError{IsTimeout: true}}, true},
+		{"network error", &url.Error{Op: "Post", URL: "https://example.com", Err: &net.DNSError{}}, false},
+		{"other", errors.New("bacon"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.timeout, internal.IsTimeout(tt.err))
+		})
+	}
+}
@@ -2,6 +2,10 @@ package internal
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 )
@@ -13,33 +17,120 @@ type State struct {
 	WorkerPool *WorkerPool
 	ASG        *types.AutoScalingGroup
 
+	// QueueDepth is the depth of the external queue feeding runs into the
+	// worker pool, if one is configured.
+	QueueDepth int
+
+	// Utilization is the average resource utilization of the workers, as a
+	// percentage, if a utilization source is configured.
+	Utilization float64
+
+	// BusySetpointIntegral is the accumulated distance of the desired capacity
+	// from the busy worker setpoint, for the integral term of the controller.
+	BusySetpointIntegral float64
+
+	// Bounds are the bounds of the bounds schedule window active for the run,
+	// if any.
+	Bounds *ScheduledBounds
+
+	// InstanceQuota is the number of instances the account's service quota
+	// allows for, if known. Zero means no limit.
+	InstanceQuota int
+
+	// ReclaimNewestFirst makes the most recently added workers the first ones
+	// to be scaled down, rather than the oldest ones.
+	ReclaimNewestFirst bool
+
+	// HeartbeatStaleness is the age of the last heartbeat after which a worker
+	// is considered dead. Zero means we trust all the workers to be alive.
+	HeartbeatStaleness time.Duration
+
+	// PendingTermination holds the IDs of the workers drained by a two-phase
+	// scale-down. They were drained on purpose, so they're not considered to
+	// be left drained.
+	PendingTermination map[string]struct{}
+
+	// LastBusy maps the IDs of the workers to the last time the autoscaler saw
+	// them busy, as a Unix timestamp. With the idle anchor, the scale-down
+	// delay counts from then rather than from the creation of the worker.
+	LastBusy map[string]int64
+
+	// ProtectedInstances holds the IDs of the instances carrying the
+	// protection tag, whose workers are never scaled down.
+	ProtectedInstances map[InstanceID]struct{}
+
+	// MetadataKeys are the keys the identifiers of the ASG and the instance
+	// are read from in the metadata of the workers.
+	MetadataKeys MetadataKeys
+
 	inServiceInstanceIDs map[InstanceID]struct{}
 	workersByInstanceID  map[InstanceID]Worker
+	zonesByInstanceID    map[InstanceID]string
+	typesByInstanceID    map[InstanceID]string
+	instancesWithoutID   int
 }
 
-func NewState(workerPool *WorkerPool, asg *types.AutoScalingGroup) (*State, error) {
-	workersByInstanceID := make(map[InstanceID]Worker)
-	inServiceInstanceIDs := make(map[InstanceID]struct{})
+// NewCountsState creates a state for a worker pool whose workers can't be
+// matched to their instances, like the worker pool summary or the cached pool
+// state. None of the workers is matched to an instance, so no instance counts
+// as a stray, and the state is only fit for deciding on counts: scaling up,
+// or holding still.
+func NewCountsState(workerPool *WorkerPool, asg *types.AutoScalingGroup) (*State, error) {
+	if err := validateASG(asg); err != nil {
+		return nil, err
+	}
 
-	// Validate the ASG.
+	var instancesWithoutID int
+
+	for _, instance := range asg.Instances {
+		if instance.InstanceId == nil {
+			instancesWithoutID++
+		}
+	}
+
+	return &State{
+		WorkerPool:           workerPool,
+		ASG:                  asg,
+		inServiceInstanceIDs: make(map[InstanceID]struct{}),
+		workersByInstanceID:  make(map[InstanceID]Worker),
+		zonesByInstanceID:    make(map[InstanceID]string),
+		typesByInstanceID:    make(map[InstanceID]string),
+		instancesWithoutID:   instancesWithoutID,
+	}, nil
+}
+
+func validateASG(asg *types.AutoScalingGroup) error {
 	if asg.AutoScalingGroupName == nil {
-		return nil, fmt.Errorf("ASG name is not set")
+		return fmt.Errorf("ASG name is not set")
 	}
 
 	if asg.MinSize == nil {
-		return nil, fmt.Errorf("ASG minimum size is not set")
+		return fmt.Errorf("ASG minimum size is not set")
 	}
 
 	if asg.MaxSize == nil {
-		return nil, fmt.Errorf("ASG maximum size is not set")
+		return fmt.Errorf("ASG maximum size is not set")
 	}
 
 	if asg.DesiredCapacity == nil {
-		return nil, fmt.Errorf("ASG desired capacity is not set")
+		return fmt.Errorf("ASG desired capacity is not set")
+	}
+
+	return nil
+}
+
+func NewState(workerPool *WorkerPool, asg *types.AutoScalingGroup, keys MetadataKeys) (*State, error) {
+	workersByInstanceID := make(map[InstanceID]Worker)
+	inServiceInstanceIDs := make(map[InstanceID]struct{})
+	zonesByInstanceID := make(map[InstanceID]string)
+	typesByInstanceID := make(map[InstanceID]string)
+
+	if err := validateASG(asg); err != nil {
+		return nil, err
 	}
 
 	for _, worker := range workerPool.Workers {
-		groupID, instanceID, err := worker.InstanceIdentity()
+		groupID, instanceID, err := worker.InstanceIdentity(keys)
 
 		if err != nil {
 			return nil, err
@@ -52,7 +143,24 @@ func NewState(workerPool *WorkerPool, asg *types.AutoScalingGroup) (*State, erro
 		workersByInstanceID[instanceID] = worker
 	}
 
+	var instancesWithoutID int
+
 	for _, instance := range asg.Instances {
+		// This may happen for instances in transitional states, and there's
+		// nothing we can do with them until they get their IDs.
+		if instance.InstanceId == nil {
+			instancesWithoutID++
+			continue
+		}
+
+		if instance.AvailabilityZone != nil {
+			zonesByInstanceID[InstanceID(*instance.InstanceId)] = *instance.AvailabilityZone
+		}
+
+		if instance.InstanceType != nil {
+			typesByInstanceID[InstanceID(*instance.InstanceId)] = *instance.InstanceType
+		}
+
 		if instance.LifecycleState != types.LifecycleStateInService {
 			continue
 		}
@@ -63,23 +171,442 @@ func NewState(workerPool *WorkerPool, asg *types.AutoScalingGroup) (*State, erro
 	return &State{
 		WorkerPool:           workerPool,
 		ASG:                  asg,
+		MetadataKeys:         keys,
 		inServiceInstanceIDs: inServiceInstanceIDs,
 		workersByInstanceID:  workersByInstanceID,
+		zonesByInstanceID:    zonesByInstanceID,
+		typesByInstanceID:    typesByInstanceID,
+		instancesWithoutID:   instancesWithoutID,
 	}, nil
 }
 
-// IdleWorkers returns a list of workers that are not currently busy.
+// InstancesWithoutID returns the number of ASG instances which were skipped
+// because they have no ID yet.
+func (s *State) InstancesWithoutID() int {
+	return s.instancesWithoutID
+}
+
+// PendingRuns returns the number of pending runs which should drive scaling.
+// Paused runs (eg. awaiting approval) are included in the pending runs count
+// reported by Spacelift, but unless configured otherwise, we don't want to
+// provision workers for them since they would just sit idle. The depth of the
+// external queue, if any, is added on top. With a run label expression, only
+// the pending runs matching it count.
+func (s *State) PendingRuns(cfg RuntimeConfig) int {
+	pending := int(s.WorkerPool.PendingRuns)
+
+	if !cfg.AutoscalingIncludePausedRuns {
+		pending -= int(s.WorkerPool.PausedRuns)
+	}
+
+	if pending < 0 {
+		pending = 0
+	}
+
+	// The runs don't tell the paused ones apart, so the matching runs are
+	// capped at the number of the pending runs which aren't paused.
+	if expr := cfg.RunLabelExpression(); expr != nil {
+		if matching := s.matchingRuns(expr); matching < pending {
+			pending = matching
+		}
+	}
+
+	return pending + s.QueueDepth
+}
+
+// matchingRuns returns the number of pending runs whose labels match the
+// expression.
+func (s *State) matchingRuns(expr LabelExpression) int {
+	var count int
+
+	for _, run := range s.WorkerPool.Runs {
+		if expr.Matches(run.Labels) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// IdleWorkers returns a list of workers that are not currently busy and can
+// accept new runs. Drained workers can't, so they neither count towards the
+// idle capacity nor get picked for scaling down here; they're handled by the
+// recovery and reclaim of drained workers instead. Workers with stale
+// heartbeats can't accept runs either, even if they claim so.
 func (s *State) IdleWorkers() []Worker {
 	var out []Worker
 
+	now := time.Now()
+
 	for _, worker := range s.WorkerPool.Workers {
-		if worker.Busy {
+		if worker.Busy || worker.Drained || worker.HeartbeatStale(now, s.HeartbeatStaleness) {
+			continue
+		}
+
+		out = append(out, worker)
+	}
+
+	return out
+}
+
+// ScalableWorkers returns a list of idle workers which have been around for
+// long enough to be considered for scaling down.
+func (s *State) ScalableWorkers(cfg RuntimeConfig) []Worker {
+	if cfg.AutoscalingScaleDownDelay <= 0 {
+		return s.IdleWorkers()
+	}
+
+	delay := time.Duration(cfg.AutoscalingScaleDownDelay) * time.Minute
+	now := time.Now()
+
+	var out []Worker
+
+	for _, worker := range s.IdleWorkers() {
+		// A missing creation timestamp tells us nothing about the worker's age,
+		// so it's up to the configuration whether we can scale it down.
+		if worker.CreatedAt == 0 {
+			if cfg.AutoscalingScaleDownUnknownAge {
+				out = append(out, worker)
+			}
+			continue
+		}
+
+		// If the clocks are skewed the worker may appear to be created in the
+		// future. Treat it as created just now rather than never old enough.
+		createdAt := time.Unix(int64(worker.CreatedAt), 0)
+		if createdAt.After(now) {
+			createdAt = now
+		}
+
+		// The last busy time is only known to the granularity of the
+		// invocations, so the cooldown may end up to one interval early.
+		anchor := createdAt
+		if cfg.AutoscalingScaleDownDelayAnchor == ScaleDownDelayAnchorIdle {
+			if at, ok := s.LastBusy[worker.ID]; ok && time.Unix(at, 0).After(anchor) {
+				anchor = time.Unix(at, 0)
+			}
+		}
+
+		if now.Sub(anchor) < delay {
+			continue
+		}
+
+		out = append(out, worker)
+	}
+
+	return out
+}
+
+// ScaleDownCandidates returns up to count scalable workers to be removed,
+// oldest first. If a per-zone minimum is configured, workers are skipped if
+// removing them would leave their availability zone with fewer workers than
+// that. If instance type balancing is enabled, workers of the most common
+// instance types go first.
+func (s *State) ScaleDownCandidates(count int, cfg RuntimeConfig) []Worker {
+	idle := s.ScalableWorkers(cfg)
+	minPerZone := cfg.AutoscalingMinPerZone
+
+	if s.ReclaimNewestFirst {
+		reversed := make([]Worker, len(idle))
+		for i, worker := range idle {
+			reversed[len(idle)-1-i] = worker
+		}
+		idle = reversed
+	}
+
+	if cfg.AutoscalingBalanceInstanceTypes {
+		idle = s.balanceInstanceTypes(idle)
+	}
+
+	// Reclaimable drained workers and dead workers are the first to go, before
+	// we touch any of the healthy idle ones.
+	idle = s.withoutProtected(append(s.reclaimableWorkers(cfg), idle...))
+
+	if minPerZone <= 0 {
+		if count > len(idle) {
+			count = len(idle)
+		}
+		return idle[:count]
+	}
+
+	workersPerZone := make(map[string]int)
+	for instanceID := range s.workersByInstanceID {
+		if zone, ok := s.zonesByInstanceID[instanceID]; ok {
+			workersPerZone[zone]++
+		}
+	}
+
+	var out []Worker
+
+	for _, worker := range idle {
+		if len(out) == count {
+			break
+		}
+
+		_, instanceID, _ := worker.InstanceIdentity(s.MetadataKeys)
+
+		if zone, ok := s.zonesByInstanceID[instanceID]; ok {
+			if workersPerZone[zone] <= minPerZone {
+				continue
+			}
+			workersPerZone[zone]--
+		}
+
+		out = append(out, worker)
+	}
+
+	return out
+}
+
+// scaleDownBlockers tells which of the filters applied by ScaleDownCandidates
+// left no workers to remove, for when there are none.
+func (s *State) scaleDownBlockers(cfg RuntimeConfig) (gates []string, comment string) {
+	idle := append(s.reclaimableWorkers(cfg), s.ScalableWorkers(cfg)...)
+	if len(idle) == 0 {
+		if cfg.AutoscalingScaleDownDelay > 0 && len(s.IdleWorkers()) > 0 {
+			return []string{GateScaleDownDelay}, "no idle workers are eligible for scaling down yet"
+		}
+
+		return nil, "no idle workers to scale down"
+	}
+
+	unprotected := s.withoutProtected(idle)
+	if len(unprotected) == 0 {
+		return []string{GateProtection}, "all the idle workers are protected from termination"
+	}
+
+	if len(unprotected) < len(idle) {
+		gates = append(gates, GateProtection)
+	}
+
+	return append(gates, GateMinPerZone), "removing any of the idle workers would go below the per-zone minimum"
+}
+
+// balanceInstanceTypes orders the workers so that each one comes from the
+// instance type with the most workers left in the pool, had all the previous
+// ones been removed. Within a type the original order is kept.
+func (s *State) balanceInstanceTypes(workers []Worker) []Worker {
+	workersPerType := make(map[string]int)
+	for instanceID := range s.workersByInstanceID {
+		workersPerType[s.typesByInstanceID[instanceID]]++
+	}
+
+	remaining := append([]Worker(nil), workers...)
+	out := make([]Worker, 0, len(workers))
+
+	for len(remaining) > 0 {
+		next, nextType := 0, ""
+
+		for i, worker := range remaining {
+			_, instanceID, _ := worker.InstanceIdentity(s.MetadataKeys)
+			instanceType := s.typesByInstanceID[instanceID]
+
+			if i == 0 || workersPerType[instanceType] > workersPerType[nextType] {
+				next, nextType = i, instanceType
+			}
+		}
+
+		out = append(out, remaining[next])
+		workersPerType[nextType]--
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return out
+}
+
+// withoutProtected filters out the workers whose instances are protected.
+func (s *State) withoutProtected(workers []Worker) []Worker {
+	if len(s.ProtectedInstances) == 0 {
+		return workers
+	}
+
+	out := make([]Worker, 0, len(workers))
+
+	for _, worker := range workers {
+		_, instanceID, _ := worker.InstanceIdentity(s.MetadataKeys)
+
+		if _, protected := s.ProtectedInstances[instanceID]; !protected {
+			out = append(out, worker)
+		}
+	}
+
+	return out
+}
+
+// IsDeadlocked detects the situation in which no scaling action can help: the
+// ASG is at its maximum size, there are more pending runs than idle workers,
+// and some of the instances are stuck outside of the InService state.
+func (s *State) IsDeadlocked() bool {
+	atCapacity := *s.ASG.DesiredCapacity >= *s.ASG.MaxSize || len(s.WorkerPool.Workers) >= int(*s.ASG.MaxSize)
+	if !atCapacity {
+		return false
+	}
+
+	if int(s.WorkerPool.PendingRuns) <= len(s.IdleWorkers()) {
+		return false
+	}
+
+	for _, instance := range s.ASG.Instances {
+		if instance.LifecycleState != types.LifecycleStateInService {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxSizeBelowWorkers detects an ASG whose maximum size is lower than the
+// number of workers already in the pool. That's a misconfiguration rather
+// than the pool being at capacity, since the ASG could not have launched the
+// instances for these workers under its current settings.
+func (s *State) MaxSizeBelowWorkers() bool {
+	return len(s.WorkerPool.Workers) > int(*s.ASG.MaxSize)
+}
+
+// CaughtUp checks whether the pool has caught up with the demand: every
+// instance in the ASG has registered as a worker, and there are enough idle
+// workers for all the pending runs.
+func (s *State) CaughtUp(cfg RuntimeConfig) bool {
+	if mismatched, _ := s.Mismatch(); mismatched || len(s.StrayInstances()) > 0 {
+		return false
+	}
+
+	return s.PendingRuns(cfg) <= len(s.IdleWorkers())
+}
+
+// Mismatch checks whether the number of workers differs from the number of
+// instances in the ASG, and if so, whether it's likely to be transient. This
+// is the case when some of the instances are still changing their lifecycle
+// state, eg. launching or terminating, since their workers are expected to
+// (de)register shortly. A mismatch with all the instances in a steady state
+// is worth a human's attention.
+func (s *State) Mismatch() (mismatched, transient bool) {
+	if len(s.WorkerPool.Workers) == len(s.ASG.Instances) {
+		return false, false
+	}
+
+	for _, instance := range s.ASG.Instances {
+		switch instance.LifecycleState {
+		case types.LifecycleStateInService, types.LifecycleStateStandby, types.LifecycleStateDetached, types.LifecycleStateTerminated:
+			continue
+		default:
+			return true, true
+		}
+	}
+
+	return true, false
+}
+
+// ReclaimableDrainedWorkers returns the drained workers which should be removed
+// before any idle ones when scaling down, oldest first. These are only the
+// idle drained workers with instances still in service, and only if such
+// workers are configured to be reclaimed.
+func (s *State) ReclaimableDrainedWorkers(cfg RuntimeConfig) []Worker {
+	if !cfg.AutoscalingReclaimDrainedWorkers {
+		return nil
+	}
+
+	out := s.LeftDrainedWorkers()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt < out[j].CreatedAt
+	})
+
+	return out
+}
+
+// StaleWorkers returns the workers which are not busy, but whose heartbeats are
+// stale. They are most likely dead, so they should be removed, oldest first.
+func (s *State) StaleWorkers() []Worker {
+	var out []Worker
+
+	now := time.Now()
+
+	for _, worker := range s.WorkerPool.Workers {
+		if !worker.Busy && worker.HeartbeatStale(now, s.HeartbeatStaleness) {
+			out = append(out, worker)
+		}
+	}
+
+	return out
+}
+
+// reclaimableWorkers returns the workers which are not available capacity and
+// should be removed before any idle ones: the reclaimable drained workers,
+// followed by the stale ones.
+func (s *State) reclaimableWorkers(cfg RuntimeConfig) []Worker {
+	out := s.ReclaimableDrainedWorkers(cfg)
+
+	seen := make(map[string]struct{}, len(out))
+	for _, worker := range out {
+		seen[worker.ID] = struct{}{}
+	}
+
+	for _, worker := range s.StaleWorkers() {
+		if _, ok := seen[worker.ID]; !ok {
+			out = append(out, worker)
+		}
+	}
+
+	return out
+}
+
+// LeftDrainedWorkers returns a list of idle drained workers whose instances are
+// still in service. We only drain workers right before terminating them, so
+// these are normally left behind by a failed undrain of a busy worker. Workers
+// pending termination after a two-phase scale-down are not included.
+func (s *State) LeftDrainedWorkers() []Worker {
+	var out []Worker
+
+	for instanceID := range s.inServiceInstanceIDs {
+		worker, ok := s.workersByInstanceID[instanceID]
+		if !ok || !worker.Drained || worker.Busy {
+			continue
+		}
+
+		if _, pending := s.PendingTermination[worker.ID]; pending {
 			continue
 		}
 
 		out = append(out, worker)
 	}
 
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+
+	return out
+}
+
+// GhostWorkers returns the workers whose instances are not in the ASG at all,
+// typically because they were terminated out of band. They can't be doing any
+// useful work, but Spacelift keeps them around until they time out. Drained
+// workers are not included, as these are the ones whose instances were
+// detached but not terminated.
+func (s *State) GhostWorkers() []Worker {
+	instanceIDs := make(map[InstanceID]struct{}, len(s.ASG.Instances))
+	for _, instance := range s.ASG.Instances {
+		// If we can't tell all the instances in the ASG apart, we can't tell
+		// which ones are missing from it either.
+		if instance.InstanceId == nil {
+			return nil
+		}
+
+		instanceIDs[InstanceID(*instance.InstanceId)] = struct{}{}
+	}
+
+	var out []Worker
+
+	for instanceID, worker := range s.workersByInstanceID {
+		if worker.Drained {
+			continue
+		}
+
+		if _, ok := instanceIDs[instanceID]; !ok {
+			out = append(out, worker)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+
 	return out
 }
 
@@ -93,14 +620,145 @@ func (s *State) StrayInstances() []string {
 		}
 	}
 
-	res = append(res, s.detachedNotTerminatedInstances()...)
+	res = append(res, s.DetachedNotTerminatedInstances()...)
 
 	return res
 }
 
-func (s *State) detachedNotTerminatedInstances() []string {
+// RemoveInstance updates the state after the instance has been detached from
+// the ASG with its desired capacity decremented, so that decisions can still
+// be made in the same invocation.
+func (s *State) RemoveInstance(instanceID string) {
+	instances := make([]types.Instance, 0, len(s.ASG.Instances))
+
+	for _, instance := range s.ASG.Instances {
+		if instance.InstanceId != nil && *instance.InstanceId == instanceID {
+			continue
+		}
+
+		instances = append(instances, instance)
+	}
+
+	if len(instances) < len(s.ASG.Instances) {
+		desired := *s.ASG.DesiredCapacity - 1
+		s.ASG.DesiredCapacity = &desired
+	}
+
+	s.ASG.Instances = instances
+
+	delete(s.inServiceInstanceIDs, InstanceID(instanceID))
+	delete(s.zonesByInstanceID, InstanceID(instanceID))
+	delete(s.typesByInstanceID, InstanceID(instanceID))
+}
+
+// StraysExceedPercent returns whether the share of instances classified as
+// strays is greater than the given percentage. That many strays at once is
+// more likely to indicate a classification problem than real strays.
+func (s *State) StraysExceedPercent(strays []string, maxPercent int) bool {
+	total := len(s.inServiceInstanceIDs) + len(s.DetachedNotTerminatedInstances())
+	if total == 0 {
+		return false
+	}
+
+	return len(strays)*100 > maxPercent*total
+}
+
+// The Auto Scaling processes the autoscaler relies on. Either of them can be
+// suspended on the ASG, typically for maintenance.
+const (
+	ProcessLaunch    = "Launch"
+	ProcessTerminate = "Terminate"
+)
+
+// ProcessSuspended checks whether the given Auto Scaling process is suspended
+// on the ASG.
+func (s *State) ProcessSuspended(process string) bool {
+	for _, suspended := range s.ASG.SuspendedProcesses {
+		if suspended.ProcessName != nil && *suspended.ProcessName == process {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SuspendedProcessFor returns the suspended Auto Scaling process which scaling
+// in the given direction relies on, if any. Scaling up needs the ASG to launch
+// instances, and scaling down is held off while terminations are suspended.
+func (s *State) SuspendedProcessFor(direction ScalingDirection) (string, bool) {
+	var process string
+
+	switch direction {
+	case ScalingDirectionUp:
+		process = ProcessLaunch
+	case ScalingDirectionDown:
+		process = ProcessTerminate
+	default:
+		return "", false
+	}
+
+	return process, s.ProcessSuspended(process)
+}
+
+// OutdatedInstances returns a list of in-service instance IDs which were
+// launched using a different launch template or launch configuration than the
+// one currently set on the ASG.
+func (s *State) OutdatedInstances() []string {
+	var res []string
+
+	for _, instance := range s.ASG.Instances {
+		if instance.InstanceId == nil || instance.LifecycleState != types.LifecycleStateInService {
+			continue
+		}
+
+		if s.isOutdated(instance) {
+			res = append(res, *instance.InstanceId)
+		}
+	}
+
+	return res
+}
+
+func (s *State) isOutdated(instance types.Instance) bool {
+	if s.ASG.LaunchConfigurationName != nil {
+		return instance.LaunchConfigurationName == nil || *instance.LaunchConfigurationName != *s.ASG.LaunchConfigurationName
+	}
+
+	expected := s.ASG.LaunchTemplate
+	if expected == nil && s.ASG.MixedInstancesPolicy != nil && s.ASG.MixedInstancesPolicy.LaunchTemplate != nil {
+		expected = s.ASG.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+
+	actual := instance.LaunchTemplate
+	if expected == nil || actual == nil {
+		return false
+	}
+
+	if expected.LaunchTemplateId != nil && actual.LaunchTemplateId != nil && *expected.LaunchTemplateId != *actual.LaunchTemplateId {
+		return true
+	}
+
+	// Symbolic versions like $Latest or $Default can't be resolved without
+	// asking EC2, so we only compare explicit version numbers.
+	if expected.Version == nil || actual.Version == nil || strings.HasPrefix(*expected.Version, "$") {
+		return false
+	}
+
+	return *expected.Version != *actual.Version
+}
+
+// DetachedNotTerminatedInstances returns a list of instance IDs of drained
+// workers whose instances are no longer part of the ASG. This happens when the
+// instance was detached, but could not be terminated.
+func (s *State) DetachedNotTerminatedInstances() []string {
 	instanceIDs := make(map[InstanceID]struct{})
 	for _, instance := range s.ASG.Instances {
+		// If we can't tell all the instances in the ASG apart, we can't tell
+		// which ones are missing from it either.
+		if instance.InstanceId == nil {
+			return nil
+		}
+
 		instanceIDs[InstanceID(*instance.InstanceId)] = struct{}{}
 	}
 
@@ -118,24 +776,203 @@ func (s *State) detachedNotTerminatedInstances() []string {
 	return res
 }
 
-func (s *State) Decide(maxCreate, maxKill int) Decision {
+func (s *State) Decide(cfg RuntimeConfig) Decision {
+	maxCreate, maxKill := cfg.AutoscalingMaxCreate, s.maxKill(cfg)
+	minStep := cfg.AutoscalingMinScaleUpStep
+
 	if len(s.WorkerPool.Workers) != len(s.ASG.Instances) {
 		return Decision{
 			ScalingDirection: ScalingDirectionNone,
 			Comments:         []string{"number of workers does not match the number of instances in the ASG"},
+			SuppressedBy:     []string{GateMismatch},
 		}
 	}
 
 	idle := s.IdleWorkers()
 
-	difference := int(s.WorkerPool.PendingRuns) - len(idle)
+	// A suspended worker pool will not receive any runs, so there is no point
+	// in adding workers to it. Optionally we can also release the idle ones.
+	if s.WorkerPool.Suspended {
+		if !cfg.AutoscalingSuspendedScaleToMin || len(idle) == 0 {
+			return Decision{
+				ScalingDirection: ScalingDirectionNone,
+				Comments:         []string{"worker pool is suspended"},
+				SuppressedBy:     []string{GatePoolSuspended},
+			}
+		}
+
+		decision := s.determineScaleDown(cfg, len(idle), maxKill, int(*s.ASG.MinSize))
+		decision.Comments = append([]string{"worker pool is suspended"}, decision.Comments...)
+
+		return decision
+	}
+
+	pending := s.PendingRuns(cfg)
+	minSize := int(*s.ASG.MinSize)
+
+	if s.Bounds != nil {
+		pending += s.Bounds.Reserve
+	}
+
+	difference := pending - len(idle)
+
+	// The schedule can call for a smaller pool than the ASG allows for, in
+	// which case the idle workers above the scheduled size are removed. Busy
+	// workers can't be, so they're left until they finish their runs.
+	if maxSize, ok := s.scheduledMaxSize(); ok {
+		excess := len(s.WorkerPool.Workers) - maxSize
+		if removable := len(idle) + len(s.reclaimableWorkers(cfg)); excess > removable {
+			excess = removable
+		}
+
+		if excess > 0 && excess > -difference {
+			decision := s.determineScaleDown(cfg, excess, maxKill, minSize)
+			decision.Comments = append([]string{fmt.Sprintf("shrinking the pool to the scheduled maximum size of %d", maxSize)}, decision.Comments...)
+
+			return decision
+		}
+	}
+
+	if s.Bounds != nil && s.Bounds.MinSize > minSize {
+		minSize = s.Bounds.MinSize
+
+		if missing := minSize - len(s.WorkerPool.Workers); missing > 0 && missing > difference {
+			decision := s.determineScaleUp(missing, maxCreate, minStep)
+			decision.Comments = append([]string{fmt.Sprintf("holding the pool at the scheduled minimum size of %d", minSize)}, decision.Comments...)
+
+			return decision
+		}
+	}
+
+	// Ahead of known busy periods, the pool should be kept warm regardless of
+	// the current demand.
+	if prewarm := s.PrewarmSize(cfg, time.Now()); prewarm > minSize {
+		minSize = prewarm
+
+		if missing := minSize - len(s.WorkerPool.Workers); missing > 0 && missing > difference {
+			decision := s.determineScaleUp(missing, maxCreate, minStep)
+			decision.Comments = append([]string{fmt.Sprintf("pre-warming the pool to %d workers", minSize)}, decision.Comments...)
+
+			return decision
+		}
+	}
+
+	// A fully saturated pool is likely to see runs queuing up soon, so we can
+	// add some headroom before they do.
+	if buffer := cfg.AutoscalingSaturationBuffer; buffer > 0 && s.saturated(cfg, idle) {
+		decision := s.determineScaleUp(buffer, maxCreate, minStep)
+		decision.Comments = append([]string{fmt.Sprintf("all workers are busy, adding a buffer of %d workers", buffer)}, decision.Comments...)
+
+		return decision
+	}
+
+	// Resource-heavy runs can saturate the workers without many runs queuing
+	// up, so the utilization can call for more workers than the pending runs.
+	// As long as there are idle workers though, new runs aren't short of any.
+	if threshold := cfg.AutoscalingUtilizationThreshold; threshold > 0 && len(idle) == 0 && s.Utilization > float64(threshold) {
+		if extra := s.utilizationDeficit(cfg, threshold); extra > difference {
+			decision := s.determineScaleUp(extra, maxCreate, minStep)
+			decision.Comments = append([]string{fmt.Sprintf("average worker utilization of %.0f%% is above the threshold of %d%%", s.Utilization, threshold)}, decision.Comments...)
+
+			return decision
+		}
+	}
+
+	drained := len(s.ReclaimableDrainedWorkers(cfg))
+	reclaimable := len(s.reclaimableWorkers(cfg))
+
+	if cfg.AutoscalingAbsoluteTarget {
+		return s.determineAbsoluteTarget(cfg, pending, len(idle), reclaimable, minSize, maxCreate, minStep, maxKill)
+	}
+
+	if cfg.AutoscalingTargetBusyPercent > 0 {
+		return s.determineBusySetpoint(cfg, len(idle), reclaimable, minSize, maxCreate, minStep, maxKill)
+	}
+
+	// Drained workers with live instances and dead workers are wasted
+	// capacity, so they are removed on top of any idle surplus.
+	if reclaimable > 0 && difference <= 0 {
+		var comments []string
+
+		if drained > 0 {
+			comments = append(comments, fmt.Sprintf("reclaiming %d drained workers", drained))
+		}
+
+		if stale := reclaimable - drained; stale > 0 {
+			comments = append(comments, fmt.Sprintf("reclaiming %d workers with stale heartbeats", stale))
+		}
+
+		decision := s.determineScaleDown(cfg, reclaimable-difference, maxKill, minSize)
+		decision.Comments = append(comments, decision.Comments...)
+
+		return decision
+	}
 
 	if difference > 0 {
-		return s.determineScaleUp(difference, maxCreate)
+		return s.determineScaleUp(difference, maxCreate, minStep)
 	}
 
 	if difference < 0 {
-		return s.determineScaleDown(-difference, maxKill)
+		return s.determineScaleDown(cfg, -difference, maxKill, minSize)
+	}
+
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         []string{"autoscaling group exactly at the right size"},
+	}
+}
+
+// saturated checks whether all the workers in the pool are busy while there
+// are no pending runs.
+func (s *State) saturated(cfg RuntimeConfig, idle []Worker) bool {
+	return len(s.WorkerPool.Workers) > 0 && len(idle) == 0 && s.PendingRuns(cfg) == 0
+}
+
+// utilizationDeficit returns the number of workers to add for the same load to
+// bring the average utilization back down to the threshold, rounded like the
+// other fractional scale-up sizes.
+func (s *State) utilizationDeficit(cfg RuntimeConfig, threshold int) int {
+	workers := len(s.WorkerPool.Workers)
+
+	return cfg.ScaleUpRounding().Round(float64(workers)*s.Utilization/float64(threshold)) - workers
+}
+
+// determineAbsoluteTarget sets the desired capacity of the ASG to exactly the
+// number of busy workers plus the number of pending runs, rather than adding
+// the difference to the current desired capacity. This way capacity which is
+// still being launched is not counted twice. Reclaimable drained workers and
+// dead workers are neither busy nor idle, so they are removed along with the
+// idle ones.
+func (s *State) determineAbsoluteTarget(cfg RuntimeConfig, pending, idle, reclaimable, minSize, maxCreate, minStep, maxKill int) Decision {
+	target := len(s.WorkerPool.Workers) - idle - reclaimable + pending
+
+	if target < minSize {
+		target = minSize
+	}
+
+	if maxSize, _ := s.maxSize(); target > maxSize {
+		target = maxSize
+	}
+
+	delta := target - int(*s.ASG.DesiredCapacity)
+
+	if delta > 0 {
+		decision := s.determineScaleUp(delta, maxCreate, minStep)
+		decision.Comments = append([]string{fmt.Sprintf("targeting desired capacity of %d", target)}, decision.Comments...)
+
+		return decision
+	}
+
+	// Only idle and reclaimable workers can be removed.
+	if delta = -delta; delta > idle+reclaimable {
+		delta = idle + reclaimable
+	}
+
+	if delta > 0 {
+		decision := s.determineScaleDown(cfg, delta, maxKill, minSize)
+		decision.Comments = append([]string{fmt.Sprintf("targeting desired capacity of %d", target)}, decision.Comments...)
+
+		return decision
 	}
 
 	return Decision{
@@ -144,11 +981,132 @@ func (s *State) Decide(maxCreate, maxKill int) Decision {
 	}
 }
 
-func (s *State) determineScaleUp(missingWorkers, maxCreate int) Decision {
-	if len(s.WorkerPool.Workers) >= int(*s.ASG.MaxSize) {
+// BusySetpointError returns how far the desired capacity of the ASG is from
+// the capacity at which the target share of the workers would be busy, with
+// the pending runs counted as busy and the capacity rounded like a scale-up
+// size. It's positive when workers are missing.
+func (s *State) BusySetpointError(cfg RuntimeConfig) float64 {
+	var busy int
+	for _, worker := range s.WorkerPool.Workers {
+		if worker.Busy {
+			busy++
+		}
+	}
+
+	setpoint := float64((busy+s.PendingRuns(cfg))*100) / float64(cfg.AutoscalingTargetBusyPercent)
+
+	return float64(cfg.ScaleUpRounding().Round(setpoint)) - float64(*s.ASG.DesiredCapacity)
+}
+
+// determineBusySetpoint steers the desired capacity of the ASG towards the
+// busy worker setpoint with a proportional-integral controller. Each run only
+// closes part of the distance, which converges without the oscillation of
+// stepping by the whole difference. The adjustment is at least one worker, so
+// that the setpoint is always reached, and the target is kept within the size
+// of the pool regardless of the gains. Like with the absolute target, only
+// idle and reclaimable workers can be removed.
+func (s *State) determineBusySetpoint(cfg RuntimeConfig, idle, reclaimable, minSize, maxCreate, minStep, maxKill int) Decision {
+	distance := s.BusySetpointError(cfg)
+	adjustment := cfg.AutoscalingTargetBusyProportionalGain*distance + cfg.AutoscalingTargetBusyIntegralGain*s.BusySetpointIntegral
+
+	// The adjustment is rounded like any other fractional scaling size.
+	delta := cfg.ScaleUpRounding().Round(adjustment)
+	if adjustment < 0 {
+		delta = -cfg.ScaleDownRounding().Round(-adjustment)
+	}
+
+	if delta == 0 && distance != 0 {
+		delta = int(math.Copysign(1, distance))
+	}
+
+	desired := int(*s.ASG.DesiredCapacity)
+	target := desired + delta
+
+	if target < minSize {
+		target = minSize
+	}
+
+	if maxSize, _ := s.maxSize(); target > maxSize {
+		target = maxSize
+	}
+
+	comment := fmt.Sprintf("steering towards %d%% busy workers, with %.0f workers to the setpoint", cfg.AutoscalingTargetBusyPercent, distance)
+
+	if delta = target - desired; delta > 0 {
+		decision := s.determineScaleUp(delta, maxCreate, minStep)
+		decision.Comments = append([]string{comment}, decision.Comments...)
+
+		return decision
+	}
+
+	if delta = -delta; delta > idle+reclaimable {
+		delta = idle + reclaimable
+	}
+
+	if delta > 0 {
+		decision := s.determineScaleDown(cfg, delta, maxKill, minSize)
+		decision.Comments = append([]string{comment}, decision.Comments...)
+
+		return decision
+	}
+
+	return Decision{
+		ScalingDirection: ScalingDirectionNone,
+		Comments:         []string{comment, "autoscaling group at the busy worker setpoint"},
+	}
+}
+
+// maxSize returns the maximum size of the pool, which is the ASG max size
+// unless the scheduled maximum size or the instance quota is lower. The second
+// return value tells whether the quota is the binding limit.
+func (s *State) maxSize() (int, bool) {
+	maxSize := int(*s.ASG.MaxSize)
+
+	if scheduled, ok := s.scheduledMaxSize(); ok {
+		maxSize = scheduled
+	}
+
+	if s.InstanceQuota > 0 && s.InstanceQuota < maxSize {
+		return s.InstanceQuota, true
+	}
+
+	return maxSize, false
+}
+
+// scheduledMaxSize returns the maximum size of the pool from the bounds
+// schedule, if it's lower than the ASG max size.
+func (s *State) scheduledMaxSize() (int, bool) {
+	if s.Bounds == nil || s.Bounds.MaxSize == nil || *s.Bounds.MaxSize >= int(*s.ASG.MaxSize) {
+		return 0, false
+	}
+
+	return *s.Bounds.MaxSize, true
+}
+
+// determineScaleUp adds the missing workers, but no more than maxCreate of
+// them, and no fewer than minStep, within the maximum size of the pool.
+func (s *State) determineScaleUp(missingWorkers, maxCreate, minStep int) Decision {
+	maxSize, quotaBound := s.maxSize()
+
+	// Unlike the ASG max size, a lower limit such as the instance quota can
+	// already be exceeded by the desired capacity, leaving no room to add to.
+	atLowerLimit := maxSize < int(*s.ASG.MaxSize) && int(*s.ASG.DesiredCapacity) >= maxSize
+
+	if len(s.WorkerPool.Workers) >= maxSize || atLowerLimit {
+		comment, gate := "autoscaling group is already at maximum size", GateMaxSize
+		if quotaBound {
+			gate = GateInstanceQuota
+			comment = fmt.Sprintf("autoscaling group is already at the instance quota of %d", maxSize)
+		} else if scheduled, ok := s.scheduledMaxSize(); ok && scheduled == maxSize {
+			comment = fmt.Sprintf("autoscaling group is already at the scheduled maximum size of %d", maxSize)
+		} else if s.MaxSizeBelowWorkers() {
+			comment = fmt.Sprintf("autoscaling group maximum size of %d is below the %d workers in the pool", maxSize, len(s.WorkerPool.Workers))
+		}
+
 		return Decision{
 			ScalingDirection: ScalingDirectionNone,
-			Comments:         []string{"autoscaling group is already at maximum size"},
+			Comments:         []string{comment},
+			SuppressedBy:     []string{gate},
 		}
 	}
 
@@ -159,9 +1117,14 @@ func (s *State) determineScaleUp(missingWorkers, maxCreate int) Decision {
 		missingWorkers = maxCreate
 	}
 
-	newASGCapacity := *s.ASG.DesiredCapacity + int32(missingWorkers)
+	if missingWorkers < minStep {
+		comments = append(comments, fmt.Sprintf("need %d workers, but scaling up by at least %d", missingWorkers, minStep))
+		missingWorkers = minStep
+	}
+
+	newASGCapacity := int(*s.ASG.DesiredCapacity) + missingWorkers
 
-	if newASGCapacity <= *s.ASG.MaxSize {
+	if newASGCapacity <= maxSize {
 		return Decision{
 			ScalingDirection: ScalingDirectionUp,
 			ScalingSize:      missingWorkers,
@@ -169,18 +1132,108 @@ func (s *State) determineScaleUp(missingWorkers, maxCreate int) Decision {
 		}
 	}
 
+	comment := "adding workers to match pending runs, up to the ASG max size"
+	if quotaBound {
+		comment = fmt.Sprintf("adding workers to match pending runs, up to the instance quota of %d", maxSize)
+	} else if scheduled, ok := s.scheduledMaxSize(); ok && scheduled == maxSize {
+		comment = fmt.Sprintf("adding workers to match pending runs, up to the scheduled maximum size of %d", maxSize)
+	}
+
 	return Decision{
 		ScalingDirection: ScalingDirectionUp,
-		ScalingSize:      int(*s.ASG.MaxSize - *s.ASG.DesiredCapacity),
-		Comments:         append(comments, "adding workers to match pending runs, up to the ASG max size"),
+		ScalingSize:      maxSize - int(*s.ASG.DesiredCapacity),
+		Comments:         append(comments, comment),
 	}
 }
 
-func (s *State) determineScaleDown(extraWorkers, maxKill int) Decision {
-	if len(s.WorkerPool.Workers) <= int(*s.ASG.MinSize) {
+// DecideOffHours decides how to scale the pool outside of business hours, when
+// it should be held at a fixed low size. We never scale up at this point, but
+// idle workers above that size can be removed.
+func (s *State) DecideOffHours(cfg RuntimeConfig) Decision {
+	const comment = "outside business hours"
+
+	if mismatched, _ := s.Mismatch(); mismatched {
+		return Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         []string{"number of workers does not match the number of instances in the ASG"},
+			SuppressedBy:     []string{GateMismatch},
+		}
+	}
+
+	extra := len(s.WorkerPool.Workers) - cfg.AutoscalingOffHoursSize
+	if idle := len(s.IdleWorkers()); extra > idle {
+		extra = idle
+	}
+
+	if extra <= 0 {
+		return Decision{
+			ScalingDirection: ScalingDirectionNone,
+			Comments:         []string{comment, "holding the pool steady"},
+			SuppressedBy:     []string{GateOffHours},
+		}
+	}
+
+	minSize := int(*s.ASG.MinSize)
+	if cfg.AutoscalingOffHoursSize > minSize {
+		minSize = cfg.AutoscalingOffHoursSize
+	}
+
+	decision := s.determineScaleDown(cfg, extra, s.maxKill(cfg), minSize)
+	decision.Comments = append([]string{comment}, decision.Comments...)
+
+	return decision
+}
+
+// PrewarmSize returns the minimum size of the pool required by the pre-warm
+// windows active at the given time. If multiple windows overlap, the largest
+// size wins.
+func (s *State) PrewarmSize(cfg RuntimeConfig, now time.Time) int {
+	// The schedule has been validated when loading the configuration.
+	windows, _ := ParsePrewarmSchedule(cfg.AutoscalingPrewarmSchedule)
+	now = InScheduleTimezone(cfg, now)
+
+	var size int
+
+	for _, window := range windows {
+		if window.Window.Contains(now) && window.MinSize > size {
+			size = window.MinSize
+		}
+	}
+
+	return size
+}
+
+// maxKill returns the maximum number of workers to remove in a single run,
+// which is the lower of the absolute and the percentage limits. The
+// percentage limit always allows removing at least one worker, otherwise
+// small pools would never scale down.
+func (s *State) maxKill(cfg RuntimeConfig) int {
+	maxKill := cfg.AutoscalingMaxKill
+
+	if cfg.AutoscalingMaxKillPercent > 0 {
+		byPercent := cfg.ScaleDownRounding().Round(float64(len(s.WorkerPool.Workers)*cfg.AutoscalingMaxKillPercent) / 100)
+		if byPercent < 1 {
+			byPercent = 1
+		}
+
+		if byPercent < maxKill {
+			maxKill = byPercent
+		}
+	}
+
+	return maxKill
+}
+
+// determineScaleDown removes the extra workers, but no more than maxKill of
+// them, and only down to the minimum size. It also never asks for more
+// workers than there are candidates to remove, so that the decision matches
+// what the scale-down will actually do.
+func (s *State) determineScaleDown(cfg RuntimeConfig, extraWorkers, maxKill, minSize int) Decision {
+	if len(s.WorkerPool.Workers) <= minSize {
 		return Decision{
 			ScalingDirection: ScalingDirectionNone,
 			Comments:         []string{"autoscaling group is already at minimum size"},
+			SuppressedBy:     []string{GateMinSize},
 		}
 	}
 
@@ -191,11 +1244,26 @@ func (s *State) determineScaleDown(extraWorkers, maxKill int) Decision {
 		extraWorkers = maxKill
 	}
 
-	if overMinimum := int(*s.ASG.DesiredCapacity - *s.ASG.MinSize); extraWorkers > overMinimum {
-		comments = append(comments, fmt.Sprintf("need to kill %d workers, but can't get below minimum size of %d", extraWorkers, *s.ASG.MinSize))
+	if overMinimum := int(*s.ASG.DesiredCapacity) - minSize; extraWorkers > overMinimum {
+		comments = append(comments, fmt.Sprintf("need to kill %d workers, but can't get below minimum size of %d", extraWorkers, minSize))
 		extraWorkers = overMinimum
 	}
 
+	if eligible := len(s.ScaleDownCandidates(extraWorkers, cfg)); extraWorkers > eligible {
+		if eligible == 0 {
+			gates, comment := s.scaleDownBlockers(cfg)
+
+			return Decision{
+				ScalingDirection: ScalingDirectionNone,
+				Comments:         append(comments, comment),
+				SuppressedBy:     gates,
+			}
+		}
+
+		comments = append(comments, fmt.Sprintf("need to kill %d workers, but only %d are eligible", extraWorkers, eligible))
+		extraWorkers = eligible
+	}
+
 	return Decision{
 		ScalingDirection: ScalingDirectionDown,
 		ScalingSize:      extraWorkers,
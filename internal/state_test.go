@@ -2,7 +2,9 @@ package internal_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/franela/goblin"
@@ -46,13 +48,1020 @@ func TestState_StrayInstances(t *testing.T) {
 		},
 	}
 
-	state, err := internal.NewState(workerPool, asg)
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
 	require.NoError(t, err)
 
 	strayInstances := state.StrayInstances()
 	assert.Equal(t, []string{failedToTerminateInstanceID}, strayInstances)
 }
 
+func TestState_CustomMetadataKeys(t *testing.T) {
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(2)),
+		DesiredCapacity:      nullable(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("instance"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+	workerPool := &internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "1", Metadata: mustJSON(map[string]any{"my_asg": "group", "my_instance": "instance"})},
+		},
+	}
+
+	_, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.Error(t, err)
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{Group: "my_asg", Instance: "my_instance"})
+	require.NoError(t, err)
+	assert.Empty(t, state.StrayInstances())
+}
+
+func TestState_GhostWorkers(t *testing.T) {
+	const asgName = "asg-name"
+
+	state, err := internal.NewState(&internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "live", CreatedAt: 1, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "live"})},
+			{ID: "terminating", CreatedAt: 2, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "terminating"})},
+			{ID: "ghost-2", CreatedAt: 4, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "gone-2"})},
+			{ID: "ghost-1", CreatedAt: 3, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "gone-1"})},
+			{ID: "detached", CreatedAt: 5, Drained: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "detached"})},
+		},
+	}, &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("live"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("terminating"), LifecycleState: types.LifecycleStateTerminating},
+		},
+	}, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	var ids []string
+	for _, worker := range state.GhostWorkers() {
+		ids = append(ids, worker.ID)
+	}
+
+	assert.Equal(t, []string{"ghost-1", "ghost-2"}, ids)
+}
+
+func TestState_RemoveInstance(t *testing.T) {
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(2)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("instance"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("stray"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+	workerPool := &internal.WorkerPool{
+		Workers: []internal.Worker{{
+			Metadata: mustJSON(map[string]any{"asg_id": "group", "instance_id": "instance"}),
+		}},
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"stray"}, state.StrayInstances())
+
+	state.RemoveInstance("stray")
+	assert.Empty(t, state.StrayInstances())
+	assert.Len(t, state.ASG.Instances, 1)
+	assert.Equal(t, int32(1), *state.ASG.DesiredCapacity)
+
+	// Removing an instance which is not in the ASG changes nothing.
+	state.RemoveInstance("other")
+	assert.Equal(t, int32(1), *state.ASG.DesiredCapacity)
+}
+
+func TestState_SuspendedProcessFor(t *testing.T) {
+	state := &internal.State{ASG: &types.AutoScalingGroup{
+		SuspendedProcesses: []types.SuspendedProcess{
+			{ProcessName: nullable("AZRebalance")},
+			{ProcessName: nullable(internal.ProcessTerminate)},
+		},
+	}}
+
+	_, suspended := state.SuspendedProcessFor(internal.ScalingDirectionUp)
+	assert.False(t, suspended)
+
+	process, suspended := state.SuspendedProcessFor(internal.ScalingDirectionDown)
+	assert.True(t, suspended)
+	assert.Equal(t, internal.ProcessTerminate, process)
+
+	_, suspended = state.SuspendedProcessFor(internal.ScalingDirectionNone)
+	assert.False(t, suspended)
+}
+
+func TestState_OutdatedInstances(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(1)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(3)),
+		LaunchTemplate: &types.LaunchTemplateSpecification{
+			LaunchTemplateId: nullable("lt-1"),
+			Version:          nullable("3"),
+		},
+		Instances: []types.Instance{
+			{
+				InstanceId:     nullable("current"),
+				LifecycleState: types.LifecycleStateInService,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: nullable("lt-1"),
+					Version:          nullable("3"),
+				},
+			},
+			{
+				InstanceId:     nullable("old-version"),
+				LifecycleState: types.LifecycleStateInService,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: nullable("lt-1"),
+					Version:          nullable("2"),
+				},
+			},
+			{
+				InstanceId:     nullable("old-template"),
+				LifecycleState: types.LifecycleStateInService,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: nullable("lt-0"),
+					Version:          nullable("3"),
+				},
+			},
+			{
+				InstanceId:     nullable("terminating"),
+				LifecycleState: types.LifecycleStateTerminating,
+				LaunchTemplate: &types.LaunchTemplateSpecification{
+					LaunchTemplateId: nullable("lt-1"),
+					Version:          nullable("1"),
+				},
+			},
+		},
+	}
+
+	state, err := internal.NewState(&internal.WorkerPool{}, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"old-version", "old-template"}, state.OutdatedInstances())
+}
+
+func TestState_LeftDrainedWorkers(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(1)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("active"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("left-drained"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("terminating"), LifecycleState: types.LifecycleStateTerminating},
+		},
+	}
+	workerPool := &internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "active", Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "active"})},
+			{ID: "left-drained", Drained: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "left-drained"})},
+			{ID: "terminating", Drained: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "terminating"})},
+		},
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	leftDrained := state.LeftDrainedWorkers()
+	require.Len(t, leftDrained, 1)
+	assert.Equal(t, "left-drained", leftDrained[0].ID)
+}
+
+func TestState_DecideReclaimsDrainedWorkers(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("busy"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("idle"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("drained"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+	workerPool := &internal.WorkerPool{
+		PendingRuns: 1,
+		Workers: []internal.Worker{
+			{ID: "busy", Busy: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "busy"})},
+			{ID: "idle", Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "idle"})},
+			{ID: "drained", Drained: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "drained"})},
+		},
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxKill: 5}
+
+	// By default, the drained worker is neither capacity nor surplus.
+	decision := state.Decide(cfg)
+	assert.Equal(t, internal.ScalingDirectionNone, decision.ScalingDirection)
+
+	cfg.AutoscalingReclaimDrainedWorkers = true
+
+	decision = state.Decide(cfg)
+	assert.Equal(t, internal.ScalingDirectionDown, decision.ScalingDirection)
+	assert.Equal(t, 1, decision.ScalingSize)
+	assert.Equal(t, []string{"reclaiming 1 drained workers", "removing idle workers"}, decision.Comments)
+
+	candidates := state.ScaleDownCandidates(decision.ScalingSize, cfg)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "drained", candidates[0].ID)
+
+	cfg.AutoscalingAbsoluteTarget = true
+
+	decision = state.Decide(cfg)
+	assert.Equal(t, internal.ScalingDirectionDown, decision.ScalingDirection)
+	assert.Equal(t, 1, decision.ScalingSize)
+}
+
+func TestState_StaleHeartbeatWorkersAreUnavailable(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("busy"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("idle"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("stale"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+
+	now := time.Now()
+	workerPool := &internal.WorkerPool{
+		PendingRuns: 1,
+		Workers: []internal.Worker{
+			{ID: "busy", Busy: true, LastSeenAt: int32(now.Unix()), Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "busy"})},
+			{ID: "idle", LastSeenAt: int32(now.Unix()), Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "idle"})},
+			{ID: "stale", LastSeenAt: int32(now.Add(-time.Hour).Unix()), Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "stale"})},
+		},
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	cfg := internal.RuntimeConfig{AutoscalingMaxCreate: 5, AutoscalingMaxKill: 5}
+
+	// Without the check, the stale worker counts as idle capacity.
+	assert.Len(t, state.IdleWorkers(), 2)
+	assert.Empty(t, state.StaleWorkers())
+
+	state.HeartbeatStaleness = 10 * time.Minute
+
+	idle := state.IdleWorkers()
+	require.Len(t, idle, 1)
+	assert.Equal(t, "idle", idle[0].ID)
+
+	stale := state.StaleWorkers()
+	require.Len(t, stale, 1)
+	assert.Equal(t, "stale", stale[0].ID)
+
+	decision := state.Decide(cfg)
+	assert.Equal(t, internal.ScalingDirectionDown, decision.ScalingDirection)
+	assert.Equal(t, 1, decision.ScalingSize)
+	assert.Equal(t, []string{"reclaiming 1 workers with stale heartbeats", "removing idle workers"}, decision.Comments)
+
+	candidates := state.ScaleDownCandidates(decision.ScalingSize, cfg)
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "stale", candidates[0].ID)
+
+	// With more pending runs, the stale worker is not counted as capacity.
+	workerPool.PendingRuns = 2
+
+	decision = state.Decide(cfg)
+	assert.Equal(t, internal.ScalingDirectionUp, decision.ScalingDirection)
+	assert.Equal(t, 1, decision.ScalingSize)
+}
+
+func TestState_DecideForSharedWorkerPools(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(10)),
+		DesiredCapacity:      nullable(int32(3)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("a-idle"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("b-busy"), LifecycleState: types.LifecycleStateInService},
+			{InstanceId: nullable("b-idle"), LifecycleState: types.LifecycleStateInService},
+		},
+	}
+
+	// On its own, the first pool has an idle worker to spare.
+	primary := &internal.WorkerPool{
+		Workers: []internal.Worker{
+			{ID: "a-idle", Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "a-idle"})},
+		},
+	}
+	shared := &internal.WorkerPool{
+		PendingRuns: 4,
+		Workers: []internal.Worker{
+			{ID: "b-busy", Busy: true, Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "b-busy"})},
+			{ID: "b-idle", Metadata: mustJSON(map[string]any{"asg_id": asgName, "instance_id": "b-idle"})},
+		},
+	}
+
+	state, err := internal.NewState(internal.MergeWorkerPools(primary, shared), asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	// Together, the pools have 4 pending runs and 2 idle workers.
+	decision := state.Decide(internal.RuntimeConfig{AutoscalingMaxCreate: 5})
+	assert.Equal(t, internal.ScalingDirectionUp, decision.ScalingDirection)
+	assert.Equal(t, 2, decision.ScalingSize)
+}
+
+func TestState_EffectiveConfig(t *testing.T) {
+	const asgName = "asg-name"
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(1)),
+		MaxSize:              nullable(int32(10)),
+		DesiredCapacity:      nullable(int32(4)),
+	}
+	workerPool := &internal.WorkerPool{PendingRuns: 3, PausedRuns: 1}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	state.InstanceQuota = 8
+
+	now := time.Now().UTC()
+	cfg := internal.RuntimeConfig{
+		Profile:                    "staging",
+		AutoscalingMaxCreate:       2,
+		AutoscalingMaxKill:         5,
+		AutoscalingMaxKillPercent:  50,
+		AutoscalingOffHoursSize:    2,
+		AutoscalingPrewarmSchedule: []string{now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04") + "=6"},
+	}
+
+	workerPool.Workers = make([]internal.Worker, 4)
+
+	assert.Equal(t, internal.EffectiveConfig{
+		Profile:       "staging",
+		MinSize:       6,
+		MaxSize:       8,
+		MaxCreate:     2,
+		MaxKill:       2,
+		PrewarmSize:   6,
+		PendingRuns:   2,
+		InstanceQuota: 8,
+	}, state.EffectiveConfig(cfg, now, false))
+
+	assert.Equal(t, internal.EffectiveConfig{
+		Profile:       "staging",
+		OffHours:      true,
+		MinSize:       2,
+		MaxSize:       8,
+		MaxKill:       2,
+		PendingRuns:   2,
+		InstanceQuota: 8,
+	}, state.EffectiveConfig(cfg, now, true))
+}
+
+func TestState_PendingRunsMatchingLabelExpression(t *testing.T) {
+	workerPool := &internal.WorkerPool{
+		PendingRuns: 4,
+		Runs: []internal.PendingRun{
+			{ID: "1", Labels: []string{"team:infra", "env:prod"}},
+			{ID: "2", Labels: []string{"team:infra", "env:dev"}},
+			{ID: "3", Labels: []string{"team:data", "env:prod"}},
+			{ID: "4"},
+		},
+	}
+
+	state, err := internal.NewState(workerPool, &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(10)),
+		DesiredCapacity:      nullable(int32(0)),
+	}, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	state.QueueDepth = 1
+
+	for expression, expected := range map[string]int{
+		"":                                  5,
+		"team=infra":                        3,
+		"team=infra AND env=prod":           2,
+		"team=infra OR env=prod":            4,
+		"team=data AND (env=dev OR env=qa)": 1,
+	} {
+		cfg := internal.RuntimeConfig{AutoscalingRunLabelExpression: expression}
+
+		assert.Equal(t, expected, state.PendingRuns(cfg), "expression %q", expression)
+	}
+
+	decision := state.Decide(internal.RuntimeConfig{
+		AutoscalingMaxCreate:          5,
+		AutoscalingRunLabelExpression: "env=prod",
+	})
+	assert.Equal(t, internal.ScalingDirectionUp, decision.ScalingDirection)
+	assert.Equal(t, 3, decision.ScalingSize)
+}
+
+func TestState_PendingRunsMatchingLabelExpressionExcludePaused(t *testing.T) {
+	workerPool := &internal.WorkerPool{
+		PendingRuns: 3,
+		PausedRuns:  2,
+		Runs: []internal.PendingRun{
+			{ID: "1", Labels: []string{"team:infra"}},
+			{ID: "2", Labels: []string{"team:infra"}},
+			{ID: "3", Labels: []string{"team:infra"}},
+		},
+	}
+
+	state, err := internal.NewState(workerPool, &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(10)),
+		DesiredCapacity:      nullable(int32(0)),
+	}, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, state.PendingRuns(internal.RuntimeConfig{AutoscalingRunLabelExpression: "team=infra"}))
+	assert.Equal(t, 3, state.PendingRuns(internal.RuntimeConfig{
+		AutoscalingRunLabelExpression: "team=infra",
+		AutoscalingIncludePausedRuns:  true,
+	}))
+}
+
+func TestState_MaxKillPercentRounding(t *testing.T) {
+	for _, tt := range []struct {
+		rounding internal.Rounding
+		maxKill  int
+	}{
+		{rounding: "", maxKill: 2},
+		{rounding: internal.RoundingFloor, maxKill: 2},
+		{rounding: internal.RoundingCeil, maxKill: 3},
+		{rounding: internal.RoundingNearest, maxKill: 3},
+	} {
+		var workers []internal.Worker
+		for i := 0; i < 5; i++ {
+			workers = append(workers, internal.Worker{
+				ID:       fmt.Sprintf("worker-%d", i),
+				Metadata: fmt.Sprintf(`{"asg_id": "group", "instance_id": "instance-%d"}`, i),
+			})
+		}
+
+		state, err := internal.NewState(&internal.WorkerPool{Workers: workers}, &types.AutoScalingGroup{
+			AutoScalingGroupName: nullable("group"),
+			MinSize:              nullable(int32(0)),
+			MaxSize:              nullable(int32(10)),
+			DesiredCapacity:      nullable(int32(5)),
+		}, internal.MetadataKeys{})
+		require.NoError(t, err)
+
+		// Half of the 5 workers is 2.5.
+		cfg := internal.RuntimeConfig{
+			AutoscalingMaxKill:           5,
+			AutoscalingMaxKillPercent:    50,
+			AutoscalingScaleDownRounding: tt.rounding,
+		}
+
+		assert.Equal(t, tt.maxKill, state.EffectiveConfig(cfg, time.Now(), false).MaxKill, "rounding %q", tt.rounding)
+	}
+}
+
+func TestState_MinScaleUpStep(t *testing.T) {
+	for name, tt := range map[string]struct {
+		pending int32
+		maxSize int32
+		size    int
+	}{
+		"deficit below the step": {pending: 1, maxSize: 10, size: 3},
+		"deficit above the step": {pending: 4, maxSize: 10, size: 4},
+		"clamped to max size":    {pending: 1, maxSize: 4, size: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state, err := internal.NewState(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+					{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+				},
+				PendingRuns: tt.pending,
+			}, &types.AutoScalingGroup{
+				AutoScalingGroupName: nullable("group"),
+				MinSize:              nullable(int32(0)),
+				MaxSize:              nullable(tt.maxSize),
+				DesiredCapacity:      nullable(int32(2)),
+				Instances: []types.Instance{
+					{InstanceId: nullable("instance-1")},
+					{InstanceId: nullable("instance-2")},
+				},
+			}, internal.MetadataKeys{})
+			require.NoError(t, err)
+
+			decision := state.Decide(internal.RuntimeConfig{AutoscalingMaxCreate: 5, AutoscalingMinScaleUpStep: 3})
+
+			assert.Equal(t, internal.ScalingDirectionUp, decision.ScalingDirection)
+			assert.Equal(t, tt.size, decision.ScalingSize)
+		})
+	}
+}
+
+func TestState_ScaleDownCandidatesBalanceInstanceTypes(t *testing.T) {
+	const asgName = "asg-name"
+
+	// The two oldest workers are the only ones on c5.large.
+	instanceTypes := []string{"c5.large", "c5.large", "m5.large", "m5.large", "m5.large", "m5.large"}
+
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable(asgName),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(10)),
+		DesiredCapacity:      nullable(int32(len(instanceTypes))),
+	}
+	workerPool := &internal.WorkerPool{}
+
+	for i, instanceType := range instanceTypes {
+		instanceID := fmt.Sprintf("instance-%d", i)
+
+		asg.Instances = append(asg.Instances, types.Instance{
+			InstanceId:     nullable(instanceID),
+			InstanceType:   nullable(instanceType),
+			LifecycleState: types.LifecycleStateInService,
+		})
+		workerPool.Workers = append(workerPool.Workers, internal.Worker{
+			ID:        fmt.Sprintf("worker-%d", i),
+			CreatedAt: int32(i + 1),
+			Metadata:  mustJSON(map[string]any{"asg_id": asgName, "instance_id": instanceID}),
+		})
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	ids := func(workers []internal.Worker) (out []string) {
+		for _, worker := range workers {
+			out = append(out, worker.ID)
+		}
+		return out
+	}
+
+	cfg := internal.RuntimeConfig{}
+
+	// Oldest first, which would leave only m5.large instances.
+	assert.Equal(t, []string{"worker-0", "worker-1"}, ids(state.ScaleDownCandidates(2, cfg)))
+
+	cfg.AutoscalingBalanceInstanceTypes = true
+
+	assert.Equal(t, []string{"worker-2", "worker-3"}, ids(state.ScaleDownCandidates(2, cfg)))
+
+	// Once the types are even, they take turns, oldest first.
+	assert.Equal(t, []string{"worker-2", "worker-3", "worker-0", "worker-4"}, ids(state.ScaleDownCandidates(4, cfg)))
+}
+
+func TestState_DecideScalingFromZero(t *testing.T) {
+	for name, tt := range map[string]struct {
+		pending   int32
+		maxSize   int32
+		maxCreate int
+		direction internal.ScalingDirection
+		size      int
+	}{
+		"no pending runs":      {pending: 0, maxSize: 5, maxCreate: 5, direction: internal.ScalingDirectionNone},
+		"first instance":       {pending: 1, maxSize: 5, maxCreate: 5, direction: internal.ScalingDirectionUp, size: 1},
+		"all the pending runs": {pending: 3, maxSize: 5, maxCreate: 5, direction: internal.ScalingDirectionUp, size: 3},
+		"capped by max create": {pending: 3, maxSize: 5, maxCreate: 1, direction: internal.ScalingDirectionUp, size: 1},
+		"capped by max size":   {pending: 3, maxSize: 2, maxCreate: 5, direction: internal.ScalingDirectionUp, size: 2},
+		"max size of zero":     {pending: 3, maxSize: 0, maxCreate: 5, direction: internal.ScalingDirectionNone},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state, err := internal.NewState(&internal.WorkerPool{PendingRuns: tt.pending}, &types.AutoScalingGroup{
+				AutoScalingGroupName: nullable("group"),
+				MinSize:              nullable(int32(0)),
+				MaxSize:              nullable(tt.maxSize),
+				DesiredCapacity:      nullable(int32(0)),
+			}, internal.MetadataKeys{})
+			require.NoError(t, err)
+
+			assert.Empty(t, state.StrayInstances())
+
+			decision := state.Decide(internal.RuntimeConfig{AutoscalingMaxCreate: tt.maxCreate})
+
+			assert.Equal(t, tt.direction, decision.ScalingDirection)
+			assert.Equal(t, tt.size, decision.ScalingSize)
+		})
+	}
+}
+
+func TestState_DecideWhileFirstInstanceLaunches(t *testing.T) {
+	// The first instance has been launched, but its worker has not registered
+	// yet, so it must not be launched again.
+	state, err := internal.NewState(&internal.WorkerPool{PendingRuns: 1}, &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(0)),
+		MaxSize:              nullable(int32(5)),
+		DesiredCapacity:      nullable(int32(1)),
+		Instances: []types.Instance{
+			{InstanceId: nullable("instance"), LifecycleState: types.LifecycleStatePending},
+		},
+	}, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	assert.Empty(t, state.StrayInstances())
+
+	decision := state.Decide(internal.RuntimeConfig{AutoscalingMaxCreate: 5})
+	assert.Equal(t, internal.ScalingDirectionNone, decision.ScalingDirection)
+}
+
+func TestState_DecideScaleDownOnlyEligibleWorkers(t *testing.T) {
+	old := int32(time.Now().Add(-time.Hour).Unix())
+	young := int32(time.Now().Add(-time.Minute).Unix())
+
+	for name, tt := range map[string]struct {
+		createdAt []int32
+		direction internal.ScalingDirection
+		size      int
+	}{
+		"all idle workers too young":  {createdAt: []int32{young, young, young}, direction: internal.ScalingDirectionNone},
+		"some idle workers too young": {createdAt: []int32{old, young, young}, direction: internal.ScalingDirectionDown, size: 1},
+		"all idle workers old enough": {createdAt: []int32{old, old, old}, direction: internal.ScalingDirectionDown, size: 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			asg := &types.AutoScalingGroup{
+				AutoScalingGroupName: nullable("group"),
+				MinSize:              nullable(int32(0)),
+				MaxSize:              nullable(int32(10)),
+				DesiredCapacity:      nullable(int32(len(tt.createdAt))),
+			}
+			workerPool := &internal.WorkerPool{}
+
+			for i, createdAt := range tt.createdAt {
+				instanceID := fmt.Sprintf("instance-%d", i)
+
+				asg.Instances = append(asg.Instances, types.Instance{
+					InstanceId:     nullable(instanceID),
+					LifecycleState: types.LifecycleStateInService,
+				})
+				workerPool.Workers = append(workerPool.Workers, internal.Worker{
+					ID:        fmt.Sprintf("worker-%d", i),
+					CreatedAt: createdAt,
+					Metadata:  mustJSON(map[string]any{"asg_id": "group", "instance_id": instanceID}),
+				})
+			}
+
+			state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+			require.NoError(t, err)
+
+			cfg := internal.RuntimeConfig{AutoscalingMaxKill: 5, AutoscalingScaleDownDelay: 10}
+
+			decision := state.Decide(cfg)
+
+			assert.Equal(t, tt.direction, decision.ScalingDirection)
+			assert.Equal(t, tt.size, decision.ScalingSize)
+			assert.Len(t, state.ScaleDownCandidates(decision.ScalingSize, cfg), tt.size)
+		})
+	}
+}
+
+func TestState_DecideOnUtilization(t *testing.T) {
+	for name, tt := range map[string]struct {
+		utilization float64
+		pending     int32
+		idle        bool
+		rounding    internal.Rounding
+		direction   internal.ScalingDirection
+		size        int
+	}{
+		"below the threshold":            {utilization: 60, direction: internal.ScalingDirectionNone},
+		"above the threshold":            {utilization: 90, direction: internal.ScalingDirectionUp, size: 1},
+		"well above the threshold":       {utilization: 100, direction: internal.ScalingDirectionUp, size: 2},
+		"rounded to the nearest":         {utilization: 100, rounding: internal.RoundingNearest, direction: internal.ScalingDirectionUp, size: 1},
+		"idle workers left":              {utilization: 100, idle: true, direction: internal.ScalingDirectionNone},
+		"pending runs need more workers": {utilization: 90, pending: 4, direction: internal.ScalingDirectionUp, size: 4},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state, err := internal.NewState(&internal.WorkerPool{
+				Workers: []internal.Worker{
+					{ID: "1", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-1"}`},
+					{ID: "2", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-2"}`},
+					{ID: "3", Busy: true, Metadata: `{"asg_id": "group", "instance_id": "instance-3"}`},
+					{ID: "4", Busy: !tt.idle, Metadata: `{"asg_id": "group", "instance_id": "instance-4"}`},
+				},
+				PendingRuns: tt.pending,
+			}, &types.AutoScalingGroup{
+				AutoScalingGroupName: nullable("group"),
+				MinSize:              nullable(int32(0)),
+				MaxSize:              nullable(int32(10)),
+				DesiredCapacity:      nullable(int32(4)),
+				Instances: []types.Instance{
+					{InstanceId: nullable("instance-1")},
+					{InstanceId: nullable("instance-2")},
+					{InstanceId: nullable("instance-3")},
+					{InstanceId: nullable("instance-4")},
+				},
+			}, internal.MetadataKeys{})
+			require.NoError(t, err)
+
+			// Keep the idle worker from being scaled down.
+			state.ASG.MinSize = nullable(int32(4))
+			state.Utilization = tt.utilization
+
+			decision := state.Decide(internal.RuntimeConfig{
+				AutoscalingMaxCreate:            5,
+				AutoscalingUtilizationThreshold: 75,
+				AutoscalingScaleUpRounding:      tt.rounding,
+			})
+
+			assert.Equal(t, tt.direction, decision.ScalingDirection)
+			assert.Equal(t, tt.size, decision.ScalingSize)
+		})
+	}
+}
+
+func TestState_DecideSuppressedBy(t *testing.T) {
+	young := int32(time.Now().Add(-time.Minute).Unix())
+
+	for name, tt := range map[string]struct {
+		workers   int
+		instances int
+		pending   int32
+		minSize   int32
+		maxSize   int32
+		createdAt int32
+		suspended bool
+		busy      bool
+		maxBound  int
+		protected bool
+		zone      string
+		cfg       internal.RuntimeConfig
+		gates     []string
+	}{
+		"right size":       {workers: 2, instances: 2, pending: 2, maxSize: 5},
+		"mismatch":         {workers: 2, instances: 3, pending: 2, maxSize: 5, gates: []string{internal.GateMismatch}},
+		"pool suspended":   {workers: 2, instances: 2, pending: 2, maxSize: 5, suspended: true, gates: []string{internal.GatePoolSuspended}},
+		"max size":         {workers: 2, instances: 2, pending: 4, maxSize: 2, gates: []string{internal.GateMaxSize}},
+		"min size":         {workers: 2, instances: 2, minSize: 2, maxSize: 5, gates: []string{internal.GateMinSize}},
+		"scale-down delay": {workers: 2, instances: 2, maxSize: 5, createdAt: young, cfg: internal.RuntimeConfig{AutoscalingScaleDownDelay: 10}, gates: []string{internal.GateScaleDownDelay}},
+		"all busy":         {workers: 2, instances: 2, maxSize: 5, busy: true, maxBound: 1},
+		"protection":       {workers: 2, instances: 2, maxSize: 5, protected: true, gates: []string{internal.GateProtection}},
+		"min per zone":     {workers: 2, instances: 2, maxSize: 5, zone: "eu-west-1a", cfg: internal.RuntimeConfig{AutoscalingMinPerZone: 2}, gates: []string{internal.GateMinPerZone}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			asg := &types.AutoScalingGroup{
+				AutoScalingGroupName: nullable("group"),
+				MinSize:              nullable(tt.minSize),
+				MaxSize:              nullable(tt.maxSize),
+				DesiredCapacity:      nullable(int32(tt.instances)),
+			}
+			workerPool := &internal.WorkerPool{PendingRuns: tt.pending, Suspended: tt.suspended}
+
+			for i := 0; i < tt.instances; i++ {
+				instance := types.Instance{
+					InstanceId:     nullable(fmt.Sprintf("instance-%d", i)),
+					LifecycleState: types.LifecycleStateInService,
+				}
+
+				if tt.zone != "" {
+					instance.AvailabilityZone = nullable(tt.zone)
+				}
+
+				asg.Instances = append(asg.Instances, instance)
+			}
+
+			for i := 0; i < tt.workers; i++ {
+				workerPool.Workers = append(workerPool.Workers, internal.Worker{
+					ID:        fmt.Sprintf("worker-%d", i),
+					Busy:      tt.busy,
+					CreatedAt: tt.createdAt,
+					Metadata:  mustJSON(map[string]any{"asg_id": "group", "instance_id": fmt.Sprintf("instance-%d", i)}),
+				})
+			}
+
+			state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+			require.NoError(t, err)
+
+			if tt.maxBound > 0 {
+				state.Bounds = &internal.ScheduledBounds{MaxSize: &tt.maxBound}
+			}
+
+			if tt.protected {
+				state.ProtectedInstances = map[internal.InstanceID]struct{}{"instance-0": {}, "instance-1": {}}
+			}
+
+			tt.cfg.AutoscalingMaxCreate = 5
+			tt.cfg.AutoscalingMaxKill = 5
+
+			decision := state.Decide(tt.cfg)
+
+			assert.Equal(t, internal.ScalingDirectionNone, decision.ScalingDirection)
+			assert.Equal(t, tt.gates, decision.SuppressedBy)
+		})
+	}
+}
+
+// sizedPoolState creates a state for a pool of the given capacity, serving
+// the given number of runs. The runs which don't fit are pending.
+func sizedPoolState(t *testing.T, capacity, runs int, maxSize int32) *internal.State {
+	asg := &types.AutoScalingGroup{
+		AutoScalingGroupName: nullable("group"),
+		MinSize:              nullable(int32(1)),
+		MaxSize:              nullable(maxSize),
+		DesiredCapacity:      nullable(int32(capacity)),
+	}
+	workerPool := &internal.WorkerPool{}
+
+	for i := 0; i < capacity; i++ {
+		instanceID := fmt.Sprintf("instance-%d", i)
+
+		asg.Instances = append(asg.Instances, types.Instance{
+			InstanceId:     nullable(instanceID),
+			LifecycleState: types.LifecycleStateInService,
+		})
+		workerPool.Workers = append(workerPool.Workers, internal.Worker{
+			ID:        fmt.Sprintf("worker-%d", i),
+			Busy:      i < runs,
+			CreatedAt: int32(i + 1),
+			Metadata:  mustJSON(map[string]any{"asg_id": "group", "instance_id": instanceID}),
+		})
+	}
+
+	if runs > capacity {
+		workerPool.PendingRuns = int32(runs - capacity)
+	}
+
+	state, err := internal.NewState(workerPool, asg, internal.MetadataKeys{})
+	require.NoError(t, err)
+
+	return state
+}
+
+func TestState_BusySetpointConverges(t *testing.T) {
+	cfg := internal.RuntimeConfig{
+		AutoscalingMaxCreate:                  20,
+		AutoscalingMaxKill:                    20,
+		AutoscalingTargetBusyPercent:          80,
+		AutoscalingTargetBusyProportionalGain: 0.5,
+	}
+
+	// Runs the controller against a steady number of runs, and returns the
+	// capacities it went through.
+	converge := func(capacity, runs int) []int {
+		var out []int
+
+		for i := 0; i < 20; i++ {
+			decision := sizedPoolState(t, capacity, runs, 20).Decide(cfg)
+
+			switch decision.ScalingDirection {
+			case internal.ScalingDirectionUp:
+				capacity += decision.ScalingSize
+			case internal.ScalingDirectionDown:
+				capacity -= decision.ScalingSize
+			default:
+				return out
+			}
+
+			out = append(out, capacity)
+		}
+
+		t.Fatalf("no convergence after %v", out)
+		return nil
+	}
+
+	// For 80% of the workers to be busy with 8 runs, the pool needs 10. Scale
+	// downs are rounded down by default, so they take smaller steps.
+	assert.Equal(t, []int{6, 8, 9, 10}, converge(2, 8))
+	assert.Equal(t, []int{7, 5, 4, 3}, converge(10, 2))
+
+	cfg.AutoscalingScaleDownRounding = internal.RoundingNearest
+	assert.Equal(t, []int{6, 4, 3}, converge(10, 2))
+}
+
+func TestState_BusySetpointBoundedByMaxSize(t *testing.T) {
+	for name, tt := range map[string]struct {
+		proportional float64
+		integral     float64
+		accumulated  float64
+	}{
+		"proportional only":     {proportional: 1},
+		"wound up integral":     {proportional: 1, integral: 1, accumulated: 100},
+		"large integral offset": {proportional: 0.1, integral: 1, accumulated: 12},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state := sizedPoolState(t, 4, 30, 12)
+			state.BusySetpointIntegral = tt.accumulated
+
+			decision := state.Decide(internal.RuntimeConfig{
+				AutoscalingMaxCreate:                  50,
+				AutoscalingTargetBusyPercent:          80,
+				AutoscalingTargetBusyProportionalGain: tt.proportional,
+				AutoscalingTargetBusyIntegralGain:     tt.integral,
+			})
+
+			assert.Equal(t, internal.ScalingDirectionUp, decision.ScalingDirection)
+			assert.Equal(t, 8, decision.ScalingSize)
+		})
+	}
+}
+
+func TestState_DecideScheduledBounds(t *testing.T) {
+	size := func(n int) *int { return &n }
+
+	for name, tt := range map[string]struct {
+		capacity, runs int
+		bounds         internal.ScheduledBounds
+		direction      internal.ScalingDirection
+		size           int
+		comment        string
+		suppressedBy   []string
+	}{
+		"scales up to the scheduled minimum size": {
+			capacity:  2,
+			bounds:    internal.ScheduledBounds{MinSize: 6},
+			direction: internal.ScalingDirectionUp,
+			size:      4,
+			comment:   "holding the pool at the scheduled minimum size of 6",
+		},
+		"removes the idle workers above the scheduled maximum size": {
+			capacity:  6,
+			runs:      2,
+			bounds:    internal.ScheduledBounds{MaxSize: size(3), Reserve: 3},
+			direction: internal.ScalingDirectionDown,
+			size:      3,
+			comment:   "shrinking the pool to the scheduled maximum size of 3",
+		},
+		"only removes the idle workers above the scheduled maximum size": {
+			capacity:  6,
+			runs:      3,
+			bounds:    internal.ScheduledBounds{MaxSize: size(2), Reserve: 2},
+			direction: internal.ScalingDirectionDown,
+			size:      3,
+			comment:   "shrinking the pool to the scheduled maximum size of 2",
+		},
+		"waits for the busy workers above the scheduled maximum size": {
+			capacity:  4,
+			runs:      4,
+			bounds:    internal.ScheduledBounds{MaxSize: size(2)},
+			direction: internal.ScalingDirectionNone,
+			comment:   "autoscaling group exactly at the right size",
+		},
+		"doesn't scale up past the scheduled maximum size": {
+			capacity:     4,
+			runs:         7,
+			bounds:       internal.ScheduledBounds{MaxSize: size(4)},
+			direction:    internal.ScalingDirectionNone,
+			comment:      "autoscaling group is already at the scheduled maximum size of 4",
+			suppressedBy: []string{internal.GateMaxSize},
+		},
+		"scales up to the scheduled maximum size": {
+			capacity:  4,
+			runs:      10,
+			bounds:    internal.ScheduledBounds{MaxSize: size(6)},
+			direction: internal.ScalingDirectionUp,
+			size:      2,
+			comment:   "adding workers to match pending runs, up to the scheduled maximum size of 6",
+		},
+		"keeps the idle reserve": {
+			capacity:  4,
+			runs:      4,
+			bounds:    internal.ScheduledBounds{Reserve: 2},
+			direction: internal.ScalingDirectionUp,
+			size:      2,
+			comment:   "adding workers to match pending runs",
+		},
+		"stays within the ASG max size": {
+			capacity:  18,
+			runs:      25,
+			bounds:    internal.ScheduledBounds{MaxSize: size(50)},
+			direction: internal.ScalingDirectionUp,
+			size:      2,
+			comment:   "adding workers to match pending runs, up to the ASG max size",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state := sizedPoolState(t, tt.capacity, tt.runs, 20)
+			state.Bounds = &tt.bounds
+
+			decision := state.Decide(internal.RuntimeConfig{AutoscalingMaxCreate: 20, AutoscalingMaxKill: 20})
+
+			assert.Equal(t, tt.direction, decision.ScalingDirection)
+			assert.Equal(t, tt.size, decision.ScalingSize)
+			assert.Contains(t, decision.Comments, tt.comment)
+			assert.Equal(t, tt.suppressedBy, decision.SuppressedBy)
+		})
+	}
+}
+
 func TestState(t *testing.T) {
 	g := goblin.Goblin(t)
 	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
@@ -78,7 +1087,7 @@ func TestState(t *testing.T) {
 				workerPool = &internal.WorkerPool{}
 			})
 
-			g.JustBeforeEach(func() { sut, err = internal.NewState(workerPool, asg) })
+			g.JustBeforeEach(func() { sut, err = internal.NewState(workerPool, asg, internal.MetadataKeys{}) })
 
 			g.Describe("when the ASG is invalid", func() {
 				g.Describe("when the name is not set", func() {
@@ -128,114 +1137,468 @@ func TestState(t *testing.T) {
 					})
 				})
 
-				g.Describe("when the worker does not belong to the ASG", func() {
-					g.BeforeEach(func() {
-						workerPool.Workers = []internal.Worker{{
-							Metadata: mustJSON(map[string]any{
-								"asg_id":      "other-asg",
-								"instance_id": "i-1234567890",
-							}),
-						}}
-					})
+				g.Describe("when a worker has an empty instance ID", func() {
+					g.BeforeEach(func() {
+						workerPool.Workers = []internal.Worker{{
+							Metadata: mustJSON(map[string]any{
+								"asg_id":      asgName,
+								"instance_id": "",
+							}),
+						}}
+					})
+
+					g.It("should return an error", func() {
+						Expect(err).To(MatchError("metadata instance_id is empty"))
+					})
+				})
+
+				g.Describe("when the worker does not belong to the ASG", func() {
+					g.BeforeEach(func() {
+						workerPool.Workers = []internal.Worker{{
+							Metadata: mustJSON(map[string]any{
+								"asg_id":      "other-asg",
+								"instance_id": "i-1234567890",
+							}),
+						}}
+					})
+
+					g.It("should return an error", func() {
+						Expect(err).To(MatchError("incorrect worker ASG: other-asg"))
+					})
+				})
+			})
+
+			// The StrayInstances function requires precalculated data from the
+			// State constructor, so it's tested here.
+			g.Describe("StrayInstances", func() {
+				const instanceID = "i-1234567890"
+
+				var instanceIDs []string
+
+				g.BeforeEach(func() {
+					asg.Instances = []types.Instance{{
+						InstanceId:     nullable(instanceID),
+						LifecycleState: types.LifecycleStateInService,
+					}}
+				})
+
+				g.JustBeforeEach(func() { instanceIDs = sut.StrayInstances() })
+
+				g.Describe("with an ASG instance without an ID", func() {
+					g.BeforeEach(func() {
+						asg.Instances = append(asg.Instances, types.Instance{LifecycleState: types.LifecycleStatePending})
+						workerPool.Workers = []internal.Worker{{
+							Drained: true,
+							Metadata: mustJSON(map[string]any{
+								"asg_id":      asgName,
+								"instance_id": "i-0987654321",
+							}),
+						}}
+					})
+
+					g.It("should skip it without panicking", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(sut.InstancesWithoutID()).To(Equal(1))
+						Expect(instanceIDs).To(ConsistOf(instanceID))
+						Expect(sut.OutdatedInstances()).To(BeEmpty())
+					})
+				})
+
+				g.Describe("with no workers", func() {
+					g.Describe("when the ASG instance is in service", func() {
+						g.It("should return the instance as stray", func() {
+							Expect(instanceIDs).To(ConsistOf(instanceID))
+						})
+					})
+
+					g.Describe("when the ASG instance is not in service", func() {
+						g.BeforeEach(func() { asg.Instances[0].LifecycleState = types.LifecycleStateTerminating })
+
+						g.It("should return an empty collection", func() {
+							Expect(instanceIDs).To(BeEmpty())
+						})
+					})
+				})
+
+				g.Describe("with a worker", func() {
+					g.Describe("when it matches the ASG instance", func() {
+						g.BeforeEach(func() {
+							workerPool.Workers = []internal.Worker{{
+								Metadata: mustJSON(map[string]any{
+									"asg_id":      asgName,
+									"instance_id": instanceID,
+								}),
+							}}
+						})
+
+						g.It("should return an empty collection", func() {
+							Expect(instanceIDs).To(BeEmpty())
+						})
+					})
+
+					g.Describe("when it does not match the ASG instance", func() {
+						g.BeforeEach(func() {
+							workerPool.Workers = []internal.Worker{{
+								Metadata: mustJSON(map[string]any{
+									"asg_id":      asgName,
+									"instance_id": "i-0987654321",
+								}),
+							}}
+						})
+
+						g.It("should return the instance as stray", func() {
+							Expect(instanceIDs).To(ConsistOf(instanceID))
+						})
+					})
+				})
+			})
+
+			// The ScaleDownCandidates function requires precalculated data from
+			// the State constructor, so it's tested here.
+			g.Describe("ScaleDownCandidates", func() {
+				var count int
+				var cfg internal.RuntimeConfig
+				var candidates []internal.Worker
+
+				g.BeforeEach(func() {
+					count, cfg = 4, internal.RuntimeConfig{}
+
+					zones := []string{"zone-a", "zone-a", "zone-b", "zone-b", "zone-c"}
+
+					asg.Instances = nil
+					workerPool.Workers = nil
+
+					for i, zone := range zones {
+						instanceID := fmt.Sprintf("i-%d", i)
+
+						asg.Instances = append(asg.Instances, types.Instance{
+							AvailabilityZone: nullable(zone),
+							InstanceId:       nullable(instanceID),
+							LifecycleState:   types.LifecycleStateInService,
+						})
+						workerPool.Workers = append(workerPool.Workers, internal.Worker{
+							ID: instanceID,
+							Metadata: mustJSON(map[string]any{
+								"asg_id":      asgName,
+								"instance_id": instanceID,
+							}),
+						})
+					}
+				})
+
+				g.JustBeforeEach(func() { candidates = sut.ScaleDownCandidates(count, cfg) })
+
+				g.Describe("with no per-zone minimum", func() {
+					g.It("should return the oldest idle workers", func() {
+						Expect(candidates).To(HaveLen(4))
+						Expect(candidates[0].ID).To(Equal("i-0"))
+						Expect(candidates[3].ID).To(Equal("i-3"))
+					})
+				})
+
+				g.Describe("with a per-zone minimum of one", func() {
+					g.BeforeEach(func() { cfg.AutoscalingMinPerZone = 1 })
+
+					g.It("should leave one worker in each zone", func() {
+						Expect(candidates).To(HaveLen(2))
+						Expect(candidates[0].ID).To(Equal("i-0"))
+						Expect(candidates[1].ID).To(Equal("i-2"))
+					})
+				})
+
+				g.Describe("with a drained worker", func() {
+					g.BeforeEach(func() { workerPool.Workers[3].Drained = true })
+
+					g.It("should skip it by default", func() {
+						Expect(candidates).To(HaveLen(4))
+						Expect(candidates[3].ID).To(Equal("i-4"))
+					})
+
+					g.Describe("when reclaiming drained workers", func() {
+						g.BeforeEach(func() { cfg.AutoscalingReclaimDrainedWorkers = true })
+
+						g.It("should return it before the idle workers", func() {
+							Expect(candidates).To(HaveLen(4))
+							Expect(candidates[0].ID).To(Equal("i-3"))
+							Expect(candidates[1].ID).To(Equal("i-0"))
+							Expect(candidates[3].ID).To(Equal("i-2"))
+						})
+					})
+				})
+
+				g.Describe("when reclaiming the newest workers first", func() {
+					g.JustBeforeEach(func() {
+						sut.ReclaimNewestFirst = true
+						candidates = sut.ScaleDownCandidates(count, cfg)
+					})
+
+					g.It("should return the newest idle workers", func() {
+						Expect(candidates).To(HaveLen(4))
+						Expect(candidates[0].ID).To(Equal("i-4"))
+						Expect(candidates[3].ID).To(Equal("i-1"))
+					})
+				})
+			})
+		})
+
+		g.Describe("IdleWorkers", func() {
+			var idleWorkers []internal.Worker
+
+			g.JustBeforeEach(func() { idleWorkers = sut.IdleWorkers() })
+
+			g.BeforeEach(func() {
+				workerPool.Workers = []internal.Worker{
+					{Busy: true, ID: "busy"},
+					{Busy: false, ID: "idle"},
+					{Busy: false, Drained: true, ID: "drained"},
+				}
+			})
+
+			g.It("should return the idle workers", func() {
+				Expect(idleWorkers).To(HaveLen(1))
+				Expect(idleWorkers[0].ID).To(Equal("idle"))
+			})
+		})
+
+		g.Describe("ScalableWorkers", func() {
+			var cfg internal.RuntimeConfig
+			var scalable []internal.Worker
+
+			g.BeforeEach(func() {
+				cfg = internal.RuntimeConfig{AutoscalingScaleDownDelay: 10}
+
+				workerPool.Workers = []internal.Worker{
+					{ID: "busy", Busy: true, CreatedAt: int32(time.Now().Add(-time.Hour).Unix())},
+					{ID: "old", CreatedAt: int32(time.Now().Add(-time.Hour).Unix())},
+					{ID: "young", CreatedAt: int32(time.Now().Add(-time.Minute).Unix())},
+					{ID: "future", CreatedAt: int32(time.Now().Add(time.Hour).Unix())},
+					{ID: "unknown"},
+				}
+			})
+
+			g.JustBeforeEach(func() { scalable = sut.ScalableWorkers(cfg) })
+
+			g.Describe("with no scale-down delay", func() {
+				g.BeforeEach(func() { cfg.AutoscalingScaleDownDelay = 0 })
+
+				g.It("should return all idle workers", func() {
+					Expect(scalable).To(HaveLen(4))
+				})
+			})
+
+			g.Describe("with a scale-down delay", func() {
+				g.It("should only return old enough workers", func() {
+					Expect(scalable).To(HaveLen(1))
+					Expect(scalable[0].ID).To(Equal("old"))
+				})
+			})
+
+			g.Describe("with an old worker which was recently busy", func() {
+				g.BeforeEach(func() {
+					sut.LastBusy = map[string]int64{"old": time.Now().Add(-time.Minute).Unix()}
+				})
+
+				g.Describe("with the delay anchored on creation", func() {
+					g.BeforeEach(func() { cfg.AutoscalingScaleDownDelayAnchor = internal.ScaleDownDelayAnchorCreated })
+
+					g.It("should return the old worker", func() {
+						Expect(scalable).To(HaveLen(1))
+						Expect(scalable[0].ID).To(Equal("old"))
+					})
+				})
+
+				g.Describe("with the delay anchored on the last busy time", func() {
+					g.BeforeEach(func() { cfg.AutoscalingScaleDownDelayAnchor = internal.ScaleDownDelayAnchorIdle })
+
+					g.It("should give the old worker a cooldown", func() {
+						Expect(scalable).To(BeEmpty())
+					})
+				})
+			})
+
+			g.Describe("with the delay anchored on the last busy time, long ago", func() {
+				g.BeforeEach(func() {
+					cfg.AutoscalingScaleDownDelayAnchor = internal.ScaleDownDelayAnchorIdle
+					sut.LastBusy = map[string]int64{"old": time.Now().Add(-time.Hour).Unix()}
+				})
+
+				g.It("should return the old worker", func() {
+					Expect(scalable).To(HaveLen(1))
+					Expect(scalable[0].ID).To(Equal("old"))
+				})
+			})
+
+			g.Describe("when workers with unknown age are scalable", func() {
+				g.BeforeEach(func() { cfg.AutoscalingScaleDownUnknownAge = true })
+
+				g.It("should also return the worker with unknown age", func() {
+					Expect(scalable).To(HaveLen(2))
+					Expect(scalable[0].ID).To(Equal("old"))
+					Expect(scalable[1].ID).To(Equal("unknown"))
+				})
+			})
+		})
+
+		g.Describe("Mismatch", func() {
+			var mismatched, transient bool
+
+			g.BeforeEach(func() {
+				asg = &types.AutoScalingGroup{
+					Instances: []types.Instance{
+						{LifecycleState: types.LifecycleStateInService},
+						{LifecycleState: types.LifecycleStateInService},
+					},
+				}
+				workerPool = &internal.WorkerPool{Workers: []internal.Worker{{}}}
+
+				sut = &internal.State{WorkerPool: workerPool, ASG: asg}
+			})
+
+			g.JustBeforeEach(func() { mismatched, transient = sut.Mismatch() })
+
+			g.Describe("when the counts match", func() {
+				g.BeforeEach(func() { workerPool.Workers = append(workerPool.Workers, internal.Worker{}) })
+
+				g.It("should not report a mismatch", func() {
+					Expect(mismatched).To(BeFalse())
+					Expect(transient).To(BeFalse())
+				})
+			})
+
+			g.Describe("when all instances are in a steady state", func() {
+				g.BeforeEach(func() { asg.Instances[1].LifecycleState = types.LifecycleStateStandby })
+
+				g.It("should report a steady mismatch", func() {
+					Expect(mismatched).To(BeTrue())
+					Expect(transient).To(BeFalse())
+				})
+			})
+
+			g.Describe("when an instance is launching", func() {
+				g.BeforeEach(func() { asg.Instances[1].LifecycleState = types.LifecycleStatePendingWait })
+
+				g.It("should report a transient mismatch", func() {
+					Expect(mismatched).To(BeTrue())
+					Expect(transient).To(BeTrue())
+				})
+			})
+
+			g.Describe("when an instance is terminating", func() {
+				g.BeforeEach(func() { asg.Instances[1].LifecycleState = types.LifecycleStateTerminating })
 
-					g.It("should return an error", func() {
-						Expect(err).To(MatchError("incorrect worker ASG: other-asg"))
-					})
+				g.It("should report a transient mismatch", func() {
+					Expect(mismatched).To(BeTrue())
+					Expect(transient).To(BeTrue())
 				})
 			})
+		})
 
-			// The StrayInstances function requires precalculated data from the
-			// State constructor, so it's tested here.
-			g.Describe("StrayInstances", func() {
-				const instanceID = "i-1234567890"
+		g.Describe("IsDeadlocked", func() {
+			var deadlocked bool
 
-				var instanceIDs []string
+			g.BeforeEach(func() {
+				asg = &types.AutoScalingGroup{
+					MinSize:         nullable(int32(0)),
+					MaxSize:         nullable(int32(2)),
+					DesiredCapacity: nullable(int32(2)),
+					Instances: []types.Instance{
+						{LifecycleState: types.LifecycleStateInService},
+						{LifecycleState: types.LifecycleStatePending},
+					},
+				}
+				workerPool = &internal.WorkerPool{
+					PendingRuns: 3,
+					Workers:     []internal.Worker{{Busy: true}},
+				}
 
-				g.BeforeEach(func() {
-					asg.Instances = []types.Instance{{
-						InstanceId:     nullable(instanceID),
-						LifecycleState: types.LifecycleStateInService,
-					}}
-				})
+				sut = &internal.State{WorkerPool: workerPool, ASG: asg}
+			})
 
-				g.JustBeforeEach(func() { instanceIDs = sut.StrayInstances() })
+			g.JustBeforeEach(func() { deadlocked = sut.IsDeadlocked() })
 
-				g.Describe("with no workers", func() {
-					g.Describe("when the ASG instance is in service", func() {
-						g.It("should return the instance as stray", func() {
-							Expect(instanceIDs).To(ConsistOf(instanceID))
-						})
-					})
+			g.Describe("when at capacity with a backlog and stuck instances", func() {
+				g.It("should detect the deadlock", func() { Expect(deadlocked).To(BeTrue()) })
+			})
 
-					g.Describe("when the ASG instance is not in service", func() {
-						g.BeforeEach(func() { asg.Instances[0].LifecycleState = types.LifecycleStateTerminating })
+			g.Describe("when not at capacity", func() {
+				g.BeforeEach(func() { asg.MaxSize = nullable(int32(3)) })
 
-						g.It("should return an empty collection", func() {
-							Expect(instanceIDs).To(BeEmpty())
-						})
-					})
-				})
+				g.It("should not detect a deadlock", func() { Expect(deadlocked).To(BeFalse()) })
+			})
 
-				g.Describe("with a worker", func() {
-					g.Describe("when it matches the ASG instance", func() {
-						g.BeforeEach(func() {
-							workerPool.Workers = []internal.Worker{{
-								Metadata: mustJSON(map[string]any{
-									"asg_id":      asgName,
-									"instance_id": instanceID,
-								}),
-							}}
-						})
+			g.Describe("when there is no backlog", func() {
+				g.BeforeEach(func() { workerPool.PendingRuns = 0 })
 
-						g.It("should return an empty collection", func() {
-							Expect(instanceIDs).To(BeEmpty())
-						})
-					})
+				g.It("should not detect a deadlock", func() { Expect(deadlocked).To(BeFalse()) })
+			})
 
-					g.Describe("when it does not match the ASG instance", func() {
-						g.BeforeEach(func() {
-							workerPool.Workers = []internal.Worker{{
-								Metadata: mustJSON(map[string]any{
-									"asg_id":      asgName,
-									"instance_id": "i-0987654321",
-								}),
-							}}
-						})
+			g.Describe("when all instances are in service", func() {
+				g.BeforeEach(func() { asg.Instances[1].LifecycleState = types.LifecycleStateInService })
 
-						g.It("should return the instance as stray", func() {
-							Expect(instanceIDs).To(ConsistOf(instanceID))
-						})
-					})
-				})
+				g.It("should not detect a deadlock", func() { Expect(deadlocked).To(BeFalse()) })
 			})
 		})
 
-		g.Describe("IdleWorkers", func() {
-			var idleWorkers []internal.Worker
-
-			g.JustBeforeEach(func() { idleWorkers = sut.IdleWorkers() })
+		g.Describe("DecideOffHours", func() {
+			var cfg internal.RuntimeConfig
+			var decision internal.Decision
 
 			g.BeforeEach(func() {
-				workerPool.Workers = []internal.Worker{
-					{Busy: true, ID: "busy"},
-					{Busy: false, ID: "idle"},
+				cfg = internal.RuntimeConfig{
+					AutoscalingMaxKill:      5,
+					AutoscalingOffHoursSize: 1,
+				}
+
+				asg = &types.AutoScalingGroup{
+					MinSize:         nullable(int32(0)),
+					MaxSize:         nullable(int32(5)),
+					DesiredCapacity: nullable(int32(3)),
+					Instances:       []types.Instance{{}, {}, {}},
+				}
+				workerPool = &internal.WorkerPool{
+					PendingRuns: 5,
+					Workers:     []internal.Worker{{Busy: true}, {}, {}},
 				}
+
+				sut = &internal.State{WorkerPool: workerPool, ASG: asg}
 			})
 
-			g.It("should return the idle workers", func() {
-				Expect(idleWorkers).To(HaveLen(1))
-				Expect(idleWorkers[0].ID).To(Equal("idle"))
+			g.JustBeforeEach(func() { decision = sut.DecideOffHours(cfg) })
+
+			g.It("should remove idle workers down to the off-hours size, despite pending runs", func() {
+				Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+				Expect(decision.ScalingSize).To(Equal(2))
+				Expect(decision.Comments).To(Equal([]string{"outside business hours", "removing idle workers"}))
+			})
+
+			g.Describe("when the pool is at the off-hours size", func() {
+				g.BeforeEach(func() { cfg.AutoscalingOffHoursSize = 3 })
+
+				g.It("should hold the pool steady", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+					Expect(decision.Comments).To(Equal([]string{"outside business hours", "holding the pool steady"}))
+				})
+			})
+
+			g.Describe("when the pool is below the off-hours size", func() {
+				g.BeforeEach(func() { cfg.AutoscalingOffHoursSize = 4 })
+
+				g.It("should not scale up", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+				})
 			})
 		})
 
 		g.Describe("Decide", func() {
-			var maxCreate, maxKill int
+			var cfg internal.RuntimeConfig
 
 			var decision internal.Decision
 
 			g.BeforeEach(func() {
-				maxCreate = 2
-				maxKill = 2
+				cfg = internal.RuntimeConfig{
+					AutoscalingMaxCreate: 2,
+					AutoscalingMaxKill:   2,
+				}
 
 				asg = &types.AutoScalingGroup{
 					MinSize: nullable(int32(0)),
@@ -250,7 +1613,7 @@ func TestState(t *testing.T) {
 			})
 
 			g.JustBeforeEach(func() {
-				decision = sut.Decide(maxCreate, maxKill)
+				decision = sut.Decide(cfg)
 			})
 
 			g.Describe("when there are no workers", func() {
@@ -296,11 +1659,43 @@ func TestState(t *testing.T) {
 						})
 					})
 
+					g.Describe("when the ASG maximum size is below the number of workers", func() {
+						g.BeforeEach(func() {
+							asg.Instances = []types.Instance{{}, {}, {}}
+							workerPool.Workers = []internal.Worker{{}, {}, {}}
+						})
+
+						g.It("should not scale and point at the misconfigured maximum size", func() {
+							Expect(sut.MaxSizeBelowWorkers()).To(BeTrue())
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+							Expect(decision.Comments).To(Equal([]string{
+								"autoscaling group maximum size of 2 is below the 3 workers in the pool",
+							}))
+						})
+					})
+
+					g.Describe("when the pool is already at the instance quota", func() {
+						g.BeforeEach(func() {
+							asg.DesiredCapacity = nullable(int32(1))
+							asg.Instances = []types.Instance{{}}
+							workerPool.Workers = []internal.Worker{{}}
+							sut.InstanceQuota = 1
+						})
+
+						g.It("should not scale because the quota is exhausted", func() {
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+							Expect(decision.ScalingSize).To(BeZero())
+							Expect(decision.Comments).To(Equal([]string{
+								"autoscaling group is already at the instance quota of 1",
+							}))
+						})
+					})
+
 					g.Describe("when the ASG is not at maximum size", func() {
 						g.BeforeEach(func() { asg.DesiredCapacity = nullable(int32(0)) })
 
 						g.Describe("when constrained by maxCreate", func() {
-							g.BeforeEach(func() { maxCreate = 1 })
+							g.BeforeEach(func() { cfg.AutoscalingMaxCreate = 1 })
 
 							g.It("scales up by 1", func() {
 								Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
@@ -313,7 +1708,7 @@ func TestState(t *testing.T) {
 						})
 
 						g.Describe("when not constrained by maxCreate", func() {
-							g.BeforeEach(func() { maxCreate = 10 })
+							g.BeforeEach(func() { cfg.AutoscalingMaxCreate = 10 })
 
 							g.Describe("when not constrained by max ASG size", func() {
 								g.BeforeEach(func() { asg.MaxSize = nullable(int32(10)) })
@@ -336,6 +1731,36 @@ func TestState(t *testing.T) {
 									}))
 								})
 							})
+
+							g.Describe("when constrained by the instance quota", func() {
+								g.BeforeEach(func() {
+									asg.MaxSize = nullable(int32(10))
+									sut.InstanceQuota = 3
+								})
+
+								g.It("scales up by 3", func() {
+									Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+									Expect(decision.ScalingSize).To(Equal(3))
+									Expect(decision.Comments).To(Equal([]string{
+										"adding workers to match pending runs, up to the instance quota of 3",
+									}))
+								})
+							})
+
+							g.Describe("when the instance quota is above max ASG size", func() {
+								g.BeforeEach(func() {
+									asg.MaxSize = nullable(int32(2))
+									sut.InstanceQuota = 8
+								})
+
+								g.It("is constrained by max ASG size", func() {
+									Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+									Expect(decision.ScalingSize).To(Equal(2))
+									Expect(decision.Comments).To(Equal([]string{
+										"adding workers to match pending runs, up to the ASG max size",
+									}))
+								})
+							})
 						})
 					})
 				})
@@ -364,7 +1789,7 @@ func TestState(t *testing.T) {
 						g.BeforeEach(func() { asg.MinSize = nullable(int32(0)) })
 
 						g.Describe("when constrained by maxKill", func() {
-							g.BeforeEach(func() { maxKill = 1 })
+							g.BeforeEach(func() { cfg.AutoscalingMaxKill = 1 })
 
 							g.It("scales down by 1", func() {
 								Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
@@ -377,7 +1802,7 @@ func TestState(t *testing.T) {
 						})
 
 						g.Describe("when not constrained by maxKill", func() {
-							g.BeforeEach(func() { maxKill = 10 })
+							g.BeforeEach(func() { cfg.AutoscalingMaxKill = 10 })
 
 							g.Describe("when constrained by min ASG size", func() {
 								g.BeforeEach(func() { asg.MinSize = nullable(int32(1)) })
@@ -405,6 +1830,285 @@ func TestState(t *testing.T) {
 					})
 				})
 			})
+
+			g.Describe("with a saturation buffer", func() {
+				g.BeforeEach(func() {
+					asg.DesiredCapacity = nullable(int32(2))
+					asg.MaxSize = nullable(int32(10))
+					asg.Instances = []types.Instance{{}, {}}
+					workerPool.Workers = []internal.Worker{{Busy: true}, {Busy: true}}
+					cfg.AutoscalingSaturationBuffer = 1
+				})
+
+				g.Describe("when all workers are busy and there are no pending runs", func() {
+					g.It("should add the buffer", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+						Expect(decision.ScalingSize).To(Equal(1))
+						Expect(decision.Comments).To(Equal([]string{
+							"all workers are busy, adding a buffer of 1 workers",
+							"adding workers to match pending runs",
+						}))
+					})
+				})
+
+				g.Describe("when a worker is idle", func() {
+					g.BeforeEach(func() { workerPool.Workers[1].Busy = false })
+
+					g.It("should not add the buffer", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+						Expect(decision.Comments).To(Equal([]string{"removing idle workers"}))
+					})
+				})
+
+				g.Describe("when there are pending runs", func() {
+					g.BeforeEach(func() { workerPool.PendingRuns = 2 })
+
+					g.It("should scale up for the pending runs only", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+						Expect(decision.ScalingSize).To(Equal(2))
+						Expect(decision.Comments).To(Equal([]string{"adding workers to match pending runs"}))
+					})
+				})
+			})
+
+			g.Describe("with a large idle pool and a percentage kill limit", func() {
+				g.BeforeEach(func() {
+					asg.DesiredCapacity = nullable(int32(50))
+					asg.MaxSize = nullable(int32(100))
+					asg.Instances = make([]types.Instance, 50)
+					workerPool.Workers = make([]internal.Worker, 50)
+					cfg.AutoscalingMaxKill = 20
+					cfg.AutoscalingMaxKillPercent = 20
+				})
+
+				g.It("should remove no more than the percentage of the pool", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+					Expect(decision.ScalingSize).To(Equal(10))
+					Expect(decision.Comments).To(Equal([]string{
+						"need to kill 50 workers, but can only kill 10",
+						"removing idle workers",
+					}))
+				})
+
+				g.Describe("when the absolute limit is lower", func() {
+					g.BeforeEach(func() { cfg.AutoscalingMaxKill = 5 })
+
+					g.It("should honor the absolute limit", func() {
+						Expect(decision.ScalingSize).To(Equal(5))
+					})
+				})
+
+				g.Describe("when the pool is too small for the percentage to allow any removal", func() {
+					g.BeforeEach(func() {
+						asg.DesiredCapacity = nullable(int32(3))
+						asg.Instances = make([]types.Instance, 3)
+						workerPool.Workers = make([]internal.Worker, 3)
+					})
+
+					g.It("should still remove one worker", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+						Expect(decision.ScalingSize).To(Equal(1))
+					})
+				})
+			})
+
+			g.Describe("when some of the pending runs are paused", func() {
+				g.BeforeEach(func() {
+					asg.DesiredCapacity = nullable(int32(0))
+					asg.MaxSize = nullable(int32(10))
+					workerPool.PendingRuns = 3
+					workerPool.PausedRuns = 2
+				})
+
+				g.It("should only scale up for the runs which are not paused", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+					Expect(decision.ScalingSize).To(Equal(1))
+				})
+
+				g.Describe("when all the pending runs are paused", func() {
+					g.BeforeEach(func() { workerPool.PausedRuns = 3 })
+
+					g.It("should not scale up", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+						Expect(decision.Comments).To(Equal([]string{"autoscaling group exactly at the right size"}))
+					})
+				})
+
+				g.Describe("when paused runs are included", func() {
+					g.BeforeEach(func() { cfg.AutoscalingIncludePausedRuns = true })
+
+					g.It("should scale up for all the pending runs", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+						Expect(decision.ScalingSize).To(Equal(2))
+						Expect(decision.Comments).To(Equal([]string{
+							"need 3 workers, but can only create 2",
+							"adding workers to match pending runs",
+						}))
+					})
+				})
+			})
+
+			g.Describe("with capacity still being launched", func() {
+				g.BeforeEach(func() {
+					asg.MaxSize = nullable(int32(10))
+					asg.DesiredCapacity = nullable(int32(3))
+					asg.Instances = []types.Instance{{}, {}}
+					workerPool.Workers = []internal.Worker{{Busy: true}, {}}
+					workerPool.PendingRuns = 2
+				})
+
+				g.Describe("in incremental mode (default)", func() {
+					g.It("should add the deficit on top of the desired capacity", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+						Expect(decision.ScalingSize).To(Equal(1))
+					})
+				})
+
+				g.Describe("in absolute target mode", func() {
+					g.BeforeEach(func() { cfg.AutoscalingAbsoluteTarget = true })
+
+					g.It("should not count the launching capacity twice", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+						Expect(decision.Comments).To(Equal([]string{"autoscaling group exactly at the right size"}))
+					})
+
+					g.Describe("with more pending runs", func() {
+						g.BeforeEach(func() { workerPool.PendingRuns = 6 })
+
+						g.It("should target busy workers plus pending runs, within limits", func() {
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+							Expect(decision.ScalingSize).To(Equal(2))
+							Expect(decision.Comments).To(Equal([]string{
+								"targeting desired capacity of 7",
+								"need 4 workers, but can only create 2",
+								"adding workers to match pending runs",
+							}))
+						})
+					})
+
+					g.Describe("with no pending runs", func() {
+						g.BeforeEach(func() { workerPool.PendingRuns = 0 })
+
+						g.It("should only remove the idle workers", func() {
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+							Expect(decision.ScalingSize).To(Equal(1))
+							Expect(decision.Comments).To(Equal([]string{
+								"targeting desired capacity of 1",
+								"removing idle workers",
+							}))
+						})
+					})
+				})
+			})
+
+			g.Describe("with an external queue", func() {
+				g.BeforeEach(func() {
+					asg.MaxSize = nullable(int32(10))
+					asg.DesiredCapacity = nullable(int32(0))
+					workerPool.PendingRuns = 1
+					sut.QueueDepth = 1
+				})
+
+				g.It("should add the queue depth to the pending runs", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+					Expect(decision.ScalingSize).To(Equal(2))
+				})
+			})
+
+			g.Describe("with a pre-warm schedule", func() {
+				window := func(from, to time.Duration) string {
+					now := time.Now().UTC()
+					return now.Add(from).Format("15:04") + "-" + now.Add(to).Format("15:04")
+				}
+
+				g.BeforeEach(func() {
+					asg.MaxSize = nullable(int32(10))
+					asg.DesiredCapacity = nullable(int32(1))
+					asg.Instances = []types.Instance{{}}
+					workerPool.Workers = []internal.Worker{{}}
+				})
+
+				g.Describe("inside the pre-warm window", func() {
+					g.BeforeEach(func() {
+						cfg.AutoscalingPrewarmSchedule = []string{window(-time.Hour, time.Hour) + "=3"}
+					})
+
+					g.It("should raise the pool to the pre-warm size", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+						Expect(decision.ScalingSize).To(Equal(2))
+						Expect(decision.Comments).To(Equal([]string{
+							"pre-warming the pool to 3 workers",
+							"adding workers to match pending runs",
+						}))
+					})
+
+					g.Describe("when the demand exceeds the pre-warm size", func() {
+						g.BeforeEach(func() { workerPool.PendingRuns = 4 })
+
+						g.It("should scale up to match the demand", func() {
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionUp))
+							Expect(decision.ScalingSize).To(Equal(2))
+							Expect(decision.Comments).To(Equal([]string{
+								"need 3 workers, but can only create 2",
+								"adding workers to match pending runs",
+							}))
+						})
+					})
+
+					g.Describe("when the pool is at the pre-warm size", func() {
+						g.BeforeEach(func() {
+							asg.DesiredCapacity = nullable(int32(3))
+							asg.Instances = []types.Instance{{}, {}, {}}
+							workerPool.Workers = []internal.Worker{{}, {}, {}}
+						})
+
+						g.It("should not scale down below it", func() {
+							Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+							Expect(decision.Comments).To(Equal([]string{"autoscaling group is already at minimum size"}))
+						})
+					})
+				})
+
+				g.Describe("outside the pre-warm window", func() {
+					g.BeforeEach(func() {
+						cfg.AutoscalingPrewarmSchedule = []string{window(2*time.Hour, 3*time.Hour) + "=3"}
+					})
+
+					g.It("should not raise the pool", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+						Expect(decision.ScalingSize).To(Equal(1))
+					})
+				})
+			})
+
+			g.Describe("when the worker pool is suspended", func() {
+				g.BeforeEach(func() {
+					asg.DesiredCapacity = nullable(int32(1))
+					asg.Instances = []types.Instance{{}}
+					workerPool.PendingRuns = 5
+					workerPool.Suspended = true
+					workerPool.Workers = []internal.Worker{{}}
+				})
+
+				g.It("should not scale up", func() {
+					Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionNone))
+					Expect(decision.ScalingSize).To(BeZero())
+					Expect(decision.Comments).To(Equal([]string{"worker pool is suspended"}))
+				})
+
+				g.Describe("when configured to scale to minimum size", func() {
+					g.BeforeEach(func() { cfg.AutoscalingSuspendedScaleToMin = true })
+
+					g.It("removes the idle workers", func() {
+						Expect(decision.ScalingDirection).To(Equal(internal.ScalingDirectionDown))
+						Expect(decision.ScalingSize).To(Equal(1))
+						Expect(decision.Comments).To(Equal([]string{
+							"worker pool is suspended",
+							"removing idle workers",
+						}))
+					})
+				})
+			})
 		})
 	})
 }
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/smithy-go"
+	"github.com/shurcooL/graphql"
+)
+
+// awsThrottlingErrorCodes are the error codes AWS services use to tell that
+// they are being called too often.
+var awsThrottlingErrorCodes = map[string]struct{}{
+	"Throttling":                {},
+	"ThrottlingException":       {},
+	"ThrottledException":        {},
+	"RequestLimitExceeded":      {},
+	"RequestThrottled":          {},
+	"RequestThrottledException": {},
+	"TooManyRequestsException":  {},
+}
+
+// IsThrottlingError checks whether the error means that an API is being called
+// too often. This covers the AWS throttling errors, both from the v1 and the
+// v2 SDK, and the HTTP 429 responses from the Spacelift API.
+func IsThrottlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		_, ok := awsThrottlingErrorCodes[apiErr.ErrorCode()]
+		return ok
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		_, ok := awsThrottlingErrorCodes[awsErr.Code()]
+		return ok
+	}
+
+	var serverErr *graphql.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
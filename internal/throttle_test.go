@@ -0,0 +1,38 @@
+package internal_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/smithy-go"
+	"github.com/shurcooL/graphql"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+)
+
+func TestIsThrottlingError(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		err       error
+		throttled bool
+	}{
+		{"no error", nil, false},
+		{"AWS throttling", &smithy.GenericAPIError{Code: "Throttling"}, true},
+		{"AWS EC2 request limit", &smithy.GenericAPIError{Code: "RequestLimitExceeded"}, true},
+		{"AWS other error", &smithy.GenericAPIError{Code: "AccessDenied"}, false},
+		{"AWS v1 SDK throttling", awserr.New("TooManyRequestsException", "slow down", nil), true},
+		{"AWS v1 SDK other error", awserr.New("NoSuchResourceException", "no quota", nil), false},
+		{"Spacelift too many requests", &graphql.ServerError{StatusCode: http.StatusTooManyRequests}, true},
+		{"Spacelift server error", &graphql.ServerError{StatusCode: http.StatusBadGateway}, false},
+		{"wrapped throttling", fmt.Errorf("could not scale up ASG: %w", &smithy.GenericAPIError{Code: "ThrottlingException"}), true},
+		{"other", errors.New("bacon"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.throttled, internal.IsThrottlingError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cloudwatchtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+// utilizationPeriod is the period over which the CPU utilization of the
+// instances is averaged. The basic EC2 monitoring reports every five minutes.
+const utilizationPeriod = 5 * time.Minute
+
+// UtilizationSource reports the average resource utilization of the workers in
+// the pool, as a percentage, so that we can scale up pools whose runs saturate
+// the workers even when few runs are pending.
+type UtilizationSource interface {
+	Utilization(ctx context.Context) (percent float64, err error)
+}
+
+// CloudWatchUtilizationSource reports the average CPU utilization of the
+// instances in the ASG, as reported to CloudWatch.
+type CloudWatchUtilizationSource struct {
+	Client               ifaces.CloudWatch
+	AutoScalingGroupName string
+
+	// Timeout for the API call. Zero means no timeout.
+	Timeout time.Duration
+
+	// OnAPICall, if set, is called before each API call, so that it counts
+	// towards the calls made by the controller.
+	OnAPICall func()
+}
+
+// NewCloudWatchUtilizationSource creates a new CloudWatch utilization source
+// for the ASG configured in the runtime configuration, sharing the AWS
+// configuration and the API call count of the controller.
+func NewCloudWatchUtilizationSource(cfg *RuntimeConfig, controller *Controller) (*CloudWatchUtilizationSource, error) {
+	_, asgName, ok := strings.Cut(cfg.AutoscalingGroupARN, "/")
+	if !ok {
+		return nil, fmt.Errorf("could not parse autoscaling group ARN")
+	}
+
+	return &CloudWatchUtilizationSource{
+		Client:               cloudwatch.NewFromConfig(controller.AWSConfig),
+		AutoScalingGroupName: asgName,
+		Timeout:              cfg.CloudAPITimeout,
+		OnAPICall:            controller.recordAPICall,
+	}, nil
+}
+
+// Utilization returns the most recent average CPU utilization of the instances
+// in the ASG.
+func (s *CloudWatchUtilizationSource) Utilization(ctx context.Context) (percent float64, err error) {
+	xray.Capture(ctx, "aws.cloudwatch.utilization", func(ctx context.Context) error {
+		var output *cloudwatch.GetMetricStatisticsOutput
+
+		// Looking back over a few periods, since the latest datapoints take a
+		// while to be published.
+		now := time.Now()
+
+		if s.OnAPICall != nil {
+			s.OnAPICall()
+		}
+
+		callCtx, cancel := withCallTimeout(ctx, s.Timeout)
+		output, err = s.Client.GetMetricStatistics(callCtx, &cloudwatch.GetMetricStatisticsInput{
+			Namespace:  awssdk.String("AWS/EC2"),
+			MetricName: awssdk.String("CPUUtilization"),
+			Dimensions: []cloudwatchtypes.Dimension{{
+				Name:  awssdk.String("AutoScalingGroupName"),
+				Value: awssdk.String(s.AutoScalingGroupName),
+			}},
+			StartTime:  awssdk.Time(now.Add(-3 * utilizationPeriod)),
+			EndTime:    awssdk.Time(now),
+			Period:     awssdk.Int32(int32(utilizationPeriod.Seconds())),
+			Statistics: []cloudwatchtypes.Statistic{cloudwatchtypes.StatisticAverage},
+		})
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("could not get metric statistics: %w", err)
+			return err
+		}
+
+		var latest *cloudwatchtypes.Datapoint
+
+		for i, datapoint := range output.Datapoints {
+			if datapoint.Timestamp == nil || datapoint.Average == nil {
+				continue
+			}
+
+			if latest == nil || datapoint.Timestamp.After(*latest.Timestamp) {
+				latest = &output.Datapoints[i]
+			}
+		}
+
+		if latest == nil {
+			err = fmt.Errorf("no recent CPU utilization datapoints")
+			return err
+		}
+
+		percent = *latest.Average
+		xray.AddMetadata(ctx, "utilization", percent)
+
+		return nil
+	})
+
+	return
+}
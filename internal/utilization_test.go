@@ -0,0 +1,114 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cloudwatchtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/spacelift-io/awsautoscalr/internal"
+	"github.com/spacelift-io/awsautoscalr/internal/ifaces"
+)
+
+func TestCloudWatchUtilizationSource(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("CloudWatchUtilizationSource", func() {
+		const asgName = "asg-name"
+
+		var percent float64
+		var err error
+
+		var mockCloudWatch *ifaces.MockCloudWatch
+		var apiCall *mock.Call
+		var input *cloudwatch.GetMetricStatisticsInput
+
+		var sut *internal.CloudWatchUtilizationSource
+
+		g.BeforeEach(func() {
+			input = nil
+
+			mockCloudWatch = &ifaces.MockCloudWatch{}
+			apiCall = mockCloudWatch.On(
+				"GetMetricStatistics",
+				mock.Anything,
+				mock.MatchedBy(func(in *cloudwatch.GetMetricStatisticsInput) bool {
+					input = in
+					return true
+				}),
+				mock.Anything,
+			)
+
+			sut = &internal.CloudWatchUtilizationSource{Client: mockCloudWatch, AutoScalingGroupName: asgName}
+		})
+
+		g.JustBeforeEach(func() { percent, err = sut.Utilization(context.Background()) })
+
+		g.Describe("when the API call fails", func() {
+			g.BeforeEach(func() { apiCall.Return(nil, errors.New("bacon")) })
+
+			g.It("sends the correct input", func() {
+				Expect(input).NotTo(BeNil())
+				Expect(*input.Namespace).To(Equal("AWS/EC2"))
+				Expect(*input.MetricName).To(Equal("CPUUtilization"))
+				Expect(input.Dimensions).To(HaveLen(1))
+				Expect(*input.Dimensions[0].Name).To(Equal("AutoScalingGroupName"))
+				Expect(*input.Dimensions[0].Value).To(Equal(asgName))
+				Expect(input.Statistics).To(ConsistOf(cloudwatchtypes.StatisticAverage))
+			})
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("could not get metric statistics: bacon"))
+			})
+		})
+
+		g.Describe("with an API call hook", func() {
+			var calls int
+
+			g.BeforeEach(func() {
+				calls = 0
+				sut.OnAPICall = func() { calls++ }
+				apiCall.Return(&cloudwatch.GetMetricStatisticsOutput{}, nil)
+			})
+
+			g.It("should report the API call", func() {
+				Expect(calls).To(Equal(1))
+			})
+		})
+
+		g.Describe("when there are no datapoints", func() {
+			g.BeforeEach(func() { apiCall.Return(&cloudwatch.GetMetricStatisticsOutput{}, nil) })
+
+			g.It("should return an error", func() {
+				Expect(err).To(MatchError("no recent CPU utilization datapoints"))
+			})
+		})
+
+		g.Describe("when there are datapoints", func() {
+			g.BeforeEach(func() {
+				now := time.Now()
+
+				apiCall.Return(&cloudwatch.GetMetricStatisticsOutput{
+					Datapoints: []cloudwatchtypes.Datapoint{
+						{Timestamp: aws.Time(now.Add(-10 * time.Minute)), Average: aws.Float64(40)},
+						{Timestamp: aws.Time(now.Add(-5 * time.Minute)), Average: aws.Float64(85)},
+						{Timestamp: aws.Time(now.Add(-15 * time.Minute)), Average: aws.Float64(20)},
+					},
+				}, nil)
+			})
+
+			g.It("returns the most recent average", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(percent).To(Equal(85.0))
+			})
+		})
+	})
+}
@@ -0,0 +1,26 @@
+// Package version holds the build information of the autoscaler. The values
+// are injected at build time using linker flags, eg.:
+//
+//	go build -ldflags "-X github.com/spacelift-io/awsautoscalr/internal/version.Version=v1.2.3"
+package version
+
+import "fmt"
+
+// Build information. These are variables rather than constants so that they
+// can be set by the linker.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// String returns a human-readable summary of the build information.
+func String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, BuildDate)
+}
+
+// LogAttrs returns the build information as key-value pairs, suitable for
+// structured logging.
+func LogAttrs() []any {
+	return []any{"version", Version, "commit", Commit, "build_date", BuildDate}
+}
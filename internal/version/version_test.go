@@ -0,0 +1,24 @@
+package version_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacelift-io/awsautoscalr/internal/version"
+)
+
+func TestDefaults(t *testing.T) {
+	require.Equal(t, "dev", version.Version)
+	require.Equal(t, "dev (commit unknown, built unknown)", version.String())
+}
+
+func TestInjectedValues(t *testing.T) {
+	defer func(v, c, d string) { version.Version, version.Commit, version.BuildDate = v, c, d }(version.Version, version.Commit, version.BuildDate)
+
+	// This is what the linker does with the -X flags.
+	version.Version, version.Commit, version.BuildDate = "v1.2.3", "abc1234", "2023-08-01T12:00:00Z"
+
+	require.Equal(t, "v1.2.3 (commit abc1234, built 2023-08-01T12:00:00Z)", version.String())
+	require.Equal(t, []any{"version", "v1.2.3", "commit", "abc1234", "build_date", "2023-08-01T12:00:00Z"}, version.LogAttrs())
+}
@@ -4,30 +4,132 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 )
 
 const (
 	asgKey      = "asg_id"
 	instanceKey = "instance_id"
+
+	metadataVersionKey = "metadata_version"
+
+	// Workers which don't report their metadata version are assumed to use
+	// the original format.
+	defaultMetadataVersion = "1"
 )
 
+// metadataKeys maps the known metadata format versions to the keys holding
+// the identifiers of the ASG and the instance. The original format is the only
+// one published so far; new ones go here as the workers start reporting them.
+var metadataKeys = map[string]struct{ group, instance string }{
+	"1": {group: asgKey, instance: instanceKey},
+}
+
+// MetadataKeys are the keys holding the identifiers of the ASG and the
+// instance in the original metadata format, for custom worker setups. Empty
+// keys stand for the built-in defaults.
+type MetadataKeys struct {
+	Group    string
+	Instance string
+}
+
+// withDefaults fills in the built-in defaults for the keys which aren't set.
+func (k MetadataKeys) withDefaults() MetadataKeys {
+	if k.Group == "" {
+		k.Group = asgKey
+	}
+
+	if k.Instance == "" {
+		k.Instance = instanceKey
+	}
+
+	return k
+}
+
 type GroupID string
 type InstanceID string
 
 type Worker struct {
-	ID        string `graphql:"id" json:"id"`
-	Busy      bool   `graphql:"busy" json:"busy"`
-	CreatedAt int32  `graphql:"createdAt" json:"createdAt"`
-	Drained   bool   `graphql:"drained" json:"drained"`
-	Metadata  string `graphql:"metadata" json:"metadata"`
+	ID        string `json:"id"`
+	Busy      bool   `json:"busy"`
+	CreatedAt int32  `json:"createdAt"`
+	Drained   bool   `json:"drained"`
+	Metadata  string `json:"metadata"`
+
+	// LastSeenAt is the time of the last heartbeat of the worker. Zero means
+	// it's unknown.
+	LastSeenAt int32 `json:"lastSeenAt"`
+}
+
+// WorkerFields are the worker fields fetched by the worker pool query and the
+// drain mutation. The heartbeat is fetched separately, and only when needed.
+type WorkerFields struct {
+	ID        string `graphql:"id"`
+	Busy      bool   `graphql:"busy"`
+	CreatedAt int32  `graphql:"createdAt"`
+	Drained   bool   `graphql:"drained"`
+	Metadata  string `graphql:"metadata"`
+}
+
+// Worker converts the fields into a worker.
+func (f *WorkerFields) Worker() Worker {
+	return Worker{
+		ID:        f.ID,
+		Busy:      f.Busy,
+		CreatedAt: f.CreatedAt,
+		Drained:   f.Drained,
+		Metadata:  f.Metadata,
+	}
 }
 
-func (w *Worker) InstanceIdentity() (GroupID, InstanceID, error) {
-	groupID, groupErr := w.metadataValue(asgKey)
-	instanceID, instanceErr := w.metadataValue(instanceKey)
+// HeartbeatStale checks whether the last heartbeat of the worker is older than
+// the given staleness threshold at the given time. A worker whose heartbeat is
+// unknown is never considered stale.
+func (w *Worker) HeartbeatStale(now time.Time, staleness time.Duration) bool {
+	if staleness <= 0 || w.LastSeenAt == 0 {
+		return false
+	}
+
+	return now.Sub(time.Unix(int64(w.LastSeenAt), 0)) > staleness
+}
+
+// InstanceIdentity returns the identifiers of the ASG and the instance the
+// worker runs on, reading the original metadata format with the given keys.
+func (w *Worker) InstanceIdentity(custom MetadataKeys) (GroupID, InstanceID, error) {
+	metadata, err := w.metadata()
+	if err != nil {
+		return "", "", err
+	}
+
+	version, known := metadataVersion(metadata)
+
+	keys := metadataKeys[version]
+	if !known || version == defaultMetadataVersion {
+		custom = custom.withDefaults()
+		keys = struct{ group, instance string }{group: custom.Group, instance: custom.Instance}
+	}
+
+	groupID, groupErr := metadataValue(metadata, keys.group)
+	instanceID, instanceErr := metadataValue(metadata, keys.instance)
+
+	if err := errors.Join(groupErr, instanceErr); err != nil && !known {
+		return "", "", fmt.Errorf("unknown metadata version %s: %w", version, err)
+	}
+
 	return GroupID(groupID), InstanceID(instanceID), errors.Join(groupErr, instanceErr)
 }
 
+// MetadataVersion returns the version of the worker metadata format, and
+// whether it's one of the versions we know how to handle.
+func (w *Worker) MetadataVersion() (version string, known bool) {
+	metadata, err := w.metadata()
+	if err != nil {
+		return "", false
+	}
+
+	return metadataVersion(metadata)
+}
+
 func (w *Worker) metadata() (map[string]string, error) {
 	out := make(map[string]string)
 
@@ -38,16 +140,27 @@ func (w *Worker) metadata() (map[string]string, error) {
 	return out, nil
 }
 
-func (w *Worker) metadataValue(key string) (string, error) {
-	metadata, err := w.metadata()
-	if err != nil {
-		return "", err
+func metadataVersion(metadata map[string]string) (version string, known bool) {
+	if version = metadata[metadataVersionKey]; version == "" {
+		version = defaultMetadataVersion
 	}
 
+	_, known = metadataKeys[version]
+
+	return version, known
+}
+
+func metadataValue(metadata map[string]string, key string) (string, error) {
 	value, exists := metadata[key]
 	if !exists {
 		return "", fmt.Errorf("metadata %s not present", key)
 	}
 
+	// An empty value is as good as a missing one, and would otherwise allow
+	// multiple workers to collide on the same (empty) identifier.
+	if value == "" {
+		return "", fmt.Errorf("metadata %s is empty", key)
+	}
+
 	return value, nil
 }
@@ -1,5 +1,5 @@
 package internal
 
 type WorkerDrainSet struct {
-	Worker Worker `graphql:"workerDrainSet(workerPool: $workerPoolId, id: $workerId, drain: $drain)"`
+	Worker WorkerFields `graphql:"workerDrainSet(workerPool: $workerPoolId, id: $workerId, drain: $drain)"`
 }
@@ -1,10 +1,152 @@
 package internal
 
+// DisabledLabel is the worker pool label which pauses the autoscaler, so that
+// it can be switched off from the Spacelift side without a redeployment.
+const DisabledLabel = "autoscaler:disabled"
+
 type WorkerPool struct {
-	PendingRuns int32    `graphql:"pendingRuns" json:"pendingRuns"`
-	Workers     []Worker `graphql:"workers" json:"workers"`
+	Labels      []string     `json:"labels"`
+	PendingRuns int32        `json:"pendingRuns"`
+	PausedRuns  int32        `json:"pausedRuns"`
+	Runs        []PendingRun `json:"runs"`
+	Suspended   bool         `json:"suspended"`
+	Workers     []Worker     `json:"workers"`
+}
+
+// PendingRun is a run waiting for a worker in the pool, along with its labels.
+type PendingRun struct {
+	ID     string   `json:"id"`
+	Labels []string `json:"labels"`
+}
+
+// AutoscalingDisabled checks whether the worker pool carries the label which
+// pauses the autoscaler.
+func (wp *WorkerPool) AutoscalingDisabled() bool {
+	for _, label := range wp.Labels {
+		if label == DisabledLabel {
+			return true
+		}
+	}
+
+	return false
 }
 
 type WorkerPoolDetails struct {
-	Pool *WorkerPool `graphql:"workerPool(id: $workerPool)"`
+	Pool *WorkerPoolFields `graphql:"workerPool(id: $workerPool)"`
+}
+
+// WorkerPoolFields are the worker pool fields fetched by every query. The ones
+// only some features need are fetched separately, and only when enabled.
+type WorkerPoolFields struct {
+	PendingRuns int32          `graphql:"pendingRuns"`
+	Workers     []WorkerFields `graphql:"workers"`
+}
+
+// WorkerPool converts the fields into a worker pool.
+func (f *WorkerPoolFields) WorkerPool() *WorkerPool {
+	out := &WorkerPool{
+		PendingRuns: f.PendingRuns,
+		Workers:     make([]Worker, 0, len(f.Workers)),
+	}
+
+	for _, worker := range f.Workers {
+		out.Workers = append(out.Workers, worker.Worker())
+	}
+
+	return out
+}
+
+// WorkerPoolSuspensionDetails queries whether the worker pool is suspended.
+type WorkerPoolSuspensionDetails struct {
+	Pool *WorkerPoolSuspension `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolSuspension struct {
+	Suspended bool `graphql:"suspended"`
+}
+
+// WorkerPoolPausedRunsDetails queries the number of pending runs which are
+// paused, eg. awaiting approval.
+type WorkerPoolPausedRunsDetails struct {
+	Pool *WorkerPoolPausedRuns `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolPausedRuns struct {
+	PausedRuns int32 `graphql:"pausedRuns"`
+}
+
+// WorkerPoolLabelsDetails queries the labels of the worker pool.
+type WorkerPoolLabelsDetails struct {
+	Pool *WorkerPoolLabels `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolLabels struct {
+	Labels []string `graphql:"labels"`
+}
+
+// WorkerPoolHeartbeatsDetails queries the last heartbeats of the workers in
+// the pool.
+type WorkerPoolHeartbeatsDetails struct {
+	Pool *WorkerPoolHeartbeats `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolHeartbeats struct {
+	Workers []WorkerHeartbeat `graphql:"workers"`
+}
+
+type WorkerHeartbeat struct {
+	ID         string `graphql:"id"`
+	LastSeenAt int32  `graphql:"lastSeenAt"`
+}
+
+// WorkerPoolSummaryDetails is a lightweight version of WorkerPoolDetails,
+// which leaves out the per-worker metadata and creation timestamps.
+type WorkerPoolSummaryDetails struct {
+	Pool *WorkerPoolSummary `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolSummary struct {
+	PendingRuns int32           `graphql:"pendingRuns" json:"pendingRuns"`
+	Workers     []WorkerSummary `graphql:"workers" json:"workers"`
+}
+
+type WorkerSummary struct {
+	ID      string `graphql:"id" json:"id"`
+	Busy    bool   `graphql:"busy" json:"busy"`
+	Drained bool   `graphql:"drained" json:"drained"`
+}
+
+// WorkerPool converts the summary into a worker pool whose workers only have
+// their ID, busy and drained status set. This is enough to decide on scaling
+// up, but not to map the workers to their instances.
+func (s *WorkerPoolSummary) WorkerPool() *WorkerPool {
+	out := &WorkerPool{
+		PendingRuns: s.PendingRuns,
+		Workers:     make([]Worker, 0, len(s.Workers)),
+	}
+
+	for _, worker := range s.Workers {
+		out.Workers = append(out.Workers, Worker{ID: worker.ID, Busy: worker.Busy, Drained: worker.Drained})
+	}
+
+	return out
+}
+
+// RunStateReady is the state of the runs waiting for a worker.
+const RunStateReady = "READY"
+
+// WorkerPoolRunsDetails queries the runs of the worker pool along with their
+// state and labels.
+type WorkerPoolRunsDetails struct {
+	Pool *WorkerPoolRuns `graphql:"workerPool(id: $workerPool)"`
+}
+
+type WorkerPoolRuns struct {
+	Runs []RunFields `graphql:"runs"`
+}
+
+type RunFields struct {
+	ID     string   `graphql:"id"`
+	State  string   `graphql:"state"`
+	Labels []string `graphql:"labels"`
 }
@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/franela/goblin"
 	. "github.com/onsi/gomega"
@@ -19,11 +20,14 @@ func TestWorker(t *testing.T) {
 		g.BeforeEach(func() { sut = &internal.Worker{} })
 
 		g.Describe("InstanceIdentity", func() {
+			var keys internal.MetadataKeys
 			var groupID internal.GroupID
 			var instanceID internal.InstanceID
 			var err error
 
-			g.JustBeforeEach(func() { groupID, instanceID, err = sut.InstanceIdentity() })
+			g.BeforeEach(func() { keys = internal.MetadataKeys{} })
+
+			g.JustBeforeEach(func() { groupID, instanceID, err = sut.InstanceIdentity(keys) })
 
 			g.Describe("with no metadata", func() {
 				g.BeforeEach(func() { sut.Metadata = "{}" })
@@ -35,6 +39,53 @@ func TestWorker(t *testing.T) {
 				})
 			})
 
+			g.Describe("with an empty instance ID", func() {
+				g.BeforeEach(func() {
+					sut.Metadata = `{"asg_id": "group", "instance_id": ""}`
+				})
+
+				g.It("should return an error", func() {
+					Expect(err).To(MatchError("metadata instance_id is empty"))
+				})
+			})
+
+			g.Describe("with an explicit version 1", func() {
+				g.BeforeEach(func() {
+					sut.Metadata = `{"metadata_version": "1", "asg_id": "group", "instance_id": "instance"}`
+				})
+
+				g.It("should return the group and instance IDs", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(instanceID).To(Equal(internal.InstanceID("instance")))
+				})
+			})
+
+			g.Describe("with an unknown version", func() {
+				g.Describe("in the default format", func() {
+					g.BeforeEach(func() {
+						sut.Metadata = `{"metadata_version": "42", "asg_id": "group", "instance_id": "instance"}`
+					})
+
+					g.It("should fall back to the default format", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(groupID).To(Equal(internal.GroupID("group")))
+						Expect(instanceID).To(Equal(internal.InstanceID("instance")))
+					})
+				})
+
+				g.Describe("in a different format", func() {
+					g.BeforeEach(func() {
+						sut.Metadata = `{"metadata_version": "42", "group": "group", "instance": "instance"}`
+					})
+
+					g.It("should return an error mentioning the version", func() {
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(HavePrefix("unknown metadata version 42: "))
+						Expect(err.Error()).To(ContainSubstring("metadata instance_id not present"))
+					})
+				})
+			})
+
 			g.Describe("with valid metadata", func() {
 				g.BeforeEach(func() {
 					sut.Metadata = `{"asg_id": "group", "instance_id": "instance"}`
@@ -46,6 +97,82 @@ func TestWorker(t *testing.T) {
 					Expect(instanceID).To(Equal(internal.InstanceID("instance")))
 				})
 			})
+
+			g.Describe("with custom metadata keys", func() {
+				g.BeforeEach(func() { keys = internal.MetadataKeys{Group: "my_asg", Instance: "my_instance"} })
+
+				g.Describe("when the metadata uses the custom keys", func() {
+					g.BeforeEach(func() {
+						sut.Metadata = `{"my_asg": "group", "my_instance": "instance"}`
+					})
+
+					g.It("should return the group and instance IDs", func() {
+						Expect(err).NotTo(HaveOccurred())
+						Expect(groupID).To(Equal(internal.GroupID("group")))
+						Expect(instanceID).To(Equal(internal.InstanceID("instance")))
+					})
+				})
+
+				g.Describe("when the metadata uses the built-in keys", func() {
+					g.BeforeEach(func() {
+						sut.Metadata = `{"asg_id": "group", "instance_id": "instance"}`
+					})
+
+					g.It("should return an error mentioning the custom keys", func() {
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(ContainSubstring("metadata my_asg not present"))
+						Expect(err.Error()).To(ContainSubstring("metadata my_instance not present"))
+					})
+				})
+			})
+		})
+
+		g.Describe("HeartbeatStale", func() {
+			now := time.Now()
+
+			g.It("should not be stale with an unknown heartbeat", func() {
+				Expect(sut.HeartbeatStale(now, time.Minute)).To(BeFalse())
+			})
+
+			g.It("should not be stale with the check disabled", func() {
+				sut.LastSeenAt = int32(now.Add(-time.Hour).Unix())
+				Expect(sut.HeartbeatStale(now, 0)).To(BeFalse())
+			})
+
+			g.It("should not be stale with a recent heartbeat", func() {
+				sut.LastSeenAt = int32(now.Add(-30 * time.Second).Unix())
+				Expect(sut.HeartbeatStale(now, time.Minute)).To(BeFalse())
+			})
+
+			g.It("should be stale with an old heartbeat", func() {
+				sut.LastSeenAt = int32(now.Add(-2 * time.Minute).Unix())
+				Expect(sut.HeartbeatStale(now, time.Minute)).To(BeTrue())
+			})
+		})
+
+		g.Describe("MetadataVersion", func() {
+			var version string
+			var known bool
+
+			g.JustBeforeEach(func() { version, known = sut.MetadataVersion() })
+
+			g.Describe("with no version", func() {
+				g.BeforeEach(func() { sut.Metadata = `{"asg_id": "group"}` })
+
+				g.It("should assume the default version", func() {
+					Expect(version).To(Equal("1"))
+					Expect(known).To(BeTrue())
+				})
+			})
+
+			g.Describe("with an unknown version", func() {
+				g.BeforeEach(func() { sut.Metadata = `{"metadata_version": "42"}` })
+
+				g.It("should report it as unknown", func() {
+					Expect(version).To(Equal("42"))
+					Expect(known).To(BeFalse())
+				})
+			})
 		})
 	})
 }